@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dnonakolesax/noted-runner/internal/batch"
+	"github.com/dnonakolesax/noted-runner/internal/logger"
+)
+
+const (
+	execOK = iota
+	execFailed
+	execUsage
+)
+
+// runExec - команда exec: выполняет блокнот из каталога без сервера, брокера и Docker, например в CI.
+// Возвращает код выхода: 1 - упал блок, 2 - блокнот не удалось загрузить или записать отчёт
+func runExec(args []string) int {
+	fs := flag.NewFlagSet("exec", flag.ContinueOnError)
+	dir := fs.String("dir", ".", "Directory with automerge block files")
+	reportPath := fs.String("report", "", "Report file: .md for Markdown, JSON otherwise; stdout if empty")
+	order := fs.String("order", "", "Comma-separated block IDs or file names to run, in order")
+	compileTimeout := fs.Duration("compile-timeout", 30*time.Second, "Timeout for building one block")
+	params := make(map[string]json.RawMessage)
+	fs.Var(paramFlag{params: params}, "p", "Parameter override name=value, value is JSON or a plain string; repeatable")
+	fs.Var(paramFlag{params: params, raw: true}, "r", "Parameter override name=value, value is always a string; repeatable")
+	versions := make(map[string][]string)
+	fs.Var(versionFlag(versions), "at", "Block version block=head[,head], heads are automerge change hashes; repeatable")
+	err := fs.Parse(args)
+	if err != nil {
+		return execUsage
+	}
+
+	log := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	var blockOrder []string
+	if *order != "" {
+		blockOrder = strings.Split(*order, ",")
+	}
+	blocks, err := batch.LoadNotebook(*dir, blockOrder, versions)
+	if err != nil {
+		log.Error("error loading notebook", logger.LogError(err))
+		return execUsage
+	}
+
+	workDir, err := os.MkdirTemp("", "noted-exec-")
+	if err != nil {
+		log.Error("error creating work dir", logger.LogError(err))
+		return execUsage
+	}
+	defer os.RemoveAll(workDir)
+
+	runner := batch.NewRunner(batch.NewLocalKernel(workDir, *compileTimeout), log)
+	abs, _ := filepath.Abs(*dir)
+	report, err := runner.Run(filepath.Base(abs), blocks, params)
+	if err != nil {
+		log.Error("error running notebook", logger.LogError(err))
+		return execUsage
+	}
+
+	err = writeReport(report, *reportPath)
+	if err != nil {
+		log.Error("error writing report", logger.LogError(err))
+		return execUsage
+	}
+	if report.Failed {
+		return execFailed
+	}
+	return execOK
+}
+
+// paramFlag - параметр запуска как в papermill: -p разбирает значение как JSON, а то, что не JSON, и -r
+// передают строкой
+type paramFlag struct {
+	params map[string]json.RawMessage
+	raw    bool
+}
+
+func (pf paramFlag) String() string {
+	return ""
+}
+
+func (pf paramFlag) Set(value string) error {
+	name, val, ok := strings.Cut(value, "=")
+	if !ok || name == "" {
+		return errors.New("expected name=value")
+	}
+	if !pf.raw && json.Valid([]byte(val)) {
+		pf.params[name] = json.RawMessage(val)
+		return nil
+	}
+	quoted, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	pf.params[name] = quoted
+	return nil
+}
+
+// versionFlag закрепляет версию блока: ID или имя файла блока = heads через запятую
+type versionFlag map[string][]string
+
+func (vf versionFlag) String() string {
+	return ""
+}
+
+func (vf versionFlag) Set(value string) error {
+	block, heads, ok := strings.Cut(value, "=")
+	if !ok || block == "" || heads == "" {
+		return errors.New("expected block=head[,head]")
+	}
+	vf[block] = strings.Split(heads, ",")
+	return nil
+}
+
+func writeReport(report *batch.Report, path string) error {
+	var w io.Writer = os.Stdout
+	if path != "" {
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	if strings.EqualFold(filepath.Ext(path), ".md") {
+		return report.WriteMarkdown(w)
+	}
+	err := report.WriteJSON(w)
+	if err != nil {
+		return fmt.Errorf("error encoding report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dnonakolesax/noted-runner/internal/batch"
+	"github.com/dnonakolesax/noted-runner/internal/logger"
+	"github.com/dnonakolesax/noted-runner/internal/model"
+	"github.com/dnonakolesax/noted-runner/internal/preproc"
+)
+
+// runExport - команда export: собирает блокнот из каталога в программу на Go. Пропущенные блоки
+// перечисляются в логе и в комментарии программы; код выхода 2 - блокнот не удалось загрузить или записать
+func runExport(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	dir := fs.String("dir", ".", "Directory with automerge block files")
+	order := fs.String("order", "", "Comma-separated block IDs or file names to export, in order")
+	layout := fs.String("layout", model.ExportMain, "main for a single main.go, package for a package with a test")
+	out := fs.String("o", "", "Output: main.go path (stdout if empty) or directory for layout=package")
+	versions := make(map[string][]string)
+	fs.Var(versionFlag(versions), "at", "Block version block=head[,head], heads are automerge change hashes; repeatable")
+	err := fs.Parse(args)
+	if err != nil {
+		return execUsage
+	}
+	if *layout != model.ExportMain && *layout != model.ExportPackage {
+		fmt.Fprintf(os.Stderr, "unknown layout %q\n", *layout)
+		return execUsage
+	}
+
+	log := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	var blockOrder []string
+	if *order != "" {
+		blockOrder = strings.Split(*order, ",")
+	}
+	blocks, err := batch.LoadNotebook(*dir, blockOrder, versions)
+	if err != nil {
+		log.Error("error loading notebook", logger.LogError(err))
+		return execUsage
+	}
+
+	program := preproc.NewProgram()
+	for _, block := range blocks {
+		program.Add(block.ID, block.Source)
+	}
+	for _, skipped := range program.Skipped() {
+		log.Warn("block is not exported", slog.String("block", skipped.BlockID), slog.String("reason", skipped.Reason))
+	}
+
+	err = writeProgram(program, *layout, *out)
+	if err != nil {
+		log.Error("error writing program", logger.LogError(err))
+		return execUsage
+	}
+	return execOK
+}
+
+func writeProgram(program *preproc.Program, layout string, out string) error {
+	if layout == model.ExportMain {
+		src, err := program.Main()
+		if err != nil {
+			return err
+		}
+		if out == "" {
+			_, err = os.Stdout.WriteString(src)
+			return err
+		}
+		return os.WriteFile(out, []byte(src), 0o644)
+	}
+
+	if out == "" {
+		out = "notebook"
+	}
+	files, err := program.Package(filepath.Base(out))
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(out, 0o755)
+	if err != nil {
+		return err
+	}
+	for name, src := range files {
+		err = os.WriteFile(filepath.Join(out, name), []byte(src), 0o644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
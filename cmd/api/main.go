@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"os"
 
 	_ "go.uber.org/automaxprocs"
 
@@ -18,6 +19,13 @@ import (
 // @host oauth.dnk33.com
 // @BasePath /api/v1/compile.
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "exec" {
+		os.Exit(runExec(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		os.Exit(runExport(os.Args[2:]))
+	}
+
 	configsPath := flag.String("configs", "/cfg", "Path to configs")
 	flag.Parse()
 
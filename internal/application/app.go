@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"net"
 	"os"
 	"os/signal"
 	"strconv"
@@ -12,8 +13,10 @@ import (
 
 	fasthttpprom "github.com/carousell/fasthttp-prometheus-middleware"
 	"github.com/valyala/fasthttp"
+	"google.golang.org/grpc"
 
 	"github.com/dnonakolesax/noted-runner/internal/configs"
+	runner "github.com/dnonakolesax/noted-runner/internal/delivery/compiler/v1/grpc/proto"
 	"github.com/dnonakolesax/noted-runner/internal/logger"
 	"github.com/dnonakolesax/noted-runner/internal/middlewares"
 	"github.com/dnonakolesax/noted-runner/internal/routing"
@@ -76,7 +79,7 @@ func (a *App) Run() {
 	p := fasthttpprom.NewPrometheus("")
 	p.Use(router.Router())
 	router.NewAPIGroup(a.configs.Service.BasePath, "1",
-		a.layers.compileHTTP)
+		a.layers.compileHTTP, a.layers.adminHTTP, a.layers.schedulerHTTP)
 
 	wg := &sync.WaitGroup{}
 
@@ -113,6 +116,25 @@ func (a *App) Run() {
 	},
 	)
 
+	/************************************************/
+	/*               GRPC SERVER START              */
+	/************************************************/
+
+	grpcSrv := grpc.NewServer(grpc.UnaryInterceptor(a.layers.grpcAuthMW.Unary()),
+		grpc.StreamInterceptor(a.layers.grpcAuthMW.Stream()))
+	runner.RegisterRunnerServiceServer(grpcSrv, a.layers.runnerGRPC)
+
+	wg.Go(func() {
+		a.initLogger.Info("Starting gRPC server", slog.Int("Port", a.configs.Service.GRPCPort))
+		lis, grpcErr := net.Listen("tcp", ":"+strconv.Itoa(a.configs.Service.GRPCPort))
+		if grpcErr == nil {
+			grpcErr = grpcSrv.Serve(lis)
+		}
+		if grpcErr != nil {
+			a.initLogger.Error(fmt.Sprintf("Couldn't start gRPC server: %v", grpcErr))
+		}
+	})
+
 	/************************************************/
 	/*              RMQ CONSUMER START              */
 	/************************************************/
@@ -120,6 +142,23 @@ func (a *App) Run() {
 		a.layers.compileResultConsumer.Consume()
 	})
 
+	/************************************************/
+	/*                SCHEDULER START               */
+	/************************************************/
+	schedCtx, stopScheduler := context.WithCancel(context.Background())
+	schedDone := make(chan struct{})
+	go func() {
+		a.layers.scheduler.Run(schedCtx)
+		close(schedDone)
+	}()
+
+	/************************************************/
+	/*               AUDIT RETENTION                */
+	/************************************************/
+	auditCtx, stopAudit := context.WithCancel(context.Background())
+	defer stopAudit()
+	go a.layers.journal.Run(auditCtx)
+
 	/************************************************/
 	/*              SHUTDOWN SIGNAL RCV             */
 	/************************************************/
@@ -132,6 +171,13 @@ func (a *App) Run() {
 			slog.String("error", err.Error()))
 	}
 
+	// незавершённые Execute ждут результата ядра, поэтому gRPC останавливается без ожидания вызовов
+	grpcSrv.Stop()
+
+	// идущие запуски по расписанию обрываются и останавливают свои ядра, пока брокер ещё доступен
+	stopScheduler()
+	<-schedDone
+
 	a.components.Rabbit.Close()
 
 	wg.Wait()
@@ -4,9 +4,14 @@ import (
 	"context"
 	"log/slog"
 
+	"github.com/dnonakolesax/noted-runner/internal/cluster"
 	"github.com/dnonakolesax/noted-runner/internal/consts"
 	"github.com/dnonakolesax/noted-runner/internal/docker"
 	"github.com/dnonakolesax/noted-runner/internal/httpclient"
+	"github.com/dnonakolesax/noted-runner/internal/kernelcmd"
+	"github.com/dnonakolesax/noted-runner/internal/model"
+	"github.com/dnonakolesax/noted-runner/internal/mount"
+	"github.com/dnonakolesax/noted-runner/internal/outbox"
 	"github.com/dnonakolesax/noted-runner/internal/rabbit"
 	accessPb "github.com/dnonakolesax/noted-runner/internal/usecase/access/proto"
 	authPb "github.com/dnonakolesax/noted-runner/internal/usecase/auth/proto"
@@ -17,6 +22,11 @@ import (
 type Components struct {
 	Docker  *docker.DockerClient
 	Rabbit  *rabbit.RabbitQueue
+	Cluster *cluster.Cluster
+	// Commands - RPC с ядрами через RabbitMQ, ответы на него разбирает консьюмер
+	Commands *kernelcmd.RabbitCommander
+	Kernels  kernelcmd.Transport
+	Outbox   *outbox.Outbox
 	HTTPC   *httpclient.HTTPClient
 	GRPCAC  *authPb.AuthServiceClient
 	GRPCAcC *accessPb.AcessServiceClient
@@ -29,15 +39,16 @@ func (a *App) SetupComponents() error {
 	/************************************************/
 	a.initLogger.InfoContext(context.Background(), "Starting RabbitMQ connection")
 
-	rmq, err := rabbit.NewRabbit(a.configs.Docker.Env.RMQAddr, a.configs.Docker.Env.ChanName, a.loggers.Infra)
+	rmq := rabbit.NewRabbit(a.configs.Docker.Env.RMQAddr, rabbit.Topology{
+		Exchange: a.configs.Docker.Env.Exchange,
+		Queue:    "noted-runner." + a.configs.Service.InstanceID,
+		Keys:     []string{cluster.ClaimPattern},
+		Shared:   map[string]string{model.DeadLetterQueue: model.DeadLetterKey},
+	}, a.configs.Rabbit, rabbit.DialAMQP, a.health.Rabbit, a.loggers.Infra)
 
-	if err != nil {
-		a.initLogger.ErrorContext(context.Background(), "Error connecting to RabbitMQ",
-			slog.String(consts.ErrorLoggerKey, err.Error()))
-		return err
-	}
-	a.initLogger.InfoContext(context.Background(), "RabbitMQ connection established")
+	a.initLogger.InfoContext(context.Background(), "RabbitMQ supervisor started")
 	a.components.Rabbit = rmq
+	a.components.Cluster = cluster.NewCluster(a.configs.Service.InstanceID, rmq, a.loggers.Infra)
 
 	/************************************************/
 	/*              DOCKER CLIENT INIT              */
@@ -67,6 +78,25 @@ func (a *App) SetupComponents() error {
 	a.initLogger.InfoContext(context.Background(), "HTTP client created")
 	a.components.HTTPC = httpc
 
+	/************************************************/
+	/*             KERNEL COMMANDS INIT             */
+	/************************************************/
+	a.components.Commands = kernelcmd.NewRabbitCommander(rmq, a.configs.Commands, a.loggers.Infra)
+	kernels, err := kernelcmd.New(a.configs.Commands.Transport, a.components.Commands,
+		kernelcmd.NewHTTPCommander(httpc, a.configs.Docker.Prefix, a.configs.Docker.AppPort), a.loggers.Infra)
+	if err != nil {
+		a.initLogger.ErrorContext(context.Background(), "Error creating kernel commands transport",
+			slog.String(consts.ErrorLoggerKey, err.Error()))
+		return err
+	}
+	a.components.Kernels = kernels
+
+	/************************************************/
+	/*                  OUTBOX INIT                 */
+	/************************************************/
+	a.components.Outbox = outbox.NewOutbox(a.configs.Outbox, mount.NewRoot(a.configs.Docker.Env.MountPath),
+		a.loggers.Service)
+
 	/************************************************/
 	/*             GRPC AUTH CLIENT INIT            */
 	/************************************************/
@@ -1,14 +1,29 @@
 package application
 
 import (
+	"github.com/dnonakolesax/noted-runner/internal/audit"
 	"github.com/dnonakolesax/noted-runner/internal/consumers"
+	adminDelivery "github.com/dnonakolesax/noted-runner/internal/delivery/admin/v1/http"
+	runnerDelivery "github.com/dnonakolesax/noted-runner/internal/delivery/compiler/v1/grpc"
 	compilerDelivery "github.com/dnonakolesax/noted-runner/internal/delivery/compiler/v1/http"
+	schedulerDelivery "github.com/dnonakolesax/noted-runner/internal/delivery/scheduler/v1/http"
 	"github.com/dnonakolesax/noted-runner/internal/middlewares"
+	"github.com/dnonakolesax/noted-runner/internal/mount"
+	"github.com/dnonakolesax/noted-runner/internal/preproc"
+	"github.com/dnonakolesax/noted-runner/internal/scheduler"
+	"github.com/dnonakolesax/noted-runner/internal/session"
 	"github.com/dnonakolesax/noted-runner/internal/usecase"
 )
 
 type Layers struct {
-	compileHTTP *compilerDelivery.ComilerDelivery
+	compileHTTP   *compilerDelivery.ComilerDelivery
+	adminHTTP     *adminDelivery.AdminDelivery
+	schedulerHTTP *schedulerDelivery.SchedulerDelivery
+	runnerGRPC    *runnerDelivery.RunnerServer
+	grpcAuthMW    *middlewares.GRPCAuthMW
+
+	scheduler *scheduler.Scheduler
+	journal   *audit.Journal
 
 	compileResultConsumer *consumers.RunnerConsumer
 }
@@ -18,25 +33,62 @@ func (a *App) SetupLayers() error {
 	/************************************************/
 	/*                USECASES INIT                 */
 	/************************************************/
-	uc := usecase.NewCompilerUsecase(a.components.Docker, a.configs.Docker.Env.MountPath, a.configs.Docker.Prefix,
-		a.loggers.Service, a.configs.Service, a.components.HTTPC)
+	magics, err := preproc.NewMagics(a.configs.Magics)
+	if err != nil {
+		return err
+	}
+	uc := usecase.NewCompilerUsecase(a.components.Docker, a.components.Cluster, a.components.Kernels,
+		a.configs.Docker.Env.MountPath, a.configs.Docker.Prefix, magics, a.loggers.Service, a.configs.Service)
+	deadLetters := usecase.NewDeadLettersUsecase(a.components.Rabbit, a.loggers.Service)
+	journal, err := audit.NewJournal(a.configs.Audit, a.loggers.Service)
+	if err != nil {
+		return err
+	}
+	a.layers.journal = journal
+	history := audit.NewTracker(journal, a.configs.Audit.OutputLimit, a.loggers.Service)
 
 	/************************************************/
 	/*              MIDDLEWARE INIT                 */
 	/************************************************/
 	authMW := middlewares.NewAuthMW(*a.components.GRPCAC, a.loggers.HTTP)
-	accessMW := middlewares.NewAccessMW(*a.components.GRPCAcC, a.loggers.HTTP)
+	accessMW := middlewares.NewAccessMW(*a.components.GRPCAcC, history, a.loggers.HTTP)
+	adminMW := middlewares.NewAdminMW(a.configs.Service.Admins, a.loggers.HTTP)
+	a.layers.grpcAuthMW = middlewares.NewGRPCAuthMW(*a.components.GRPCAC, *a.components.GRPCAcC, history,
+		a.loggers.HTTP)
 
 	/************************************************/
 	/*                DELIVERY INIT                 */
 	/************************************************/
-	cd := compilerDelivery.NewComilerDelivery(uc, a.loggers.HTTP, authMW, accessMW)
+	hub := session.NewHub(a.configs.Session, a.loggers.Service)
+	cd := compilerDelivery.NewComilerDelivery(uc, hub, a.components.Outbox, history, a.configs.WebSocket,
+		a.configs.REST, a.metrics.WSMetrics, a.loggers.HTTP, authMW, accessMW)
 	a.layers.compileHTTP = cd
+	a.layers.adminHTTP = adminDelivery.NewAdminDelivery(deadLetters, history, a.loggers.HTTP, authMW, adminMW)
+	a.layers.runnerGRPC = runnerDelivery.NewRunnerServer(cd, a.loggers.HTTP)
+	a.components.Cluster.OnRelease(cd.Release)
+
+	/************************************************/
+	/*                SCHEDULER INIT                */
+	/************************************************/
+	store, err := scheduler.NewStore(a.configs.Scheduler.Dir, a.configs.Scheduler.History)
+	if err != nil {
+		return err
+	}
+	blocks := scheduler.NotebookBlocks(mount.NewRoot(a.configs.Docker.Env.MountPath))
+	sched, err := scheduler.NewScheduler(store, cd, blocks, *a.components.GRPCAcC, history, a.configs.Scheduler,
+		a.loggers.Service)
+	if err != nil {
+		return err
+	}
+	a.layers.scheduler = sched
+	a.layers.schedulerHTTP = schedulerDelivery.NewSchedulerDelivery(sched, a.loggers.HTTP, authMW, accessMW)
+	cd.Describe(a.layers.schedulerHTTP.Routes()...)
 
 	/************************************************/
 	/*                CONSUMERS INIT                */
 	/************************************************/
-	consumer := consumers.NewRunnerConsumer(a.components.Rabbit.Queue, cd, a.loggers.Infra)
+	consumer := consumers.NewRunnerConsumer(a.components.Rabbit.Queue, cd, a.components.Cluster,
+		a.components.Commands, a.components.Rabbit, a.loggers.Infra)
 	a.layers.compileResultConsumer = consumer
 	return nil
 }
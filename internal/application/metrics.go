@@ -8,6 +8,7 @@ import (
 
 type Metrics struct {
 	RunnerMetrics      *metrics.HTTPRequestMetrics
+	WSMetrics          *metrics.WSMetrics
 
 	Reg *prometheus.Registry
 }
@@ -21,9 +22,11 @@ func (a *App) SetupMetrics() {
 	)
 
 	runnerRequestMetrics := metrics.NewHTTPRequestMetrics(reg, "runner_get")
+	wsMetrics := metrics.NewWSMetrics(reg, "runner_ws")
 
 	a.metrics = &Metrics{
 		RunnerMetrics: runnerRequestMetrics,
+		WSMetrics:     wsMetrics,
 		Reg: reg,
 	}
 }
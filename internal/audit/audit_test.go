@@ -0,0 +1,124 @@
+package audit
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dnonakolesax/noted-runner/internal/configs"
+	"github.com/dnonakolesax/noted-runner/internal/model"
+)
+
+func newJournal(t *testing.T, retention time.Duration) *Journal {
+	t.Helper()
+	journal, err := NewJournal(&configs.AuditConfig{Dir: t.TempDir(), Retention: retention}, slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return journal
+}
+
+func TestJournalQueryAndPrune(t *testing.T) {
+	journal := newJournal(t, 48*time.Hour)
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	for idx, at := range []time.Time{now.AddDate(0, 0, -5), now.AddDate(0, 0, -1), now, now.Add(time.Minute)} {
+		rec := model.ExecutionRecord{ID: string(rune('a' + idx)), KernelID: "k1", BlockID: "b1", StartedAt: at}
+		if idx == 2 {
+			rec.KernelID = "k2"
+		}
+		if err := journal.Record(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// недописанная строка не ломает чтение остальных записей
+	file, err := os.OpenFile(journal.path(executionsDir, now.Format(dayLayout)), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = file.WriteString(`{"id":"broken`)
+	_ = file.Close()
+
+	records, err := journal.Executions(Filter{KernelID: "k1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(records); got != "dba" {
+		t.Fatalf("expected newest first, got %q", got)
+	}
+	records, _ = journal.Executions(Filter{KernelID: "k1", Limit: 2})
+	if got := ids(records); got != "db" {
+		t.Fatalf("limit was not applied: %q", got)
+	}
+	last, err := journal.LastExecutions("k1", []string{"b1", "b2", "b1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(last) != 1 || last["b1"].ID != "d" {
+		t.Fatalf("unexpected last executions: %+v", last)
+	}
+
+	if err = journal.Prune(now); err != nil {
+		t.Fatal(err)
+	}
+	records, _ = journal.Executions(Filter{})
+	if got := ids(records); got != "dcb" {
+		t.Fatalf("expected the oldest day to be pruned, got %q", got)
+	}
+	_, err = os.Stat(filepath.Join(journal.dir, executionsDir, now.AddDate(0, 0, -5).Format(dayLayout)+".jsonl"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("pruned day is still on disk: %v", err)
+	}
+}
+
+func TestTracker(t *testing.T) {
+	journal := newJournal(t, 0)
+	tracker := NewTracker(journal, 5, slog.Default())
+	started := time.Now()
+
+	tracker.Begin("k1", "b1", "u1", started, model.BlockRun{Attempt: "at1", CompileTime: 1500 * time.Millisecond}, nil)
+	tracker.Begin("k1", "b1", "u1", started, model.BlockRun{Attempt: "at2"}, nil)
+	tracker.Begin("k1", "b2", "u1", started, model.BlockRun{Attempt: "at1"}, errors.New("error parsing block"))
+	tracker.Complete(model.KernelMessage{KernelID: "k1", BlockID: "b1", Result: "ответ 42"})
+	tracker.Complete(model.KernelMessage{KernelID: "k1", BlockID: "b3", Result: "nobody ran it"})
+	tracker.FailKernel("k1", errors.New("kernel stopped"))
+
+	records, err := tracker.Executions(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records, got %+v", records)
+	}
+	stopped, done, failed := records[0], records[1], records[2]
+	if failed.BlockID != "b2" || failed.Status != model.ExecutionFailed || failed.Error != "error parsing block" {
+		t.Fatalf("unexpected record of the block that did not compile: %+v", failed)
+	}
+	// вывод обрезается по границе символа: от «ответ» остаются два символа по два байта
+	if done.Attempt != "at1" || done.Status != model.ExecutionDone || done.Output != "от" || !done.Truncated ||
+		done.CompileMS != 1500 {
+		t.Fatalf("unexpected record of the finished block: %+v", done)
+	}
+	if stopped.Attempt != "at2" || stopped.Status != model.ExecutionFailed || stopped.Error != "kernel stopped" {
+		t.Fatalf("unexpected record of the interrupted block: %+v", stopped)
+	}
+
+	tracker.Event(model.AuditAccessDenied, "u2", "k1", "no right to execute")
+	events, err := tracker.Events(Filter{Type: model.AuditAccessDenied})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].UserID != "u2" || events[0].Time.IsZero() {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+}
+
+func ids(records []model.ExecutionRecord) string {
+	var s string
+	for _, rec := range records {
+		s += rec.ID
+	}
+	return s
+}
@@ -0,0 +1,248 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dnonakolesax/noted-runner/internal/configs"
+	"github.com/dnonakolesax/noted-runner/internal/logger"
+	"github.com/dnonakolesax/noted-runner/internal/model"
+)
+
+const (
+	executionsDir = "executions"
+	eventsDir     = "events"
+	dayLayout     = "2006-01-02"
+	pruneInterval = time.Hour
+)
+
+// Filter отбирает записи журнала; пустые поля не ограничивают выборку
+type Filter struct {
+	KernelID string
+	UserID   string
+	BlockID  string
+	Type     string
+	Limit    int
+}
+
+// Journal - журнал выполнений и событий. Записи только дописываются в файлы по дням (UTC),
+// старые дни удаляются целиком по истечении срока хранения
+type Journal struct {
+	dir       string
+	retention time.Duration
+	logger    *slog.Logger
+	mu        sync.Mutex
+}
+
+func NewJournal(config *configs.AuditConfig, logger *slog.Logger) (*Journal, error) {
+	for _, sub := range []string{executionsDir, eventsDir} {
+		err := os.MkdirAll(filepath.Join(config.Dir, sub), 0o750)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &Journal{dir: config.Dir, retention: config.Retention, logger: logger}, nil
+}
+
+func (j *Journal) Record(rec model.ExecutionRecord) error {
+	return j.append(executionsDir, rec.StartedAt, rec)
+}
+
+func (j *Journal) Event(ev model.AuditEvent) error {
+	return j.append(eventsDir, ev.Time, ev)
+}
+
+// Executions возвращает записи о выполнениях, начиная с последней
+func (j *Journal) Executions(filter Filter) ([]model.ExecutionRecord, error) {
+	return query(j, executionsDir, filter.Limit, func(rec model.ExecutionRecord) bool {
+		return match(filter.KernelID, rec.KernelID) && match(filter.UserID, rec.UserID) &&
+			match(filter.BlockID, rec.BlockID)
+	})
+}
+
+// Events возвращает события, начиная с последнего
+func (j *Journal) Events(filter Filter) ([]model.AuditEvent, error) {
+	return query(j, eventsDir, filter.Limit, func(ev model.AuditEvent) bool {
+		return match(filter.KernelID, ev.KernelID) && match(filter.UserID, ev.UserID) && match(filter.Type, ev.Type)
+	})
+}
+
+// LastExecutions возвращает последнее выполнение каждого из блоков ядра за один проход по журналу
+func (j *Journal) LastExecutions(kernelID string, blockIDs []string) (map[string]model.ExecutionRecord, error) {
+	wanted := make(map[string]bool, len(blockIDs))
+	for _, blockID := range blockIDs {
+		wanted[blockID] = true
+	}
+	last := make(map[string]model.ExecutionRecord, len(wanted))
+	if len(wanted) == 0 {
+		return last, nil
+	}
+	err := scan(j, executionsDir, func(rec model.ExecutionRecord) bool {
+		if _, ok := last[rec.BlockID]; !ok && rec.KernelID == kernelID && wanted[rec.BlockID] {
+			last[rec.BlockID] = rec
+		}
+		return len(last) == len(wanted)
+	})
+	return last, err
+}
+
+// Prune удаляет дни, целиком вышедшие за срок хранения
+func (j *Journal) Prune(now time.Time) error {
+	if j.retention <= 0 {
+		return nil
+	}
+	deadline := now.UTC().Add(-j.retention)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, sub := range []string{executionsDir, eventsDir} {
+		days, err := j.days(sub)
+		if err != nil {
+			return err
+		}
+		for _, day := range days {
+			start, err := time.Parse(dayLayout, day)
+			if err != nil || !start.AddDate(0, 0, 1).Before(deadline) {
+				continue
+			}
+			err = os.Remove(j.path(sub, day))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Run чистит журнал при старте и затем раз в час, пока не отменён ctx
+func (j *Journal) Run(ctx context.Context) {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	for {
+		err := j.Prune(time.Now())
+		if err != nil {
+			j.logger.Error("error pruning audit journal", logger.LogError(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (j *Journal) append(sub string, at time.Time, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	file, err := os.OpenFile(j.path(sub, at.UTC().Format(dayLayout)), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// days возвращает дни, за которые есть файлы, от последнего к первому
+func (j *Journal) days(sub string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(j.dir, sub))
+	if err != nil {
+		return nil, err
+	}
+	var days []string
+	for _, entry := range entries {
+		if day, ok := strings.CutSuffix(entry.Name(), ".jsonl"); ok && !entry.IsDir() {
+			days = append(days, day)
+		}
+	}
+	slices.Sort(days)
+	slices.Reverse(days)
+	return days, nil
+}
+
+func (j *Journal) path(sub string, day string) string {
+	return filepath.Join(j.dir, sub, day+".jsonl")
+}
+
+// query собирает записи, которые оставляет keep, пока не наберёт limit
+func query[T any](j *Journal, sub string, limit int, keep func(T) bool) ([]T, error) {
+	found := []T{}
+	err := scan(j, sub, func(item T) bool {
+		if keep(item) {
+			found = append(found, item)
+		}
+		return limit > 0 && len(found) == limit
+	})
+	if err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// scan читает дни от последнего к первому и внутри дня - с конца файла, пока visit не вернёт true.
+// Файлы только дописываются, поэтому читаются без j.mu и не задерживают запись; день,
+// удалённый Prune во время чтения, пропускается
+func scan[T any](j *Journal, sub string, visit func(T) bool) error {
+	days, err := j.days(sub)
+	if err != nil {
+		return err
+	}
+	for _, day := range days {
+		lines, err := readLines(j.path(sub, day))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for idx := len(lines) - 1; idx >= 0; idx-- {
+			var item T
+			// недописанную строку пропускаем, остальной журнал остаётся читаемым
+			if json.Unmarshal(lines[idx], &item) != nil {
+				continue
+			}
+			if visit(item) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+func readLines(path string) ([][]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines [][]byte
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, slices.Clone(scanner.Bytes()))
+	}
+	return lines, scanner.Err()
+}
+
+func match(want string, got string) bool {
+	return want == "" || want == got
+}
@@ -0,0 +1,160 @@
+package audit
+
+import (
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dnonakolesax/noted-runner/internal/logger"
+	"github.com/dnonakolesax/noted-runner/internal/model"
+	"github.com/dnonakolesax/noted-runner/internal/rnd"
+)
+
+const recordIDLen = 16
+
+type pending struct {
+	record model.ExecutionRecord
+	sentAt time.Time
+}
+
+// Tracker сводит отправку блока и результат ядра в одну запись журнала. Результат приходит без ID
+// запуска, поэтому, как и в реестре выполнений, достаётся самому старому запуску того же блока
+type Tracker struct {
+	journal     *Journal
+	outputLimit int
+	logger      *slog.Logger
+
+	mu      sync.Mutex
+	pending map[string][]pending
+}
+
+func NewTracker(journal *Journal, outputLimit int, logger *slog.Logger) *Tracker {
+	return &Tracker{journal: journal, outputLimit: outputLimit, logger: logger, pending: make(map[string][]pending)}
+}
+
+// Begin отмечает отправку блока в ядро. Если собрать или отправить блок не удалось, запись сразу
+// попадает в журнал как проваленная
+func (t *Tracker) Begin(kernelID string, blockID string, userID string, startedAt time.Time, run model.BlockRun,
+	err error) {
+	now := time.Now()
+	record := model.ExecutionRecord{
+		ID:         string(rnd.NotSafeGenRandomString(recordIDLen)),
+		UserID:     userID,
+		KernelID:   kernelID,
+		BlockID:    blockID,
+		Attempt:    run.Attempt,
+		Mode:       run.Mode,
+		Heads:      run.Heads,
+		SourceHash: run.SourceHash,
+		CodePath:   run.CodePath,
+		Status:     model.ExecutionRunning,
+		StartedAt:  startedAt,
+		CompileMS:  run.CompileTime.Milliseconds(),
+	}
+	if err != nil {
+		record.Status = model.ExecutionFailed
+		record.Error = err.Error()
+		record.FinishedAt = now
+		t.write(record)
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := kernelID + "/" + blockID
+	t.pending[key] = append(t.pending[key], pending{record: record, sentAt: now})
+}
+
+// Complete записывает результат ядра и возвращает запись о выполнении. Результат блока, отправка
+// которого не отмечалась, не пишется
+func (t *Tracker) Complete(msg model.KernelMessage) (model.ExecutionRecord, bool) {
+	key := msg.KernelID + "/" + msg.BlockID
+	t.mu.Lock()
+	queue := t.pending[key]
+	if len(queue) == 0 {
+		t.mu.Unlock()
+		return model.ExecutionRecord{}, false
+	}
+	p := queue[0]
+	if len(queue) == 1 {
+		delete(t.pending, key)
+	} else {
+		t.pending[key] = queue[1:]
+	}
+	t.mu.Unlock()
+
+	p.record.Status = model.ExecutionDone
+	if msg.Fail {
+		p.record.Status = model.ExecutionFailed
+	}
+	p.record.Output, p.record.Truncated = truncate(msg.Result, t.outputLimit)
+	return t.finish(p), true
+}
+
+// FailKernel записывает незавершённые запуски ядра как проваленные: ядро остановлено или перезапущено
+func (t *Tracker) FailKernel(kernelID string, err error) {
+	t.mu.Lock()
+	var failed []pending
+	for key, queue := range t.pending {
+		if strings.HasPrefix(key, kernelID+"/") {
+			failed = append(failed, queue...)
+			delete(t.pending, key)
+		}
+	}
+	t.mu.Unlock()
+
+	for _, p := range failed {
+		p.record.Status = model.ExecutionFailed
+		p.record.Error = err.Error()
+		t.finish(p)
+	}
+}
+
+// Event пишет событие аудита; время события проставляется здесь
+func (t *Tracker) Event(eventType string, userID string, kernelID string, detail string) {
+	ev := model.AuditEvent{Time: time.Now(), Type: eventType, UserID: userID, KernelID: kernelID, Detail: detail}
+	err := t.journal.Event(ev)
+	if err != nil {
+		t.logger.Error("error writing audit event", logger.LogError(err), slog.String("type", eventType))
+	}
+}
+
+func (t *Tracker) Executions(filter Filter) ([]model.ExecutionRecord, error) {
+	return t.journal.Executions(filter)
+}
+
+func (t *Tracker) LastExecutions(kernelID string, blockIDs []string) (map[string]model.ExecutionRecord, error) {
+	return t.journal.LastExecutions(kernelID, blockIDs)
+}
+
+func (t *Tracker) Events(filter Filter) ([]model.AuditEvent, error) {
+	return t.journal.Events(filter)
+}
+
+func (t *Tracker) finish(p pending) model.ExecutionRecord {
+	p.record.FinishedAt = time.Now()
+	p.record.RunMS = p.record.FinishedAt.Sub(p.sentAt).Milliseconds()
+	t.write(p.record)
+	return p.record
+}
+
+func (t *Tracker) write(record model.ExecutionRecord) {
+	err := t.journal.Record(record)
+	if err != nil {
+		t.logger.Error("error writing execution record", logger.LogError(err), slog.String("kernel", record.KernelID))
+	}
+}
+
+// truncate обрезает вывод до limit байт, не разрывая символ UTF-8; limit <= 0 - без ограничения
+func truncate(output string, limit int) (string, bool) {
+	if limit <= 0 || len(output) <= limit {
+		return output, false
+	}
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(output[cut]) {
+		cut--
+	}
+	return output[:cut], true
+}
@@ -0,0 +1,102 @@
+package batch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dnonakolesax/noted-runner/internal/logger"
+	"github.com/dnonakolesax/noted-runner/internal/model"
+	"github.com/dnonakolesax/noted-runner/internal/preproc"
+)
+
+// attempt - у каждого блока в пакетном запуске одна попытка
+const attempt = "at1"
+
+// Runner выполняет блоки блокнота по порядку тем же препроцессором, что и RunBlock
+type Runner struct {
+	kernel Kernel
+	types  *preproc.KernelTypes
+	logger *slog.Logger
+}
+
+func NewRunner(kernel Kernel, logger *slog.Logger) *Runner {
+	return &Runner{kernel: kernel, types: preproc.NewKernelTypes(), logger: logger}
+}
+
+// ErrNoParameters - параметры переданы, а блока параметров в блокноте нет
+var ErrNoParameters = errors.New("notebook has no parameters block")
+
+// Run выполняет блоки до первой ошибки; блоки после неё попадают в отчёт пропущенными.
+// params переопределяют переменные первого блока параметров
+func (r *Runner) Run(notebook string, blocks []Block, params map[string]json.RawMessage) (*Report, error) {
+	paramsBlock := slices.IndexFunc(blocks, func(block Block) bool { return preproc.IsParameters(block.Source) })
+	if paramsBlock < 0 && len(params) != 0 {
+		return nil, ErrNoParameters
+	}
+
+	report := &Report{Notebook: notebook, StartedAt: time.Now(), Blocks: make([]BlockReport, 0, len(blocks))}
+	for idx, block := range blocks {
+		if report.Failed {
+			report.Blocks = append(report.Blocks, BlockReport{ID: block.ID, File: block.File, Status: StatusSkipped})
+			continue
+		}
+		started := time.Now()
+		var blockParams map[string]json.RawMessage
+		if idx == paramsBlock {
+			blockParams = params
+		}
+		result, effective := r.runBlock(block, blockParams)
+		if idx == paramsBlock {
+			report.Parameters = effective
+		}
+		result.Duration = time.Since(started)
+		if result.Status == model.ExecutionFailed {
+			report.Failed = true
+			r.logger.Error("block failed", slog.String("block", block.File), slog.String("error", result.Error))
+		} else {
+			r.logger.Info("block done", slog.String("block", block.File), slog.Duration("duration", result.Duration))
+		}
+		report.Blocks = append(report.Blocks, result)
+	}
+	report.Duration = time.Since(report.StartedAt)
+	return report, nil
+}
+
+func (r *Runner) runBlock(block Block, params map[string]json.RawMessage) (BlockReport, map[string]string) {
+	result := BlockReport{ID: block.ID, File: block.File, Status: model.ExecutionDone, Heads: block.Heads}
+
+	pb := preproc.NewBlock(block.ID, block.Source, r.types)
+	err := pb.Parse()
+	if err != nil {
+		r.logger.Debug("error parsing block", logger.LogError(err))
+		return failed(result, fmt.Errorf("error parsing block: %s", err)), nil
+	}
+	effective, err := pb.Override(params)
+	if err != nil {
+		return failed(result, err), nil
+	}
+	code := pb.FormExportFunc(attempt)
+	if code == "" {
+		return failed(result, errors.New("error forming block code")), effective
+	}
+	result.Warnings = pb.Warnings()
+
+	symbol := "Export_block_" + strings.ReplaceAll(block.ID, "-", "_") + "_" + attempt
+	result.Output, err = r.kernel.Exec(block.ID, code, symbol)
+	if err != nil {
+		return failed(result, err), effective
+	}
+	pb.Commit()
+	return result, effective
+}
+
+func failed(result BlockReport, err error) BlockReport {
+	result.Status = model.ExecutionFailed
+	result.Error = err.Error()
+	return result
+}
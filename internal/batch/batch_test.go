@@ -0,0 +1,296 @@
+package batch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/automerge/automerge-go"
+	"github.com/dnonakolesax/noted-runner/internal/model"
+	"github.com/dnonakolesax/noted-runner/internal/preproc"
+)
+
+const testBlock = "4bcb102d-d663-4bec-86b4-86e978b5b54c"
+
+func writeBlock(t *testing.T, dir string, name string, text string) {
+	t.Helper()
+	doc := automerge.New()
+	err := doc.Path("text").Set(automerge.NewText(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, name), doc.Save(), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadNotebook(t *testing.T) {
+	dir := t.TempDir()
+	writeBlock(t, dir, "02-print", "fmt.Println(x)")
+	writeBlock(t, dir, "01_init", "x := 1")
+	writeBlock(t, dir, "block_"+strings.ReplaceAll(testBlock, "-", "_"), "y := 2")
+	writeBlock(t, dir, ".draft", "broken(")
+
+	blocks, err := LoadNotebook(dir, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, block := range blocks {
+		got = append(got, block.ID)
+	}
+	if want := []string{"01_init", "02_print", testBlock}; strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("unexpected blocks %v, want %v", got, want)
+	}
+	if blocks[0].Source != "x := 1" {
+		t.Fatalf("unexpected source %q", blocks[0].Source)
+	}
+
+	blocks, err = LoadNotebook(dir, []string{testBlock, "01_init"}, nil)
+	if err != nil || len(blocks) != 2 || blocks[0].ID != testBlock {
+		t.Fatalf("order is not applied: %v, %v", blocks, err)
+	}
+	if _, err = LoadNotebook(dir, []string{"03_missing"}, nil); err == nil {
+		t.Fatal("unknown block in order is accepted")
+	}
+
+	err = os.WriteFile(filepath.Join(dir, "03_corrupt"), []byte("not automerge"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = LoadNotebook(dir, nil, nil); err == nil || !strings.Contains(err.Error(), "03_corrupt") {
+		t.Fatalf("corrupt block is not reported: %v", err)
+	}
+}
+
+func TestLoadNotebookVersions(t *testing.T) {
+	dir := t.TempDir()
+	doc := automerge.New()
+	if err := doc.Path("text").Set(automerge.NewText("x := 1")); err != nil {
+		t.Fatal(err)
+	}
+	first, err := doc.Commit("first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = doc.Path("text").Text().Set("x := 2"); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(dir, "block_"+testBlock), doc.Save(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	writeBlock(t, dir, "02_print", "fmt.Println(x)")
+
+	blocks, err := LoadNotebook(dir, nil, map[string][]string{testBlock: {first.String()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blocks[0].Source != "fmt.Println(x)" || len(blocks[0].Heads) != 1 {
+		t.Fatalf("unpinned block is not loaded at its current version: %+v", blocks[0])
+	}
+	if blocks[1].Source != "x := 1" || len(blocks[1].Heads) != 1 || blocks[1].Heads[0] != first.String() {
+		t.Fatalf("block is not loaded at the pinned version: %+v", blocks[1])
+	}
+
+	if _, err = LoadNotebook(dir, nil, map[string][]string{"02_print": {first.String()}}); err == nil ||
+		!strings.Contains(err.Error(), "02_print") {
+		t.Fatalf("version from another block is accepted: %v", err)
+	}
+	if _, err = LoadNotebook(dir, nil, map[string][]string{"03_missing": {first.String()}}); err == nil {
+		t.Fatal("version of an unknown block is accepted")
+	}
+}
+
+type fakeKernel struct {
+	symbols []string
+	fail    string
+}
+
+func (fk *fakeKernel) Exec(blockID string, _ string, symbol string) (string, error) {
+	fk.symbols = append(fk.symbols, symbol)
+	if blockID == fk.fail {
+		return "partial\n", errors.New("block panicked: boom")
+	}
+	return "out of " + blockID + "\n", nil
+}
+
+func TestRunStopsAtFirstFailure(t *testing.T) {
+	kernel := &fakeKernel{fail: "b"}
+	blocks := []Block{
+		{ID: testBlock, File: "block_a", Source: "x := 1"},
+		{ID: "b", File: "b", Source: "y := x"},
+		{ID: "c", File: "c", Source: "z := y"},
+	}
+	report, err := NewRunner(kernel, slog.Default()).Run("nb", blocks, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !report.Failed {
+		t.Fatal("report is not failed")
+	}
+	if want := "Export_block_4bcb102d_d663_4bec_86b4_86e978b5b54c_at1 Export_block_b_at1"; strings.Join(kernel.symbols,
+		" ") != want {
+		t.Fatalf("unexpected symbols %v", kernel.symbols)
+	}
+	statuses := []string{report.Blocks[0].Status, report.Blocks[1].Status, report.Blocks[2].Status}
+	if statuses[0] != model.ExecutionDone || statuses[1] != model.ExecutionFailed || statuses[2] != StatusSkipped {
+		t.Fatalf("unexpected statuses %v", statuses)
+	}
+	if report.Blocks[1].Output != "partial\n" || report.Blocks[1].Error == "" {
+		t.Fatalf("failed block lost its output: %+v", report.Blocks[1])
+	}
+
+	report, _ = NewRunner(&fakeKernel{}, slog.Default()).Run("nb", []Block{{ID: "a", File: "a", Source: "x := "}}, nil)
+	if !report.Failed || !strings.Contains(report.Blocks[0].Error, "error parsing block") {
+		t.Fatalf("parse error is not reported: %+v", report.Blocks[0])
+	}
+}
+
+func TestRunParameters(t *testing.T) {
+	blocks := []Block{
+		{ID: "a", File: "a", Source: "x := 1"},
+		{ID: "params", File: "params", Source: preproc.ParametersTag + "\nregion := \"eu\"\nlimit := 10"},
+		{ID: "b", File: "b", Source: "fmt.Println(region, limit)"},
+	}
+	runner := NewRunner(&fakeKernel{}, slog.Default())
+	report, err := runner.Run("nb", blocks, map[string]json.RawMessage{"region": json.RawMessage(`"us"`)})
+	if err != nil || report.Failed {
+		t.Fatalf("run failed: %v, %+v", err, report)
+	}
+	if want := map[string]string{"region": `"us"`, "limit": "10"}; !maps.Equal(report.Parameters, want) {
+		t.Fatalf("got parameters %v, expected %v", report.Parameters, want)
+	}
+
+	report, _ = NewRunner(&fakeKernel{}, slog.Default()).Run("nb", blocks,
+		map[string]json.RawMessage{"limit": json.RawMessage(`"many"`)})
+	if !report.Failed || report.Blocks[1].Status != model.ExecutionFailed ||
+		!strings.Contains(report.Blocks[1].Error, "invalid parameter value limit") {
+		t.Fatalf("invalid override is not reported: %+v", report.Blocks[1])
+	}
+
+	_, err = NewRunner(&fakeKernel{}, slog.Default()).Run("nb", blocks[:1], map[string]json.RawMessage{"x": nil})
+	if !errors.Is(err, ErrNoParameters) {
+		t.Fatalf("parameters without a parameters block: %v", err)
+	}
+}
+
+func TestWriteMarkdown(t *testing.T) {
+	report := &Report{Notebook: "nb", StartedAt: time.Now(), Failed: true, Blocks: []BlockReport{
+		{File: "a", Status: model.ExecutionDone, Output: "```go\n```\n", Warnings: []string{"x is redeclared"}},
+		{File: "b", Status: model.ExecutionFailed, Error: "boom"},
+		{File: "c", Status: StatusSkipped},
+	}}
+	var buf bytes.Buffer
+	err := report.WriteMarkdown(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	md := buf.String()
+	for _, want := range []string{"failed.", "| c | skipped |", "````\n```go\n```\n````", "> warning: x is redeclared",
+		"Error:\n\n```\nboom\n```"} {
+		if !strings.Contains(md, want) {
+			t.Fatalf("report has no %q:\n%s", want, md)
+		}
+	}
+	if strings.Contains(md, "## c") {
+		t.Fatalf("skipped block has a section:\n%s", md)
+	}
+}
+
+// TestLocalKernel собирает настоящие плагины, поэтому нужен go и CGO
+func TestLocalKernel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds plugins")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is not installed")
+	}
+	dir := t.TempDir()
+	writeBlock(t, dir, "01_init", preproc.ParametersTag+"\nx := 21")
+	writeBlock(t, dir, "02_print", "fmt.Println(x * 2)")
+	blocks, err := LoadNotebook(dir, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := NewRunner(NewLocalKernel(t.TempDir(), time.Minute), slog.Default()).Run("nb", blocks,
+		map[string]json.RawMessage{"x": json.RawMessage(`50`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Failed && strings.Contains(report.Blocks[0].Error, "plugin") {
+		t.Skipf("plugins are not supported here: %s", report.Blocks[0].Error)
+	}
+	if report.Failed || report.Blocks[1].Output != "100\n" || report.Parameters["x"] != "50" {
+		t.Fatalf("unexpected report: %+v", report.Blocks)
+	}
+}
+
+// TestLocalKernelTypes - значения типов ядра переходят между плагинами: каждый плагин объявляет
+// типы заново, и *main.Counter первого блока для второго - другой тип
+func TestLocalKernelTypes(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds plugins")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is not installed")
+	}
+	dir := t.TempDir()
+	writeBlock(t, dir, "01_types", `type point struct {
+	x, y int
+}
+
+type Counter struct {
+	n    int
+	last *point
+}
+
+func (c *Counter) Inc() {
+	c.n++
+}
+
+func shift(p point, d int) point {
+	return point{p.x + d, p.y + d}
+}
+
+c := &Counter{last: &point{1, 2}}
+c.Inc()
+points := map[string][]point{"a": {{1, 1}}}`)
+	writeBlock(t, dir, "02_use", `func (c *Counter) Value() int {
+	return c.n
+}
+
+c.Inc()
+p := shift(*c.last, 10)
+points["b"] = append(points["a"], p)
+fmt.Println(c.Value(), p.x, len(points["b"]))`)
+	writeBlock(t, dir, "03_again", `fmt.Println(c.n, points["b"][1].y)`)
+	blocks, err := LoadNotebook(dir, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := NewRunner(NewLocalKernel(t.TempDir(), time.Minute), slog.Default()).Run("nb", blocks, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Failed && strings.Contains(report.Blocks[0].Error, "plugin") {
+		t.Skipf("plugins are not supported here: %s", report.Blocks[0].Error)
+	}
+	if report.Failed {
+		t.Fatalf("unexpected report: %+v", report.Blocks)
+	}
+	if report.Blocks[1].Output != "2 11 2\n" || report.Blocks[2].Output != "2 12\n" {
+		t.Fatalf("unexpected output: %q, %q", report.Blocks[1].Output, report.Blocks[2].Output)
+	}
+}
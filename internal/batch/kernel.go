@@ -0,0 +1,111 @@
+package batch
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"plugin"
+	"sync"
+	"time"
+)
+
+// Kernel выполняет код блока, собранный препроцессором, и возвращает его вывод
+type Kernel interface {
+	Exec(blockID string, code string, symbol string) (string, error)
+}
+
+// LocalKernel - ядро в процессе раннера: блоки собираются плагинами, как в контейнере ядра,
+// и делят между собой funcMap и varMap. Раннер должен быть собран той же версией Go с CGO
+type LocalKernel struct {
+	workDir        string
+	compileTimeout time.Duration
+	funcs          map[string]any
+	vars           map[string]any
+	// mu сериализует подмену os.Stdout
+	mu sync.Mutex
+}
+
+func NewLocalKernel(workDir string, compileTimeout time.Duration) *LocalKernel {
+	return &LocalKernel{
+		workDir:        workDir,
+		compileTimeout: compileTimeout,
+		funcs:          make(map[string]any),
+		vars:           make(map[string]any),
+	}
+}
+
+func (lk *LocalKernel) Exec(blockID string, code string, symbol string) (string, error) {
+	soPath, err := lk.build(blockID, code, symbol)
+	if err != nil {
+		return "", err
+	}
+	p, err := plugin.Open(soPath)
+	if err != nil {
+		return "", fmt.Errorf("error loading block: %w", err)
+	}
+	sym, err := p.Lookup(symbol)
+	if err != nil {
+		return "", fmt.Errorf("error loading block: %w", err)
+	}
+	export, ok := sym.(func(*map[string]any, *map[string]any))
+	if !ok {
+		return "", fmt.Errorf("block symbol %s has unexpected type %T", symbol, sym)
+	}
+
+	lk.mu.Lock()
+	defer lk.mu.Unlock()
+	return captureStdout(func() {
+		export(&lk.funcs, &lk.vars)
+	})
+}
+
+func (lk *LocalKernel) build(blockID string, code string, symbol string) (string, error) {
+	srcPath := filepath.Join(lk.workDir, "block_"+blockID+".go")
+	soPath := filepath.Join(lk.workDir, symbol+".so")
+	err := os.WriteFile(srcPath, []byte(code), 0o600)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), lk.compileTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "go", "build", "-buildmode=plugin", "-o", soPath, srcPath)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("error running go build: %v\nOutput: %s", err, out)
+	}
+	return soPath, nil
+}
+
+// captureStdout перехватывает вывод блока; паника в блоке становится ошибкой запуска
+func captureStdout(run func()) (output string, err error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	copied := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(&buf, r)
+		close(copied)
+	}()
+
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("block panicked: %v", rec)
+		}
+		os.Stdout = stdout
+		_ = w.Close()
+		<-copied
+		_ = r.Close()
+		output = buf.String()
+	}()
+	run()
+	return "", nil
+}
@@ -0,0 +1,123 @@
+package batch
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/dnonakolesax/noted-runner/internal/blocksource"
+	"github.com/dnonakolesax/noted-runner/internal/ids"
+)
+
+const blockFilePrefix = "block_"
+
+var nonIdent = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// Block - исходник блока блокнота
+type Block struct {
+	ID     string
+	File   string
+	Source string
+	// Heads - версия блока в automerge, из которой взят Source
+	Heads []string
+}
+
+// LoadNotebook читает automerge-файлы блоков из каталога. Блоки идут в порядке order (ID или имена файлов),
+// а без него - по именам файлов (ReadDir их сортирует), поэтому в CI их удобно называть 01_setup, 02_check и т.д.
+// versions закрепляет версии блоков (ID или имя файла -> heads); остальные блоки берутся в текущей версии
+func LoadNotebook(dir string, order []string, versions map[string][]string) ([]Block, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var blocks []Block
+	pinned := 0
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		heads, ok := versionOf(versions, entry.Name())
+		if ok {
+			pinned++
+		}
+		block, err := loadBlock(filepath.Join(dir, entry.Name()), heads)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+	if pinned != len(versions) {
+		for key := range versions {
+			if !slices.ContainsFunc(blocks, func(block Block) bool { return matches(block, key) }) {
+				return nil, fmt.Errorf("block %s is not found in the notebook", key)
+			}
+		}
+	}
+	if len(order) == 0 {
+		return blocks, nil
+	}
+	return reorder(blocks, order)
+}
+
+// versionOf ищет закреплённую версию файла name по имени файла или ID блока
+func versionOf(versions map[string][]string, name string) ([]string, bool) {
+	block := Block{ID: blockID(name), File: name}
+	for key, heads := range versions {
+		if matches(block, key) {
+			return heads, true
+		}
+	}
+	return nil, false
+}
+
+func matches(block Block, key string) bool {
+	return key == block.File || key == block.ID || blockID(key) == block.ID
+}
+
+func loadBlock(path string, heads []string) (Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Block{}, err
+	}
+	name := filepath.Base(path)
+	source, err := blocksource.Decode(data, heads)
+	if err != nil {
+		return Block{}, fmt.Errorf("block %s: %w", name, err)
+	}
+	return Block{ID: blockID(name), File: name, Source: source.Text, Heads: source.Heads}, nil
+}
+
+// blockID выводит ID блока из имени файла: block_<uuid> у раннера или произвольное имя,
+// приведённое к идентификатору Go - из ID строится имя экспортируемой функции
+func blockID(name string) string {
+	name = strings.TrimPrefix(name, blockFilePrefix)
+	if _, err := ids.Parse(name); err == nil {
+		return strings.ReplaceAll(name, "_", "-")
+	}
+	return nonIdent.ReplaceAllString(name, "_")
+}
+
+func reorder(blocks []Block, order []string) ([]Block, error) {
+	byKey := make(map[string]Block, 2*len(blocks))
+	for _, block := range blocks {
+		byKey[block.ID] = block
+		byKey[block.File] = block
+	}
+
+	ordered := make([]Block, 0, len(order))
+	for _, key := range order {
+		block, ok := byKey[key]
+		if !ok {
+			block, ok = byKey[blockID(key)]
+		}
+		if !ok {
+			return nil, fmt.Errorf("block %s is not found in the notebook", key)
+		}
+		ordered = append(ordered, block)
+	}
+	return ordered, nil
+}
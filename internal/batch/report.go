@@ -0,0 +1,96 @@
+package batch
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// StatusSkipped - блок не запускался, потому что до него упал другой
+const StatusSkipped = "skipped"
+
+// Report - результат пакетного запуска блокнота
+type Report struct {
+	Notebook  string        `json:"notebook"`
+	StartedAt time.Time     `json:"startedAt"`
+	Duration  time.Duration `json:"duration"`
+	Failed    bool          `json:"failed"`
+	// Parameters - действующие параметры блока параметров: имя -> выражение Go
+	Parameters map[string]string `json:"parameters,omitempty"`
+	Blocks     []BlockReport     `json:"blocks"`
+}
+
+type BlockReport struct {
+	ID       string        `json:"id"`
+	File     string        `json:"file"`
+	Status   string        `json:"status"`
+	Output   string        `json:"output,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Warnings []string      `json:"warnings,omitempty"`
+	Duration time.Duration `json:"duration"`
+	// Heads - версия блока в automerge, которая выполнялась
+	Heads []string `json:"heads,omitempty"`
+}
+
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteMarkdown пишет отчёт для людей: сводную таблицу и вывод каждого блока
+func (r *Report) WriteMarkdown(w io.Writer) error {
+	var b strings.Builder
+	result := "passed"
+	if r.Failed {
+		result = "failed"
+	}
+	fmt.Fprintf(&b, "# %s\n\n", r.Notebook)
+	fmt.Fprintf(&b, "Started %s, took %s, %s.\n\n", r.StartedAt.Format(time.RFC3339), r.Duration.Round(time.Millisecond),
+		result)
+	if len(r.Parameters) != 0 {
+		b.WriteString("Parameters:\n\n")
+		for _, name := range slices.Sorted(maps.Keys(r.Parameters)) {
+			fmt.Fprintf(&b, "- `%s = %s`\n", name, r.Parameters[name])
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("| Block | Status | Duration |\n|---|---|---|\n")
+	for _, block := range r.Blocks {
+		fmt.Fprintf(&b, "| %s | %s | %s |\n", block.File, block.Status, block.Duration.Round(time.Millisecond))
+	}
+
+	for _, block := range r.Blocks {
+		if block.Status == StatusSkipped {
+			continue
+		}
+		fmt.Fprintf(&b, "\n## %s\n", block.File)
+		if len(block.Heads) != 0 {
+			fmt.Fprintf(&b, "\nVersion: `%s`\n", strings.Join(block.Heads, ","))
+		}
+		for _, warning := range block.Warnings {
+			fmt.Fprintf(&b, "\n> warning: %s\n", warning)
+		}
+		if block.Output != "" {
+			fmt.Fprintf(&b, "\n%s", fenced(block.Output))
+		}
+		if block.Error != "" {
+			fmt.Fprintf(&b, "\nError:\n\n%s", fenced(block.Error))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// fenced оборачивает текст в блок кода, длина ограды больше любой последовательности ` внутри
+func fenced(text string) string {
+	fence := "```"
+	for strings.Contains(text, fence) {
+		fence += "`"
+	}
+	return fence + "\n" + strings.TrimSuffix(text, "\n") + "\n" + fence + "\n"
+}
@@ -0,0 +1,47 @@
+package blocksource
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/automerge/automerge-go"
+)
+
+// ErrInvalidHeads - запрошенная версия блока не разбирается или её нет в документе
+var ErrInvalidHeads = errors.New("invalid automerge heads")
+
+// At возвращает документ в версии heads (hex хешей изменений automerge) и heads этой версии.
+// Без heads возвращается текущая версия
+func At(doc *automerge.Doc, heads []string) (*automerge.Doc, []string, error) {
+	if len(heads) == 0 {
+		return doc, Heads(doc), nil
+	}
+	hashes := make([]automerge.ChangeHash, 0, len(heads))
+	for _, head := range heads {
+		hash, err := automerge.NewChangeHash(head)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %q is not a change hash", ErrInvalidHeads, head)
+		}
+		// Fork на неизвестном хеше отвечает невнятным "invalid hash", поэтому хеш проверяется заранее
+		_, err = doc.Change(hash)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: change %s is not in the block history", ErrInvalidHeads, head)
+		}
+		hashes = append(hashes, hash)
+	}
+	forked, err := doc.Fork(hashes...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrInvalidHeads, err)
+	}
+	return forked, Heads(forked), nil
+}
+
+// Heads возвращает heads документа в hex
+func Heads(doc *automerge.Doc) []string {
+	hashes := doc.Heads()
+	heads := make([]string, 0, len(hashes))
+	for _, hash := range hashes {
+		heads = append(heads, hash.String())
+	}
+	return heads
+}
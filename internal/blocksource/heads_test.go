@@ -0,0 +1,46 @@
+package blocksource
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/automerge/automerge-go"
+)
+
+func TestAt(t *testing.T) {
+	doc := automerge.New()
+	if err := doc.Path("text").Set(automerge.NewText("x := 1")); err != nil {
+		t.Fatal(err)
+	}
+	first, err := doc.Commit("first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = doc.Path("text").Text().Set("x := 2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = doc.Commit("second"); err != nil {
+		t.Fatal(err)
+	}
+
+	current, heads, err := At(doc, nil)
+	if err != nil || current != doc || len(heads) != 1 || heads[0] == first.String() {
+		t.Fatalf("unexpected current version: %v %v", heads, err)
+	}
+
+	old, heads, err := At(doc, []string{first.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, _ := old.Path("text").Text().Get()
+	if text != "x := 1" || len(heads) != 1 || heads[0] != first.String() {
+		t.Fatalf("unexpected old version %q at %v", text, heads)
+	}
+
+	for _, bad := range []string{"zz", first.String()[:10], strings.Repeat("0", 64)} {
+		if _, _, err = At(doc, []string{bad}); !errors.Is(err, ErrInvalidHeads) {
+			t.Fatalf("heads %q: expected ErrInvalidHeads, got %v", bad, err)
+		}
+	}
+}
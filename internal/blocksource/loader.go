@@ -0,0 +1,147 @@
+package blocksource
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/automerge/automerge-go"
+	"github.com/dnonakolesax/noted-runner/internal/mount"
+)
+
+const blockFilePrefix = "block_"
+
+// Loader достаёт блок блокнота в нужной версии. Ошибки оборачивают ErrNotFound, ErrCorrupt, ErrSchema
+// или ErrInvalidHeads. List возвращает ID блоков ядра по возрастанию, Store создаёт или перезаписывает блок
+type Loader interface {
+	Load(kernelID string, blockID string, heads []string) (Source, error)
+	List(kernelID string) ([]string, error)
+	Store(kernelID string, blockID string, source Source) error
+}
+
+// FileLoader читает блоки из файлов block_<id> в каталогах ядер под корнем монтирования
+type FileLoader struct {
+	root *mount.Root
+}
+
+func NewFileLoader(root *mount.Root) *FileLoader {
+	return &FileLoader{root: root}
+}
+
+func (fl *FileLoader) Load(kernelID string, blockID string, heads []string) (Source, error) {
+	path, err := fl.root.Path(kernelID, blockFilePrefix+blockID)
+	if err != nil {
+		return Source{}, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Source{}, fmt.Errorf("%w: %s", ErrNotFound, blockID)
+	}
+	if err != nil {
+		return Source{}, err
+	}
+	source, err := Decode(data, heads)
+	if err != nil {
+		return Source{}, fmt.Errorf("block %s: %w", blockID, err)
+	}
+	return source, nil
+}
+
+func (fl *FileLoader) List(kernelID string) ([]string, error) {
+	dir, err := fl.root.Path(kernelID)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var blocks []string
+	for _, entry := range entries {
+		name, ok := strings.CutPrefix(entry.Name(), blockFilePrefix)
+		if ok && !entry.IsDir() {
+			blocks = append(blocks, name)
+		}
+	}
+	return blocks, nil
+}
+
+func (fl *FileLoader) Store(kernelID string, blockID string, source Source) error {
+	path, err := fl.root.Path(kernelID, blockFilePrefix+blockID)
+	if err != nil {
+		return err
+	}
+	doc, err := ToDoc(source)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0o777)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, doc.Save(), 0o666)
+}
+
+// MemoryLoader держит документы блоков в памяти: для тестов и блокнотов, которые не лежат на диске
+type MemoryLoader struct {
+	mu   sync.Mutex
+	docs map[string][]byte
+}
+
+func NewMemoryLoader() *MemoryLoader {
+	return &MemoryLoader{docs: make(map[string][]byte)}
+}
+
+// Put сохраняет документ блока; его дальнейшие изменения загрузчик не видит
+func (ml *MemoryLoader) Put(kernelID string, blockID string, doc *automerge.Doc) {
+	ml.PutRaw(kernelID, blockID, doc.Save())
+}
+
+// PutRaw сохраняет байты как есть, в том числе испорченные
+func (ml *MemoryLoader) PutRaw(kernelID string, blockID string, data []byte) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	ml.docs[kernelID+"/"+blockID] = data
+}
+
+func (ml *MemoryLoader) Load(kernelID string, blockID string, heads []string) (Source, error) {
+	ml.mu.Lock()
+	data, ok := ml.docs[kernelID+"/"+blockID]
+	ml.mu.Unlock()
+	if !ok {
+		return Source{}, fmt.Errorf("%w: %s", ErrNotFound, blockID)
+	}
+	source, err := Decode(data, heads)
+	if err != nil {
+		return Source{}, fmt.Errorf("block %s: %w", blockID, err)
+	}
+	return source, nil
+}
+
+func (ml *MemoryLoader) List(kernelID string) ([]string, error) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	var blocks []string
+	for key := range ml.docs {
+		if blockID, ok := strings.CutPrefix(key, kernelID+"/"); ok {
+			blocks = append(blocks, blockID)
+		}
+	}
+	sort.Strings(blocks)
+	return blocks, nil
+}
+
+func (ml *MemoryLoader) Store(kernelID string, blockID string, source Source) error {
+	doc, err := ToDoc(source)
+	if err != nil {
+		return err
+	}
+	ml.Put(kernelID, blockID, doc)
+	return nil
+}
@@ -0,0 +1,171 @@
+package blocksource
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/automerge/automerge-go"
+)
+
+var (
+	// ErrNotFound - файла блока нет
+	ErrNotFound = errors.New("block not found")
+	// ErrCorrupt - файл не читается как документ automerge, например недописан при синхронизации
+	ErrCorrupt = errors.New("block document is corrupt")
+	// ErrSchema - документ читается, но устроен не как блок
+	ErrSchema = errors.New("block document has wrong schema")
+)
+
+// Поля корня документа блока. Обязателен только text: блоки старого формата содержат лишь его
+const (
+	fieldText     = "text"
+	fieldLanguage = "language"
+	fieldTags     = "tags"
+	fieldSettings = "settings"
+)
+
+// Source - текст блока в выбранной версии вместе с метаданными, которые хранятся рядом с text
+type Source struct {
+	Text string
+	// Language - язык блока; пустой у блоков без метаданных, то есть Go
+	Language string
+	Tags     []string
+	// Settings - настройки выполнения блока: имя -> строка, число или bool
+	Settings map[string]any
+	// Heads - версия документа, из которой взят блок
+	Heads []string
+}
+
+// Decode разбирает сохранённый документ блока в версии heads; без heads - в текущей
+func Decode(data []byte, heads []string) (Source, error) {
+	if len(data) == 0 {
+		return Source{}, fmt.Errorf("%w: empty file", ErrCorrupt)
+	}
+	doc, err := automerge.Load(data)
+	if err != nil {
+		return Source{}, fmt.Errorf("%w: %s", ErrCorrupt, err)
+	}
+	return FromDoc(doc, heads)
+}
+
+// FromDoc читает блок из документа в версии heads. Неизвестные поля корня пропускаются,
+// чтобы новые клиенты могли добавлять метаданные раньше, чем их начнёт понимать раннер
+func FromDoc(doc *automerge.Doc, heads []string) (Source, error) {
+	doc, used, err := At(doc, heads)
+	if err != nil {
+		return Source{}, err
+	}
+	fields, err := doc.RootMap().Values()
+	if err != nil {
+		return Source{}, fmt.Errorf("%w: %s", ErrCorrupt, err)
+	}
+
+	source := Source{Heads: used}
+	text, ok := fields[fieldText]
+	if !ok {
+		return Source{}, fmt.Errorf("%w: no %s field", ErrSchema, fieldText)
+	}
+	switch text.Kind() {
+	case automerge.KindText:
+		source.Text, err = text.Text().Get()
+		if err != nil {
+			return Source{}, fmt.Errorf("%w: %s", ErrCorrupt, err)
+		}
+	case automerge.KindStr:
+		source.Text = text.Str()
+	default:
+		return Source{}, fmt.Errorf("%w: %s is %s, expected text", ErrSchema, fieldText, text.Kind())
+	}
+
+	if language, ok := fields[fieldLanguage]; ok {
+		if language.Kind() != automerge.KindStr {
+			return Source{}, fmt.Errorf("%w: %s is %s, expected string", ErrSchema, fieldLanguage, language.Kind())
+		}
+		source.Language = language.Str()
+	}
+	if tags, ok := fields[fieldTags]; ok {
+		source.Tags, err = stringList(tags)
+		if err != nil {
+			return Source{}, err
+		}
+	}
+	if settings, ok := fields[fieldSettings]; ok {
+		source.Settings, err = scalarMap(settings)
+		if err != nil {
+			return Source{}, err
+		}
+	}
+	return source, nil
+}
+
+// ToDoc собирает документ блока из source; Heads не используются
+func ToDoc(source Source) (*automerge.Doc, error) {
+	doc := automerge.New()
+	err := doc.Path(fieldText).Set(automerge.NewText(source.Text))
+	if err != nil {
+		return nil, err
+	}
+	if source.Language != "" {
+		err = doc.Path(fieldLanguage).Set(source.Language)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if source.Tags != nil {
+		err = doc.Path(fieldTags).Set(source.Tags)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if source.Settings != nil {
+		err = doc.Path(fieldSettings).Set(source.Settings)
+		if err != nil {
+			return nil, err
+		}
+	}
+	_, err = doc.Commit("")
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func stringList(v *automerge.Value) ([]string, error) {
+	if v.Kind() != automerge.KindList {
+		return nil, fmt.Errorf("%w: %s is %s, expected list", ErrSchema, fieldTags, v.Kind())
+	}
+	items, err := v.List().Values()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCorrupt, err)
+	}
+	list := make([]string, 0, len(items))
+	for idx, item := range items {
+		if item.Kind() != automerge.KindStr {
+			return nil, fmt.Errorf("%w: %s[%d] is %s, expected string", ErrSchema, fieldTags, idx, item.Kind())
+		}
+		list = append(list, item.Str())
+	}
+	return list, nil
+}
+
+func scalarMap(v *automerge.Value) (map[string]any, error) {
+	if v.Kind() != automerge.KindMap {
+		return nil, fmt.Errorf("%w: %s is %s, expected map", ErrSchema, fieldSettings, v.Kind())
+	}
+	items, err := v.Map().Values()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCorrupt, err)
+	}
+	settings := make(map[string]any, len(items))
+	for name, item := range items {
+		switch item.Kind() {
+		case automerge.KindStr, automerge.KindBool, automerge.KindInt64, automerge.KindUint64,
+			automerge.KindFloat64:
+			settings[name] = item.Interface()
+		default:
+			return nil, fmt.Errorf("%w: %s.%s is %s, expected a string, number or bool", ErrSchema, fieldSettings,
+				name, item.Kind())
+		}
+	}
+	return settings, nil
+}
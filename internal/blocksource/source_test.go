@@ -0,0 +1,133 @@
+package blocksource
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/automerge/automerge-go"
+	"github.com/dnonakolesax/noted-runner/internal/mount"
+)
+
+const (
+	testKernel = "0b3c9a52-6f2e-4d0c-9a57-2f8b1c3d4e5f"
+	testBlock  = "4bcb102d-d663-4bec-86b4-86e978b5b54c"
+)
+
+func newDoc(t *testing.T, fields map[string]any) *automerge.Doc {
+	t.Helper()
+	doc := automerge.New()
+	for name, value := range fields {
+		if err := doc.Path(name).Set(value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return doc
+}
+
+func TestLayouts(t *testing.T) {
+	loader := NewMemoryLoader()
+
+	loader.Put(testKernel, "plain", newDoc(t, map[string]any{"text": automerge.NewText("x := 1")}))
+	source, err := loader.Load(testKernel, "plain", nil)
+	if err != nil || source.Text != "x := 1" || source.Language != "" || len(source.Heads) != 1 {
+		t.Fatalf("unexpected plain block %+v: %v", source, err)
+	}
+
+	loader.Put(testKernel, "str", newDoc(t, map[string]any{"text": "y := 2"}))
+	if source, err = loader.Load(testKernel, "str", nil); err != nil || source.Text != "y := 2" {
+		t.Fatalf("unexpected block with string text %+v: %v", source, err)
+	}
+
+	loader.Put(testKernel, "meta", newDoc(t, map[string]any{
+		"text":     automerge.NewText("fmt.Println(x)"),
+		"language": "go",
+		"tags":     []any{"setup", "slow"},
+		"settings": map[string]any{"timeout": "30s", "retries": int64(2), "cache": true},
+		"cursor":   map[string]any{"line": int64(3)},
+	}))
+	source, err = loader.Load(testKernel, "meta", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Source{Text: "fmt.Println(x)", Language: "go", Tags: []string{"setup", "slow"},
+		Settings: map[string]any{"timeout": "30s", "retries": int64(2), "cache": true}, Heads: source.Heads}
+	if !reflect.DeepEqual(source, want) {
+		t.Fatalf("got %+v, want %+v", source, want)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	loader := NewMemoryLoader()
+	valid := newDoc(t, map[string]any{"text": automerge.NewText("x := 1")}).Save()
+
+	loader.PutRaw(testKernel, "empty", nil)
+	loader.PutRaw(testKernel, "garbage", []byte("not automerge"))
+	loader.PutRaw(testKernel, "truncated", valid[:len(valid)/2])
+	loader.Put(testKernel, "no-text", newDoc(t, map[string]any{"language": "go"}))
+	loader.Put(testKernel, "int-text", newDoc(t, map[string]any{"text": int64(1)}))
+	loader.Put(testKernel, "int-language", newDoc(t, map[string]any{"text": "x", "language": int64(1)}))
+	loader.Put(testKernel, "bad-tags", newDoc(t, map[string]any{"text": "x", "tags": []any{"ok", int64(1)}}))
+	loader.Put(testKernel, "nested-settings", newDoc(t, map[string]any{"text": "x",
+		"settings": map[string]any{"env": map[string]any{"A": "1"}}}))
+	loader.PutRaw(testKernel, "valid", valid)
+
+	cases := map[string]error{
+		"missing":         ErrNotFound,
+		"empty":           ErrCorrupt,
+		"garbage":         ErrCorrupt,
+		"truncated":       ErrCorrupt,
+		"no-text":         ErrSchema,
+		"int-text":        ErrSchema,
+		"int-language":    ErrSchema,
+		"bad-tags":        ErrSchema,
+		"nested-settings": ErrSchema,
+	}
+	for block, want := range cases {
+		if _, err := loader.Load(testKernel, block, nil); !errors.Is(err, want) {
+			t.Errorf("block %s: expected %v, got %v", block, want, err)
+		}
+	}
+	if _, err := loader.Load(testKernel, "valid", []string{"abc"}); !errors.Is(err, ErrInvalidHeads) {
+		t.Errorf("expected ErrInvalidHeads, got %v", err)
+	}
+}
+
+func TestFileLoader(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, testKernel), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	doc := newDoc(t, map[string]any{"text": automerge.NewText("x := 1")})
+	if err := os.WriteFile(filepath.Join(dir, testKernel, "block_"+testBlock), doc.Save(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	loader := NewFileLoader(mount.NewRoot(dir))
+
+	if source, err := loader.Load(testKernel, testBlock, nil); err != nil || source.Text != "x := 1" {
+		t.Fatalf("unexpected block %+v: %v", source, err)
+	}
+	if _, err := loader.Load(testKernel, "4bcb102d-0000-4bec-86b4-86e978b5b54c", nil); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if _, err := loader.Load("..", testBlock, nil); !errors.Is(err, mount.ErrUnsafePath) {
+		t.Fatalf("expected ErrUnsafePath, got %v", err)
+	}
+
+	stored := Source{Text: "# Notes", Language: "markdown", Tags: []string{"intro"}, Settings: map[string]any{"hidden": true}}
+	const other = "0bcb102d-d663-4bec-86b4-86e978b5b54c"
+	if err := loader.Store(testKernel, other, stored); err != nil {
+		t.Fatal(err)
+	}
+	source, err := loader.Load(testKernel, other, nil)
+	if err != nil || source.Text != stored.Text || source.Language != stored.Language ||
+		!slices.Equal(source.Tags, stored.Tags) || source.Settings["hidden"] != true {
+		t.Fatalf("stored block reads back as %+v: %v", source, err)
+	}
+	if blocks, err := loader.List(testKernel); err != nil || !slices.Equal(blocks, []string{other, testBlock}) {
+		t.Fatalf("unexpected blocks %v: %v", blocks, err)
+	}
+}
@@ -0,0 +1,144 @@
+package cluster
+
+import (
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/dnonakolesax/noted-runner/internal/ids"
+	"github.com/dnonakolesax/noted-runner/internal/logger"
+)
+
+const (
+	resultPrefix = "kernel."
+	claimPrefix  = "owner."
+	// ClaimPattern - ключ, по которому каждая реплика слушает захваты ядер другими репликами
+	ClaimPattern = claimPrefix + "*"
+)
+
+// ResultKey - ключ маршрутизации, с которым ядро публикует результаты в обменник
+func ResultKey(kernelID ids.ID) string {
+	return resultPrefix + kernelID.String()
+}
+
+func ClaimKey(kernelID ids.ID) string {
+	return claimPrefix + kernelID.String()
+}
+
+// ParseKey разбирает ключ маршрутизации входящего сообщения
+func ParseKey(key string) (kernelID ids.ID, claim bool, err error) {
+	if rest, ok := strings.CutPrefix(key, claimPrefix); ok {
+		kernelID, err = ids.Parse(rest)
+		return kernelID, true, err
+	}
+	kernelID, err = ids.Parse(strings.TrimPrefix(key, resultPrefix))
+	return kernelID, false, err
+}
+
+type Broker interface {
+	Bind(key string) error
+	Unbind(key string) error
+	Publish(key string, body []byte) error
+}
+
+// Cluster отслеживает, какие ядра принадлежат этой реплике. Реплика получает результаты
+// только тех ядер, которыми владеет, а захват ядра другой репликой (переподключение
+// пользователя к ней) освобождает его здесь
+type Cluster struct {
+	instanceID string
+	broker     Broker
+	mu         sync.Mutex
+	owned      map[ids.ID]bool
+	onRelease  func(kernelID ids.ID)
+	logger     *slog.Logger
+}
+
+func NewCluster(instanceID string, broker Broker, logger *slog.Logger) *Cluster {
+	return &Cluster{instanceID: instanceID, broker: broker, owned: make(map[ids.ID]bool), logger: logger}
+}
+
+func (c *Cluster) InstanceID() string {
+	return c.instanceID
+}
+
+// OnRelease задаёт обработчик, вызываемый, когда ядро забрала другая реплика
+func (c *Cluster) OnRelease(f func(kernelID ids.ID)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.onRelease = f
+}
+
+// Claim подписывает реплику на результаты ядра и сообщает остальным репликам о захвате
+func (c *Cluster) Claim(kernelID ids.ID) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.owned[kernelID] {
+		err := c.broker.Bind(ResultKey(kernelID))
+		if err != nil {
+			return err
+		}
+		c.owned[kernelID] = true
+	}
+	return c.broker.Publish(ClaimKey(kernelID), []byte(c.instanceID))
+}
+
+// Release отписывает реплику от результатов ядра
+func (c *Cluster) Release(kernelID ids.ID) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.release(kernelID)
+}
+
+func (c *Cluster) Owns(kernelID ids.ID) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.owned[kernelID]
+}
+
+// Owned возвращает ядра реплики, например чтобы заново привязать их после переподключения к брокеру
+func (c *Cluster) Owned() []ids.ID {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	owned := make([]ids.ID, 0, len(c.owned))
+	for kernelID := range c.owned {
+		owned = append(owned, kernelID)
+	}
+	return owned
+}
+
+// HandleClaim обрабатывает захват ядра репликой instanceID
+func (c *Cluster) HandleClaim(kernelID ids.ID, instanceID string) {
+	if instanceID == c.instanceID {
+		return
+	}
+
+	c.mu.Lock()
+	if !c.owned[kernelID] {
+		c.mu.Unlock()
+		return
+	}
+	err := c.release(kernelID)
+	onRelease := c.onRelease
+	c.mu.Unlock()
+
+	if err != nil {
+		c.logger.Error("error releasing kernel", logger.LogError(err), slog.String("kernel", kernelID.String()))
+	}
+	c.logger.Info("kernel taken over", slog.String("kernel", kernelID.String()), slog.String("by", instanceID))
+	if onRelease != nil {
+		onRelease(kernelID)
+	}
+}
+
+func (c *Cluster) release(kernelID ids.ID) error {
+	if !c.owned[kernelID] {
+		return nil
+	}
+	delete(c.owned, kernelID)
+	return c.broker.Unbind(ResultKey(kernelID))
+}
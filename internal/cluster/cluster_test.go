@@ -0,0 +1,106 @@
+package cluster
+
+import (
+	"log/slog"
+	"sync"
+	"testing"
+
+	"github.com/dnonakolesax/noted-runner/internal/ids"
+)
+
+// fakeBroker доставляет захваты всем репликам, как topic-обменник с ключом owner.*
+type fakeBroker struct {
+	mu       sync.Mutex
+	bindings map[string]bool
+	peers    *[]*Cluster
+}
+
+func (fb *fakeBroker) Bind(key string) error {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	fb.bindings[key] = true
+	return nil
+}
+
+func (fb *fakeBroker) Unbind(key string) error {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	delete(fb.bindings, key)
+	return nil
+}
+
+func (fb *fakeBroker) Publish(key string, body []byte) error {
+	kernelID, claim, err := ParseKey(key)
+	if err != nil || !claim {
+		return err
+	}
+	for _, peer := range *fb.peers {
+		peer.HandleClaim(kernelID, string(body))
+	}
+	return nil
+}
+
+func (fb *fakeBroker) bound(key string) bool {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	return fb.bindings[key]
+}
+
+func TestTakeover(t *testing.T) {
+	var peers []*Cluster
+	brokerA := &fakeBroker{bindings: map[string]bool{}, peers: &peers}
+	brokerB := &fakeBroker{bindings: map[string]bool{}, peers: &peers}
+	a := NewCluster("a", brokerA, slog.Default())
+	b := NewCluster("b", brokerB, slog.Default())
+	peers = []*Cluster{a, b}
+
+	var released []ids.ID
+	a.OnRelease(func(kernelID ids.ID) { released = append(released, kernelID) })
+
+	kernelID := ids.MustParse("0b3c9a52-6f2e-4d0c-9a57-2f8b1c3d4e5f")
+	if err := a.Claim(kernelID); err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+	if !a.Owns(kernelID) || !brokerA.bound(ResultKey(kernelID)) {
+		t.Fatalf("a doesn't own the kernel after claim")
+	}
+	if len(released) != 0 {
+		t.Fatalf("own claim released the kernel")
+	}
+
+	if err := b.Claim(kernelID); err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+	if a.Owns(kernelID) || brokerA.bound(ResultKey(kernelID)) {
+		t.Fatalf("a still owns the kernel after takeover")
+	}
+	if !b.Owns(kernelID) || !brokerB.bound(ResultKey(kernelID)) {
+		t.Fatalf("b doesn't own the kernel after takeover")
+	}
+	if len(released) != 1 || released[0] != kernelID {
+		t.Fatalf("a wasn't notified about takeover: %v", released)
+	}
+
+	if err := b.Release(kernelID); err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+	if b.Owns(kernelID) || brokerB.bound(ResultKey(kernelID)) || len(b.Owned()) != 0 {
+		t.Fatalf("b still owns the kernel after release")
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	kernelID := ids.MustParse("0b3c9a52-6f2e-4d0c-9a57-2f8b1c3d4e5f")
+
+	parsed, claim, err := ParseKey(ResultKey(kernelID))
+	if err != nil || claim || parsed != kernelID {
+		t.Fatalf("result key: %v %v %v", parsed, claim, err)
+	}
+	parsed, claim, err = ParseKey(ClaimKey(kernelID))
+	if err != nil || !claim || parsed != kernelID {
+		t.Fatalf("claim key: %v %v %v", parsed, claim, err)
+	}
+	if _, _, err = ParseKey("owner.../../x"); err == nil {
+		t.Fatalf("malformed claim key accepted")
+	}
+}
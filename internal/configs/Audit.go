@@ -0,0 +1,37 @@
+package configs
+
+import (
+	"time"
+
+	"github.com/dnonakolesax/viper"
+)
+
+const (
+	auditDirKey             = "audit.dir"
+	auditDirDefault         = "/noted/audit"
+	auditRetentionKey       = "audit.retention"
+	auditRetentionDefault   = 30 * 24 * time.Hour
+	auditOutputLimitKey     = "audit.output-limit"
+	auditOutputLimitDefault = 4096
+)
+
+type AuditConfig struct {
+	// Dir - каталог с журналами выполнений и событий
+	Dir string
+	// Retention - сколько хранить записи; 0 - хранить всегда
+	Retention time.Duration
+	// OutputLimit - сколько байт вывода блока сохранять в записи о выполнении
+	OutputLimit int
+}
+
+func (ac *AuditConfig) SetDefaults(v *viper.Viper) {
+	v.SetDefault(auditDirKey, auditDirDefault)
+	v.SetDefault(auditRetentionKey, auditRetentionDefault)
+	v.SetDefault(auditOutputLimitKey, auditOutputLimitDefault)
+}
+
+func (ac *AuditConfig) Load(v *viper.Viper) {
+	ac.Dir = v.GetString(auditDirKey)
+	ac.Retention = v.GetDuration(auditRetentionKey)
+	ac.OutputLimit = v.GetInt(auditOutputLimitKey)
+}
@@ -0,0 +1,47 @@
+package configs
+
+import (
+	"time"
+
+	"github.com/dnonakolesax/viper"
+)
+
+const (
+	CommandsTransportRabbit = "rabbit"
+	CommandsTransportHTTP   = "http"
+	// CommandsTransportRabbitHTTP - RabbitMQ, а при его недоступности HTTP
+	CommandsTransportRabbitHTTP = "rabbit+http"
+)
+
+const (
+	commandsTransportKey      = "commands.transport"
+	commandsTransportDefault  = CommandsTransportRabbitHTTP
+	commandsTimeoutKey        = "commands.timeout"
+	commandsTimeoutDefault    = 5 * time.Second
+	commandsRetriesKey        = "commands.retries"
+	commandsRetriesDefault    = 3
+	commandsRetryDelayKey     = "commands.retry-delay"
+	commandsRetryDelayDefault = 500 * time.Millisecond
+)
+
+type CommandsConfig struct {
+	Transport string
+	// Timeout - сколько ждать подтверждения команды от ядра в одной попытке
+	Timeout    time.Duration
+	Retries    int
+	RetryDelay time.Duration
+}
+
+func (cc *CommandsConfig) SetDefaults(v *viper.Viper) {
+	v.SetDefault(commandsTransportKey, commandsTransportDefault)
+	v.SetDefault(commandsTimeoutKey, commandsTimeoutDefault)
+	v.SetDefault(commandsRetriesKey, commandsRetriesDefault)
+	v.SetDefault(commandsRetryDelayKey, commandsRetryDelayDefault)
+}
+
+func (cc *CommandsConfig) Load(v *viper.Viper) {
+	cc.Transport = v.GetString(commandsTransportKey)
+	cc.Timeout = v.GetDuration(commandsTimeoutKey)
+	cc.Retries = v.GetInt(commandsRetriesKey)
+	cc.RetryDelay = v.GetDuration(commandsRetryDelayKey)
+}
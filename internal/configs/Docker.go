@@ -39,6 +39,8 @@ const (
 	envBlockPrefixDefault  = "block_"
 	envChanNameKey         = "docker.env.chan_name"
 	envChanNameDefault     = "noted-kernels"
+	envExchangeKey         = "docker.env.exchange"
+	envExchangeDefault     = "noted-kernels"
 	envBlockTimeoutKey     = "docker.env.block_timeout"
 	envBlockTimeoutDefault = 30 * time.Second
 )
@@ -49,6 +51,7 @@ type EnvConfig struct {
 	ExportPrefix string
 	BlockPrefix  string
 	ChanName     string
+	Exchange     string
 	BlockTimeout time.Duration
 }
 
@@ -58,6 +61,7 @@ func (ec *EnvConfig) SetDefaults(v *viper.Viper) {
 	v.SetDefault(envExportPrefixKey, envExportPrefixDefault)
 	v.SetDefault(envBlockPrefixKey, envBlockPrefixDefault)
 	v.SetDefault(envChanNameKey, envChanNameDefault)
+	v.SetDefault(envExchangeKey, envExchangeDefault)
 	v.SetDefault(envBlockTimeoutKey, envBlockTimeoutDefault)
 }
 
@@ -67,6 +71,7 @@ func (ec *EnvConfig) Load(v *viper.Viper) {
 	ec.ExportPrefix = v.GetString(envExportPrefixKey)
 	ec.BlockPrefix = v.GetString(envBlockPrefixKey)
 	ec.ChanName = v.GetString(envChanNameKey)
+	ec.Exchange = v.GetString(envExchangeKey)
 	ec.BlockTimeout = v.GetDuration(envBlockTimeoutKey)
 }
 
@@ -0,0 +1,37 @@
+package configs
+
+import (
+	"time"
+
+	"github.com/dnonakolesax/viper"
+)
+
+const (
+	magicsEnabledKey       = "magics.enabled"
+	magicsEnvKey           = "magics.env"
+	magicsTimeitRunKey     = "magics.timeit-run"
+	magicsTimeitRunDefault = 200 * time.Millisecond
+)
+
+var magicsEnabledDefault = []string{"time", "timeit", "env", "reset", "who"}
+
+type MagicsConfig struct {
+	// Enabled - магические команды, доступные блокам (без %)
+	Enabled []string
+	// Env - переменные окружения ядра, которые можно читать и менять через %env
+	Env []string
+	// TimeitRun - сколько должен длиться один замер %timeit; по нему подбирается число повторов
+	TimeitRun time.Duration
+}
+
+func (mc *MagicsConfig) SetDefaults(v *viper.Viper) {
+	v.SetDefault(magicsEnabledKey, magicsEnabledDefault)
+	v.SetDefault(magicsEnvKey, []string{})
+	v.SetDefault(magicsTimeitRunKey, magicsTimeitRunDefault)
+}
+
+func (mc *MagicsConfig) Load(v *viper.Viper) {
+	mc.Enabled = v.GetStringSlice(magicsEnabledKey)
+	mc.Env = v.GetStringSlice(magicsEnvKey)
+	mc.TimeitRun = v.GetDuration(magicsTimeitRunKey)
+}
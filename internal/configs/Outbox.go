@@ -0,0 +1,29 @@
+package configs
+
+import (
+	"github.com/dnonakolesax/viper"
+)
+
+const (
+	outboxMemoryLimitKey     = "outbox.memory-limit"
+	outboxMemoryLimitDefault = 256
+	outboxDiskLimitKey       = "outbox.disk-limit"
+	outboxDiskLimitDefault   = 0
+)
+
+type OutboxConfig struct {
+	// MemoryLimit - сколько результатов на ядро держать в памяти, пока клиент отключён
+	MemoryLimit int
+	// DiskLimit - сколько результатов сверх этого сбрасывать на диск; 0 - не сбрасывать
+	DiskLimit int
+}
+
+func (oc *OutboxConfig) SetDefaults(v *viper.Viper) {
+	v.SetDefault(outboxMemoryLimitKey, outboxMemoryLimitDefault)
+	v.SetDefault(outboxDiskLimitKey, outboxDiskLimitDefault)
+}
+
+func (oc *OutboxConfig) Load(v *viper.Viper) {
+	oc.MemoryLimit = v.GetInt(outboxMemoryLimitKey)
+	oc.DiskLimit = v.GetInt(outboxDiskLimitKey)
+}
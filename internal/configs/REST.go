@@ -0,0 +1,37 @@
+package configs
+
+import (
+	"time"
+
+	"github.com/dnonakolesax/viper"
+)
+
+const (
+	restSyncTimeoutKey        = "rest.sync-timeout"
+	restSyncTimeoutDefault    = 30 * time.Second
+	restMaxSyncTimeoutKey     = "rest.max-sync-timeout"
+	restMaxSyncTimeoutDefault = 5 * time.Minute
+	restExecutionTTLKey       = "rest.execution-ttl"
+	restExecutionTTLDefault   = time.Hour
+)
+
+type RESTConfig struct {
+	// SyncTimeout - сколько синхронный запуск блока ждёт результата, если клиент не указал своё время
+	SyncTimeout time.Duration
+	// MaxSyncTimeout - верхняя граница времени ожидания, которое может попросить клиент
+	MaxSyncTimeout time.Duration
+	// ExecutionTTL - сколько хранить результат завершённого запуска
+	ExecutionTTL time.Duration
+}
+
+func (rc *RESTConfig) SetDefaults(v *viper.Viper) {
+	v.SetDefault(restSyncTimeoutKey, restSyncTimeoutDefault)
+	v.SetDefault(restMaxSyncTimeoutKey, restMaxSyncTimeoutDefault)
+	v.SetDefault(restExecutionTTLKey, restExecutionTTLDefault)
+}
+
+func (rc *RESTConfig) Load(v *viper.Viper) {
+	rc.SyncTimeout = v.GetDuration(restSyncTimeoutKey)
+	rc.MaxSyncTimeout = v.GetDuration(restMaxSyncTimeoutKey)
+	rc.ExecutionTTL = v.GetDuration(restExecutionTTLKey)
+}
@@ -0,0 +1,40 @@
+package configs
+
+import (
+	"time"
+
+	"github.com/dnonakolesax/viper"
+)
+
+const (
+	rabbitReconnectBaseDelayKey     = "rabbit.reconnect.base-delay"
+	rabbitReconnectBaseDelayDefault = 500 * time.Millisecond
+	rabbitReconnectMaxDelayKey      = "rabbit.reconnect.max-delay"
+	rabbitReconnectMaxDelayDefault  = 30 * time.Second
+	rabbitPrefetchKey               = "rabbit.prefetch"
+	rabbitPrefetchDefault           = 64
+	rabbitQueueExpiresKey           = "rabbit.queue-expires"
+	rabbitQueueExpiresDefault       = 30 * time.Minute
+)
+
+type RabbitConfig struct {
+	ReconnectBaseDelay time.Duration
+	ReconnectMaxDelay  time.Duration
+	Prefetch           int
+	// QueueExpires - через сколько брокер удалит очередь реплики, которая перестала её слушать
+	QueueExpires time.Duration
+}
+
+func (rc *RabbitConfig) SetDefaults(v *viper.Viper) {
+	v.SetDefault(rabbitReconnectBaseDelayKey, rabbitReconnectBaseDelayDefault)
+	v.SetDefault(rabbitReconnectMaxDelayKey, rabbitReconnectMaxDelayDefault)
+	v.SetDefault(rabbitPrefetchKey, rabbitPrefetchDefault)
+	v.SetDefault(rabbitQueueExpiresKey, rabbitQueueExpiresDefault)
+}
+
+func (rc *RabbitConfig) Load(v *viper.Viper) {
+	rc.ReconnectBaseDelay = v.GetDuration(rabbitReconnectBaseDelayKey)
+	rc.ReconnectMaxDelay = v.GetDuration(rabbitReconnectMaxDelayKey)
+	rc.Prefetch = v.GetInt(rabbitPrefetchKey)
+	rc.QueueExpires = v.GetDuration(rabbitQueueExpiresKey)
+}
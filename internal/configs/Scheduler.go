@@ -0,0 +1,43 @@
+package configs
+
+import (
+	"time"
+
+	"github.com/dnonakolesax/viper"
+)
+
+const (
+	schedulerDirKey               = "scheduler.dir"
+	schedulerDirDefault           = "/noted/schedules"
+	schedulerMaxConcurrentKey     = "scheduler.max-concurrent"
+	schedulerMaxConcurrentDefault = 2
+	schedulerHistoryKey           = "scheduler.history"
+	schedulerHistoryDefault       = 50
+	schedulerBlockTimeoutKey      = "scheduler.block-timeout"
+	schedulerBlockTimeoutDefault  = 10 * time.Minute
+)
+
+type SchedulerConfig struct {
+	// Dir - каталог с расписаниями и историей запусков
+	Dir string
+	// MaxConcurrent - сколько запусков по расписанию идёт одновременно, остальные ждут очереди
+	MaxConcurrent int
+	// History - сколько последних запусков хранить на расписание
+	History int
+	// BlockTimeout - сколько ждать результата одного блока, прежде чем считать запуск проваленным
+	BlockTimeout time.Duration
+}
+
+func (sc *SchedulerConfig) SetDefaults(v *viper.Viper) {
+	v.SetDefault(schedulerDirKey, schedulerDirDefault)
+	v.SetDefault(schedulerMaxConcurrentKey, schedulerMaxConcurrentDefault)
+	v.SetDefault(schedulerHistoryKey, schedulerHistoryDefault)
+	v.SetDefault(schedulerBlockTimeoutKey, schedulerBlockTimeoutDefault)
+}
+
+func (sc *SchedulerConfig) Load(v *viper.Viper) {
+	sc.Dir = v.GetString(schedulerDirKey)
+	sc.MaxConcurrent = v.GetInt(schedulerMaxConcurrentKey)
+	sc.History = v.GetInt(schedulerHistoryKey)
+	sc.BlockTimeout = v.GetDuration(schedulerBlockTimeoutKey)
+}
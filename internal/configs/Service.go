@@ -1,6 +1,7 @@
 package configs
 
 import (
+	"os"
 	"time"
 
 	"github.com/dnonakolesax/viper"
@@ -13,12 +14,16 @@ const (
 	serviceBasePathDefault        = "/compiler"
 	serviceMetricsPortKey         = "service.metrics-port"
 	serviceMetricsPortDefault     = 8801
+	serviceGRPCPortKey            = "service.grpc-port"
+	serviceGRPCPortDefault        = 8703
 	serviceMetricsEndpointKey     = "service.metrics-endpoint"
 	serviceMetricsEndpointDefault = "/metrics"
 	serviceCompileTimeoutKey      = "service.compile-timeout"
 	serviceCompileTimeoutDefault  = time.Second * 30
 	serviceCMDTimeoutKey          = "service.cmd-timeout"
 	serviceCMDTimeoutDefault      = time.Second * 10
+	serviceInstanceIDKey          = "service.instance-id"
+	serviceAdminsKey              = "service.admins"
 )
 
 type ServiceConfig struct {
@@ -28,12 +33,19 @@ type ServiceConfig struct {
 	MetricsEndpoint string
 	CompileTimeout  time.Duration
 	CMDTimeout      time.Duration
+	// GRPCPort - порт gRPC-сервиса раннера для других бэкендов
+	GRPCPort int
+	// InstanceID - имя реплики раннера; по умолчанию имя хоста
+	InstanceID string
+	// Admins - ID пользователей, которым доступны служебные ручки
+	Admins []string
 }
 
 func (sc *ServiceConfig) SetDefaults(v *viper.Viper) {
 	v.SetDefault(servicePortKey, servicePortDefault)
 	v.SetDefault(serviceBasePathKey, serviceBasePathDefault)
 	v.SetDefault(serviceMetricsPortKey, serviceMetricsPortDefault)
+	v.SetDefault(serviceGRPCPortKey, serviceGRPCPortDefault)
 	v.SetDefault(serviceMetricsEndpointKey, serviceMetricsEndpointDefault)
 	v.SetDefault(serviceCompileTimeoutKey, serviceCompileTimeoutDefault)
 	v.SetDefault(serviceCMDTimeoutKey, serviceCMDTimeoutDefault)
@@ -43,7 +55,13 @@ func (sc *ServiceConfig) Load(v *viper.Viper) {
 	sc.Port = v.GetInt(servicePortKey)
 	sc.BasePath = v.GetString(serviceBasePathKey)
 	sc.MetricsPort = v.GetInt(serviceMetricsPortKey)
+	sc.GRPCPort = v.GetInt(serviceGRPCPortKey)
 	sc.MetricsEndpoint = v.GetString(serviceMetricsEndpointKey)
 	sc.CompileTimeout = v.GetDuration(serviceCompileTimeoutKey)
 	sc.CMDTimeout = v.GetDuration(serviceCMDTimeoutKey)
+	sc.Admins = v.GetStringSlice(serviceAdminsKey)
+	sc.InstanceID = v.GetString(serviceInstanceIDKey)
+	if sc.InstanceID == "" {
+		sc.InstanceID, _ = os.Hostname()
+	}
 }
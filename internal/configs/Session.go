@@ -0,0 +1,31 @@
+package configs
+
+import (
+	"time"
+
+	"github.com/dnonakolesax/viper"
+)
+
+const (
+	sessionGracePeriodKey      = "session.grace-period"
+	sessionGracePeriodDefault  = 2 * time.Minute
+	sessionReplayBufferKey     = "session.replay-buffer"
+	sessionReplayBufferDefault = 256
+)
+
+type SessionConfig struct {
+	// GracePeriod - сколько ядро живёт после обрыва соединения в ожидании переподключения; 0 - не ждать
+	GracePeriod time.Duration
+	// ReplayBuffer - сколько последних отправленных сообщений помнить для повторной отправки
+	ReplayBuffer int
+}
+
+func (sc *SessionConfig) SetDefaults(v *viper.Viper) {
+	v.SetDefault(sessionGracePeriodKey, sessionGracePeriodDefault)
+	v.SetDefault(sessionReplayBufferKey, sessionReplayBufferDefault)
+}
+
+func (sc *SessionConfig) Load(v *viper.Viper) {
+	sc.GracePeriod = v.GetDuration(sessionGracePeriodKey)
+	sc.ReplayBuffer = v.GetInt(sessionReplayBufferKey)
+}
@@ -0,0 +1,37 @@
+package configs
+
+import (
+	"time"
+
+	"github.com/dnonakolesax/viper"
+)
+
+const (
+	websocketPingIntervalKey     = "websocket.ping-interval"
+	websocketPingIntervalDefault = 30 * time.Second
+	websocketPongWaitKey         = "websocket.pong-wait"
+	websocketPongWaitDefault     = 60 * time.Second
+	websocketWriteWaitKey        = "websocket.write-wait"
+	websocketWriteWaitDefault    = 10 * time.Second
+)
+
+type WebSocketConfig struct {
+	// PingInterval - как часто слать клиенту ping; должен быть меньше PongWait
+	PingInterval time.Duration
+	// PongWait - сколько ждать от клиента pong или любое сообщение, прежде чем считать соединение мёртвым
+	PongWait time.Duration
+	// WriteWait - сколько ждать записи одного сообщения в сокет
+	WriteWait time.Duration
+}
+
+func (wc *WebSocketConfig) SetDefaults(v *viper.Viper) {
+	v.SetDefault(websocketPingIntervalKey, websocketPingIntervalDefault)
+	v.SetDefault(websocketPongWaitKey, websocketPongWaitDefault)
+	v.SetDefault(websocketWriteWaitKey, websocketWriteWaitDefault)
+}
+
+func (wc *WebSocketConfig) Load(v *viper.Viper) {
+	wc.PingInterval = v.GetDuration(websocketPingIntervalKey)
+	wc.PongWait = v.GetDuration(websocketPongWaitKey)
+	wc.WriteWait = v.GetDuration(websocketWriteWaitKey)
+}
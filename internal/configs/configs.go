@@ -11,6 +11,16 @@ import (
 
 type Config struct {
 	Docker *DockerConfig
+	Rabbit *RabbitConfig
+
+	Commands  *CommandsConfig
+	Outbox    *OutboxConfig
+	Session   *SessionConfig
+	WebSocket *WebSocketConfig
+	REST      *RESTConfig
+	Scheduler *SchedulerConfig
+	Audit     *AuditConfig
+	Magics    *MagicsConfig
 
 	HTTPClient *HTTPClientConfig
 	HTTPServer *HTTPServerConfig
@@ -33,8 +43,19 @@ func SetupConfigs(initLogger *slog.Logger, configsDir string) (*Config, error) {
 	httpClientConfig := &HTTPClientConfig{}
 	loggerConfig := &LoggerConfig{}
 	dockerConfig := &DockerConfig{}
+	rabbitConfig := &RabbitConfig{}
+	commandsConfig := &CommandsConfig{}
+	outboxConfig := &OutboxConfig{}
+	sessionConfig := &SessionConfig{}
+	websocketConfig := &WebSocketConfig{}
+	restConfig := &RESTConfig{}
+	schedulerConfig := &SchedulerConfig{}
+	auditConfig := &AuditConfig{}
+	magicsConfig := &MagicsConfig{}
 
-	err = Load(configsDir, v, initLogger, appConfig, serverConfig, httpClientConfig, loggerConfig, dockerConfig)
+	err = Load(configsDir, v, initLogger, appConfig, serverConfig, httpClientConfig, loggerConfig, dockerConfig,
+		rabbitConfig, commandsConfig, outboxConfig, sessionConfig, websocketConfig,
+		restConfig, schedulerConfig, auditConfig, magicsConfig)
 
 	if err != nil {
 		initLogger.ErrorContext(context.Background(), "Error loading config",
@@ -45,6 +66,16 @@ func SetupConfigs(initLogger *slog.Logger, configsDir string) (*Config, error) {
 	
 	return &Config{
 		Docker: dockerConfig,
+		Rabbit: rabbitConfig,
+
+		Commands:  commandsConfig,
+		Outbox:    outboxConfig,
+		Session:   sessionConfig,
+		WebSocket: websocketConfig,
+		REST:      restConfig,
+		Scheduler: schedulerConfig,
+		Audit:     auditConfig,
+		Magics:    magicsConfig,
 
 		HTTPClient: httpClientConfig,
 		HTTPServer: serverConfig,
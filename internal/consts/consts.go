@@ -22,4 +22,16 @@ const (
 
 const (
 	CtxUserIDKey = "user_id"
-)
\ No newline at end of file
+)
+
+// Ключи метаданных gRPC: токены передаются в них вместо cookie
+const (
+	GRPCATMetaKey    = "access-token"
+	GRPCRTMetaKey    = "refresh-token"
+	GRPCIDTMetaKey   = "id-token"
+	GRPCTraceMetaKey = "trace_id"
+)
+
+const (
+	UserIDContextKey ContextKey = "user_id"
+)
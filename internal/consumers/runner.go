@@ -2,35 +2,125 @@ package consumers
 
 import (
 	"encoding/json"
+	"errors"
 	"log/slog"
+	"time"
 
-	compilerDelivery "github.com/dnonakolesax/noted-runner/internal/delivery/compiler/v1/http"
+	"github.com/dnonakolesax/noted-runner/internal/cluster"
+	"github.com/dnonakolesax/noted-runner/internal/ids"
 	"github.com/dnonakolesax/noted-runner/internal/logger"
 	"github.com/dnonakolesax/noted-runner/internal/model"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+type ResultSender interface {
+	SendMemes(kernelID string, memes string) error
+}
+
+type ClaimHandler interface {
+	HandleClaim(kernelID ids.ID, instanceID string)
+}
+
+type ReplyHandler interface {
+	HandleReply(msg amqp.Delivery) bool
+}
+
+type DeadLetterSender interface {
+	Send(key string, msg amqp.Publishing) error
+}
+
 type RunnerConsumer struct {
-	messages  <-chan amqp.Delivery
-	delivery *compilerDelivery.ComilerDelivery
-	logger *slog.Logger
+	messages    <-chan amqp.Delivery
+	delivery    ResultSender
+	cluster     ClaimHandler
+	replies     ReplyHandler
+	deadLetters DeadLetterSender
+	logger      *slog.Logger
 }
 
-func NewRunnerConsumer(messages <-chan amqp.Delivery, delivery *compilerDelivery.ComilerDelivery, logger *slog.Logger) *RunnerConsumer {
-	return &RunnerConsumer{messages: messages, delivery: delivery, logger: logger}
+func NewRunnerConsumer(messages <-chan amqp.Delivery, delivery ResultSender,
+	cl ClaimHandler, replies ReplyHandler, deadLetters DeadLetterSender, logger *slog.Logger) *RunnerConsumer {
+	return &RunnerConsumer{messages: messages, delivery: delivery, cluster: cl, replies: replies,
+		deadLetters: deadLetters, logger: logger}
 }
 
+// Consume подтверждает результат только после того, как он ушёл в сокет клиента.
+// Если брокер упадёт раньше, он отдаст сообщение заново после переподключения. Результат, который
+// уже засчитан запуску, в очередь не возвращается, а уходит в мёртвые письма
 func (rc *RunnerConsumer) Consume() {
 	for msg := range rc.messages {
-		rc.logger.Info("received rmq message")
+		rc.logger.Info("received rmq message", slog.String("key", msg.RoutingKey))
+		if msg.CorrelationId != "" {
+			// ответ ядра на команду: приходит напрямую в очередь реплики
+			if !rc.replies.HandleReply(msg) {
+				rc.logger.Warn("unexpected kernel reply", slog.String("correlation id", msg.CorrelationId))
+			}
+			rc.ack(msg)
+			continue
+		}
+
+		kernelID, claim, err := cluster.ParseKey(msg.RoutingKey)
+		if err == nil && claim {
+			rc.cluster.HandleClaim(kernelID, string(msg.Body))
+			rc.ack(msg)
+			continue
+		}
+
 		var kmessage model.KernelMessage
-		err := json.Unmarshal(msg.Body, &kmessage)
+		err = json.Unmarshal(msg.Body, &kmessage)
 
 		if err != nil {
 			rc.logger.Error("error unmarshaling kernel data", logger.LogError(err))
+			rc.deadLetter(msg, err)
 			continue
 		}
 
-		rc.delivery.SendMemes(kmessage.KernelID, string(msg.Body))
+		// если клиент отключён, доставка сама складывает результат в outbox
+		err = rc.delivery.SendMemes(kmessage.KernelID, string(msg.Body))
+		if errors.Is(err, model.ErrCompleted) {
+			rc.logger.Error("error delivering completed result", logger.LogError(err))
+			rc.deadLetter(msg, err)
+			continue
+		}
+		if err != nil {
+			// один повтор: outbox мог быть переполнен, а клиент - как раз переподключаться
+			rc.nack(msg, !msg.Redelivered)
+			continue
+		}
+		rc.ack(msg)
+	}
+}
+
+// deadLetter перекладывает сообщение в очередь мёртвых писем вместе с ошибкой разбора или доставки.
+// Если опубликовать не удалось, сообщение отклоняется без повтора
+func (rc *RunnerConsumer) deadLetter(msg amqp.Delivery, cause error) {
+	err := rc.deadLetters.Send(model.DeadLetterKey, amqp.Publishing{
+		ContentType: msg.ContentType,
+		Timestamp:   time.Now(),
+		Headers: amqp.Table{
+			model.HeaderParseError:  cause.Error(),
+			model.HeaderOriginalKey: msg.RoutingKey,
+		},
+		Body: msg.Body,
+	})
+	if err != nil {
+		rc.logger.Error("error publishing dead letter", logger.LogError(err))
+		rc.nack(msg, false)
+		return
+	}
+	rc.ack(msg)
+}
+
+func (rc *RunnerConsumer) ack(msg amqp.Delivery) {
+	err := msg.Ack(false)
+	if err != nil {
+		rc.logger.Error("error acking rmq message", logger.LogError(err))
+	}
+}
+
+func (rc *RunnerConsumer) nack(msg amqp.Delivery, requeue bool) {
+	err := msg.Nack(false, requeue)
+	if err != nil {
+		rc.logger.Error("error nacking rmq message", logger.LogError(err))
 	}
 }
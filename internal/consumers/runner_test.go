@@ -0,0 +1,134 @@
+package consumers
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dnonakolesax/noted-runner/internal/configs"
+	"github.com/dnonakolesax/noted-runner/internal/ids"
+	"github.com/dnonakolesax/noted-runner/internal/model"
+	"github.com/dnonakolesax/noted-runner/internal/rabbit"
+	"github.com/dnonakolesax/noted-runner/internal/rabbit/rabbittest"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	exchange = "noted-kernels"
+	kernelID = "0b3c9a52-6f2e-4d0c-9a57-2f8b1c3d4e5f"
+)
+
+type fakeSender struct {
+	mu     sync.Mutex
+	online bool
+	// completed - результат засчитан запуску до того, как его не удалось доставить
+	completed bool
+	sent      []string
+}
+
+func (fs *fakeSender) SendMemes(_ string, memes string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if !fs.online && fs.completed {
+		return errors.Join(model.ErrCompleted, errors.New("offline"))
+	}
+	if !fs.online {
+		return errors.New("offline")
+	}
+	fs.sent = append(fs.sent, memes)
+	return nil
+}
+
+type fakeClaims struct {
+	claims atomic.Int64
+}
+
+func (fc *fakeClaims) HandleClaim(_ ids.ID, _ string) {
+	fc.claims.Add(1)
+}
+
+type fakeReplies struct {
+	replies atomic.Int64
+}
+
+func (fr *fakeReplies) HandleReply(_ amqp.Delivery) bool {
+	fr.replies.Add(1)
+	return true
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestConsumeAcks(t *testing.T) {
+	server := rabbittest.NewServer()
+	healthy := &atomic.Bool{}
+	rq := rabbit.NewRabbit("amqp://test", rabbit.Topology{Exchange: exchange, Queue: "noted-runner.test",
+		Keys: []string{"owner.*", "kernel." + kernelID}, Shared: map[string]string{model.DeadLetterQueue: model.DeadLetterKey}},
+		&configs.RabbitConfig{ReconnectBaseDelay: time.Millisecond, ReconnectMaxDelay: time.Millisecond, Prefetch: 8},
+		server.Dial, healthy, slog.Default())
+	defer rq.Close()
+
+	sender := &fakeSender{online: true}
+	claims := &fakeClaims{}
+	replies := &fakeReplies{}
+	go NewRunnerConsumer(rq.Queue, sender, claims, replies, rq, slog.Default()).Consume()
+	waitFor(t, "connection", healthy.Load)
+
+	result := `{"kernel_id":"` + kernelID + `","result":"42"}`
+	server.Publish(exchange, "kernel."+kernelID, amqp.Publishing{Body: []byte(result)})
+	waitFor(t, "ack of a delivered result", func() bool { return server.Acked() == 1 })
+
+	server.Publish(exchange, "owner."+kernelID, amqp.Publishing{Body: []byte("other-replica")})
+	waitFor(t, "ack of a claim", func() bool { return server.Acked() == 2 })
+	if claims.claims.Load() != 1 {
+		t.Fatalf("claim was not handled")
+	}
+
+	server.Publish("", "noted-runner.test", amqp.Publishing{CorrelationId: "cmd", Body: []byte(`{"ok":true}`)})
+	waitFor(t, "ack of a reply", func() bool { return server.Acked() == 3 })
+	if replies.replies.Load() != 1 {
+		t.Fatalf("reply was not handled")
+	}
+
+	server.Publish(exchange, "kernel."+kernelID, amqp.Publishing{Body: []byte("{broken")})
+	waitFor(t, "ack of a malformed message", func() bool { return server.Acked() == 4 })
+	dead, ok := server.Get(model.DeadLetterQueue)
+	if !ok || string(dead.Body) != "{broken" || dead.Headers[model.HeaderParseError] == "" ||
+		dead.Headers[model.HeaderOriginalKey] != "kernel."+kernelID {
+		t.Fatalf("malformed message was not dead-lettered: %+v", dead)
+	}
+
+	// клиент отключён: одна повторная попытка, затем сообщение отбрасывается
+	sender.mu.Lock()
+	sender.online = false
+	sender.mu.Unlock()
+	server.Publish(exchange, "kernel."+kernelID, amqp.Publishing{Body: []byte(result)})
+	waitFor(t, "nacks of an undeliverable result", func() bool { return server.Nacked() == 2 })
+
+	if server.Acked() != 4 || len(sender.sent) != 1 {
+		t.Fatalf("unexpected state: %d acks, %d sent", server.Acked(), len(sender.sent))
+	}
+
+	// засчитанный результат не возвращается в очередь, иначе его засчитал бы следующий запуск блока
+	sender.mu.Lock()
+	sender.completed = true
+	sender.mu.Unlock()
+	server.Publish(exchange, "kernel."+kernelID, amqp.Publishing{Body: []byte(result)})
+	waitFor(t, "ack of a dead-lettered result", func() bool { return server.Acked() == 5 })
+	dead, ok = server.Get(model.DeadLetterQueue)
+	if !ok || string(dead.Body) != result || server.Nacked() != 2 {
+		t.Fatalf("completed result was requeued: %+v, %d nacks", dead, server.Nacked())
+	}
+}
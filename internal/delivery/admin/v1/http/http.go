@@ -0,0 +1,138 @@
+package http
+
+import (
+	"encoding/json"
+	"log/slog"
+
+	"github.com/dnonakolesax/noted-runner/internal/audit"
+	"github.com/dnonakolesax/noted-runner/internal/logger"
+	"github.com/dnonakolesax/noted-runner/internal/middlewares"
+	"github.com/dnonakolesax/noted-runner/internal/model"
+	"github.com/fasthttp/router"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+type DeadLettersUsecase interface {
+	List(limit int) ([]model.DeadLetter, error)
+	Purge() (int, error)
+}
+
+// AuditLog - журнал выполнений блоков и событий аудита
+type AuditLog interface {
+	Executions(filter audit.Filter) ([]model.ExecutionRecord, error)
+	Events(filter audit.Filter) ([]model.AuditEvent, error)
+}
+
+type AdminDelivery struct {
+	deadLetters DeadLettersUsecase
+	audit       AuditLog
+	logger      *slog.Logger
+	authMW      *middlewares.AuthMW
+	adminMW     *middlewares.AdminMW
+}
+
+func NewAdminDelivery(deadLetters DeadLettersUsecase, audit AuditLog, logger *slog.Logger,
+	authMW *middlewares.AuthMW, adminMW *middlewares.AdminMW) *AdminDelivery {
+	return &AdminDelivery{deadLetters: deadLetters, audit: audit, logger: logger, authMW: authMW, adminMW: adminMW}
+}
+
+// ListDeadLetters отдаёт сообщения из очереди мёртвых писем; ?limit= ограничивает их число
+func (ad *AdminDelivery) ListDeadLetters(ctx *fasthttp.RequestCtx) {
+	limit, ok := parseLimit(ctx)
+	if !ok {
+		return
+	}
+
+	letters, err := ad.deadLetters.List(limit)
+	if err != nil {
+		ad.logger.Error("error listing dead letters", logger.LogError(err))
+		ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+		return
+	}
+	ad.writeJSON(ctx, letters)
+}
+
+// ListEvents отдаёт события аудита от последнего; ?type=, ?user-id= и ?kernel-id= сужают выборку
+func (ad *AdminDelivery) ListEvents(ctx *fasthttp.RequestCtx) {
+	filter, ok := auditFilter(ctx)
+	if !ok {
+		return
+	}
+	filter.Type = string(ctx.QueryArgs().Peek("type"))
+	events, err := ad.audit.Events(filter)
+	if err != nil {
+		ad.logger.Error("error reading audit events", logger.LogError(err))
+		ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+		return
+	}
+	ad.writeJSON(ctx, events)
+}
+
+// ListExecutions отдаёт журнал выполнений всех блокнотов; ?block-id=, ?user-id= и ?kernel-id= сужают выборку
+func (ad *AdminDelivery) ListExecutions(ctx *fasthttp.RequestCtx) {
+	filter, ok := auditFilter(ctx)
+	if !ok {
+		return
+	}
+	filter.BlockID = string(ctx.QueryArgs().Peek("block-id"))
+	records, err := ad.audit.Executions(filter)
+	if err != nil {
+		ad.logger.Error("error reading execution records", logger.LogError(err))
+		ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+		return
+	}
+	ad.writeJSON(ctx, records)
+}
+
+func auditFilter(ctx *fasthttp.RequestCtx) (audit.Filter, bool) {
+	limit, ok := parseLimit(ctx)
+	return audit.Filter{UserID: string(ctx.QueryArgs().Peek("user-id")),
+		KernelID: string(ctx.QueryArgs().Peek("kernel-id")), Limit: limit}, ok
+}
+
+func parseLimit(ctx *fasthttp.RequestCtx) (int, bool) {
+	if !ctx.QueryArgs().Has("limit") {
+		return defaultLimit, true
+	}
+	limit, err := ctx.QueryArgs().GetUint("limit")
+	if err != nil || limit == 0 || limit > maxLimit {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBodyString("invalid limit")
+		return 0, false
+	}
+	return limit, true
+}
+
+func (ad *AdminDelivery) PurgeDeadLetters(ctx *fasthttp.RequestCtx) {
+	purged, err := ad.deadLetters.Purge()
+	if err != nil {
+		ad.logger.Error("error purging dead letters", logger.LogError(err))
+		ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+		return
+	}
+	ad.writeJSON(ctx, map[string]int{"purged": purged})
+}
+
+func (ad *AdminDelivery) writeJSON(ctx *fasthttp.RequestCtx, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		ad.logger.Error("error marshaling response", logger.LogError(err))
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.SetContentType("application/json")
+	ctx.SetBody(data)
+}
+
+func (ad *AdminDelivery) RegisterRoutes(apiGroup *router.Group) {
+	group := apiGroup.Group("/admin")
+	group.GET("/dead-letters", ad.authMW.AuthMiddleware(ad.adminMW.MW(ad.ListDeadLetters)))
+	group.DELETE("/dead-letters", ad.authMW.AuthMiddleware(ad.adminMW.MW(ad.PurgeDeadLetters)))
+	group.GET("/audit/events", ad.authMW.AuthMiddleware(ad.adminMW.MW(ad.ListEvents)))
+	group.GET("/audit/executions", ad.authMW.AuthMiddleware(ad.adminMW.MW(ad.ListExecutions)))
+}
@@ -0,0 +1,150 @@
+package grpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+
+	"github.com/dnonakolesax/noted-runner/internal/consts"
+	runner "github.com/dnonakolesax/noted-runner/internal/delivery/compiler/v1/grpc/proto"
+	compilerDelivery "github.com/dnonakolesax/noted-runner/internal/delivery/compiler/v1/http"
+	"github.com/dnonakolesax/noted-runner/internal/ids"
+	"github.com/dnonakolesax/noted-runner/internal/logger"
+	"github.com/dnonakolesax/noted-runner/internal/model"
+	"github.com/dnonakolesax/noted-runner/internal/registry"
+	"github.com/dnonakolesax/noted-runner/internal/session"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Kernels - операции с ядрами, которые gRPC делит с REST
+type Kernels interface {
+	OpenKernel(kernelID ids.ID, userID ids.ID) (*session.Session, error)
+	RunExecution(kernelID ids.ID, blockID ids.ID, userID ids.ID,
+		params map[string]json.RawMessage, heads []string, mode string) (*registry.Execution, error)
+	InterruptKernel(kernelID ids.ID, userID ids.ID) error
+	InspectKernel(kernelID ids.ID, userID ids.ID) (json.RawMessage, error)
+	StopSession(kernelID ids.ID, userID ids.ID) error
+}
+
+// RunnerServer - gRPC-сервис раннера для других бэкендов Noted, например для запусков по расписанию.
+// Пользователь берётся из контекста, куда его кладёт GRPCAuthMW
+type RunnerServer struct {
+	runner.UnimplementedRunnerServiceServer
+	kernels Kernels
+	logger  *slog.Logger
+}
+
+func NewRunnerServer(kernels Kernels, logger *slog.Logger) *RunnerServer {
+	return &RunnerServer{kernels: kernels, logger: logger}
+}
+
+func (rs *RunnerServer) StartKernel(ctx context.Context, req *runner.KernelRequest) (*runner.KernelData, error) {
+	kernelID, userID, err := requestIDs(ctx, req.GetKernelID())
+	if err != nil {
+		return nil, err
+	}
+	sess, err := rs.kernels.OpenKernel(kernelID, userID)
+	if err != nil {
+		return nil, rs.status(err)
+	}
+	return &runner.KernelData{KernelID: sess.KernelID, Token: sess.Token}, nil
+}
+
+// Execute отправляет блок в ядро и передаёт клиенту сначала принятый запуск, затем его результат.
+// Если клиент отменит вызов, запуск продолжится, а его результат получат подключённые клиенты сессии
+func (rs *RunnerServer) Execute(req *runner.ExecuteRequest, stream runner.RunnerService_ExecuteServer) error {
+	kernelID, userID, err := requestIDs(stream.Context(), req.GetKernelID())
+	if err != nil {
+		return err
+	}
+	blockID, err := ids.Parse(req.GetBlockID())
+	if err != nil {
+		return status.Error(codes.InvalidArgument, "invalid BlockID: expected UUID")
+	}
+
+	execution, err := rs.kernels.RunExecution(kernelID, blockID, userID, nil, nil, model.ModeRun)
+	if execution == nil {
+		return rs.status(err)
+	}
+	// блок не удалось отправить: запуск уже провален, и это его единственный результат
+	accepted := execution.Snapshot()
+	err = stream.Send(output(accepted))
+	if err != nil || accepted.Status != model.ExecutionRunning {
+		return err
+	}
+
+	select {
+	case <-execution.Done():
+		return stream.Send(output(execution.Snapshot()))
+	case <-stream.Context().Done():
+		return status.FromContextError(stream.Context().Err()).Err()
+	}
+}
+
+func (rs *RunnerServer) Interrupt(ctx context.Context, req *runner.KernelRequest) (*runner.Empty, error) {
+	kernelID, userID, err := requestIDs(ctx, req.GetKernelID())
+	if err != nil {
+		return nil, err
+	}
+	err = rs.kernels.InterruptKernel(kernelID, userID)
+	if err != nil {
+		return nil, rs.status(err)
+	}
+	return &runner.Empty{}, nil
+}
+
+func (rs *RunnerServer) Inspect(ctx context.Context, req *runner.KernelRequest) (*runner.InspectData, error) {
+	kernelID, userID, err := requestIDs(ctx, req.GetKernelID())
+	if err != nil {
+		return nil, err
+	}
+	state, err := rs.kernels.InspectKernel(kernelID, userID)
+	if err != nil {
+		return nil, rs.status(err)
+	}
+	return &runner.InspectData{State: string(state)}, nil
+}
+
+func (rs *RunnerServer) StopKernel(ctx context.Context, req *runner.KernelRequest) (*runner.Empty, error) {
+	kernelID, userID, err := requestIDs(ctx, req.GetKernelID())
+	if err != nil {
+		return nil, err
+	}
+	err = rs.kernels.StopSession(kernelID, userID)
+	if err != nil {
+		return nil, rs.status(err)
+	}
+	return &runner.Empty{}, nil
+}
+
+func requestIDs(ctx context.Context, rawKernelID string) (ids.ID, ids.ID, error) {
+	rawUserID, _ := ctx.Value(consts.UserIDContextKey).(string)
+	userID, err := ids.Parse(rawUserID)
+	if err != nil {
+		return "", "", status.Error(codes.Unauthenticated, "invalid user id")
+	}
+	kernelID, err := ids.Parse(rawKernelID)
+	if err != nil {
+		return "", "", status.Error(codes.InvalidArgument, "invalid KernelID: expected UUID")
+	}
+	return kernelID, userID, nil
+}
+
+func (rs *RunnerServer) status(err error) error {
+	switch {
+	case errors.Is(err, compilerDelivery.ErrNotStarted):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, session.ErrExists):
+		return status.Error(codes.AlreadyExists, err.Error())
+	default:
+		rs.logger.Error("grpc call failed", logger.LogError(err))
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+func output(e model.Execution) *runner.Output {
+	return &runner.Output{ExecutionID: e.ID, KernelID: e.KernelID, BlockID: e.BlockID, Status: e.Status,
+		Result: e.Result, Fail: e.Fail, Warnings: e.Warnings, Error: e.Error}
+}
@@ -0,0 +1,226 @@
+package grpc
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dnonakolesax/noted-runner/internal/configs"
+	"github.com/dnonakolesax/noted-runner/internal/consts"
+	runner "github.com/dnonakolesax/noted-runner/internal/delivery/compiler/v1/grpc/proto"
+	compilerDelivery "github.com/dnonakolesax/noted-runner/internal/delivery/compiler/v1/http"
+	"github.com/dnonakolesax/noted-runner/internal/ids"
+	"github.com/dnonakolesax/noted-runner/internal/middlewares"
+	"github.com/dnonakolesax/noted-runner/internal/model"
+	"github.com/dnonakolesax/noted-runner/internal/registry"
+	"github.com/dnonakolesax/noted-runner/internal/session"
+	access "github.com/dnonakolesax/noted-runner/internal/usecase/access/proto"
+	auth "github.com/dnonakolesax/noted-runner/internal/usecase/auth/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const (
+	testUser   = "6f0e4b1c-2a3d-4e5f-8a9b-0c1d2e3f4a5b"
+	testKernel = "0b3c9a52-6f2e-4d0c-9a57-2f8b1c3d4e5f"
+	testBlock  = "4bcb102d-1c2e-4f3a-8b9c-0d1e2f3a4b5c"
+)
+
+type fakeAuth struct{}
+
+func (fakeAuth) AuthUserIDCtx(_ context.Context, in *auth.UserTokens, _ ...grpc.CallOption) (*auth.TokenData, error) {
+	if in.Refresh != "valid" {
+		return nil, status.Error(codes.Unauthenticated, "bad token")
+	}
+	return &auth.TokenData{ID: testUser}, nil
+}
+
+// fakeAccess разрешает выполнение только в testKernel
+type fakeAccess struct{}
+
+func (fakeAccess) FileAccessCtx(_ context.Context, in *access.AccessRequest,
+	_ ...grpc.CallOption) (*access.AccessData, error) {
+	if in.FileID == testKernel && in.UserID == testUser {
+		return &access.AccessData{Access: "rwx"}, nil
+	}
+	return &access.AccessData{Access: "r"}, nil
+}
+
+// fakeAuditor запоминает отказы в доступе
+type fakeAuditor struct {
+	mu     sync.Mutex
+	denied []string
+}
+
+func (fa *fakeAuditor) Event(eventType string, _ string, kernelID string, _ string) {
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+	if eventType == model.AuditAccessDenied {
+		fa.denied = append(fa.denied, kernelID)
+	}
+}
+
+type fakeKernels struct {
+	hub        *session.Hub
+	executions *registry.Executions
+}
+
+func (fk *fakeKernels) OpenKernel(kernelID ids.ID, userID ids.ID) (*session.Session, error) {
+	return fk.hub.Open(userID.String(), kernelID.String())
+}
+
+func (fk *fakeKernels) RunExecution(kernelID ids.ID, blockID ids.ID, userID ids.ID,
+	_ map[string]json.RawMessage, _ []string, _ string) (*registry.Execution, error) {
+	if _, ok := fk.hub.Lookup(kernelID.String()); !ok {
+		return nil, compilerDelivery.ErrNotStarted
+	}
+	return fk.executions.Start(kernelID.String(), blockID.String(), userID.String()), nil
+}
+
+func (fk *fakeKernels) InterruptKernel(kernelID ids.ID, _ ids.ID) error {
+	if _, ok := fk.hub.Lookup(kernelID.String()); !ok {
+		return compilerDelivery.ErrNotStarted
+	}
+	return nil
+}
+
+func (fk *fakeKernels) InspectKernel(_ ids.ID, _ ids.ID) (json.RawMessage, error) {
+	return json.RawMessage(`{"vars":1}`), nil
+}
+
+func (fk *fakeKernels) StopSession(kernelID ids.ID, _ ids.ID) error {
+	sess, ok := fk.hub.Lookup(kernelID.String())
+	if !ok {
+		return compilerDelivery.ErrNotStarted
+	}
+	fk.hub.End(sess)
+	return nil
+}
+
+func dial(t *testing.T, kernels Kernels) runner.RunnerServiceClient {
+	t.Helper()
+	return dialAudited(t, kernels, &fakeAuditor{})
+}
+
+func dialAudited(t *testing.T, kernels Kernels, auditor middlewares.Auditor) runner.RunnerServiceClient {
+	t.Helper()
+	mw := middlewares.NewGRPCAuthMW(fakeAuth{}, fakeAccess{}, auditor, slog.Default())
+	srv := grpc.NewServer(grpc.UnaryInterceptor(mw.Unary()), grpc.StreamInterceptor(mw.Stream()))
+	runner.RegisterRunnerServiceServer(srv, NewRunnerServer(kernels, slog.Default()))
+
+	lis := bufconn.Listen(1 << 20)
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+	conn, err := grpc.NewClient("passthrough:///runner", grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+		srv.Stop()
+	})
+	return runner.NewRunnerServiceClient(conn)
+}
+
+func withTokens(refresh string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), consts.GRPCATMetaKey, "at",
+		consts.GRPCRTMetaKey, refresh)
+}
+
+func code(err error) codes.Code {
+	return status.Code(err)
+}
+
+func TestAuthRules(t *testing.T) {
+	hub := session.NewHub(&configs.SessionConfig{GracePeriod: time.Hour, ReplayBuffer: 8}, slog.Default())
+	auditor := &fakeAuditor{}
+	client := dialAudited(t, &fakeKernels{hub: hub, executions: registry.NewExecutions(time.Hour)}, auditor)
+	req := &runner.KernelRequest{KernelID: testKernel}
+
+	if _, err := client.StartKernel(context.Background(), req); code(err) != codes.Unauthenticated {
+		t.Fatalf("call without tokens: %v", err)
+	}
+	if _, err := client.StartKernel(withTokens("stale"), req); code(err) != codes.Unauthenticated {
+		t.Fatalf("call with invalid tokens: %v", err)
+	}
+	foreign := &runner.KernelRequest{KernelID: "1a2b3c4d-1c2e-4f3a-8b9c-0d1e2f3a4b5c"}
+	if _, err := client.StartKernel(withTokens("valid"), foreign); code(err) != codes.PermissionDenied {
+		t.Fatalf("call without access: %v", err)
+	}
+
+	stream, err := client.Execute(withTokens("valid"), &runner.ExecuteRequest{KernelID: foreign.KernelID,
+		BlockID: testBlock})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if code(err) != codes.PermissionDenied {
+		t.Fatalf("stream without access: %v", err)
+	}
+
+	auditor.mu.Lock()
+	defer auditor.mu.Unlock()
+	if len(auditor.denied) != 2 || auditor.denied[0] != foreign.KernelID {
+		t.Fatalf("denials were not audited: %v", auditor.denied)
+	}
+}
+
+func TestKernelLifecycle(t *testing.T) {
+	hub := session.NewHub(&configs.SessionConfig{GracePeriod: time.Hour, ReplayBuffer: 8}, slog.Default())
+	executions := registry.NewExecutions(time.Hour)
+	client := dial(t, &fakeKernels{hub: hub, executions: executions})
+	ctx := withTokens("valid")
+	req := &runner.KernelRequest{KernelID: testKernel}
+
+	if _, err := client.Interrupt(ctx, req); code(err) != codes.NotFound {
+		t.Fatalf("interrupt before start: %v", err)
+	}
+	created, err := client.StartKernel(ctx, req)
+	if err != nil || created.Token == "" {
+		t.Fatalf("start failed: %v", err)
+	}
+	if _, err := client.StartKernel(ctx, req); code(err) != codes.AlreadyExists {
+		t.Fatalf("second start: %v", err)
+	}
+	stream, err := client.Execute(ctx, &runner.ExecuteRequest{KernelID: testKernel, BlockID: "../x"})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if code(err) != codes.InvalidArgument {
+		t.Fatalf("invalid block: %v", err)
+	}
+
+	stream, err = client.Execute(ctx, &runner.ExecuteRequest{KernelID: testKernel, BlockID: testBlock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted, err := stream.Recv()
+	if err != nil || accepted.Status != model.ExecutionRunning || accepted.ExecutionID == "" {
+		t.Fatalf("unexpected first output: %v, %v", accepted, err)
+	}
+	executions.Complete(model.KernelMessage{KernelID: testKernel, BlockID: testBlock, Result: "42"})
+	done, err := stream.Recv()
+	if err != nil || done.Status != model.ExecutionDone || done.Result != "42" || done.ExecutionID != accepted.ExecutionID {
+		t.Fatalf("unexpected result: %v, %v", done, err)
+	}
+
+	state, err := client.Inspect(ctx, req)
+	if err != nil || state.State != `{"vars":1}` {
+		t.Fatalf("unexpected inspect: %v, %v", state, err)
+	}
+	if _, err := client.StopKernel(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if hub.Len() != 0 {
+		t.Fatalf("session outlived StopKernel")
+	}
+}
@@ -0,0 +1,447 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.10
+// 	protoc        v3.21.12
+// source: runner.proto
+
+package runner
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type KernelRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	KernelID      string                 `protobuf:"bytes,1,opt,name=KernelID,proto3" json:"KernelID,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *KernelRequest) Reset() {
+	*x = KernelRequest{}
+	mi := &file_runner_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *KernelRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*KernelRequest) ProtoMessage() {}
+
+func (x *KernelRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_runner_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use KernelRequest.ProtoReflect.Descriptor instead.
+func (*KernelRequest) Descriptor() ([]byte, []int) {
+	return file_runner_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *KernelRequest) GetKernelID() string {
+	if x != nil {
+		return x.KernelID
+	}
+	return ""
+}
+
+type KernelData struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	KernelID      string                 `protobuf:"bytes,1,opt,name=KernelID,proto3" json:"KernelID,omitempty"`
+	Token         string                 `protobuf:"bytes,2,opt,name=Token,proto3" json:"Token,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *KernelData) Reset() {
+	*x = KernelData{}
+	mi := &file_runner_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *KernelData) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*KernelData) ProtoMessage() {}
+
+func (x *KernelData) ProtoReflect() protoreflect.Message {
+	mi := &file_runner_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use KernelData.ProtoReflect.Descriptor instead.
+func (*KernelData) Descriptor() ([]byte, []int) {
+	return file_runner_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *KernelData) GetKernelID() string {
+	if x != nil {
+		return x.KernelID
+	}
+	return ""
+}
+
+func (x *KernelData) GetToken() string {
+	if x != nil {
+		return x.Token
+	}
+	return ""
+}
+
+type ExecuteRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	KernelID      string                 `protobuf:"bytes,1,opt,name=KernelID,proto3" json:"KernelID,omitempty"`
+	BlockID       string                 `protobuf:"bytes,2,opt,name=BlockID,proto3" json:"BlockID,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ExecuteRequest) Reset() {
+	*x = ExecuteRequest{}
+	mi := &file_runner_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ExecuteRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExecuteRequest) ProtoMessage() {}
+
+func (x *ExecuteRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_runner_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExecuteRequest.ProtoReflect.Descriptor instead.
+func (*ExecuteRequest) Descriptor() ([]byte, []int) {
+	return file_runner_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ExecuteRequest) GetKernelID() string {
+	if x != nil {
+		return x.KernelID
+	}
+	return ""
+}
+
+func (x *ExecuteRequest) GetBlockID() string {
+	if x != nil {
+		return x.BlockID
+	}
+	return ""
+}
+
+type Output struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ExecutionID   string                 `protobuf:"bytes,1,opt,name=ExecutionID,proto3" json:"ExecutionID,omitempty"`
+	KernelID      string                 `protobuf:"bytes,2,opt,name=KernelID,proto3" json:"KernelID,omitempty"`
+	BlockID       string                 `protobuf:"bytes,3,opt,name=BlockID,proto3" json:"BlockID,omitempty"`
+	Status        string                 `protobuf:"bytes,4,opt,name=Status,proto3" json:"Status,omitempty"`
+	Result        string                 `protobuf:"bytes,5,opt,name=Result,proto3" json:"Result,omitempty"`
+	Fail          bool                   `protobuf:"varint,6,opt,name=Fail,proto3" json:"Fail,omitempty"`
+	Warnings      []string               `protobuf:"bytes,7,rep,name=Warnings,proto3" json:"Warnings,omitempty"`
+	Error         string                 `protobuf:"bytes,8,opt,name=Error,proto3" json:"Error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Output) Reset() {
+	*x = Output{}
+	mi := &file_runner_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Output) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Output) ProtoMessage() {}
+
+func (x *Output) ProtoReflect() protoreflect.Message {
+	mi := &file_runner_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Output.ProtoReflect.Descriptor instead.
+func (*Output) Descriptor() ([]byte, []int) {
+	return file_runner_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Output) GetExecutionID() string {
+	if x != nil {
+		return x.ExecutionID
+	}
+	return ""
+}
+
+func (x *Output) GetKernelID() string {
+	if x != nil {
+		return x.KernelID
+	}
+	return ""
+}
+
+func (x *Output) GetBlockID() string {
+	if x != nil {
+		return x.BlockID
+	}
+	return ""
+}
+
+func (x *Output) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *Output) GetResult() string {
+	if x != nil {
+		return x.Result
+	}
+	return ""
+}
+
+func (x *Output) GetFail() bool {
+	if x != nil {
+		return x.Fail
+	}
+	return false
+}
+
+func (x *Output) GetWarnings() []string {
+	if x != nil {
+		return x.Warnings
+	}
+	return nil
+}
+
+func (x *Output) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type InspectData struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	State         string                 `protobuf:"bytes,1,opt,name=State,proto3" json:"State,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InspectData) Reset() {
+	*x = InspectData{}
+	mi := &file_runner_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InspectData) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InspectData) ProtoMessage() {}
+
+func (x *InspectData) ProtoReflect() protoreflect.Message {
+	mi := &file_runner_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InspectData.ProtoReflect.Descriptor instead.
+func (*InspectData) Descriptor() ([]byte, []int) {
+	return file_runner_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *InspectData) GetState() string {
+	if x != nil {
+		return x.State
+	}
+	return ""
+}
+
+type Empty struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Empty) Reset() {
+	*x = Empty{}
+	mi := &file_runner_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Empty) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Empty) ProtoMessage() {}
+
+func (x *Empty) ProtoReflect() protoreflect.Message {
+	mi := &file_runner_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Empty.ProtoReflect.Descriptor instead.
+func (*Empty) Descriptor() ([]byte, []int) {
+	return file_runner_proto_rawDescGZIP(), []int{5}
+}
+
+var File_runner_proto protoreflect.FileDescriptor
+
+const file_runner_proto_rawDesc = "" +
+	"\n" +
+	"\frunner.proto\x12\x06runner\"+\n" +
+	"\rKernelRequest\x12\x1a\n" +
+	"\bKernelID\x18\x01 \x01(\tR\bKernelID\">\n" +
+	"\n" +
+	"KernelData\x12\x1a\n" +
+	"\bKernelID\x18\x01 \x01(\tR\bKernelID\x12\x14\n" +
+	"\x05Token\x18\x02 \x01(\tR\x05Token\"F\n" +
+	"\x0eExecuteRequest\x12\x1a\n" +
+	"\bKernelID\x18\x01 \x01(\tR\bKernelID\x12\x18\n" +
+	"\aBlockID\x18\x02 \x01(\tR\aBlockID\"\xd6\x01\n" +
+	"\x06Output\x12 \n" +
+	"\vExecutionID\x18\x01 \x01(\tR\vExecutionID\x12\x1a\n" +
+	"\bKernelID\x18\x02 \x01(\tR\bKernelID\x12\x18\n" +
+	"\aBlockID\x18\x03 \x01(\tR\aBlockID\x12\x16\n" +
+	"\x06Status\x18\x04 \x01(\tR\x06Status\x12\x16\n" +
+	"\x06Result\x18\x05 \x01(\tR\x06Result\x12\x12\n" +
+	"\x04Fail\x18\x06 \x01(\bR\x04Fail\x12\x1a\n" +
+	"\bWarnings\x18\a \x03(\tR\bWarnings\x12\x14\n" +
+	"\x05Error\x18\b \x01(\tR\x05Error\"#\n" +
+	"\vInspectData\x12\x14\n" +
+	"\x05State\x18\x01 \x01(\tR\x05State\"\a\n" +
+	"\x05Empty2\xa6\x02\n" +
+	"\rRunnerService\x12:\n" +
+	"\vStartKernel\x12\x15.runner.KernelRequest\x1a\x12.runner.KernelData\"\x00\x125\n" +
+	"\aExecute\x12\x16.runner.ExecuteRequest\x1a\x0e.runner.Output\"\x000\x01\x123\n" +
+	"\tInterrupt\x12\x15.runner.KernelRequest\x1a\r.runner.Empty\"\x00\x127\n" +
+	"\aInspect\x12\x15.runner.KernelRequest\x1a\x13.runner.InspectData\"\x00\x124\n" +
+	"\n" +
+	"StopKernel\x12\x15.runner.KernelRequest\x1a\r.runner.Empty\"\x00B\vZ\t./;runnerb\x06proto3"
+
+var (
+	file_runner_proto_rawDescOnce sync.Once
+	file_runner_proto_rawDescData []byte
+)
+
+func file_runner_proto_rawDescGZIP() []byte {
+	file_runner_proto_rawDescOnce.Do(func() {
+		file_runner_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_runner_proto_rawDesc), len(file_runner_proto_rawDesc)))
+	})
+	return file_runner_proto_rawDescData
+}
+
+var file_runner_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_runner_proto_goTypes = []any{
+	(*KernelRequest)(nil),  // 0: runner.KernelRequest
+	(*KernelData)(nil),     // 1: runner.KernelData
+	(*ExecuteRequest)(nil), // 2: runner.ExecuteRequest
+	(*Output)(nil),         // 3: runner.Output
+	(*InspectData)(nil),    // 4: runner.InspectData
+	(*Empty)(nil),          // 5: runner.Empty
+}
+var file_runner_proto_depIdxs = []int32{
+	0, // 0: runner.RunnerService.StartKernel:input_type -> runner.KernelRequest
+	2, // 1: runner.RunnerService.Execute:input_type -> runner.ExecuteRequest
+	0, // 2: runner.RunnerService.Interrupt:input_type -> runner.KernelRequest
+	0, // 3: runner.RunnerService.Inspect:input_type -> runner.KernelRequest
+	0, // 4: runner.RunnerService.StopKernel:input_type -> runner.KernelRequest
+	1, // 5: runner.RunnerService.StartKernel:output_type -> runner.KernelData
+	3, // 6: runner.RunnerService.Execute:output_type -> runner.Output
+	5, // 7: runner.RunnerService.Interrupt:output_type -> runner.Empty
+	4, // 8: runner.RunnerService.Inspect:output_type -> runner.InspectData
+	5, // 9: runner.RunnerService.StopKernel:output_type -> runner.Empty
+	5, // [5:10] is the sub-list for method output_type
+	0, // [0:5] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_runner_proto_init() }
+func file_runner_proto_init() {
+	if File_runner_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_runner_proto_rawDesc), len(file_runner_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   6,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_runner_proto_goTypes,
+		DependencyIndexes: file_runner_proto_depIdxs,
+		MessageInfos:      file_runner_proto_msgTypes,
+	}.Build()
+	File_runner_proto = out.File
+	file_runner_proto_goTypes = nil
+	file_runner_proto_depIdxs = nil
+}
@@ -0,0 +1,140 @@
+package http
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dnonakolesax/noted-runner/internal/configs"
+	"github.com/dnonakolesax/noted-runner/internal/logger"
+	"github.com/fasthttp/websocket"
+)
+
+var ErrConnClosed = errors.New("connection closed")
+
+const sendBufferSize = 64
+
+type wsConn interface {
+	WriteMessage(messageType int, data []byte) error
+	WriteControl(messageType int, data []byte, deadline time.Time) error
+	SetWriteDeadline(t time.Time) error
+	Close() error
+}
+
+// Conn сериализует запись в websocket: писать в сокет может только одна горутина,
+// а SendMemes и цикл чтения лишь ставят сообщения в очередь. Та же горутина шлёт клиенту ping
+type Conn struct {
+	ws           wsConn
+	config       *configs.WebSocketConfig
+	out          chan []byte
+	done         chan struct{}
+	stopped      chan struct{}
+	once         sync.Once
+	lastActivity atomic.Int64
+	logger       *slog.Logger
+}
+
+func NewConn(ws wsConn, config *configs.WebSocketConfig, logger *slog.Logger) *Conn {
+	c := &Conn{ws: ws, config: config, out: make(chan []byte, sendBufferSize), done: make(chan struct{}),
+		stopped: make(chan struct{}), logger: logger}
+	c.Touch()
+	go c.writeLoop()
+	return c
+}
+
+func (c *Conn) Send(data []byte) error {
+	select {
+	case <-c.done:
+		return ErrConnClosed
+	default:
+	}
+
+	select {
+	case c.out <- data:
+		return nil
+	case <-c.done:
+		return ErrConnClosed
+	}
+}
+
+// Close закрывает сокет и дожидается пишущей горутины: после выхода из обработчика
+// fasthttp переиспользует соединение, и писать в него уже нельзя
+func (c *Conn) Close() error {
+	err := c.close()
+	<-c.stopped
+	return err
+}
+
+// close шлёт клиенту close-фрейм: сокет, захваченный у fasthttp, закрывается только после выхода
+// из обработчика, и без ответного фрейма клиента цикл чтения ждал бы до таймаута
+func (c *Conn) close() error {
+	var err error
+	c.once.Do(func() {
+		close(c.done)
+		_ = c.ws.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), c.deadline(c.config.WriteWait))
+		err = c.ws.Close()
+	})
+	return err
+}
+
+// Touch отмечает, что клиент подал признаки жизни: прислал сообщение или pong
+func (c *Conn) Touch() {
+	c.lastActivity.Store(time.Now().UnixNano())
+}
+
+func (c *Conn) LastActivity() time.Time {
+	return time.Unix(0, c.lastActivity.Load())
+}
+
+func (c *Conn) writeLoop() {
+	defer close(c.stopped)
+
+	var ping <-chan time.Time
+	if c.config.PingInterval > 0 {
+		ticker := time.NewTicker(c.config.PingInterval)
+		defer ticker.Stop()
+		ping = ticker.C
+	}
+
+	for {
+		select {
+		case data := <-c.out:
+			if !c.write(websocket.TextMessage, data) {
+				return
+			}
+		case <-ping:
+			if !c.write(websocket.PingMessage, nil) {
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *Conn) write(messageType int, data []byte) bool {
+	err := c.ws.SetWriteDeadline(c.deadline(c.config.WriteWait))
+	if err == nil {
+		err = c.ws.WriteMessage(messageType, data)
+	}
+	if err != nil {
+		c.logger.Error("error sending message", logger.LogError(err))
+		err = c.close()
+		if err != nil {
+			c.logger.Error("error closing conn", logger.LogError(err))
+		}
+		return false
+	}
+	return true
+}
+
+// deadline - момент через wait; без таймаута - нулевое время, то есть без ограничения
+func (c *Conn) deadline(wait time.Duration) time.Time {
+	if wait <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(wait)
+}
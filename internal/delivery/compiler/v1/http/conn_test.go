@@ -0,0 +1,120 @@
+package http
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dnonakolesax/noted-runner/internal/configs"
+	"github.com/fasthttp/websocket"
+)
+
+// fakeWS падает, если в него пишут из нескольких горутин одновременно
+type fakeWS struct {
+	writing atomic.Bool
+	written atomic.Int64
+	pings   atomic.Int64
+	closed  atomic.Bool
+	fail    bool
+}
+
+func (fw *fakeWS) WriteMessage(messageType int, _ []byte) error {
+	if !fw.writing.CompareAndSwap(false, true) {
+		panic("concurrent write to websocket connection")
+	}
+	defer fw.writing.Store(false)
+	time.Sleep(time.Microsecond)
+	if fw.fail {
+		return errors.New("broken pipe")
+	}
+	if messageType == websocket.PingMessage {
+		fw.pings.Add(1)
+		return nil
+	}
+	fw.written.Add(1)
+	return nil
+}
+
+func (fw *fakeWS) WriteControl(_ int, _ []byte, _ time.Time) error {
+	return nil
+}
+
+func (fw *fakeWS) SetWriteDeadline(_ time.Time) error {
+	return nil
+}
+
+func (fw *fakeWS) Close() error {
+	fw.closed.Store(true)
+	return nil
+}
+
+func TestConnSerialisesWrites(t *testing.T) {
+	ws := &fakeWS{}
+	conn := NewConn(ws, &configs.WebSocketConfig{PingInterval: time.Millisecond}, slog.Default())
+
+	var wg sync.WaitGroup
+	for range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				if err := conn.Send([]byte("memes")); err != nil {
+					t.Errorf("send: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for ws.written.Load() != 1600 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if ws.written.Load() != 1600 {
+		t.Fatalf("expected 1600 messages, got %d", ws.written.Load())
+	}
+
+	for ws.pings.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if ws.pings.Load() == 0 {
+		t.Fatalf("no pings were sent")
+	}
+
+	_ = conn.Close()
+	if err := conn.Send([]byte("late")); !errors.Is(err, ErrConnClosed) {
+		t.Fatalf("expected ErrConnClosed, got %v", err)
+	}
+}
+
+func TestConnClosesOnWriteError(t *testing.T) {
+	ws := &fakeWS{fail: true}
+	conn := NewConn(ws, &configs.WebSocketConfig{}, slog.Default())
+
+	_ = conn.Send([]byte("memes"))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !ws.closed.Load() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if !ws.closed.Load() {
+		t.Fatalf("conn is not closed after write error")
+	}
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range sendBufferSize * 2 {
+				_ = conn.Send([]byte("memes"))
+			}
+		}()
+	}
+	wg.Wait()
+	_ = conn.Close()
+}
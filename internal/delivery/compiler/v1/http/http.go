@@ -1,36 +1,85 @@
 package http
 
 import (
+	"encoding/json"
+	"errors"
 	"log/slog"
+	"strconv"
+	"time"
 
+	"github.com/dnonakolesax/noted-runner/internal/audit"
+	"github.com/dnonakolesax/noted-runner/internal/configs"
 	"github.com/dnonakolesax/noted-runner/internal/consts"
+	"github.com/dnonakolesax/noted-runner/internal/ids"
 	"github.com/dnonakolesax/noted-runner/internal/logger"
+	"github.com/dnonakolesax/noted-runner/internal/metrics"
 	"github.com/dnonakolesax/noted-runner/internal/middlewares"
 	"github.com/dnonakolesax/noted-runner/internal/model"
+	"github.com/dnonakolesax/noted-runner/internal/openapi"
+	"github.com/dnonakolesax/noted-runner/internal/registry"
+	"github.com/dnonakolesax/noted-runner/internal/session"
+	"github.com/dnonakolesax/noted-runner/internal/testreport"
 	"github.com/fasthttp/router"
 	"github.com/fasthttp/websocket"
 	"github.com/valyala/fasthttp"
 )
 
+var ErrNoListener = errors.New("no client is listening to the kernel")
+
 type CompilerUsecase interface {
-	StartKernel(kernelID string, userID string) (string, error)
-	RunBlock(kernelID string, blockID string, userID string) error
-	StopKernel(string) error
+	StartKernel(kernelID ids.ID, userID ids.ID) (string, error)
+	RunBlock(kernelID ids.ID, blockID ids.ID, userID ids.ID, params map[string]json.RawMessage,
+		heads []string, mode string) (model.BlockRun, error)
+	ForgetBlock(kernelID ids.ID, blockID ids.ID, userID ids.ID) ([]string, error)
+	StopKernel(kernelID ids.ID, userID ids.ID) error
+	InterruptKernel(kernelID ids.ID, userID ids.ID) error
+	InspectKernel(kernelID ids.ID, userID ids.ID) (json.RawMessage, error)
+	ReleaseKernel(kernelID ids.ID)
+	ExportNotebook(kernelID ids.ID, blockIDs []ids.ID, layout string) (model.NotebookExport, error)
+	ImportNotebook(kernelID ids.ID, data []byte) (model.NotebookImport, error)
+	NotebookIPYNB(kernelID ids.ID, blockIDs []ids.ID,
+		lastRuns func(blockIDs []string) map[string]model.ExecutionRecord) ([]byte, error)
+}
+
+// History - журнал выполнений блоков и событий ядер
+type History interface {
+	Begin(kernelID string, blockID string, userID string, startedAt time.Time, run model.BlockRun, err error)
+	Complete(msg model.KernelMessage) (model.ExecutionRecord, bool)
+	FailKernel(kernelID string, err error)
+	Event(eventType string, userID string, kernelID string, detail string)
+	Executions(filter audit.Filter) ([]model.ExecutionRecord, error)
+	LastExecutions(kernelID string, blockIDs []string) (map[string]model.ExecutionRecord, error)
+}
+
+// Outbox хранит результаты ядер, пока их клиент отключён
+type Outbox interface {
+	Push(kernelID string, msg []byte) error
+	Drain(kernelID string) ([][]byte, error)
+	Drop(kernelID string)
 }
 
 type ComilerDelivery struct {
-	activeConns     map[string]*websocket.Conn
-	kernelListeners map[string]string
-	usecase         CompilerUsecase
-	logger          *slog.Logger
-	authMW          *middlewares.AuthMW
-	accessMW        *middlewares.AccessMW
+	hub        *session.Hub
+	usecase    CompilerUsecase
+	outbox     Outbox
+	history    History
+	executions *registry.Executions
+	wsConfig   *configs.WebSocketConfig
+	restConfig *configs.RESTConfig
+	metrics    *metrics.WSMetrics
+	logger     *slog.Logger
+	authMW     *middlewares.AuthMW
+	accessMW   *middlewares.AccessMW
+	// described - ручки других доставок, которые попадают в общий документ OpenAPI
+	described []openapi.Route
 }
 
-func NewComilerDelivery(usecase CompilerUsecase, logger *slog.Logger, authMW *middlewares.AuthMW, accessMW *middlewares.AccessMW) *ComilerDelivery {
-	activeConns := make(map[string]*websocket.Conn)
-	kernelListeners := make(map[string]string)
-	return &ComilerDelivery{activeConns: activeConns, kernelListeners: kernelListeners, usecase: usecase, logger: logger, authMW: authMW, accessMW: accessMW}
+func NewComilerDelivery(usecase CompilerUsecase, hub *session.Hub, outbox Outbox, history History,
+	wsConfig *configs.WebSocketConfig, restConfig *configs.RESTConfig, metrics *metrics.WSMetrics, logger *slog.Logger,
+	authMW *middlewares.AuthMW, accessMW *middlewares.AccessMW) *ComilerDelivery {
+	return &ComilerDelivery{hub: hub, usecase: usecase, outbox: outbox, history: history,
+		executions: registry.NewExecutions(restConfig.ExecutionTTL), wsConfig: wsConfig, restConfig: restConfig,
+		metrics: metrics, logger: logger, authMW: authMW, accessMW: accessMW}
 }
 
 var upgrader = websocket.FastHTTPUpgrader{
@@ -39,106 +88,408 @@ var upgrader = websocket.FastHTTPUpgrader{
 	CheckOrigin:     func(ctx *fasthttp.RequestCtx) bool { return true },
 }
 
+// Compile подключает клиента к ядру по вебсокету. С session-token клиент возвращается в свою сессию
+// и получает сообщения после last-seq; без токена подключается к своей свободной сессии с ядром
+// или запускает новое ядро
 func (cd *ComilerDelivery) Compile(ctx *fasthttp.RequestCtx) {
 	userId := ctx.Request.UserValue(consts.CtxUserIDKey).(string)
 
-	kernelID := ctx.QueryArgs().Peek("kernel-id")
+	rawKernelID := ctx.QueryArgs().Peek("kernel-id")
 
-	if kernelID == nil {
+	if rawKernelID == nil {
 		cd.logger.Warn("no kernel id passed")
 		ctx.Response.SetStatusCode(fasthttp.StatusBadRequest)
 		return
 	}
 
-	if _, ok := cd.activeConns[userId]; ok {
-		cd.logger.Warn("user already connected", slog.String("id", userId))
+	kernelID, err := ids.Parse(string(rawKernelID))
+	if err != nil {
+		cd.logger.Warn("invalid kernel id", logger.LogError(err))
 		ctx.Response.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.Response.SetBodyString("invalid kernel-id: expected UUID")
 		return
 	}
 
-	cd.logger.Info("starting kernel", slog.String("id", string(kernelID)))
-
-	id, err := cd.usecase.StartKernel(string(kernelID), userId)
-	cd.logger.Info("started kernel", slog.String("container id", id))
+	userID, err := ids.Parse(userId)
+	if err != nil {
+		cd.logger.Warn("invalid user id", logger.LogError(err))
+		ctx.Response.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.Response.SetBodyString("invalid user id: expected UUID")
+		return
+	}
 
+	lastSeq, err := parseLastSeq(ctx)
 	if err != nil {
-		cd.logger.Error("error starting kernel", slog.String("error", err.Error()))
 		ctx.Response.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.Response.SetBodyString(err.Error())
+		return
+	}
+
+	sess, resumed, err := cd.openSession(string(ctx.QueryArgs().Peek("session-token")), kernelID, userID)
+	if err != nil {
+		cd.logger.Warn("error opening session", logger.LogError(err), slog.String("kernel", kernelID.String()))
+		ctx.Response.SetStatusCode(sessionStatus(err))
+		ctx.Response.SetBodyString(err.Error())
 		return
 	}
 
-	err = upgrader.Upgrade(ctx, func(conn *websocket.Conn) {
-		cd.activeConns[userId] = conn
-		cd.kernelListeners[string(kernelID)] = userId
+	err = upgrader.Upgrade(ctx, func(ws *websocket.Conn) {
+		conn := NewConn(ws, cd.wsConfig, cd.logger)
+
+		cd.sendSession(conn, sess, resumed)
+		err := cd.hub.Attach(sess, conn, lastSeq, func() [][]byte { return cd.drain(sess.KernelID) })
+		if err != nil {
+			cd.logger.Warn("error attaching to session", logger.LogError(err))
+			_ = conn.Close()
+			cd.suspend(sess, !resumed)
+			return
+		}
+
+		cd.metrics.ActiveConnections.Inc()
+		shutdown := false
+		defer func() {
+			cd.metrics.ActiveConnections.Dec()
+			cd.hub.Detach(sess, conn)
+			err := conn.Close()
+			if err != nil {
+				cd.logger.Error("error closing conn", logger.LogError(err))
+			}
+			cd.suspend(sess, shutdown)
+		}()
+
+		cd.keepAlive(ws, conn)
 		for {
-			messageType, message, err := conn.ReadMessage()
+			messageType, message, err := ws.ReadMessage()
 
 			if messageType == websocket.CloseMessage || messageType == -1 {
-				delete(cd.activeConns, userId)
-				cd.logger.Info("kernelid", slog.String("container id", id))
-				_ = cd.usecase.StopKernel(id)
+				if isTimeout(err) {
+					cd.metrics.HeartbeatTimeouts.Inc()
+					cd.logger.Warn("heartbeat timeout", slog.String("kernel", sess.KernelID),
+						slog.Time("last activity", conn.LastActivity()))
+				}
 				break
 			}
-
 			if err != nil {
 				cd.logger.Error("error reading message", logger.LogError(err))
-				err := conn.WriteMessage(websocket.TextMessage, []byte("error reading message"))
+				err := conn.Send([]byte("error reading message"))
 				if err != nil {
 					cd.logger.Error("error sending message", logger.LogError(err))
-					err := conn.Close()
-					if err != nil {
-						cd.logger.Error("error closing conn", logger.LogError(err))
-					}
 					break
 				}
 				continue
 			}
 
-			cd.logger.Info("received message", slog.String("text", string(message)))
-			err = cd.usecase.RunBlock(string(kernelID), string(message), userId)
-
-			if err != nil {
-				cd.logger.Error("error compiling", logger.LogError(err))
+			// дедлайн продлевается только после удачного чтения; его ошибка не должна затирать err
+			conn.Touch()
+			deadlineErr := ws.SetReadDeadline(conn.deadline(cd.wsConfig.PongWait))
+			if deadlineErr != nil {
+				cd.logger.Error("error setting read deadline", logger.LogError(deadlineErr))
+				break
+			}
 
-				resp := model.KernelMessage{}
-				resp.KernelID = string(kernelID)
-				resp.BlockID = string(message)
-				resp.Result = "error compiling:" + err.Error()
-				resp.Fail = true
+			cd.logger.Info("received message", slog.String("text", string(message)))
+			cmd := parseClientMessage(message)
+			if cmd.Type == model.ClientShutdown {
+				shutdown = true
+				break
+			}
 
-				err := conn.WriteJSON(resp)
+			resp, ok := cd.handleClientMessage(kernelID, userID, cmd)
+			if !ok {
+				continue
+			}
 
-				if err != nil {
-					cd.logger.Error("error sending message", logger.LogError(err))
-					err := conn.Close()
-					if err != nil {
-						cd.logger.Error("error closing conn", logger.LogError(err))
-					}
-					break
-				}
+			err = sess.Send(resp, func(data []byte) error { return cd.retain(sess.KernelID, data, ErrNoListener) })
+			if err != nil {
+				cd.logger.Error("error sending message", logger.LogError(err))
+				break
 			}
 		}
 	})
 	if err != nil {
 		cd.logger.Error("error upgrading", logger.LogError(err))
+		cd.suspend(sess, !resumed)
 		ctx.Response.SetStatusCode(fasthttp.StatusBadRequest)
 		return
 	}
 }
 
-func (cd *ComilerDelivery) SendMemes(kernelId string, memes string) {
-	userId := cd.kernelListeners[kernelId]
-	if conn, ok := cd.activeConns[userId]; ok {
-		err := conn.WriteMessage(websocket.TextMessage, []byte(memes))
+// keepAlive продлевает срок чтения на каждый pong: если клиент замолчит дольше PongWait,
+// ReadMessage вернёт ошибку таймаута и соединение будет отвязано от сессии
+func (cd *ComilerDelivery) keepAlive(ws *websocket.Conn, conn *Conn) {
+	err := ws.SetReadDeadline(conn.deadline(cd.wsConfig.PongWait))
+	if err != nil {
+		cd.logger.Error("error setting read deadline", logger.LogError(err))
+	}
+	ws.SetPongHandler(func(string) error {
+		conn.Touch()
+		return ws.SetReadDeadline(conn.deadline(cd.wsConfig.PongWait))
+	})
+}
+
+// parseLastSeq возвращает номер последнего полученного клиентом сообщения: из заголовка Last-Event-ID,
+// который браузер сам присылает при переподключении к SSE, или из параметра last-seq
+func parseLastSeq(ctx *fasthttp.RequestCtx) (uint64, error) {
+	raw := ctx.Request.Header.Peek(fasthttp.HeaderLastEventID)
+	if raw == nil {
+		raw = ctx.QueryArgs().Peek("last-seq")
+	}
+	if raw == nil {
+		return 0, nil
+	}
+	seq, err := strconv.ParseUint(string(raw), 10, 64)
+	if err != nil {
+		return 0, errors.New("invalid last-seq: expected non-negative number")
+	}
+	return seq, nil
+}
+
+func isTimeout(err error) bool {
+	var timeout interface{ Timeout() bool }
+	return errors.As(err, &timeout) && timeout.Timeout()
+}
+
+// openSession возвращает сессию по токену или запускает ядро под новую сессию
+func (cd *ComilerDelivery) openSession(token string, kernelID ids.ID, userID ids.ID) (*session.Session, bool, error) {
+	if token != "" {
+		sess, err := cd.hub.Resume(token, userID.String(), kernelID.String())
+		return sess, true, err
+	}
+
+	if sess, ok := cd.hub.Lookup(kernelID.String()); ok {
+		if sess.UserID != userID.String() {
+			return nil, false, session.ErrExists
+		}
+		// у пользователя уже есть сессия с ядром: созданная через REST или открытая другим транспортом
+		return sess, true, nil
+	}
+	if cd.hub.Connected(userID.String()) {
+		return nil, false, session.ErrBusy
+	}
+
+	cd.logger.Info("starting kernel", slog.String("id", kernelID.String()))
+	sess, err := cd.OpenKernel(kernelID, userID)
+	if err != nil {
+		return nil, false, err
+	}
+	cd.logger.Info("started kernel", slog.String("id", kernelID.String()))
+	return sess, false, nil
+}
+
+func sessionStatus(err error) int {
+	switch {
+	case errors.Is(err, session.ErrUnknown):
+		return fasthttp.StatusGone
+	case errors.Is(err, session.ErrExists):
+		return fasthttp.StatusConflict
+	default:
+		return fasthttp.StatusBadRequest
+	}
+}
+
+func sessionMessage(sess *session.Session, resumed bool) ([]byte, error) {
+	return json.Marshal(model.SessionMessage{Type: model.SessionOpened, Token: sess.Token,
+		KernelID: sess.KernelID, Seq: sess.Seq(), Resumed: resumed})
+}
+
+func (cd *ComilerDelivery) sendSession(conn session.Sender, sess *session.Session, resumed bool) {
+	data, err := sessionMessage(sess, resumed)
+	if err != nil {
+		cd.logger.Error("error marshaling message", logger.LogError(err))
+		return
+	}
+	err = conn.Send(data)
+	if err != nil {
+		cd.logger.Error("error sending message", logger.LogError(err))
+	}
+}
+
+// suspend оставляет ядро ждать переподключения клиента, а при now останавливает его сразу
+func (cd *ComilerDelivery) suspend(sess *session.Session, now bool) {
+	if now {
+		cd.teardown(sess)
+		return
+	}
+	cd.hub.Suspend(sess, cd.teardown)
+}
+
+// teardown завершает сессию и останавливает её ядро
+func (cd *ComilerDelivery) teardown(sess *session.Session) {
+	cd.hub.End(sess)
+	cd.outbox.Drop(sess.KernelID)
+	cd.executions.FailKernel(sess.KernelID, errKernelStopped)
+	cd.history.FailKernel(sess.KernelID, errKernelStopped)
+	cd.logger.Info("stopping kernel", slog.String("kernel", sess.KernelID))
+	err := cd.stopKernel(ids.MustParse(sess.KernelID), ids.MustParse(sess.UserID), "session ended")
+	if err != nil {
+		cd.logger.Error("error stopping kernel", logger.LogError(err))
+	}
+}
+
+func parseClientMessage(message []byte) model.ClientMessage {
+	var cmd model.ClientMessage
+	err := json.Unmarshal(message, &cmd)
+	if err != nil || (cmd.Type == "" && cmd.BlockID == "") {
+		return model.ClientMessage{Type: model.ClientRun, BlockID: string(message)}
+	}
+	return cmd
+}
+
+// handleClientMessage выполняет команду клиента. Возвращает false, если отвечать клиенту нечего:
+// результат выполнения блока придёт от ядра через брокер
+func (cd *ComilerDelivery) handleClientMessage(kernelID ids.ID, userID ids.ID,
+	cmd model.ClientMessage) (model.KernelMessage, bool) {
+	resp := model.KernelMessage{}
+	resp.KernelID = kernelID.String()
+	resp.BlockID = cmd.BlockID
+
+	var warnings []string
+	var err error
+	errPrefix := "error compiling:"
+	switch cmd.Type {
+	case model.ClientInterrupt:
+		errPrefix = "error interrupting:"
+		err = cd.usecase.InterruptKernel(kernelID, userID)
+	case model.ClientInspect:
+		errPrefix = "error inspecting:"
+		var state json.RawMessage
+		state, err = cd.usecase.InspectKernel(kernelID, userID)
+		resp.Result = string(state)
+	default:
+		var blockID ids.ID
+		blockID, err = ids.Parse(cmd.BlockID)
+		if err != nil {
+			errPrefix = "invalid block id:"
+			err = errors.New("expected UUID")
+			break
+		}
+		if cmd.Type == model.ClientDelete {
+			errPrefix = "error deleting block:"
+			warnings, err = cd.usecase.ForgetBlock(kernelID, blockID, userID)
+		} else {
+			var run model.BlockRun
+			run, err = cd.runBlock(kernelID, blockID, userID, cmd.Parameters, cmd.Heads, cmd.Mode)
+			warnings = run.Warnings
+			resp.Parameters = run.Parameters
+		}
+	}
+
+	if err != nil {
+		cd.logger.Error("error handling message", logger.LogError(err), slog.String("type", cmd.Type))
+		resp.Result = errPrefix + err.Error()
+		resp.Fail = true
+		return resp, true
+	}
+	resp.Warnings = warnings
+	return resp, len(warnings) != 0 || len(resp.Parameters) != 0 || cmd.Type == model.ClientInspect
+}
+
+// SendMemes отдаёт результат клиенту ядра, а если клиента нет - откладывает его в outbox.
+// Результат, который ждал REST-запуск, без подключённого клиента не откладывается. Если результат
+// уже засчитан запуску, а доставить его не удалось, ошибка оборачивает model.ErrCompleted
+func (cd *ComilerDelivery) SendMemes(kernelId string, memes string) error {
+	var msg model.KernelMessage
+	err := json.Unmarshal([]byte(memes), &msg)
+	if err != nil {
+		return err
+	}
+	// поля, которых раннер не знает, клиент получает как их прислало ядро
+	err = json.Unmarshal([]byte(memes), &msg.Raw)
+	if err != nil {
+		return err
+	}
+	// версия блока и режим запуска известны только раннеру: ядро присылает результат без них
+	record, recorded := cd.history.Complete(msg)
+	if recorded {
+		msg.Heads = record.Heads
+		if record.Mode == model.ModeTest {
+			testResult(&msg)
+		}
+	}
+	completed := cd.executions.Complete(msg)
+
+	err = cd.deliver(kernelId, msg, completed)
+	if err != nil && (recorded || completed) {
+		return errors.Join(model.ErrCompleted, err)
+	}
+	return err
+}
+
+func (cd *ComilerDelivery) deliver(kernelId string, msg model.KernelMessage, completed bool) error {
+	sess, ok := cd.hub.Lookup(kernelId)
+	if !ok {
+		cd.logger.Warn("no session for kernel, retaining result", slog.String("kernel", kernelId))
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		return cd.retain(kernelId, data, ErrNoListener)
+	}
+	return sess.Send(msg, func(data []byte) error {
+		if completed {
+			return nil
+		}
+		return cd.retain(kernelId, data, ErrNoListener)
+	})
+}
+
+// testResult заменяет вывод запуска в режиме тестов таблицей результатов, а сами результаты кладёт в Tests.
+// Если тесты не начались, например блок упал раньше, вывод остаётся как есть
+func testResult(msg *model.KernelMessage) {
+	report := testreport.Parse(msg.Result)
+	if len(report.Tests) == 0 && len(report.Benchmarks) == 0 {
+		return
+	}
+	msg.Tests = &report
+	msg.Result = testreport.Markdown(report)
+	msg.Fail = msg.Fail || testreport.Failed(report)
+}
+
+func (cd *ComilerDelivery) retain(kernelID string, data []byte, cause error) error {
+	err := cd.outbox.Push(kernelID, data)
+	if err != nil {
+		cd.logger.Error("error retaining result", logger.LogError(err), slog.String("kernel", kernelID))
+		return errors.Join(cause, err)
+	}
+	return nil
+}
+
+func (cd *ComilerDelivery) drain(kernelID string) [][]byte {
+	msgs, err := cd.outbox.Drain(kernelID)
+	if err != nil {
+		cd.logger.Error("error draining outbox", logger.LogError(err), slog.String("kernel", kernelID))
+	}
+	if len(msgs) != 0 {
+		cd.logger.Info("replaying results", slog.String("kernel", kernelID), slog.Int("count", len(msgs)))
+	}
+	return msgs
+}
+
+// Release отключает клиентов ядра, которое забрала другая реплика: пользователь переподключился к ней.
+// Сессия завершается без остановки ядра
+func (cd *ComilerDelivery) Release(kernelID ids.ID) {
+	cd.usecase.ReleaseKernel(kernelID)
+	cd.history.FailKernel(kernelID.String(), errKernelReleased)
+	sess, ok := cd.hub.Lookup(kernelID.String())
+	if !ok {
+		return
+	}
+	cd.hub.End(sess)
+	cd.closeConns(sess)
+}
+
+// closeConns отключает всех клиентов сессии
+func (cd *ComilerDelivery) closeConns(sess *session.Session) {
+	for _, conn := range sess.Conns() {
+		err := conn.Close()
 		if err != nil {
-			cd.logger.Error("error sending message", logger.LogError(err))
+			cd.logger.Error("error closing conn", logger.LogError(err))
 		}
-	} else {
-		cd.logger.Error("couldn't find user", slog.String("id", userId))
 	}
 }
 
 func (cd *ComilerDelivery) RegisterRoutes(apiGroup *router.Group) {
 	group := apiGroup.Group("/ws")
 	group.ANY("/", cd.authMW.AuthMiddleware(cd.accessMW.MW(cd.Compile)))
+	cd.registerREST(apiGroup)
 }
@@ -0,0 +1,423 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dnonakolesax/noted-runner/internal/audit"
+	"github.com/dnonakolesax/noted-runner/internal/blocksource"
+	"github.com/dnonakolesax/noted-runner/internal/configs"
+	"github.com/dnonakolesax/noted-runner/internal/consts"
+	"github.com/dnonakolesax/noted-runner/internal/ids"
+	"github.com/dnonakolesax/noted-runner/internal/ipynb"
+	"github.com/dnonakolesax/noted-runner/internal/metrics"
+	"github.com/dnonakolesax/noted-runner/internal/model"
+	"github.com/dnonakolesax/noted-runner/internal/mount"
+	"github.com/dnonakolesax/noted-runner/internal/outbox"
+	"github.com/dnonakolesax/noted-runner/internal/session"
+	"github.com/fasthttp/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+const testKernel = "0b3c9a52-6f2e-4d0c-9a57-2f8b1c3d4e5f"
+
+type recordingConn struct {
+	mu   sync.Mutex
+	sent []string
+}
+
+func (rc *recordingConn) Send(msg []byte) error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.sent = append(rc.sent, string(msg))
+	return nil
+}
+
+func (rc *recordingConn) Close() error {
+	return nil
+}
+
+func newDelivery(t *testing.T) *ComilerDelivery {
+	box := outbox.NewOutbox(&configs.OutboxConfig{MemoryLimit: 1, DiskLimit: 1}, mount.NewRoot(t.TempDir()),
+		slog.Default())
+	hub := session.NewHub(&configs.SessionConfig{GracePeriod: time.Hour, ReplayBuffer: 8}, slog.Default())
+	journal, err := audit.NewJournal(&configs.AuditConfig{Dir: t.TempDir()}, slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	history := audit.NewTracker(journal, 16, slog.Default())
+	return NewComilerDelivery(nil, hub, box, history, &configs.WebSocketConfig{},
+		&configs.RESTConfig{SyncTimeout: time.Second, MaxSyncTimeout: time.Second, ExecutionTTL: time.Hour}, nil,
+		slog.Default(), nil, nil)
+}
+
+func TestSendMemesRetainsAndReplays(t *testing.T) {
+	cd := newDelivery(t)
+
+	for _, memes := range []string{`{"result":"first"}`, `{"result":"second"}`} {
+		if err := cd.SendMemes(testKernel, memes); err != nil {
+			t.Fatalf("result %q was not retained: %v", memes, err)
+		}
+	}
+	if err := cd.SendMemes(testKernel, `{"result":"third"}`); err == nil || errors.Is(err, model.ErrCompleted) {
+		t.Fatalf("full outbox accepted a result or it was counted as completed: %v", err)
+	}
+	// результат засчитан REST-запуску: при повторной доставке его засчитал бы следующий запуск
+	cd.executions.Start(testKernel, testBlock, testUser)
+	err := cd.SendMemes(testKernel, `{"kernel_id":"`+testKernel+`","block_id":"`+testBlock+`","result":"rest"}`)
+	if !errors.Is(err, model.ErrCompleted) {
+		t.Fatalf("undelivered completed result is retryable: %v", err)
+	}
+
+	sess, err := cd.hub.Open("user", testKernel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := &recordingConn{}
+	if err := cd.hub.Attach(sess, conn, 0, func() [][]byte { return cd.drain(testKernel) }); err != nil {
+		t.Fatal(err)
+	}
+	if err := cd.SendMemes(testKernel, `{"result":"live"}`); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{`"result":"first"`, `"result":"second"`, `"result":"live","fail":false,"seq":1`}
+	if len(conn.sent) != len(want) {
+		t.Fatalf("got %v, want %v", conn.sent, want)
+	}
+	for i := range want {
+		if !strings.Contains(conn.sent[i], want[i]) {
+			t.Fatalf("got %v, want %v", conn.sent, want)
+		}
+	}
+}
+
+func TestResultCarriesHeads(t *testing.T) {
+	cd := newDelivery(t)
+	cd.usecase = &fakeUsecase{}
+	sess, _ := cd.hub.Open(testUser, testKernel)
+	conn := &recordingConn{}
+	_ = cd.hub.Attach(sess, conn, 0, func() [][]byte { return nil })
+
+	cd.handleClientMessage(ids.MustParse(testKernel), ids.MustParse(testUser),
+		model.ClientMessage{Type: model.ClientRun, BlockID: testBlock, Heads: []string{"h1"}})
+	_ = cd.SendMemes(testKernel, `{"kernel_id":"`+testKernel+`","block_id":"`+testBlock+`","result":"old"}`)
+	if len(conn.sent) != 1 || !strings.Contains(conn.sent[0], `"heads":["h1"]`) {
+		t.Fatalf("result does not carry the block version: %v", conn.sent)
+	}
+}
+
+func TestTestModeResult(t *testing.T) {
+	cd := newDelivery(t)
+	cd.usecase = &fakeUsecase{}
+	sess, _ := cd.hub.Open(testUser, testKernel)
+	conn := &recordingConn{}
+	_ = cd.hub.Attach(sess, conn, 0, func() [][]byte { return nil })
+
+	output := "=== RUN   TestSum\n    block:4: got 3\n--- FAIL: TestSum (0.00s)\nFAIL\n"
+	result, _ := json.Marshal(model.KernelMessage{KernelID: testKernel, BlockID: testBlock, Result: output})
+	cd.handleClientMessage(ids.MustParse(testKernel), ids.MustParse(testUser),
+		model.ClientMessage{Type: model.ClientRun, BlockID: testBlock, Mode: model.ModeTest})
+	_ = cd.SendMemes(testKernel, string(result))
+	if len(conn.sent) != 1 {
+		t.Fatalf("got %v", conn.sent)
+	}
+	var msg model.KernelMessage
+	_ = json.Unmarshal([]byte(conn.sent[0]), &msg)
+	if !msg.Fail || msg.Tests == nil || len(msg.Tests.Tests) != 1 || msg.Tests.Tests[0].Messages[0].Line != 4 ||
+		!strings.Contains(msg.Result, "| TestSum | FAIL | 0.00s | line 4: got 3 |") {
+		t.Fatalf("test results are not reported: %+v", msg)
+	}
+}
+
+// TestConcurrentOpen открывает одно ядро от разных пользователей разом: как планировщик и клиент
+func TestConcurrentOpen(t *testing.T) {
+	cd := newDelivery(t)
+	usecase := &fakeUsecase{delay: 10 * time.Millisecond}
+	cd.usecase = usecase
+
+	var wg sync.WaitGroup
+	var opened, rejected atomic.Int64
+	for idx := range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			userID := ids.MustParse(fmt.Sprintf("6f0e4b1c-2a3d-4e5f-8a9b-0c1d2e3f4a%02d", idx))
+			_, err := cd.OpenKernel(ids.MustParse(testKernel), userID)
+			switch {
+			case err == nil:
+				opened.Add(1)
+			case errors.Is(err, session.ErrExists):
+				rejected.Add(1)
+			default:
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if opened.Load() != 1 || rejected.Load() != 15 {
+		t.Fatalf("opened %d, rejected %d", opened.Load(), rejected.Load())
+	}
+	if usecase.started != 1 || usecase.stopped != 0 {
+		t.Fatalf("losers touched the kernel: started %d, stopped %d", usecase.started, usecase.stopped)
+	}
+}
+
+func TestResumeAfterDisconnect(t *testing.T) {
+	cd := newDelivery(t)
+	sess, _ := cd.hub.Open("user", testKernel)
+
+	first := &recordingConn{}
+	_ = cd.hub.Attach(sess, first, 0, func() [][]byte { return cd.drain(testKernel) })
+	_ = cd.SendMemes(testKernel, `{"result":"before"}`)
+	cd.hub.Detach(sess, first)
+	cd.hub.Suspend(sess, cd.teardown)
+	_ = cd.SendMemes(testKernel, `{"result":"while away"}`)
+
+	_, _, err := cd.openSession(sess.Token, ids.MustParse(testKernel), ids.MustParse("6f0e4b1c-2a3d-4e5f-8a9b-0c1d2e3f4a5b"))
+	if !errors.Is(err, session.ErrUnknown) {
+		t.Fatalf("foreign user resumed a session: %v", err)
+	}
+	second := &recordingConn{}
+	if err := cd.hub.Attach(sess, second, 1, func() [][]byte { return cd.drain(testKernel) }); err != nil {
+		t.Fatal(err)
+	}
+	if len(second.sent) != 1 || !strings.Contains(second.sent[0], `"result":"while away"`) ||
+		!strings.Contains(second.sent[0], `"seq":2`) {
+		t.Fatalf("unexpected replay: %v", second.sent)
+	}
+}
+
+type fakeUsecase struct {
+	mu      sync.Mutex
+	started int
+	stopped int
+	// delay - сколько длится запуск ядра
+	delay time.Duration
+}
+
+func (fu *fakeUsecase) StartKernel(_ ids.ID, _ ids.ID) (string, error) {
+	time.Sleep(fu.delay)
+	fu.mu.Lock()
+	defer fu.mu.Unlock()
+	fu.started++
+	return "container", nil
+}
+
+// RunBlock считает все переданные параметры действующими, а версию блока - найденной
+func (fu *fakeUsecase) RunBlock(_ ids.ID, _ ids.ID, _ ids.ID, params map[string]json.RawMessage,
+	heads []string, mode string) (model.BlockRun, error) {
+	run := model.BlockRun{Mode: mode, Attempt: "at1", Heads: heads, SourceHash: "hash"}
+	for name, value := range params {
+		if run.Parameters == nil {
+			run.Parameters = make(map[string]string)
+		}
+		run.Parameters[name] = string(value)
+	}
+	return run, nil
+}
+
+func (fu *fakeUsecase) ForgetBlock(_ ids.ID, _ ids.ID, _ ids.ID) ([]string, error) {
+	return nil, nil
+}
+
+func (fu *fakeUsecase) StopKernel(_ ids.ID, _ ids.ID) error {
+	fu.mu.Lock()
+	defer fu.mu.Unlock()
+	fu.stopped++
+	return nil
+}
+
+func (fu *fakeUsecase) InterruptKernel(_ ids.ID, _ ids.ID) error {
+	return nil
+}
+
+func (fu *fakeUsecase) InspectKernel(_ ids.ID, _ ids.ID) (json.RawMessage, error) {
+	return nil, nil
+}
+
+func (fu *fakeUsecase) ReleaseKernel(_ ids.ID) {}
+
+// ExportNotebook собирает в main.go по строке на блок; layout package даёт два файла
+func (fu *fakeUsecase) ExportNotebook(_ ids.ID, blockIDs []ids.ID, layout string) (model.NotebookExport, error) {
+	if len(blockIDs) == 0 {
+		return model.NotebookExport{}, fmt.Errorf("%w: kernel", blocksource.ErrNotFound)
+	}
+	export := model.NotebookExport{Files: map[string]string{}, Skipped: []model.SkippedBlock{
+		{BlockID: blockIDs[0].String(), Reason: "doesn't compile"}}}
+	var src strings.Builder
+	for _, blockID := range blockIDs[1:] {
+		src.WriteString("// block " + blockID.String() + "\n")
+	}
+	export.Files["main.go"] = src.String()
+	if layout == model.ExportPackage {
+		export.Files["go.mod"] = "module notebook\n"
+	}
+	return export, nil
+}
+
+// ImportNotebook отвергает всё, кроме JSON, и создаёт по блоку на запрос
+func (fu *fakeUsecase) ImportNotebook(_ ids.ID, data []byte) (model.NotebookImport, error) {
+	if !json.Valid(data) {
+		return model.NotebookImport{}, fmt.Errorf("%w: not JSON", ipynb.ErrFormat)
+	}
+	return model.NotebookImport{Blocks: []model.ImportedBlock{{Cell: 1, BlockID: testBlock, Language: "go"}}}, nil
+}
+
+// NotebookIPYNB выгружает вывод последнего выполнения каждого блока
+func (fu *fakeUsecase) NotebookIPYNB(_ ids.ID, blockIDs []ids.ID,
+	lastRuns func(blockIDs []string) map[string]model.ExecutionRecord) ([]byte, error) {
+	names := make([]string, 0, len(blockIDs))
+	for _, blockID := range blockIDs {
+		names = append(names, blockID.String())
+	}
+	records := lastRuns(names)
+	var outputs []string
+	for _, blockID := range blockIDs {
+		if record, ok := records[blockID.String()]; ok {
+			outputs = append(outputs, record.Output)
+		}
+	}
+	return json.Marshal(outputs)
+}
+
+func (fu *fakeUsecase) counts() (int, int) {
+	fu.mu.Lock()
+	defer fu.mu.Unlock()
+	return fu.started, fu.stopped
+}
+
+const testUser = "6f0e4b1c-2a3d-4e5f-8a9b-0c1d2e3f4a5b"
+
+// serve поднимает Compile на in-memory листенере и возвращает функцию подключения к нему
+func serve(t *testing.T, cd *ComilerDelivery) func(query string) (*websocket.Conn, error) {
+	t.Helper()
+	ln := fasthttputil.NewInmemoryListener()
+	server := &fasthttp.Server{Handler: func(ctx *fasthttp.RequestCtx) {
+		ctx.SetUserValue(consts.CtxUserIDKey, testUser)
+		cd.Compile(ctx)
+	}}
+	go func() {
+		_ = server.Serve(ln)
+	}()
+	t.Cleanup(func() {
+		_ = server.Shutdown()
+	})
+
+	dialer := websocket.Dialer{NetDial: func(_, _ string) (net.Conn, error) { return ln.Dial() }}
+	return func(query string) (*websocket.Conn, error) {
+		ws, _, err := dialer.Dial("ws://runner/ws?kernel-id="+testKernel+query, nil)
+		return ws, err
+	}
+}
+
+func readSession(t *testing.T, ws *websocket.Conn) model.SessionMessage {
+	t.Helper()
+	var msg model.SessionMessage
+	if err := ws.ReadJSON(&msg); err != nil || msg.Type != model.SessionOpened || msg.Token == "" {
+		t.Fatalf("no session message: %+v, %v", msg, err)
+	}
+	return msg
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHeartbeatTimeoutSuspendsSession(t *testing.T) {
+	uc := &fakeUsecase{}
+	cd := newDelivery(t)
+	cd.usecase = uc
+	cd.wsConfig = &configs.WebSocketConfig{PingInterval: 10 * time.Millisecond, PongWait: 50 * time.Millisecond,
+		WriteWait: time.Second}
+	cd.metrics = metrics.NewWSMetrics(prometheus.NewRegistry(), "test_ws")
+	dial := serve(t, cd)
+
+	// клиент, который не читает сокет, не отвечает и на ping
+	ws, err := dial("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = ws.Close()
+	}()
+	waitFor(t, "heartbeat timeout", func() bool { return testutil.ToFloat64(cd.metrics.HeartbeatTimeouts) == 1 })
+	waitFor(t, "detach", func() bool { return !cd.hub.Connected(testUser) })
+
+	if testutil.ToFloat64(cd.metrics.ActiveConnections) != 0 {
+		t.Fatalf("dead connection is still counted as active")
+	}
+	if started, stopped := uc.counts(); started != 1 || stopped != 0 || cd.hub.Len() != 1 {
+		t.Fatalf("kernel must survive the grace period: started %d, stopped %d", started, stopped)
+	}
+
+	sess, _ := cd.hub.Lookup(testKernel)
+	resumed, err := dial("&session-token=" + sess.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = resumed.Close()
+	}()
+	if msg := readSession(t, resumed); !msg.Resumed {
+		t.Fatalf("session was not resumed")
+	}
+	if started, _ := uc.counts(); started != 1 {
+		t.Fatalf("resume started a new kernel")
+	}
+
+	if err := resumed.WriteJSON(model.ClientMessage{Type: model.ClientShutdown}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "explicit shutdown", func() bool { _, stopped := uc.counts(); return stopped == 1 })
+	if cd.hub.Len() != 0 {
+		t.Fatalf("session outlived an explicit shutdown")
+	}
+
+	if _, err := dial("&session-token=" + sess.Token); err == nil {
+		t.Fatalf("resumed a session after shutdown")
+	}
+}
+
+func TestSendMemesKeepsKernelFields(t *testing.T) {
+	cd := newDelivery(t)
+	cd.usecase = &fakeUsecase{}
+	sess, _ := cd.hub.Open(testUser, testKernel)
+	conn := &recordingConn{}
+	_ = cd.hub.Attach(sess, conn, 0, func() [][]byte { return nil })
+
+	cd.handleClientMessage(ids.MustParse(testKernel), ids.MustParse(testUser),
+		model.ClientMessage{Type: model.ClientRun, BlockID: testBlock, Heads: []string{"h1"}})
+	_ = cd.SendMemes(testKernel, `{"kernel_id":"`+testKernel+`","block_id":"`+testBlock+
+		`","result":"42","duration_ms":17,"images":[{"mime":"image/png"}]}`)
+	if len(conn.sent) != 1 {
+		t.Fatalf("got %v", conn.sent)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(conn.sent[0]), &fields); err != nil {
+		t.Fatal(err)
+	}
+	if string(fields["duration_ms"]) != "17" || string(fields["images"]) != `[{"mime":"image/png"}]` ||
+		string(fields["heads"]) != `["h1"]` || string(fields["seq"]) != "1" || string(fields["result"]) != `"42"` {
+		t.Fatalf("kernel fields are not forwarded: %s", conn.sent[0])
+	}
+}
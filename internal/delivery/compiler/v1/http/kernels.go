@@ -0,0 +1,119 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/dnonakolesax/noted-runner/internal/ids"
+	"github.com/dnonakolesax/noted-runner/internal/logger"
+	"github.com/dnonakolesax/noted-runner/internal/model"
+	"github.com/dnonakolesax/noted-runner/internal/registry"
+	"github.com/dnonakolesax/noted-runner/internal/session"
+)
+
+// ErrNotStarted - у пользователя нет сессии с ядром; чужая сессия неотличима от отсутствующей
+var ErrNotStarted = errors.New("kernel is not started")
+
+// Операции с ядрами, общие для REST и gRPC: транспорты отличаются только разбором запроса и ответом
+
+// OpenKernel запускает ядро под новую сессию пользователя. Сессия открывается до запуска контейнера:
+// она атомарно занимает ядро, и тот, кому это не удалось, получает session.ErrExists, не трогая
+// чужой контейнер. Если ядро не запустилось, сессия завершается
+func (cd *ComilerDelivery) OpenKernel(kernelID ids.ID, userID ids.ID) (*session.Session, error) {
+	sess, err := cd.hub.Open(userID.String(), kernelID.String())
+	if err != nil {
+		return nil, err
+	}
+	_, err = cd.startKernel(kernelID, userID)
+	if err != nil {
+		cd.logger.Error("error starting kernel", logger.LogError(err))
+		cd.hub.End(sess)
+		cd.closeConns(sess)
+		return nil, err
+	}
+	return sess, nil
+}
+
+func (cd *ComilerDelivery) OwnSession(kernelID ids.ID, userID ids.ID) (*session.Session, error) {
+	sess, ok := cd.hub.Lookup(kernelID.String())
+	if !ok || sess.UserID != userID.String() {
+		return nil, ErrNotStarted
+	}
+	return sess, nil
+}
+
+// RunExecution отправляет блок в ядро. Запуск регистрируется до отправки, чтобы не пропустить
+// быстрый результат; при ошибке отправки возвращается уже проваленный запуск
+func (cd *ComilerDelivery) RunExecution(kernelID ids.ID, blockID ids.ID, userID ids.ID,
+	params map[string]json.RawMessage, heads []string, mode string) (*registry.Execution, error) {
+	if _, err := cd.OwnSession(kernelID, userID); err != nil {
+		return nil, err
+	}
+	execution := cd.executions.Start(kernelID.String(), blockID.String(), userID.String())
+	run, err := cd.runBlock(kernelID, blockID, userID, params, heads, mode)
+	execution.AddWarnings(run.Warnings)
+	execution.SetParameters(run.Parameters)
+	execution.SetHeads(run.Heads)
+	if err != nil {
+		cd.logger.Error("error running block", logger.LogError(err))
+		cd.executions.Fail(execution, err)
+		return execution, err
+	}
+	return execution, nil
+}
+
+func (cd *ComilerDelivery) InterruptKernel(kernelID ids.ID, userID ids.ID) error {
+	if _, err := cd.OwnSession(kernelID, userID); err != nil {
+		return err
+	}
+	return cd.usecase.InterruptKernel(kernelID, userID)
+}
+
+func (cd *ComilerDelivery) InspectKernel(kernelID ids.ID, userID ids.ID) (json.RawMessage, error) {
+	if _, err := cd.OwnSession(kernelID, userID); err != nil {
+		return nil, err
+	}
+	return cd.usecase.InspectKernel(kernelID, userID)
+}
+
+// StopSession отключает клиентов и останавливает ядро
+func (cd *ComilerDelivery) StopSession(kernelID ids.ID, userID ids.ID) error {
+	sess, err := cd.OwnSession(kernelID, userID)
+	if err != nil {
+		return err
+	}
+	cd.closeConns(sess)
+	cd.teardown(sess)
+	return nil
+}
+
+// startKernel, stopKernel и runBlock - обращения к usecase, которые попадают в журнал
+// startKernel запускает ядро. Если ядро уже запускает или держит кто-то другой, возвращается
+// session.ErrExists: его контейнер и очередь команд вызывающий не трогает
+func (cd *ComilerDelivery) startKernel(kernelID ids.ID, userID ids.ID) (string, error) {
+	id, err := cd.usecase.StartKernel(kernelID, userID)
+	if errors.Is(err, registry.ErrReserved) {
+		return "", session.ErrExists
+	}
+	if err == nil {
+		cd.history.Event(model.AuditKernelStart, userID.String(), kernelID.String(), "")
+	}
+	return id, err
+}
+
+func (cd *ComilerDelivery) stopKernel(kernelID ids.ID, userID ids.ID, reason string) error {
+	err := cd.usecase.StopKernel(kernelID, userID)
+	if err == nil {
+		cd.history.Event(model.AuditKernelStop, userID.String(), kernelID.String(), reason)
+	}
+	return err
+}
+
+func (cd *ComilerDelivery) runBlock(kernelID ids.ID, blockID ids.ID, userID ids.ID,
+	params map[string]json.RawMessage, heads []string, mode string) (model.BlockRun, error) {
+	startedAt := time.Now()
+	run, err := cd.usecase.RunBlock(kernelID, blockID, userID, params, heads, mode)
+	cd.history.Begin(kernelID.String(), blockID.String(), userID.String(), startedAt, run, err)
+	return run, err
+}
@@ -0,0 +1,523 @@
+package http
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dnonakolesax/noted-runner/internal/audit"
+	"github.com/dnonakolesax/noted-runner/internal/blocksource"
+	"github.com/dnonakolesax/noted-runner/internal/consts"
+	"github.com/dnonakolesax/noted-runner/internal/ids"
+	"github.com/dnonakolesax/noted-runner/internal/ipynb"
+	"github.com/dnonakolesax/noted-runner/internal/logger"
+	"github.com/dnonakolesax/noted-runner/internal/model"
+	"github.com/dnonakolesax/noted-runner/internal/openapi"
+	"github.com/dnonakolesax/noted-runner/internal/session"
+	"github.com/fasthttp/router"
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	errKernelStopped   = errors.New("kernel stopped")
+	errKernelRestarted = errors.New("kernel restarted")
+	errKernelReleased  = errors.New("kernel moved to another replica")
+)
+
+const (
+	openAPIPath         = "/openapi.json"
+	defaultHistoryLimit = 100
+	maxHistoryLimit     = 1000
+	exportArchive       = "notebook.zip"
+	exportNotebook      = "notebook.ipynb"
+)
+
+// route - REST-ручка вместе с её описанием для OpenAPI. access - проверять права на ядро из пути,
+// write - требовать вдобавок право на запись: ручка меняет блоки блокнота
+type route struct {
+	openapi.Route
+	handler fasthttp.RequestHandler
+	access  bool
+	write   bool
+}
+
+func (cd *ComilerDelivery) routes() []route {
+	badRequest := []int{http.StatusBadRequest, http.StatusUnauthorized}
+	notFound := []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound}
+	return []route{
+		{Route: openapi.Route{Method: http.MethodPost, Path: "/kernels/{kernel-id}", Summary: "Start a kernel",
+			Response: model.KernelCreated{}, Status: http.StatusCreated, Errors: append(badRequest, http.StatusConflict)},
+			handler: cd.CreateKernel, access: true},
+		{Route: openapi.Route{Method: http.MethodGet, Path: "/kernels", Summary: "List the user's kernels",
+			Response: []model.KernelStatus{}, Errors: []int{http.StatusUnauthorized}},
+			handler: cd.ListKernels},
+		{Route: openapi.Route{Method: http.MethodGet, Path: "/kernels/{kernel-id}", Summary: "Get kernel status",
+			Response: model.KernelStatus{}, Errors: notFound},
+			handler: cd.KernelStatus, access: true},
+		{Route: openapi.Route{Method: http.MethodPost, Path: "/kernels/{kernel-id}/executions",
+			Summary: "Execute a block; waits for the result unless async is set",
+			Request: model.ExecuteRequest{}, Response: model.Execution{}, Errors: notFound},
+			handler: cd.Execute, access: true},
+		{Route: openapi.Route{Method: http.MethodGet, Path: "/kernels/{kernel-id}/executions/{execution-id}",
+			Summary: "Get an execution result", Response: model.Execution{}, Errors: notFound},
+			handler: cd.ExecutionResult, access: true},
+		{Route: openapi.Route{Method: http.MethodGet, Path: "/kernels/{kernel-id}/history",
+			Summary: "List recorded executions of the notebook, newest first",
+			Query:   []string{"block-id", "user-id", "limit"}, Response: []model.ExecutionRecord{},
+			Errors: append(badRequest, http.StatusServiceUnavailable)},
+			handler: cd.History, access: true},
+		{Route: openapi.Route{Method: http.MethodGet, Path: "/kernels/{kernel-id}/export",
+			Summary: "Download the notebook as a Go program: main.go, or a zip with a package and a test for layout=package",
+			Query:   []string{"blocks", "layout"}, Response: "", ContentType: "text/x-go", Errors: notFound},
+			handler: cd.Export, access: true},
+		{Route: openapi.Route{Method: http.MethodPost, Path: "/kernels/{kernel-id}/ipynb",
+			Summary:  "Import a Jupyter notebook with a Go kernel: the body is .ipynb JSON, cells become new blocks",
+			Response: model.NotebookImport{}, Status: http.StatusCreated,
+			Errors: append(badRequest, http.StatusInternalServerError)},
+			handler: cd.ImportIPYNB, access: true, write: true},
+		{Route: openapi.Route{Method: http.MethodGet, Path: "/kernels/{kernel-id}/ipynb",
+			Summary: "Download the notebook as .ipynb with the output of each block's last execution",
+			Query:   []string{"blocks"}, Response: map[string]any{}, ContentType: "application/x-ipynb+json",
+			Errors: notFound},
+			handler: cd.DownloadIPYNB, access: true},
+		{Route: openapi.Route{Method: http.MethodPost, Path: "/kernels/{kernel-id}/interrupt",
+			Summary: "Interrupt the running block", Status: http.StatusNoContent, Errors: notFound},
+			handler: cd.Interrupt, access: true},
+		{Route: openapi.Route{Method: http.MethodPost, Path: "/kernels/{kernel-id}/restart",
+			Summary: "Restart the kernel with a clean state", Status: http.StatusNoContent, Errors: notFound},
+			handler: cd.Restart, access: true},
+		{Route: openapi.Route{Method: http.MethodGet, Path: "/kernels/{kernel-id}/events",
+			Summary: "Stream session messages as Server-Sent Events; starts the kernel if needed",
+			Query:   []string{"session-token", "last-seq"}, Response: model.KernelMessage{},
+			ContentType: "text/event-stream", Errors: append(badRequest, http.StatusConflict, http.StatusGone)},
+			handler: cd.Events, access: true},
+		{Route: openapi.Route{Method: http.MethodPost, Path: "/kernels/{kernel-id}/commands",
+			Summary: "Send a command; responses are delivered to every connected client",
+			Request: model.ClientMessage{}, Status: http.StatusAccepted, Errors: notFound},
+			handler: cd.Commands, access: true},
+		{Route: openapi.Route{Method: http.MethodDelete, Path: "/kernels/{kernel-id}", Summary: "Stop the kernel",
+			Status: http.StatusNoContent, Errors: notFound},
+			handler: cd.DeleteKernel, access: true},
+	}
+}
+
+func (cd *ComilerDelivery) registerREST(apiGroup *router.Group) {
+	routes := cd.routes()
+	for _, r := range routes {
+		apiGroup.Handle(r.Method, r.Path, cd.authMW.AuthMiddleware(cd.guard(r)))
+	}
+
+	specs := make([]openapi.Route, 0, len(routes)+len(cd.described))
+	for _, r := range routes {
+		specs = append(specs, r.Route)
+	}
+	specs = append(specs, cd.described...)
+	apiGroup.GET(openAPIPath, func(ctx *fasthttp.RequestCtx) {
+		basePath := strings.TrimSuffix(string(ctx.Path()), openAPIPath)
+		cd.writeJSON(ctx, fasthttp.StatusOK, openapi.Document("noted-runner", "1", basePath, specs))
+	})
+}
+
+// guard оборачивает ручку проверкой прав на ядро
+func (cd *ComilerDelivery) guard(r route) fasthttp.RequestHandler {
+	switch {
+	case r.write:
+		return cd.accessMW.Require("wx", r.handler)
+	case r.access:
+		return cd.accessMW.MW(r.handler)
+	}
+	return r.handler
+}
+
+// Describe добавляет ручки другой доставки в документ OpenAPI; вызывается до RegisterRoutes
+func (cd *ComilerDelivery) Describe(routes ...openapi.Route) {
+	cd.described = append(cd.described, routes...)
+}
+
+func (cd *ComilerDelivery) CreateKernel(ctx *fasthttp.RequestCtx) {
+	kernelID, userID, ok := cd.restIDs(ctx)
+	if !ok {
+		return
+	}
+	sess, err := cd.OpenKernel(kernelID, userID)
+	if err != nil {
+		cd.writeError(ctx, sessionStatus(err), err)
+		return
+	}
+	cd.writeJSON(ctx, fasthttp.StatusCreated, model.KernelCreated{KernelID: sess.KernelID, Token: sess.Token})
+}
+
+func (cd *ComilerDelivery) ListKernels(ctx *fasthttp.RequestCtx) {
+	userID, _ := ctx.Request.UserValue(consts.CtxUserIDKey).(string)
+	statuses := []model.KernelStatus{}
+	for _, sess := range cd.hub.List(userID) {
+		statuses = append(statuses, model.KernelStatus{KernelID: sess.KernelID, Connected: sess.Connected(),
+			Seq: sess.Seq()})
+	}
+	cd.writeJSON(ctx, fasthttp.StatusOK, statuses)
+}
+
+// KernelStatus дополняет сведения о сессии состоянием, которое сообщает само ядро
+func (cd *ComilerDelivery) KernelStatus(ctx *fasthttp.RequestCtx) {
+	kernelID, userID, ok := cd.restIDs(ctx)
+	if !ok {
+		return
+	}
+	sess, ok := cd.ownSession(ctx, kernelID, userID)
+	if !ok {
+		return
+	}
+
+	status := model.KernelStatus{KernelID: sess.KernelID, Connected: sess.Connected(), Seq: sess.Seq()}
+	state, err := cd.usecase.InspectKernel(kernelID, userID)
+	if err != nil {
+		status.Error = err.Error()
+	} else {
+		status.State = state
+	}
+	cd.writeJSON(ctx, fasthttp.StatusOK, status)
+}
+
+func (cd *ComilerDelivery) Execute(ctx *fasthttp.RequestCtx) {
+	kernelID, userID, ok := cd.restIDs(ctx)
+	if !ok {
+		return
+	}
+	if _, ok = cd.ownSession(ctx, kernelID, userID); !ok {
+		return
+	}
+
+	var req model.ExecuteRequest
+	err := json.Unmarshal(ctx.PostBody(), &req)
+	if err != nil {
+		cd.writeError(ctx, fasthttp.StatusBadRequest, errors.New("invalid body: expected JSON"))
+		return
+	}
+	blockID, err := ids.Parse(req.BlockID)
+	if err != nil {
+		cd.writeError(ctx, fasthttp.StatusBadRequest, errors.New("invalid block_id: expected UUID"))
+		return
+	}
+	timeout, err := cd.syncTimeout(req.Timeout)
+	if err != nil {
+		cd.writeError(ctx, fasthttp.StatusBadRequest, err)
+		return
+	}
+
+	execution, err := cd.RunExecution(kernelID, blockID, userID, req.Parameters, req.Heads, req.Mode)
+	// сессию могли закрыть после проверки выше: тогда запуска нет вовсе
+	if execution == nil {
+		status := fasthttp.StatusBadRequest
+		if errors.Is(err, ErrNotStarted) {
+			status = fasthttp.StatusNotFound
+		}
+		cd.writeError(ctx, status, err)
+		return
+	}
+	if err != nil {
+		cd.writeJSON(ctx, fasthttp.StatusBadRequest, execution.Snapshot())
+		return
+	}
+
+	if req.Async {
+		cd.writeJSON(ctx, fasthttp.StatusAccepted, execution.Snapshot())
+		return
+	}
+	select {
+	case <-execution.Done():
+		cd.writeJSON(ctx, fasthttp.StatusOK, execution.Snapshot())
+	case <-time.After(timeout):
+		cd.writeJSON(ctx, fasthttp.StatusAccepted, execution.Snapshot())
+	}
+}
+
+func (cd *ComilerDelivery) ExecutionResult(ctx *fasthttp.RequestCtx) {
+	kernelID, userID, ok := cd.restIDs(ctx)
+	if !ok {
+		return
+	}
+	executionID, _ := ctx.UserValue("execution-id").(string)
+	execution, ok := cd.executions.Get(executionID)
+	if !ok || execution.UserID != userID.String() || execution.Snapshot().KernelID != kernelID.String() {
+		cd.writeError(ctx, fasthttp.StatusNotFound, errors.New("unknown execution"))
+		return
+	}
+	cd.writeJSON(ctx, fasthttp.StatusOK, execution.Snapshot())
+}
+
+// History отдаёт журнал выполнений блокнота от всех его пользователей; block-id и user-id сужают выборку
+func (cd *ComilerDelivery) History(ctx *fasthttp.RequestCtx) {
+	kernelID, _, ok := cd.restIDs(ctx)
+	if !ok {
+		return
+	}
+	filter := audit.Filter{KernelID: kernelID.String(), BlockID: string(ctx.QueryArgs().Peek("block-id")),
+		UserID: string(ctx.QueryArgs().Peek("user-id")), Limit: defaultHistoryLimit}
+	if ctx.QueryArgs().Has("limit") {
+		limit, err := ctx.QueryArgs().GetUint("limit")
+		if err != nil || limit == 0 || limit > maxHistoryLimit {
+			cd.writeError(ctx, fasthttp.StatusBadRequest, errors.New("invalid limit"))
+			return
+		}
+		filter.Limit = limit
+	}
+
+	records, err := cd.history.Executions(filter)
+	if err != nil {
+		cd.logger.Error("error reading execution history", logger.LogError(err))
+		cd.writeError(ctx, fasthttp.StatusServiceUnavailable, errors.New("execution history is unavailable"))
+		return
+	}
+	cd.writeJSON(ctx, fasthttp.StatusOK, records)
+}
+
+// Export отдаёт блокнот программой на Go. blocks - ID блоков через запятую в нужном порядке, без него
+// берутся все блоки по порядку ID. Пропущенные блоки перечислены в X-Skipped-Blocks и в комментарии программы
+func (cd *ComilerDelivery) Export(ctx *fasthttp.RequestCtx) {
+	kernelID, _, ok := cd.restIDs(ctx)
+	if !ok {
+		return
+	}
+	blockIDs, ok := cd.queryBlocks(ctx)
+	if !ok {
+		return
+	}
+	layout := string(ctx.QueryArgs().Peek("layout"))
+	if layout == "" {
+		layout = model.ExportMain
+	}
+
+	export, err := cd.usecase.ExportNotebook(kernelID, blockIDs, layout)
+	if errors.Is(err, blocksource.ErrNotFound) {
+		cd.writeError(ctx, fasthttp.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		cd.writeError(ctx, fasthttp.StatusBadRequest, err)
+		return
+	}
+
+	skipped := make([]string, 0, len(export.Skipped))
+	for _, block := range export.Skipped {
+		skipped = append(skipped, block.BlockID)
+	}
+	if len(skipped) != 0 {
+		ctx.Response.Header.Set("X-Skipped-Blocks", strings.Join(skipped, ","))
+	}
+	if src, ok := export.Files["main.go"]; ok && len(export.Files) == 1 {
+		attachment(ctx, "main.go", "text/x-go; charset=utf-8", []byte(src))
+		return
+	}
+	data, err := zipFiles(export.Files)
+	if err != nil {
+		cd.logger.Error("error packing notebook export", logger.LogError(err))
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		return
+	}
+	attachment(ctx, exportArchive, "application/zip", data)
+}
+
+// ImportIPYNB создаёт блоки из ячеек .ipynb и сообщает, какие ячейки пришлось изменить или пропустить
+func (cd *ComilerDelivery) ImportIPYNB(ctx *fasthttp.RequestCtx) {
+	kernelID, _, ok := cd.restIDs(ctx)
+	if !ok {
+		return
+	}
+	imported, err := cd.usecase.ImportNotebook(kernelID, ctx.PostBody())
+	if errors.Is(err, ipynb.ErrFormat) || errors.Is(err, ipynb.ErrLanguage) {
+		cd.writeError(ctx, fasthttp.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		cd.writeError(ctx, fasthttp.StatusInternalServerError, errors.New("error storing imported blocks"))
+		return
+	}
+	cd.writeJSON(ctx, fasthttp.StatusCreated, imported)
+}
+
+// DownloadIPYNB отдаёт блоки в .ipynb; вывод берётся из последнего выполнения блока в журнале
+func (cd *ComilerDelivery) DownloadIPYNB(ctx *fasthttp.RequestCtx) {
+	kernelID, _, ok := cd.restIDs(ctx)
+	if !ok {
+		return
+	}
+	blockIDs, ok := cd.queryBlocks(ctx)
+	if !ok {
+		return
+	}
+	lastRuns := func(blockIDs []string) map[string]model.ExecutionRecord {
+		records, err := cd.history.LastExecutions(kernelID.String(), blockIDs)
+		if err != nil {
+			cd.logger.Warn("error reading execution history", logger.LogError(err))
+			return nil
+		}
+		return records
+	}
+
+	data, err := cd.usecase.NotebookIPYNB(kernelID, blockIDs, lastRuns)
+	if errors.Is(err, blocksource.ErrNotFound) {
+		cd.writeError(ctx, fasthttp.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		cd.writeError(ctx, fasthttp.StatusBadRequest, err)
+		return
+	}
+	attachment(ctx, exportNotebook, "application/x-ipynb+json", data)
+}
+
+// queryBlocks разбирает blocks - ID блоков через запятую; пустой список значит все блоки
+func (cd *ComilerDelivery) queryBlocks(ctx *fasthttp.RequestCtx) ([]ids.ID, bool) {
+	raw := string(ctx.QueryArgs().Peek("blocks"))
+	if raw == "" {
+		return nil, true
+	}
+	var blockIDs []ids.ID
+	for _, rawID := range strings.Split(raw, ",") {
+		blockID, err := ids.Parse(rawID)
+		if err != nil {
+			cd.writeError(ctx, fasthttp.StatusBadRequest, errors.New("invalid blocks: expected comma-separated UUIDs"))
+			return nil, false
+		}
+		blockIDs = append(blockIDs, blockID)
+	}
+	return blockIDs, true
+}
+
+func (cd *ComilerDelivery) Interrupt(ctx *fasthttp.RequestCtx) {
+	kernelID, userID, ok := cd.restIDs(ctx)
+	if !ok {
+		return
+	}
+	if _, ok = cd.ownSession(ctx, kernelID, userID); !ok {
+		return
+	}
+	err := cd.usecase.InterruptKernel(kernelID, userID)
+	if err != nil {
+		cd.writeError(ctx, fasthttp.StatusBadRequest, err)
+		return
+	}
+	ctx.SetStatusCode(fasthttp.StatusNoContent)
+}
+
+// Restart пересоздаёт контейнер ядра, оставляя сессию: подключённые клиенты продолжают получать сообщения
+func (cd *ComilerDelivery) Restart(ctx *fasthttp.RequestCtx) {
+	kernelID, userID, ok := cd.restIDs(ctx)
+	if !ok {
+		return
+	}
+	if _, ok = cd.ownSession(ctx, kernelID, userID); !ok {
+		return
+	}
+	err := cd.stopKernel(kernelID, userID, "restart")
+	if err != nil {
+		cd.logger.Error("error stopping kernel", logger.LogError(err))
+	}
+	cd.executions.FailKernel(kernelID.String(), errKernelRestarted)
+	cd.history.FailKernel(kernelID.String(), errKernelRestarted)
+	_, err = cd.startKernel(kernelID, userID)
+	if err != nil {
+		cd.logger.Error("error starting kernel", logger.LogError(err))
+		cd.writeError(ctx, fasthttp.StatusBadRequest, err)
+		return
+	}
+	ctx.SetStatusCode(fasthttp.StatusNoContent)
+}
+
+func (cd *ComilerDelivery) DeleteKernel(ctx *fasthttp.RequestCtx) {
+	kernelID, userID, ok := cd.restIDs(ctx)
+	if !ok {
+		return
+	}
+	err := cd.StopSession(kernelID, userID)
+	if err != nil {
+		cd.writeError(ctx, fasthttp.StatusNotFound, err)
+		return
+	}
+	ctx.SetStatusCode(fasthttp.StatusNoContent)
+}
+
+func (cd *ComilerDelivery) restIDs(ctx *fasthttp.RequestCtx) (ids.ID, ids.ID, bool) {
+	rawKernelID, _ := ctx.UserValue("kernel-id").(string)
+	kernelID, err := ids.Parse(rawKernelID)
+	if err != nil {
+		cd.writeError(ctx, fasthttp.StatusBadRequest, errors.New("invalid kernel-id: expected UUID"))
+		return "", "", false
+	}
+	rawUserID, _ := ctx.Request.UserValue(consts.CtxUserIDKey).(string)
+	userID, err := ids.Parse(rawUserID)
+	if err != nil {
+		cd.writeError(ctx, fasthttp.StatusBadRequest, errors.New("invalid user id: expected UUID"))
+		return "", "", false
+	}
+	return kernelID, userID, true
+}
+
+func (cd *ComilerDelivery) ownSession(ctx *fasthttp.RequestCtx, kernelID ids.ID, userID ids.ID) (*session.Session, bool) {
+	sess, err := cd.OwnSession(kernelID, userID)
+	if err != nil {
+		cd.writeError(ctx, fasthttp.StatusNotFound, err)
+		return nil, false
+	}
+	return sess, true
+}
+
+func (cd *ComilerDelivery) syncTimeout(raw string) (time.Duration, error) {
+	if raw == "" {
+		return cd.restConfig.SyncTimeout, nil
+	}
+	timeout, err := time.ParseDuration(raw)
+	if err != nil || timeout <= 0 {
+		return 0, errors.New("invalid timeout: expected duration like 30s")
+	}
+	return min(timeout, cd.restConfig.MaxSyncTimeout), nil
+}
+
+func (cd *ComilerDelivery) writeJSON(ctx *fasthttp.RequestCtx, status int, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		cd.logger.Error("error marshaling response", logger.LogError(err))
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.SetStatusCode(status)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(data)
+}
+
+func attachment(ctx *fasthttp.RequestCtx, name string, contentType string, data []byte) {
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType(contentType)
+	ctx.Response.Header.Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	ctx.SetBody(data)
+}
+
+func zipFiles(files map[string]string) ([]byte, error) {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		w, err := archive.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = w.Write([]byte(files[name])); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (cd *ComilerDelivery) writeError(ctx *fasthttp.RequestCtx, status int, err error) {
+	cd.logger.Warn("rest request failed", slog.Int("status", status), logger.LogError(err))
+	cd.writeJSON(ctx, status, model.ErrorResponse{Error: err.Error()})
+}
@@ -0,0 +1,364 @@
+package http
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dnonakolesax/noted-runner/internal/audit"
+	"github.com/dnonakolesax/noted-runner/internal/consts"
+	"github.com/dnonakolesax/noted-runner/internal/metrics"
+	"github.com/dnonakolesax/noted-runner/internal/middlewares"
+	"github.com/dnonakolesax/noted-runner/internal/model"
+	access "github.com/dnonakolesax/noted-runner/internal/usecase/access/proto"
+	"github.com/fasthttp/router"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/valyala/fasthttp"
+	"google.golang.org/grpc"
+)
+
+const testBlock = "4bcb102d-1c2e-4f3a-8b9c-0d1e2f3a4b5c"
+
+func restCtx(userID string, body any, params ...string) *fasthttp.RequestCtx {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.SetUserValue(consts.CtxUserIDKey, userID)
+	ctx.SetUserValue("kernel-id", testKernel)
+	for i := 0; i+1 < len(params); i += 2 {
+		ctx.SetUserValue(params[i], params[i+1])
+	}
+	if body != nil {
+		data, _ := json.Marshal(body)
+		ctx.Request.SetBody(data)
+	}
+	return ctx
+}
+
+func decode[T any](t *testing.T, ctx *fasthttp.RequestCtx, status int) T {
+	t.Helper()
+	if ctx.Response.StatusCode() != status {
+		t.Fatalf("expected status %d, got %d: %s", status, ctx.Response.StatusCode(), ctx.Response.Body())
+	}
+	var v T
+	if err := json.Unmarshal(ctx.Response.Body(), &v); err != nil {
+		t.Fatalf("invalid body %s: %v", ctx.Response.Body(), err)
+	}
+	return v
+}
+
+func TestRESTLifecycle(t *testing.T) {
+	uc := &fakeUsecase{}
+	cd := newDelivery(t)
+	cd.usecase = uc
+
+	ctx := restCtx(testUser, nil)
+	cd.CreateKernel(ctx)
+	created := decode[model.KernelCreated](t, ctx, fasthttp.StatusCreated)
+	if created.KernelID != testKernel || created.Token == "" {
+		t.Fatalf("unexpected create response: %+v", created)
+	}
+	ctx = restCtx(testUser, nil)
+	cd.CreateKernel(ctx)
+	if ctx.Response.StatusCode() != fasthttp.StatusConflict {
+		t.Fatalf("kernel was created twice")
+	}
+
+	ctx = restCtx(testUser, nil)
+	cd.ListKernels(ctx)
+	if list := decode[[]model.KernelStatus](t, ctx, fasthttp.StatusOK); len(list) != 1 {
+		t.Fatalf("unexpected list: %+v", list)
+	}
+
+	// синхронный запуск: результат приходит от ядра, пока запрос ждёт
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_ = cd.SendMemes(testKernel, `{"kernel_id":"`+testKernel+`","block_id":"`+testBlock+`","result":"42"}`)
+	}()
+	ctx = restCtx(testUser, model.ExecuteRequest{BlockID: testBlock, Timeout: "5s"})
+	cd.Execute(ctx)
+	done := decode[model.Execution](t, ctx, fasthttp.StatusOK)
+	if done.Status != model.ExecutionDone || done.Result != "42" || done.FinishedAt == nil {
+		t.Fatalf("unexpected execution: %+v", done)
+	}
+
+	ctx = restCtx(testUser, model.ExecuteRequest{BlockID: testBlock, Async: true,
+		Parameters: map[string]json.RawMessage{"region": json.RawMessage(`"eu"`)}})
+	cd.Execute(ctx)
+	pending := decode[model.Execution](t, ctx, fasthttp.StatusAccepted)
+	if pending.Status != model.ExecutionRunning || pending.ID == "" || pending.Parameters["region"] != `"eu"` {
+		t.Fatalf("unexpected async execution: %+v", pending)
+	}
+
+	ctx = restCtx(testUser, nil, "execution-id", pending.ID)
+	cd.ExecutionResult(ctx)
+	if got := decode[model.Execution](t, ctx, fasthttp.StatusOK); got.Status != model.ExecutionRunning {
+		t.Fatalf("execution finished without a result: %+v", got)
+	}
+	ctx = restCtx("6f0e4b1c-0000-4e5f-8a9b-0c1d2e3f4a5b", nil, "execution-id", pending.ID)
+	cd.ExecutionResult(ctx)
+	if ctx.Response.StatusCode() != fasthttp.StatusNotFound {
+		t.Fatalf("foreign user read an execution")
+	}
+
+	// ядро остановлено раньше, чем пришёл результат
+	ctx = restCtx(testUser, nil)
+	cd.DeleteKernel(ctx)
+	if ctx.Response.StatusCode() != fasthttp.StatusNoContent {
+		t.Fatalf("delete failed: %d", ctx.Response.StatusCode())
+	}
+	ctx = restCtx(testUser, nil, "execution-id", pending.ID)
+	cd.ExecutionResult(ctx)
+	if got := decode[model.Execution](t, ctx, fasthttp.StatusOK); got.Status != model.ExecutionFailed ||
+		got.Error != errKernelStopped.Error() {
+		t.Fatalf("pending execution outlived its kernel: %+v", got)
+	}
+	if started, stopped := uc.counts(); started != 1 || stopped != 1 {
+		t.Fatalf("started %d, stopped %d", started, stopped)
+	}
+
+	ctx = restCtx(testUser, nil)
+	cd.KernelStatus(ctx)
+	if ctx.Response.StatusCode() != fasthttp.StatusNotFound {
+		t.Fatalf("status of a deleted kernel: %d", ctx.Response.StatusCode())
+	}
+}
+
+func TestHistory(t *testing.T) {
+	cd := newDelivery(t)
+	cd.usecase = &fakeUsecase{}
+
+	ctx := restCtx(testUser, nil)
+	cd.CreateKernel(ctx)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_ = cd.SendMemes(testKernel, `{"kernel_id":"`+testKernel+`","block_id":"`+testBlock+
+			`","result":"a result longer than the limit"}`)
+	}()
+	ctx = restCtx(testUser, model.ExecuteRequest{BlockID: testBlock, Timeout: "5s", Heads: []string{"h1"}})
+	cd.Execute(ctx)
+	if got := decode[model.Execution](t, ctx, fasthttp.StatusOK); len(got.Heads) != 1 || got.Heads[0] != "h1" {
+		t.Fatalf("execution does not report the block version: %+v", got)
+	}
+	cd.Execute(restCtx(testUser, model.ExecuteRequest{BlockID: testBlock, Async: true}))
+	cd.DeleteKernel(restCtx(testUser, nil))
+
+	ctx = restCtx(testUser, nil)
+	cd.History(ctx)
+	records := decode[[]model.ExecutionRecord](t, ctx, fasthttp.StatusOK)
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %+v", records)
+	}
+	if stopped := records[0]; stopped.Status != model.ExecutionFailed || stopped.Error != errKernelStopped.Error() {
+		t.Fatalf("unexpected record of the interrupted run: %+v", stopped)
+	}
+	done := records[1]
+	if done.Status != model.ExecutionDone || done.Output != "a result longer " || !done.Truncated ||
+		done.SourceHash != "hash" || len(done.Heads) != 1 || done.UserID != testUser || done.FinishedAt.Before(done.StartedAt) {
+		t.Fatalf("unexpected record of the finished run: %+v", done)
+	}
+
+	ctx = restCtx(testUser, nil)
+	ctx.QueryArgs().Set("limit", "1")
+	cd.History(ctx)
+	if got := decode[[]model.ExecutionRecord](t, ctx, fasthttp.StatusOK); len(got) != 1 || got[0].ID != records[0].ID {
+		t.Fatalf("limit was not applied: %+v", got)
+	}
+	ctx = restCtx(testUser, nil)
+	ctx.QueryArgs().Set("block-id", "other")
+	cd.History(ctx)
+	if got := decode[[]model.ExecutionRecord](t, ctx, fasthttp.StatusOK); len(got) != 0 {
+		t.Fatalf("block filter was not applied: %+v", got)
+	}
+
+	events, err := cd.history.(*audit.Tracker).Events(audit.Filter{KernelID: testKernel})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Type != model.AuditKernelStop || events[1].Type != model.AuditKernelStart {
+		t.Fatalf("unexpected kernel events: %+v", events)
+	}
+}
+
+func TestExecuteValidation(t *testing.T) {
+	cd := newDelivery(t)
+	cd.usecase = &fakeUsecase{}
+	if _, err := cd.hub.Open(testUser, testKernel); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, body := range []any{"not an object", model.ExecuteRequest{BlockID: "../x"},
+		model.ExecuteRequest{BlockID: testBlock, Timeout: "soon"}} {
+		ctx := restCtx(testUser, body)
+		cd.Execute(ctx)
+		if ctx.Response.StatusCode() != fasthttp.StatusBadRequest {
+			t.Fatalf("%v: expected 400, got %d", body, ctx.Response.StatusCode())
+		}
+	}
+
+	ctx := restCtx(testUser, model.ExecuteRequest{BlockID: testBlock, Timeout: "10ms"})
+	cd.Execute(ctx)
+	if got := decode[model.Execution](t, ctx, fasthttp.StatusAccepted); got.Status != model.ExecutionRunning {
+		t.Fatalf("timed out execution: %+v", got)
+	}
+}
+
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	cd := newDelivery(t)
+	r := router.New()
+	cd.RegisterRoutes(r.Group("/api/v1/compiler"))
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/api/v1/compiler/openapi.json")
+	r.Handler(ctx)
+	doc := decode[struct {
+		Paths map[string]map[string]any `json:"paths"`
+	}](t, ctx, fasthttp.StatusOK)
+
+	operations := 0
+	for path, item := range doc.Paths {
+		if !strings.HasPrefix(path, "/api/v1/compiler/kernels") {
+			t.Fatalf("unexpected path %s", path)
+		}
+		operations += len(item)
+	}
+	if operations != len(cd.routes()) {
+		t.Fatalf("described %d operations, registered %d", operations, len(cd.routes()))
+	}
+}
+
+func TestWebSocketJoinsRESTKernel(t *testing.T) {
+	uc := &fakeUsecase{}
+	cd := newDelivery(t)
+	cd.usecase = uc
+	cd.metrics = metrics.NewWSMetrics(prometheus.NewRegistry(), "test_ws")
+	dial := serve(t, cd)
+
+	ctx := restCtx(testUser, nil)
+	cd.CreateKernel(ctx)
+	created := decode[model.KernelCreated](t, ctx, fasthttp.StatusCreated)
+
+	ws, err := dial("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = ws.Close()
+	}()
+	if msg := readSession(t, ws); !msg.Resumed || msg.Token != created.Token {
+		t.Fatalf("websocket did not join the REST session: %+v", msg)
+	}
+	if started, _ := uc.counts(); started != 1 {
+		t.Fatalf("websocket started a second kernel")
+	}
+}
+
+func TestExport(t *testing.T) {
+	cd := newDelivery(t)
+	cd.usecase = &fakeUsecase{}
+
+	ctx := restCtx(testUser, nil)
+	ctx.QueryArgs().Set("blocks", testKernel+","+testBlock)
+	cd.Export(ctx)
+	if ctx.Response.StatusCode() != fasthttp.StatusOK ||
+		string(ctx.Response.Header.Peek("X-Skipped-Blocks")) != testKernel ||
+		!strings.Contains(string(ctx.Response.Header.Peek("Content-Disposition")), `filename="main.go"`) ||
+		string(ctx.Response.Body()) != "// block "+testBlock+"\n" {
+		t.Fatalf("unexpected export: %d %s", ctx.Response.StatusCode(), ctx.Response.Body())
+	}
+
+	ctx = restCtx(testUser, nil)
+	ctx.QueryArgs().Set("blocks", testBlock)
+	ctx.QueryArgs().Set("layout", model.ExportPackage)
+	cd.Export(ctx)
+	archive, err := zip.NewReader(bytes.NewReader(ctx.Response.Body()), int64(len(ctx.Response.Body())))
+	if err != nil || len(archive.File) != 2 || archive.File[0].Name != "go.mod" {
+		t.Fatalf("unexpected archive: %v", err)
+	}
+
+	ctx = restCtx(testUser, nil)
+	cd.Export(ctx)
+	decode[model.ErrorResponse](t, ctx, fasthttp.StatusNotFound)
+
+	ctx = restCtx(testUser, nil)
+	ctx.QueryArgs().Set("blocks", "not-a-block")
+	cd.Export(ctx)
+	decode[model.ErrorResponse](t, ctx, fasthttp.StatusBadRequest)
+}
+
+func TestIPYNB(t *testing.T) {
+	cd := newDelivery(t)
+	cd.usecase = &fakeUsecase{}
+
+	ctx := restCtx(testUser, nil)
+	ctx.Request.SetBody([]byte(`{"nbformat": 4}`))
+	cd.ImportIPYNB(ctx)
+	if imported := decode[model.NotebookImport](t, ctx, fasthttp.StatusCreated); len(imported.Blocks) != 1 {
+		t.Fatalf("unexpected import %+v", imported)
+	}
+	ctx = restCtx(testUser, nil)
+	ctx.Request.SetBody([]byte(`not a notebook`))
+	cd.ImportIPYNB(ctx)
+	decode[model.ErrorResponse](t, ctx, fasthttp.StatusBadRequest)
+
+	cd.CreateKernel(restCtx(testUser, nil))
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_ = cd.SendMemes(testKernel, `{"kernel_id":"`+testKernel+`","block_id":"`+testBlock+`","result":"42"}`)
+	}()
+	cd.Execute(restCtx(testUser, model.ExecuteRequest{BlockID: testBlock, Timeout: "5s"}))
+
+	ctx = restCtx(testUser, nil)
+	ctx.QueryArgs().Set("blocks", testBlock+","+testKernel)
+	cd.DownloadIPYNB(ctx)
+	if ctx.Response.StatusCode() != fasthttp.StatusOK || string(ctx.Response.Body()) != `["42"]` ||
+		!strings.Contains(string(ctx.Response.Header.Peek("Content-Disposition")), "notebook.ipynb") {
+		t.Fatalf("unexpected notebook: %d %s", ctx.Response.StatusCode(), ctx.Response.Body())
+	}
+}
+
+// fakeAccess выдаёт пользователю права rights на любой блокнот
+type fakeAccess struct {
+	rights string
+}
+
+func (fa fakeAccess) FileAccessCtx(_ context.Context, _ *access.AccessRequest,
+	_ ...grpc.CallOption) (*access.AccessData, error) {
+	return &access.AccessData{Access: fa.rights}, nil
+}
+
+// TestImportNeedsWrite: импорт добавляет блоки в блокнот, поэтому права на выполнение для него мало
+func TestImportNeedsWrite(t *testing.T) {
+	cd := newDelivery(t)
+	cd.usecase = &fakeUsecase{}
+	var imports route
+	for _, r := range cd.routes() {
+		if r.Method == http.MethodPost && r.Path == "/kernels/{kernel-id}/ipynb" {
+			imports = r
+		}
+	}
+
+	for _, c := range []struct {
+		rights string
+		status int
+	}{{"x", fasthttp.StatusUnauthorized}, {"rx", fasthttp.StatusUnauthorized}, {"rwx", fasthttp.StatusCreated}} {
+		cd.accessMW = middlewares.NewAccessMW(fakeAccess{rights: c.rights}, cd.history, slog.Default())
+		ctx := restCtx(testUser, map[string]any{})
+		cd.guard(imports)(ctx)
+		if ctx.Response.StatusCode() != c.status {
+			t.Fatalf("access %q: expected status %d, got %d", c.rights, c.status, ctx.Response.StatusCode())
+		}
+	}
+
+	events, err := cd.history.(*audit.Tracker).Events(audit.Filter{KernelID: testKernel, Type: model.AuditAccessDenied})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || !strings.Contains(events[0].Detail, "no right to write") {
+		t.Fatalf("denied imports are not audited: %+v", events)
+	}
+}
@@ -0,0 +1,238 @@
+package http
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dnonakolesax/noted-runner/internal/configs"
+	"github.com/dnonakolesax/noted-runner/internal/logger"
+	"github.com/dnonakolesax/noted-runner/internal/model"
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	errInvalidCommand = errors.New("invalid command: expected JSON with type")
+	pingFrame         = []byte(": ping\n\n")
+)
+
+type deadliner interface {
+	SetWriteDeadline(t time.Time) error
+}
+
+// SSEConn - подключение клиента по Server-Sent Events. Как и Conn, пишет в поток одной горутиной;
+// каждое сообщение сессии уходит событием с id, равным его seq, поэтому браузер при переподключении
+// сам пришлёт Last-Event-ID. Комментарии-ping не дают прокси закрыть простаивающий поток
+type SSEConn struct {
+	w       *bufio.Writer
+	conn    deadliner
+	config  *configs.WebSocketConfig
+	out     chan []byte
+	done    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+	logger  *slog.Logger
+}
+
+func NewSSEConn(w *bufio.Writer, conn deadliner, config *configs.WebSocketConfig, logger *slog.Logger) *SSEConn {
+	c := &SSEConn{w: w, conn: conn, config: config, out: make(chan []byte, sendBufferSize),
+		done: make(chan struct{}), stopped: make(chan struct{}), logger: logger}
+	go c.writeLoop()
+	return c
+}
+
+func (c *SSEConn) Send(data []byte) error {
+	return c.queue(messageFrame(data))
+}
+
+func (c *SSEConn) queue(frame []byte) error {
+	select {
+	case <-c.done:
+		return ErrConnClosed
+	default:
+	}
+
+	select {
+	case c.out <- frame:
+		return nil
+	case <-c.done:
+		return ErrConnClosed
+	}
+}
+
+// Close завершает поток и дожидается пишущей горутины
+func (c *SSEConn) Close() error {
+	c.close()
+	<-c.stopped
+	return nil
+}
+
+func (c *SSEConn) close() {
+	c.once.Do(func() {
+		close(c.done)
+	})
+}
+
+// Stopped закрывается, когда поток завершён: клиент ушёл или подключение закрыли
+func (c *SSEConn) Stopped() <-chan struct{} {
+	return c.stopped
+}
+
+func (c *SSEConn) writeLoop() {
+	defer close(c.stopped)
+
+	var ping <-chan time.Time
+	if c.config.PingInterval > 0 {
+		ticker := time.NewTicker(c.config.PingInterval)
+		defer ticker.Stop()
+		ping = ticker.C
+	}
+
+	for {
+		select {
+		case frame := <-c.out:
+			if !c.write(frame) {
+				return
+			}
+		case <-ping:
+			if !c.write(pingFrame) {
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *SSEConn) write(frame []byte) bool {
+	var err error
+	if c.config.WriteWait > 0 {
+		err = c.conn.SetWriteDeadline(time.Now().Add(c.config.WriteWait))
+	}
+	if err == nil {
+		_, err = c.w.Write(frame)
+	}
+	if err == nil {
+		err = c.w.Flush()
+	}
+	if err != nil {
+		c.logger.Warn("error writing event", logger.LogError(err))
+		c.close()
+		return false
+	}
+	return true
+}
+
+// messageFrame оформляет сообщение сессии событием; сообщения без seq, например из outbox, идут без id
+func messageFrame(data []byte) []byte {
+	var msg struct {
+		Seq uint64 `json:"seq"`
+	}
+	_ = json.Unmarshal(data, &msg)
+
+	frame := make([]byte, 0, len(data)+32)
+	if msg.Seq != 0 {
+		frame = append(frame, "id: "...)
+		frame = strconv.AppendUint(frame, msg.Seq, 10)
+		frame = append(frame, '\n')
+	}
+	frame = append(frame, "data: "...)
+	frame = append(frame, data...)
+	return append(frame, "\n\n"...)
+}
+
+func sessionFrame(data []byte) []byte {
+	frame := append([]byte("event: "+model.SessionOpened+"\ndata: "), data...)
+	return append(frame, "\n\n"...)
+}
+
+// Events отдаёт сообщения сессии ядра потоком Server-Sent Events. Правила подключения те же,
+// что у вебсокета, и оба транспорта могут быть подключены к ядру одновременно
+func (cd *ComilerDelivery) Events(ctx *fasthttp.RequestCtx) {
+	kernelID, userID, ok := cd.restIDs(ctx)
+	if !ok {
+		return
+	}
+	lastSeq, err := parseLastSeq(ctx)
+	if err != nil {
+		cd.writeError(ctx, fasthttp.StatusBadRequest, err)
+		return
+	}
+
+	sess, resumed, err := cd.openSession(string(ctx.QueryArgs().Peek("session-token")), kernelID, userID)
+	if err != nil {
+		cd.writeError(ctx, sessionStatus(err), err)
+		return
+	}
+	hello, err := sessionMessage(sess, resumed)
+	if err != nil {
+		cd.logger.Error("error marshaling message", logger.LogError(err))
+		cd.suspend(sess, !resumed)
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		return
+	}
+
+	ctx.SetContentType("text/event-stream")
+	ctx.Response.Header.Set(fasthttp.HeaderCacheControl, "no-cache")
+	ctx.Response.Header.Set("X-Accel-Buffering", "no")
+	netConn := ctx.Conn()
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		conn := NewSSEConn(w, netConn, cd.wsConfig, cd.logger)
+		_ = conn.queue(sessionFrame(hello))
+
+		err := cd.hub.Attach(sess, conn, lastSeq, func() [][]byte { return cd.drain(sess.KernelID) })
+		if err != nil {
+			cd.logger.Warn("error attaching to session", logger.LogError(err))
+			_ = conn.Close()
+			cd.suspend(sess, !resumed)
+			return
+		}
+
+		<-conn.Stopped()
+		cd.hub.Detach(sess, conn)
+		cd.suspend(sess, false)
+	})
+}
+
+// Commands принимает команду клиента по HTTP - для тех, кто слушает ядро через SSE.
+// Ответ ядра получают все подключённые клиенты сессии
+func (cd *ComilerDelivery) Commands(ctx *fasthttp.RequestCtx) {
+	kernelID, userID, ok := cd.restIDs(ctx)
+	if !ok {
+		return
+	}
+	var cmd model.ClientMessage
+	err := json.Unmarshal(ctx.PostBody(), &cmd)
+	if err != nil || cmd.Type == "" {
+		cd.writeError(ctx, fasthttp.StatusBadRequest, errInvalidCommand)
+		return
+	}
+	sess, ok := cd.ownSession(ctx, kernelID, userID)
+	if !ok {
+		return
+	}
+
+	if cmd.Type == model.ClientShutdown {
+		cd.closeConns(sess)
+		cd.teardown(sess)
+		ctx.SetStatusCode(fasthttp.StatusNoContent)
+		return
+	}
+
+	resp, ok := cd.handleClientMessage(kernelID, userID, cmd)
+	if ok {
+		err = sess.Send(resp, func(data []byte) error { return cd.retain(sess.KernelID, data, ErrNoListener) })
+		if err != nil {
+			cd.logger.Error("error sending message", logger.LogError(err))
+		}
+	}
+	if resp.Fail {
+		cd.writeError(ctx, fasthttp.StatusBadRequest, errors.New(resp.Result))
+		return
+	}
+	ctx.SetStatusCode(fasthttp.StatusAccepted)
+}
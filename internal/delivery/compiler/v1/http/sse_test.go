@@ -0,0 +1,205 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dnonakolesax/noted-runner/internal/configs"
+	"github.com/dnonakolesax/noted-runner/internal/consts"
+	"github.com/dnonakolesax/noted-runner/internal/metrics"
+	"github.com/dnonakolesax/noted-runner/internal/model"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+type event struct {
+	id   string
+	name string
+	data string
+}
+
+type eventStream struct {
+	body   *bufio.Reader
+	cancel func()
+}
+
+// serveSSE поднимает Events и Commands на in-memory листенере и возвращает HTTP-клиента к нему
+func serveSSE(t *testing.T, cd *ComilerDelivery) *http.Client {
+	t.Helper()
+	ln := fasthttputil.NewInmemoryListener()
+	server := &fasthttp.Server{Handler: func(ctx *fasthttp.RequestCtx) {
+		ctx.SetUserValue(consts.CtxUserIDKey, testUser)
+		ctx.SetUserValue("kernel-id", testKernel)
+		if strings.HasSuffix(string(ctx.Path()), "/events") {
+			cd.Events(ctx)
+		} else {
+			cd.Commands(ctx)
+		}
+	}}
+	go func() {
+		_ = server.Serve(ln)
+	}()
+	t.Cleanup(func() {
+		_ = server.Shutdown()
+	})
+
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(context.Context, string, string) (net.Conn, error) { return ln.Dial() },
+	}}
+}
+
+func subscribe(t *testing.T, client *http.Client, lastEventID string) *eventStream {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://runner/kernels/"+testKernel+"/events", nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		cancel()
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		cancel()
+		t.Fatalf("unexpected response: %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	return &eventStream{body: bufio.NewReader(resp.Body), cancel: func() {
+		cancel()
+		_ = resp.Body.Close()
+	}}
+}
+
+// next читает следующее событие, пропуская ping-комментарии
+func (es *eventStream) next(t *testing.T) event {
+	t.Helper()
+	var ev event
+	for {
+		line, err := es.body.ReadString('\n')
+		if err != nil {
+			t.Fatalf("stream ended: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			if ev.data != "" {
+				return ev
+			}
+		case strings.HasPrefix(line, ":"):
+		case strings.HasPrefix(line, "id: "):
+			ev.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			ev.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			ev.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func command(t *testing.T, client *http.Client, body string) int {
+	t.Helper()
+	resp, err := client.Post("http://runner/kernels/"+testKernel+"/commands", "application/json",
+		strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestSSEAndWebSocketShareSession(t *testing.T) {
+	uc := &fakeUsecase{}
+	cd := newDelivery(t)
+	cd.usecase = uc
+	cd.wsConfig = &configs.WebSocketConfig{PingInterval: 10 * time.Millisecond, WriteWait: time.Second}
+	cd.metrics = metrics.NewWSMetrics(prometheus.NewRegistry(), "test_ws")
+	dial := serve(t, cd)
+	client := serveSSE(t, cd)
+
+	ws, err := dial("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = ws.Close()
+	}()
+	token := readSession(t, ws).Token
+	// клиент читает вебсокет постоянно, иначе ping-и забьют in-memory соединение
+	messages := make(chan string, 16)
+	go func() {
+		defer close(messages)
+		for {
+			_, msg, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			messages <- string(msg)
+		}
+	}()
+
+	stream := subscribe(t, client, "")
+	defer stream.cancel()
+	if ev := stream.next(t); ev.name != model.SessionOpened || !strings.Contains(ev.data, token) {
+		t.Fatalf("SSE did not join the websocket session: %+v", ev)
+	}
+	sess, _ := cd.hub.Lookup(testKernel)
+	waitFor(t, "SSE attach", func() bool { return len(sess.Conns()) == 2 })
+
+	if err := cd.SendMemes(testKernel, `{"result":"both"}`); err != nil {
+		t.Fatal(err)
+	}
+	if ev := stream.next(t); ev.id != "1" || !strings.Contains(ev.data, "both") {
+		t.Fatalf("unexpected event: %+v", ev)
+	}
+	if msg := <-messages; !strings.Contains(msg, "both") {
+		t.Fatalf("websocket missed the result: %s", msg)
+	}
+
+	if status := command(t, client, `{"type":"inspect"}`); status != http.StatusAccepted {
+		t.Fatalf("unexpected command status %d", status)
+	}
+	if ev := stream.next(t); ev.id != "2" {
+		t.Fatalf("command response was not streamed: %+v", ev)
+	}
+	if status := command(t, client, `{"type":"run","block_id":"nope"}`); status != http.StatusBadRequest {
+		t.Fatalf("failed command accepted: %d", status)
+	}
+	if status := command(t, client, `memes`); status != http.StatusBadRequest {
+		t.Fatalf("malformed command accepted: %d", status)
+	}
+
+	// обрыв потока замечается на ближайшем ping, а браузер возвращается с Last-Event-ID
+	stream.cancel()
+	waitFor(t, "SSE detach", func() bool { return len(sess.Conns()) == 1 })
+	resumed := subscribe(t, client, "1")
+	defer resumed.cancel()
+	if ev := resumed.next(t); ev.name != model.SessionOpened || !strings.Contains(ev.data, `"resumed":true`) {
+		t.Fatalf("unexpected session event: %+v", ev)
+	}
+	if ev := resumed.next(t); ev.id != "2" {
+		t.Fatalf("replay did not start after Last-Event-ID: %+v", ev)
+	}
+
+	if status := command(t, client, `{"type":"shutdown"}`); status != http.StatusNoContent {
+		t.Fatalf("unexpected shutdown status %d", status)
+	}
+	if _, stopped := uc.counts(); stopped != 1 || cd.hub.Len() != 0 {
+		t.Fatalf("shutdown did not stop the kernel")
+	}
+	// оба транспорта отключены: сервер закрыл вебсокет, а поток событий завершён
+	for range messages {
+	}
+	waitFor(t, "websocket close", func() bool { return testutil.ToFloat64(cd.metrics.ActiveConnections) == 0 })
+	for {
+		if _, err := resumed.body.ReadString('\n'); err != nil {
+			break
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/dnonakolesax/noted-runner/internal/consts"
+	"github.com/dnonakolesax/noted-runner/internal/ids"
+	"github.com/dnonakolesax/noted-runner/internal/logger"
+	"github.com/dnonakolesax/noted-runner/internal/middlewares"
+	"github.com/dnonakolesax/noted-runner/internal/model"
+	"github.com/dnonakolesax/noted-runner/internal/openapi"
+	"github.com/dnonakolesax/noted-runner/internal/scheduler"
+	"github.com/fasthttp/router"
+	"github.com/valyala/fasthttp"
+)
+
+type Scheduler interface {
+	Create(kernelID ids.ID, userID ids.ID, req model.ScheduleRequest) (model.Schedule, error)
+	List(kernelID ids.ID, userID ids.ID) []model.Schedule
+	Delete(kernelID ids.ID, userID ids.ID, scheduleID string) error
+	Runs(kernelID ids.ID, userID ids.ID, scheduleID string) ([]model.ScheduledRun, error)
+}
+
+// SchedulerDelivery - ручки расписаний блокнота; права проверяются на ядро из пути, как у остальных ручек ядра
+type SchedulerDelivery struct {
+	scheduler Scheduler
+	logger    *slog.Logger
+	authMW    *middlewares.AuthMW
+	accessMW  *middlewares.AccessMW
+}
+
+func NewSchedulerDelivery(scheduler Scheduler, logger *slog.Logger, authMW *middlewares.AuthMW,
+	accessMW *middlewares.AccessMW) *SchedulerDelivery {
+	return &SchedulerDelivery{scheduler: scheduler, logger: logger, authMW: authMW, accessMW: accessMW}
+}
+
+type route struct {
+	openapi.Route
+	handler fasthttp.RequestHandler
+}
+
+func (sd *SchedulerDelivery) routes() []route {
+	notFound := []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound}
+	return []route{
+		{Route: openapi.Route{Method: http.MethodPost, Path: "/kernels/{kernel-id}/schedules",
+			Summary: "Schedule notebook runs", Request: model.ScheduleRequest{}, Response: model.Schedule{},
+			Status: http.StatusCreated, Errors: []int{http.StatusBadRequest, http.StatusUnauthorized}},
+			handler: sd.CreateSchedule},
+		{Route: openapi.Route{Method: http.MethodGet, Path: "/kernels/{kernel-id}/schedules",
+			Summary: "List the user's schedules of the notebook", Response: []model.Schedule{},
+			Errors: []int{http.StatusBadRequest, http.StatusUnauthorized}},
+			handler: sd.ListSchedules},
+		{Route: openapi.Route{Method: http.MethodDelete, Path: "/kernels/{kernel-id}/schedules/{schedule-id}",
+			Summary: "Delete a schedule and its run history", Status: http.StatusNoContent, Errors: notFound},
+			handler: sd.DeleteSchedule},
+		{Route: openapi.Route{Method: http.MethodGet, Path: "/kernels/{kernel-id}/schedules/{schedule-id}/runs",
+			Summary: "List scheduled runs, newest first", Response: []model.ScheduledRun{}, Errors: notFound},
+			handler: sd.ListRuns},
+	}
+}
+
+// Routes описывает ручки для общего документа OpenAPI
+func (sd *SchedulerDelivery) Routes() []openapi.Route {
+	routes := sd.routes()
+	specs := make([]openapi.Route, 0, len(routes))
+	for _, r := range routes {
+		specs = append(specs, r.Route)
+	}
+	return specs
+}
+
+func (sd *SchedulerDelivery) RegisterRoutes(apiGroup *router.Group) {
+	for _, r := range sd.routes() {
+		apiGroup.Handle(r.Method, r.Path, sd.authMW.AuthMiddleware(sd.accessMW.MW(r.handler)))
+	}
+}
+
+func (sd *SchedulerDelivery) CreateSchedule(ctx *fasthttp.RequestCtx) {
+	kernelID, userID, ok := sd.ids(ctx)
+	if !ok {
+		return
+	}
+	var req model.ScheduleRequest
+	err := json.Unmarshal(ctx.PostBody(), &req)
+	if err != nil {
+		sd.writeError(ctx, fasthttp.StatusBadRequest, errors.New("invalid body: expected JSON"))
+		return
+	}
+	schedule, err := sd.scheduler.Create(kernelID, userID, req)
+	switch {
+	case errors.Is(err, scheduler.ErrInvalidSpec), errors.Is(err, scheduler.ErrInvalidTimezone),
+		errors.Is(err, scheduler.ErrInvalidBlock):
+		sd.writeError(ctx, fasthttp.StatusBadRequest, err)
+	case err != nil:
+		sd.logger.Error("error creating schedule", logger.LogError(err))
+		sd.writeError(ctx, fasthttp.StatusInternalServerError, errors.New("error saving schedule"))
+	default:
+		sd.writeJSON(ctx, fasthttp.StatusCreated, schedule)
+	}
+}
+
+func (sd *SchedulerDelivery) ListSchedules(ctx *fasthttp.RequestCtx) {
+	kernelID, userID, ok := sd.ids(ctx)
+	if !ok {
+		return
+	}
+	sd.writeJSON(ctx, fasthttp.StatusOK, sd.scheduler.List(kernelID, userID))
+}
+
+func (sd *SchedulerDelivery) DeleteSchedule(ctx *fasthttp.RequestCtx) {
+	kernelID, userID, ok := sd.ids(ctx)
+	if !ok {
+		return
+	}
+	scheduleID, _ := ctx.UserValue("schedule-id").(string)
+	err := sd.scheduler.Delete(kernelID, userID, scheduleID)
+	if err != nil {
+		sd.scheduleError(ctx, err)
+		return
+	}
+	ctx.SetStatusCode(fasthttp.StatusNoContent)
+}
+
+func (sd *SchedulerDelivery) ListRuns(ctx *fasthttp.RequestCtx) {
+	kernelID, userID, ok := sd.ids(ctx)
+	if !ok {
+		return
+	}
+	scheduleID, _ := ctx.UserValue("schedule-id").(string)
+	runs, err := sd.scheduler.Runs(kernelID, userID, scheduleID)
+	if err != nil {
+		sd.scheduleError(ctx, err)
+		return
+	}
+	sd.writeJSON(ctx, fasthttp.StatusOK, runs)
+}
+
+func (sd *SchedulerDelivery) scheduleError(ctx *fasthttp.RequestCtx, err error) {
+	if errors.Is(err, scheduler.ErrUnknownSchedule) {
+		sd.writeError(ctx, fasthttp.StatusNotFound, err)
+		return
+	}
+	sd.logger.Error("error handling schedule", logger.LogError(err))
+	sd.writeError(ctx, fasthttp.StatusInternalServerError, errors.New("error reading schedules"))
+}
+
+func (sd *SchedulerDelivery) ids(ctx *fasthttp.RequestCtx) (ids.ID, ids.ID, bool) {
+	rawKernelID, _ := ctx.UserValue("kernel-id").(string)
+	kernelID, err := ids.Parse(rawKernelID)
+	if err != nil {
+		sd.writeError(ctx, fasthttp.StatusBadRequest, errors.New("invalid kernel-id: expected UUID"))
+		return "", "", false
+	}
+	rawUserID, _ := ctx.Request.UserValue(consts.CtxUserIDKey).(string)
+	userID, err := ids.Parse(rawUserID)
+	if err != nil {
+		sd.writeError(ctx, fasthttp.StatusBadRequest, errors.New("invalid user id: expected UUID"))
+		return "", "", false
+	}
+	return kernelID, userID, true
+}
+
+func (sd *SchedulerDelivery) writeJSON(ctx *fasthttp.RequestCtx, status int, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		sd.logger.Error("error marshaling response", logger.LogError(err))
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.SetStatusCode(status)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(data)
+}
+
+func (sd *SchedulerDelivery) writeError(ctx *fasthttp.RequestCtx, status int, err error) {
+	sd.logger.Warn("rest request failed", slog.Int("status", status), logger.LogError(err))
+	sd.writeJSON(ctx, status, model.ErrorResponse{Error: err.Error()})
+}
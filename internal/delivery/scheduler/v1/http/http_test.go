@@ -0,0 +1,92 @@
+package http
+
+import (
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/dnonakolesax/noted-runner/internal/configs"
+	"github.com/dnonakolesax/noted-runner/internal/consts"
+	"github.com/dnonakolesax/noted-runner/internal/ids"
+	"github.com/dnonakolesax/noted-runner/internal/model"
+	"github.com/dnonakolesax/noted-runner/internal/scheduler"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	testUser   = "7d1e2f3a-4b5c-4d6e-8f90-a1b2c3d4e5f6"
+	otherUser  = "6f0e4b1c-2a3d-4e5f-8a9b-0c1d2e3f4a5b"
+	testKernel = "0b3c9a52-6f2e-4d0c-9a57-2f8b1c3d4e5f"
+)
+
+func restCtx(userID string, body any, params ...string) *fasthttp.RequestCtx {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.SetUserValue(consts.CtxUserIDKey, userID)
+	ctx.SetUserValue("kernel-id", testKernel)
+	for i := 0; i+1 < len(params); i += 2 {
+		ctx.SetUserValue(params[i], params[i+1])
+	}
+	if body != nil {
+		data, _ := json.Marshal(body)
+		ctx.Request.SetBody(data)
+	}
+	return ctx
+}
+
+func TestScheduleRoutes(t *testing.T) {
+	store, err := scheduler.NewStore(t.TempDir(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// запуски в тесте не наступают, поэтому ядра и проверка доступа не нужны
+	sched, err := scheduler.NewScheduler(store, nil, func(ids.ID) ([]ids.ID, error) { return nil, nil },
+		nil, nil, &configs.SchedulerConfig{MaxConcurrent: 1}, slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd := NewSchedulerDelivery(sched, slog.Default(), nil, nil)
+
+	ctx := restCtx(testUser, model.ScheduleRequest{Spec: "every morning"})
+	sd.CreateSchedule(ctx)
+	if ctx.Response.StatusCode() != fasthttp.StatusBadRequest {
+		t.Fatalf("invalid spec: got %d", ctx.Response.StatusCode())
+	}
+
+	ctx = restCtx(testUser, model.ScheduleRequest{Spec: "0 7 * * 1-5", Timezone: "Europe/Berlin"})
+	sd.CreateSchedule(ctx)
+	if ctx.Response.StatusCode() != fasthttp.StatusCreated {
+		t.Fatalf("create: got %d: %s", ctx.Response.StatusCode(), ctx.Response.Body())
+	}
+	var created model.Schedule
+	_ = json.Unmarshal(ctx.Response.Body(), &created)
+	if created.ID == "" || created.NextRun.IsZero() || created.UserID != testUser {
+		t.Fatalf("unexpected schedule: %+v", created)
+	}
+
+	ctx = restCtx(otherUser, nil)
+	sd.ListSchedules(ctx)
+	if string(ctx.Response.Body()) != "[]" {
+		t.Fatalf("foreign user sees schedules: %s", ctx.Response.Body())
+	}
+	ctx = restCtx(otherUser, nil, "schedule-id", created.ID)
+	sd.ListRuns(ctx)
+	if ctx.Response.StatusCode() != fasthttp.StatusNotFound {
+		t.Fatalf("foreign user reads runs: got %d", ctx.Response.StatusCode())
+	}
+
+	ctx = restCtx(testUser, nil, "schedule-id", created.ID)
+	sd.ListRuns(ctx)
+	if ctx.Response.StatusCode() != fasthttp.StatusOK || string(ctx.Response.Body()) != "[]" {
+		t.Fatalf("runs: got %d: %s", ctx.Response.StatusCode(), ctx.Response.Body())
+	}
+	ctx = restCtx(testUser, nil, "schedule-id", created.ID)
+	sd.DeleteSchedule(ctx)
+	if ctx.Response.StatusCode() != fasthttp.StatusNoContent {
+		t.Fatalf("delete: got %d", ctx.Response.StatusCode())
+	}
+	ctx = restCtx(testUser, nil, "schedule-id", created.ID)
+	sd.DeleteSchedule(ctx)
+	if ctx.Response.StatusCode() != fasthttp.StatusNotFound {
+		t.Fatalf("second delete: got %d", ctx.Response.StatusCode())
+	}
+}
@@ -4,14 +4,20 @@ import (
 	"context"
 	"log/slog"
 	"os"
+	"slices"
 	"strconv"
+	"sync"
 
+	"github.com/dnonakolesax/noted-runner/internal/cluster"
 	"github.com/dnonakolesax/noted-runner/internal/configs"
+	"github.com/dnonakolesax/noted-runner/internal/ids"
+	"github.com/dnonakolesax/noted-runner/internal/kernelcmd"
 	"github.com/dnonakolesax/noted-runner/internal/logger"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
 	"github.com/docker/go-connections/nat"
 )
 
@@ -19,6 +25,8 @@ type DockerClient struct {
 	client *client.Client
 	config *configs.DockerConfig
 	logger *slog.Logger
+	// active - контейнеры, которые эта реплика удалит при остановке
+	mu     sync.Mutex
 	active []string
 }
 
@@ -47,13 +55,32 @@ func NewDockerClient(config *configs.DockerConfig, dckLogger *slog.Logger) (*Doc
 }
 
 func (dc *DockerClient) Close() {
-	for _, act := range(dc.active) {
+	dc.mu.Lock()
+	active := slices.Clone(dc.active)
+	dc.mu.Unlock()
+	for _, act := range(active) {
 		_ = dc.Remove(act)
 	}
 	_ = dc.client.Close()
 }
 
-func (dc *DockerClient) Create(name string, kernelID string) (string, error) {
+// Forget перестаёт считать контейнер своим: его забрала другая реплика, и при остановке
+// эта реплика его не удаляет
+func (dc *DockerClient) Forget(id string) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	dc.active = slices.DeleteFunc(dc.active, func(act string) bool { return act == id })
+}
+
+func (dc *DockerClient) track(id string) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	if !slices.Contains(dc.active, id) {
+		dc.active = append(dc.active, id)
+	}
+}
+
+func (dc *DockerClient) Create(name string, kernelID ids.ID) (string, error) {
 	ports := make(nat.PortSet)
 	ports[nat.Port(dc.config.AppPort)] = struct{}{}
 
@@ -61,11 +88,14 @@ func (dc *DockerClient) Create(name string, kernelID string) (string, error) {
 		Image:        dc.config.Image,
 		ExposedPorts: ports,
 		Env: []string{"RMQ_ADDR=" + dc.config.Env.RMQAddr, 
-					  "KERNEL_ID=" + kernelID, 
+					  "KERNEL_ID=" + kernelID.String(), 
 					  "MOUNT_PATH=" + dc.config.Env.MountPath, 
 					  "EXPORT_PREFIX=" + dc.config.Env.ExportPrefix, 
 					  "BLOCK_PREFIX=" + dc.config.Env.BlockPrefix, 
 					  "CHAN_NAME=" + dc.config.Env.ChanName, 
+					  "EXCHANGE_NAME=" + dc.config.Env.Exchange, 
+					  "ROUTING_KEY=" + cluster.ResultKey(kernelID), 
+					  "CMD_QUEUE=" + kernelcmd.QueueName(kernelID), 
 					  "BLOCK_TIMEOUT=" + strconv.Itoa(int(dc.config.Env.BlockTimeout.Seconds()))},
 	}
 
@@ -95,11 +125,22 @@ func (dc *DockerClient) Create(name string, kernelID string) (string, error) {
 		nil,
 		name,
 	)
+	if errdefs.IsConflict(err) {
+		// контейнер ядра уже запущен другой репликой - забираем его себе
+		existing, err := dc.client.ContainerInspect(context.Background(), name)
+		if err != nil {
+			dc.logger.Error("error inspecting existing container", logger.LogError(err))
+			return "", err
+		}
+		dc.logger.Info("adopting existing container", slog.String("name", name))
+		dc.track(existing.ID)
+		return existing.ID, nil
+	}
 	if err != nil {
 		dc.logger.Error("error creating container", logger.LogError(err))
 		return "", err
 	}
-	dc.active = append(dc.active, resp.ID)
+	dc.track(resp.ID)
 	return resp.ID, nil
 }
 
@@ -129,5 +170,6 @@ func (dc *DockerClient) Remove(id string) error {
 		}
 		return err
 	}
+	dc.Forget(id)
 	return nil
 }
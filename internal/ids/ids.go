@@ -0,0 +1,77 @@
+package ids
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrInvalidID = errors.New("invalid id")
+
+// ID - проверенный идентификатор ядра, блока или пользователя в формате UUID.
+// Разделители - либо все дефисы, либо все подчёркивания (так блоки называются в файлах).
+// Только такие значения можно подставлять в пути, имена контейнеров и адреса ядер
+type ID string
+
+const uuidLen = 36
+
+func Parse(s string) (ID, error) {
+	if len(s) != uuidLen {
+		return "", fmt.Errorf("%w %q: expected UUID", ErrInvalidID, s)
+	}
+
+	sep := s[8]
+	if sep != '-' && sep != '_' {
+		return "", fmt.Errorf("%w %q: expected UUID", ErrInvalidID, s)
+	}
+	for i := range len(s) {
+		switch i {
+		case 8, 13, 18, 23:
+			if s[i] != sep {
+				return "", fmt.Errorf("%w %q: expected UUID", ErrInvalidID, s)
+			}
+		default:
+			if !isHex(s[i]) {
+				return "", fmt.Errorf("%w %q: expected UUID", ErrInvalidID, s)
+			}
+		}
+	}
+	return ID(s), nil
+}
+
+func MustParse(s string) ID {
+	id, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+// New создаёт UUIDv7: ID, созданные в разные миллисекунды, сортируются по времени at.
+// Так блоки, которые раннер создаёт сам, идут в порядке создания
+func New(at time.Time) ID {
+	var b [16]byte
+	_, _ = rand.Read(b[6:])
+	var ms [8]byte
+	binary.BigEndian.PutUint64(ms[:], uint64(at.UnixMilli()))
+	copy(b[:6], ms[2:])
+	b[6] = b[6]&0x0f | 0x70
+	b[8] = b[8]&0x3f | 0x80
+	return ID(fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]))
+}
+
+func (id ID) String() string {
+	return string(id)
+}
+
+// Ident возвращает id в виде, пригодном для идентификатора Go
+func (id ID) Ident() string {
+	return strings.ReplaceAll(string(id), "-", "_")
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
@@ -0,0 +1,56 @@
+package ids
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	valid := []string{
+		"4bcb102d-d663-4bec-86b4-86e978b5b54c",
+		"4bcb102d_d663_4bec_86b4_86e978b5b54c",
+		"4BCB102D-D663-4BEC-86B4-86E978B5B54C",
+	}
+	for _, s := range valid {
+		if _, err := Parse(s); err != nil {
+			t.Fatalf("parse %q: %v", s, err)
+		}
+	}
+
+	malicious := []string{
+		"",
+		"1",
+		"../../x",
+		"../../../../../../etc/passwd",
+		"4bcb102d-d663-4bec-86b4-86e978b5b54c/../../x",
+		"4bcb102d-d663-4bec-86b4-86e978b5b5/.",
+		"4bcb102d/d663/4bec/86b4/86e978b5b54c",
+		"4bcb102d-d663_4bec-86b4-86e978b5b54c",
+		"4bcb102d-d663-4bec-86b4-86e978b5b54g",
+		"4bcb102d-d663-4bec-86b4-86e978b5b5\x00c",
+		"4bcb102d-d663-4bec-86b4-86e978b5b54c&user_id=1",
+		"4bcb102d-d663-4bec-86b4-86e978b5b54c\n",
+		" 4bcb102d-d663-4bec-86b4-86e978b5b54",
+	}
+	for _, s := range malicious {
+		if _, err := Parse(s); !errors.Is(err, ErrInvalidID) {
+			t.Fatalf("parse %q: got %v, expected ErrInvalidID", s, err)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	at := time.UnixMilli(1760000000000)
+	prev := New(at)
+	if _, err := Parse(prev.String()); err != nil || prev[14] != '7' {
+		t.Fatalf("invalid UUIDv7 %s: %v", prev, err)
+	}
+	for i := 1; i < 100; i++ {
+		id := New(at.Add(time.Duration(i) * time.Millisecond))
+		if id <= prev {
+			t.Fatalf("%s is not after %s", id, prev)
+		}
+		prev = id
+	}
+}
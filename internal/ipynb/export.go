@@ -0,0 +1,65 @@
+package ipynb
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// ExportCell - блок для выгрузки вместе с результатом последнего выполнения. Без Executed ячейка
+// выгружается без вывода
+type ExportCell struct {
+	BlockID  string
+	Text     string
+	Language string
+	Executed bool
+	Output   string
+	Error    string
+}
+
+// Export собирает .ipynb с ядром gophernotes: блоки Go и других языков - ячейки кода, markdown - ячейки
+// markdown. Номера выполнения идут по порядку среди выполненных блоков
+func Export(cells []ExportCell) ([]byte, error) {
+	nb := Notebook{
+		Cells: make([]Cell, 0, len(cells)),
+		Metadata: Metadata{
+			KernelSpec:   &KernelSpec{Name: "gophernotes", DisplayName: "Go", Language: "go"},
+			LanguageInfo: &LanguageInfo{Name: "go"},
+		},
+		NBFormat:      nbFormat,
+		NBFormatMinor: nbFormatMinor,
+	}
+
+	count := 0
+	for _, block := range cells {
+		cell := Cell{CellType: CellCode, ID: block.BlockID, Source: Text(block.Text)}
+		switch strings.ToLower(block.Language) {
+		case "markdown":
+			cell.CellType = CellMarkdown
+			nb.Cells = append(nb.Cells, cell)
+			continue
+		case "", "go":
+		default:
+			cell.Metadata = map[string]any{languageKey: block.Language}
+		}
+		if block.Executed {
+			count++
+			executed := count
+			cell.ExecutionCount = &executed
+			cell.Outputs = outputs(block)
+		}
+		nb.Cells = append(nb.Cells, cell)
+	}
+	return json.MarshalIndent(nb, "", " ")
+}
+
+func outputs(block ExportCell) []Output {
+	var res []Output
+	if block.Output != "" {
+		res = append(res, Output{OutputType: "stream", Name: "stdout", Text: Text(block.Output)})
+	}
+	if block.Error != "" {
+		res = append(res, Output{OutputType: "error", EName: "error", EValue: block.Error,
+			Traceback: strings.Split(block.Error, "\n")})
+	}
+	return res
+}
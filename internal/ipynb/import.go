@@ -0,0 +1,207 @@
+package ipynb
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/dnonakolesax/noted-runner/internal/preproc"
+)
+
+// Block - блок, в который превратилась ячейка. Cell - номер ячейки с единицы
+type Block struct {
+	Cell     int
+	Text     string
+	Language string
+}
+
+// Note - что случилось с ячейкой при переносе
+type Note struct {
+	Cell int
+	Note string
+}
+
+// Result - перенесённые блоки в порядке ячеек, правки в них и ячейки, которые перенести не удалось
+type Result struct {
+	Blocks  []Block
+	Notes   []Note
+	Skipped []Note
+}
+
+// Import разбирает .ipynb. Ячейки кода становятся блоками Go: магии и команды оболочки, которых у раннера нет,
+// убираются, импорты стандартной библиотеки тоже (их подставляет препроцессор), а func main из GoNB
+// разворачивается в инструкции блока. Markdown переносится блоками markdown, raw-ячейки пропускаются
+func Import(data []byte) (Result, error) {
+	var nb Notebook
+	err := json.Unmarshal(data, &nb)
+	if err != nil {
+		return Result{}, fmt.Errorf("%w: %s", ErrFormat, err)
+	}
+	if nb.NBFormat != nbFormat {
+		return Result{}, fmt.Errorf("%w: nbformat %d, expected %d", ErrFormat, nb.NBFormat, nbFormat)
+	}
+	if lang := nb.Metadata.language(); lang != "" && lang != "go" {
+		return Result{}, fmt.Errorf("%w: %s", ErrLanguage, lang)
+	}
+
+	var res Result
+	for idx, cell := range nb.Cells {
+		num := idx + 1
+		text := string(cell.Source)
+		switch cell.CellType {
+		case CellMarkdown:
+			if strings.TrimSpace(text) != "" {
+				res.Blocks = append(res.Blocks, Block{Cell: num, Text: text, Language: "markdown"})
+			}
+		case CellCode:
+			if lang, ok := cell.Metadata[languageKey].(string); ok {
+				res.Blocks = append(res.Blocks, Block{Cell: num, Text: text, Language: lang})
+				continue
+			}
+			converted, notes, err := convert(text)
+			for _, note := range notes {
+				res.Notes = append(res.Notes, Note{Cell: num, Note: note})
+			}
+			switch {
+			case err != nil:
+				res.Skipped = append(res.Skipped, Note{Cell: num, Note: err.Error()})
+			case strings.TrimSpace(converted) != "":
+				res.Blocks = append(res.Blocks, Block{Cell: num, Text: converted, Language: "go"})
+			case len(notes) != 0:
+				res.Skipped = append(res.Skipped, Note{Cell: num, Note: "nothing left to run after conversion"})
+			}
+		default:
+			res.Skipped = append(res.Skipped, Note{Cell: num, Note: cell.CellType + " cells are not supported"})
+		}
+	}
+	return res, nil
+}
+
+// convert приводит ячейку gophernotes или GoNB к блоку раннера и возвращает правки, которые пришлось сделать
+func convert(src string) (string, []string, error) {
+	var (
+		kept     []string
+		magics   []string
+		notes    []string
+		inImport bool
+	)
+	for _, line := range strings.Split(src, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if inImport {
+			if strings.HasPrefix(trimmed, ")") {
+				inImport = false
+				continue
+			}
+			if err := checkImport(trimmed); err != nil {
+				return "", notes, err
+			}
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(trimmed, "%") && preproc.IsMagic(trimmed):
+			// команды раннера ставятся в начало блока: %%time и %reset работают только там
+			magics = append(magics, trimmed)
+		case strings.HasPrefix(trimmed, "%%"):
+			// в GoNB всё после %% - тело main, у раннера инструкции блока и так выполняются
+			notes = append(notes, "GoNB %% marker removed")
+		case strings.HasPrefix(trimmed, "%"):
+			notes = append(notes, fmt.Sprintf("magic %s is not supported, line removed", strings.Fields(trimmed)[0]))
+		case strings.HasPrefix(trimmed, "!"):
+			notes = append(notes, "shell command removed: "+trimmed)
+		case isGomacroCommand(trimmed):
+			notes = append(notes, fmt.Sprintf("gophernotes command %s removed", strings.Fields(trimmed)[0]))
+		case isImport(trimmed):
+			spec := strings.TrimSpace(strings.TrimPrefix(trimmed, "import"))
+			if rest, ok := strings.CutPrefix(spec, "("); ok {
+				rest, closed := strings.CutSuffix(strings.TrimSpace(rest), ")")
+				inImport = !closed
+				spec = rest
+			}
+			for _, part := range strings.Split(spec, ";") {
+				if err := checkImport(strings.TrimSpace(part)); err != nil {
+					return "", notes, err
+				}
+			}
+		default:
+			kept = append(kept, line)
+		}
+	}
+
+	text := strings.Trim(strings.Join(kept, "\n"), "\n")
+	if unwrapped, ok := unwrapMain(text); ok {
+		text = unwrapped
+		notes = append(notes, "func main unwrapped into block statements")
+	}
+	if len(magics) != 0 {
+		text = strings.TrimSuffix(strings.Join(magics, "\n")+"\n"+text, "\n")
+	}
+	return text, notes, nil
+}
+
+// isGomacroCommand - команды интерпретатора gophernotes вроде :help и :inspect; строка Go с двоеточия не начинается
+func isGomacroCommand(line string) bool {
+	return len(line) > 1 && line[0] == ':' && ('a' <= line[1] && line[1] <= 'z')
+}
+
+func isImport(line string) bool {
+	rest, ok := strings.CutPrefix(line, "import")
+	return ok && (rest == "" || strings.ContainsAny(rest[:1], " \t(\""))
+}
+
+// checkImport проверяет, что импорт можно просто убрать: блок получит тот же пакет под тем же именем
+func checkImport(spec string) error {
+	if idx := strings.Index(spec, "//"); idx >= 0 {
+		spec = spec[:idx]
+	}
+	fields := strings.Fields(strings.TrimSuffix(strings.TrimSpace(spec), ";"))
+	if len(fields) == 0 {
+		return nil
+	}
+	importPath, err := strconv.Unquote(fields[len(fields)-1])
+	if err != nil || len(fields) > 2 {
+		return fmt.Errorf("can't parse import %s", spec)
+	}
+	if len(fields) == 2 {
+		switch alias := fields[0]; {
+		case alias == "_":
+			return nil
+		case alias == ".":
+			return fmt.Errorf("dot import of %s is not supported", importPath)
+		case alias != path.Base(importPath):
+			return fmt.Errorf("import alias %s for %s is not supported", alias, importPath)
+		}
+	}
+	if !preproc.Importable(importPath) {
+		return fmt.Errorf("package %s is not available in the runner", importPath)
+	}
+	return nil
+}
+
+// unwrapMain заменяет func main() { ... } телом функции: GoNB выполняет main ячейки, а раннер - инструкции блока
+func unwrapMain(src string) (string, bool) {
+	const prefix = "package main\n"
+	file, err := parser.ParseFile(token.NewFileSet(), "", prefix+src, parser.SkipObjectResolution)
+	if err != nil {
+		return "", false
+	}
+	for _, decl := range file.Decls {
+		fd, ok := decl.(*ast.FuncDecl)
+		if !ok || fd.Name.Name != "main" || fd.Recv != nil || fd.Body == nil || fd.Type.Params.NumFields() != 0 ||
+			fd.Type.Results.NumFields() != 0 {
+			continue
+		}
+		start, end := int(fd.Pos())-1-len(prefix), int(fd.End())-1-len(prefix)
+		if fd.Doc != nil {
+			start = int(fd.Doc.Pos()) - 1 - len(prefix)
+		}
+		body := src[int(fd.Body.Lbrace)-len(prefix) : int(fd.Body.Rbrace)-1-len(prefix)]
+		return src[:start] + strings.Trim(body, "\n") + src[end:], true
+	}
+	return "", false
+}
@@ -0,0 +1,94 @@
+package ipynb
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestImport(t *testing.T) {
+	data, err := os.ReadFile("testdata/gonb.ipynb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := Import(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Block{
+		{Cell: 1, Text: "# Squares\nComputes a few squares.", Language: "markdown"},
+		{Cell: 2, Text: "func square(x int) int {\n\treturn x * x\n}", Language: "go"},
+		{Cell: 3, Text: "%env GREETING=hi\n\tfmt.Println(square(3))\n\tfmt.Println(strings.Repeat(\"-\", 3))", Language: "go"},
+		{Cell: 6, Text: "n := square(4)\nfmt.Println(n)", Language: "go"},
+	}
+	if len(res.Blocks) != len(want) {
+		t.Fatalf("got blocks %+v", res.Blocks)
+	}
+	for idx := range want {
+		if res.Blocks[idx] != want[idx] {
+			t.Errorf("block %d: got %+v, expected %+v", idx, res.Blocks[idx], want[idx])
+		}
+	}
+
+	skipped := map[int]string{}
+	for _, note := range res.Skipped {
+		skipped[note.Cell] = note.Note
+	}
+	if len(skipped) != 3 || !strings.Contains(skipped[4], "nothing left") ||
+		!strings.Contains(skipped[5], "github.com/example/lib is not available") || !strings.Contains(skipped[7], "raw") {
+		t.Fatalf("unexpected skipped cells %+v", res.Skipped)
+	}
+	if len(res.Notes) != 3 {
+		t.Fatalf("unexpected notes %+v", res.Notes)
+	}
+
+	for _, bad := range []string{`{"nbformat": 3, "cells": []}`, `not json`} {
+		if _, err = Import([]byte(bad)); !errors.Is(err, ErrFormat) {
+			t.Errorf("%s: expected ErrFormat, got %v", bad, err)
+		}
+	}
+	python := `{"nbformat": 4, "cells": [], "metadata": {"kernelspec": {"name": "python3", "display_name": "Python 3", "language": "python"}}}`
+	if _, err = Import([]byte(python)); !errors.Is(err, ErrLanguage) {
+		t.Fatalf("expected ErrLanguage, got %v", err)
+	}
+}
+
+func TestExport(t *testing.T) {
+	data, err := Export([]ExportCell{
+		{BlockID: "a", Text: "# Title", Language: "markdown"},
+		{BlockID: "b", Text: "fmt.Println(1)\nfmt.Println(2)", Executed: true, Output: "1\n2\n"},
+		{BlockID: "c", Text: "panic(1)", Executed: true, Error: "panic: 1"},
+		{BlockID: "d", Text: "SELECT 1", Language: "sql"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var raw struct {
+		Cells []map[string]any `json:"cells"`
+	}
+	if err = json.Unmarshal(data, &raw); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := raw.Cells[0]["outputs"]; ok {
+		t.Fatalf("markdown cell has outputs: %v", raw.Cells[0])
+	}
+	if count, ok := raw.Cells[3]["execution_count"]; !ok || count != nil {
+		t.Fatalf("code cell must have null execution_count: %v", raw.Cells[3])
+	}
+	if raw.Cells[2]["execution_count"] != 2.0 {
+		t.Fatalf("unexpected execution count: %v", raw.Cells[2])
+	}
+
+	// выгруженный блокнот читается обратно теми же блоками
+	res, err := Import(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Blocks) != 4 || res.Blocks[1].Text != "fmt.Println(1)\nfmt.Println(2)" || res.Blocks[3].Language != "sql" {
+		t.Fatalf("round trip changed blocks: %+v", res.Blocks)
+	}
+}
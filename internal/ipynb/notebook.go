@@ -0,0 +1,129 @@
+// Package ipynb переносит блокноты Jupyter (nbformat 4) с ядрами Go - gophernotes и GoNB - в блоки раннера
+// и выгружает блоки с их результатами обратно в .ipynb
+package ipynb
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+var (
+	ErrFormat   = errors.New("invalid notebook")
+	ErrLanguage = errors.New("notebook kernel is not Go")
+)
+
+const (
+	CellCode     = "code"
+	CellMarkdown = "markdown"
+
+	nbFormat      = 4
+	nbFormatMinor = 5
+	// languageKey - ключ метаданных ячейки с языком блока, если это не Go и не Markdown
+	languageKey = "noted_language"
+)
+
+// Text - многострочное поле nbformat: при чтении строка или список строк, при записи список строк
+type Text string
+
+func (t *Text) UnmarshalJSON(data []byte) error {
+	var s string
+	if json.Unmarshal(data, &s) == nil {
+		*t = Text(s)
+		return nil
+	}
+	var lines []string
+	err := json.Unmarshal(data, &lines)
+	if err != nil {
+		return err
+	}
+	*t = Text(strings.Join(lines, ""))
+	return nil
+}
+
+func (t Text) MarshalJSON() ([]byte, error) {
+	lines := strings.SplitAfter(string(t), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return json.Marshal(lines)
+}
+
+type Notebook struct {
+	Cells         []Cell   `json:"cells"`
+	Metadata      Metadata `json:"metadata"`
+	NBFormat      int      `json:"nbformat"`
+	NBFormatMinor int      `json:"nbformat_minor"`
+}
+
+type Metadata struct {
+	KernelSpec   *KernelSpec   `json:"kernelspec,omitempty"`
+	LanguageInfo *LanguageInfo `json:"language_info,omitempty"`
+}
+
+type KernelSpec struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	Language    string `json:"language,omitempty"`
+}
+
+type LanguageInfo struct {
+	Name string `json:"name"`
+}
+
+// language возвращает язык ядра блокнота в нижнем регистре; пустой, если он не указан
+func (m Metadata) language() string {
+	if m.KernelSpec != nil && m.KernelSpec.Language != "" {
+		return strings.ToLower(m.KernelSpec.Language)
+	}
+	if m.LanguageInfo != nil {
+		return strings.ToLower(m.LanguageInfo.Name)
+	}
+	return ""
+}
+
+type Cell struct {
+	CellType       string         `json:"cell_type"`
+	ID             string         `json:"id,omitempty"`
+	Metadata       map[string]any `json:"metadata"`
+	Source         Text           `json:"source"`
+	Outputs        []Output       `json:"outputs,omitempty"`
+	ExecutionCount *int           `json:"execution_count,omitempty"`
+}
+
+// MarshalJSON пишет outputs и execution_count только у ячеек кода и у них всегда: так требует схема nbformat
+func (c Cell) MarshalJSON() ([]byte, error) {
+	if c.Metadata == nil {
+		c.Metadata = map[string]any{}
+	}
+	type plain struct {
+		CellType string         `json:"cell_type"`
+		ID       string         `json:"id,omitempty"`
+		Metadata map[string]any `json:"metadata"`
+		Source   Text           `json:"source"`
+	}
+	if c.CellType != CellCode {
+		return json.Marshal(plain{CellType: c.CellType, ID: c.ID, Metadata: c.Metadata, Source: c.Source})
+	}
+	type code struct {
+		plain
+		Outputs        []Output `json:"outputs"`
+		ExecutionCount *int     `json:"execution_count"`
+	}
+	outputs := c.Outputs
+	if outputs == nil {
+		outputs = []Output{}
+	}
+	return json.Marshal(code{plain: plain{CellType: c.CellType, ID: c.ID, Metadata: c.Metadata, Source: c.Source},
+		Outputs: outputs, ExecutionCount: c.ExecutionCount})
+}
+
+// Output - вывод ячейки кода: stream с текстом или error
+type Output struct {
+	OutputType string   `json:"output_type"`
+	Name       string   `json:"name,omitempty"`
+	Text       Text     `json:"text,omitempty"`
+	EName      string   `json:"ename,omitempty"`
+	EValue     string   `json:"evalue,omitempty"`
+	Traceback  []string `json:"traceback,omitempty"`
+}
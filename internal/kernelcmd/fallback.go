@@ -0,0 +1,55 @@
+package kernelcmd
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/dnonakolesax/noted-runner/internal/ids"
+	"github.com/dnonakolesax/noted-runner/internal/logger"
+	"github.com/dnonakolesax/noted-runner/internal/model"
+)
+
+type Transport interface {
+	Commander
+	Open(kernelID ids.ID) error
+	Close(kernelID ids.ID) error
+}
+
+// Fallback отправляет команду через primary, а если тот недоступен - через secondary.
+// Повторная доставка безопасна: ядро отбрасывает команды с уже виденным ID
+type Fallback struct {
+	primary   Transport
+	secondary Transport
+	logger    *slog.Logger
+}
+
+func NewFallback(primary Transport, secondary Transport, logger *slog.Logger) *Fallback {
+	return &Fallback{primary: primary, secondary: secondary, logger: logger}
+}
+
+func (f *Fallback) Open(kernelID ids.ID) error {
+	err := f.primary.Open(kernelID)
+	if err != nil {
+		f.logger.Warn("error opening primary command transport", logger.LogError(err))
+	}
+	return f.secondary.Open(kernelID)
+}
+
+func (f *Fallback) Close(kernelID ids.ID) error {
+	err := f.primary.Close(kernelID)
+	if err != nil {
+		f.logger.Warn("error closing primary command transport", logger.LogError(err))
+	}
+	return f.secondary.Close(kernelID)
+}
+
+func (f *Fallback) Send(ctx context.Context, kernelID ids.ID, cmd model.KernelCommand) (model.KernelReply, error) {
+	reply, err := f.primary.Send(ctx, kernelID, cmd)
+	if !errors.Is(err, ErrUnavailable) {
+		return reply, err
+	}
+	f.logger.Warn("primary command transport unavailable, falling back", logger.LogError(err),
+		slog.String("type", cmd.Type))
+	return f.secondary.Send(ctx, kernelID, cmd)
+}
@@ -0,0 +1,81 @@
+package kernelcmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/dnonakolesax/noted-runner/internal/httpclient"
+	"github.com/dnonakolesax/noted-runner/internal/ids"
+	"github.com/dnonakolesax/noted-runner/internal/model"
+)
+
+// HTTPCommander отправляет команды напрямую в HTTP-сервер ядра. Повторы делает httpclient
+type HTTPCommander struct {
+	client  *httpclient.HTTPClient
+	baseURL func(kernelID ids.ID) string
+}
+
+func NewHTTPCommander(client *httpclient.HTTPClient, kernelPrefix string, port string) *HTTPCommander {
+	return &HTTPCommander{client: client, baseURL: func(kernelID ids.ID) string {
+		return "http://" + kernelPrefix + kernelID.String() + ":" + port
+	}}
+}
+
+func (hc *HTTPCommander) Open(_ ids.ID) error {
+	return nil
+}
+
+func (hc *HTTPCommander) Close(_ ids.ID) error {
+	return nil
+}
+
+func (hc *HTTPCommander) Send(ctx context.Context, kernelID ids.ID, cmd model.KernelCommand) (model.KernelReply, error) {
+	resp, err := hc.client.Get(ctx, hc.endpoint(kernelID, cmd))
+	if err != nil {
+		return model.KernelReply{}, fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return model.KernelReply{}, fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+
+	// старые ядра отвечают не JSON-подтверждением, а просто статусом
+	var reply model.KernelReply
+	if json.Unmarshal(body, &reply) != nil || reply.ID == "" {
+		reply = model.KernelReply{ID: cmd.ID, OK: resp.StatusCode < http.StatusBadRequest}
+		if !reply.OK {
+			reply.Error = string(body)
+		} else if json.Valid(body) {
+			reply.Data = body
+		}
+	}
+	return reply, replyError(reply)
+}
+
+func (hc *HTTPCommander) endpoint(kernelID ids.ID, cmd model.KernelCommand) string {
+	query := url.Values{}
+	query.Set("id", cmd.ID)
+	if cmd.BlockID != "" {
+		query.Set("block_id", cmd.BlockID)
+	}
+	if cmd.UserID != "" {
+		query.Set("user_id", cmd.UserID)
+	}
+	if cmd.Attempt != "" {
+		query.Set("attempt", cmd.Attempt)
+	}
+
+	path := cmd.Type
+	if cmd.Type == model.CommandExecute {
+		path = "run"
+	}
+	return hc.baseURL(kernelID) + "/" + path + "?" + query.Encode()
+}
@@ -0,0 +1,63 @@
+// Package kernelcmd доставляет команды ядрам (выполнить блок, прервать, осмотреть состояние)
+// и ждёт от них подтверждения. Основной транспорт - RPC через RabbitMQ, запасной - HTTP
+package kernelcmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/dnonakolesax/noted-runner/internal/configs"
+	"github.com/dnonakolesax/noted-runner/internal/ids"
+	"github.com/dnonakolesax/noted-runner/internal/model"
+	"github.com/dnonakolesax/noted-runner/internal/rnd"
+)
+
+var (
+	// ErrUnavailable - транспорт не смог доставить команду или дождаться ответа
+	ErrUnavailable = errors.New("kernel is unavailable")
+	// ErrRejected - ядро получило команду, но ответило ошибкой
+	ErrRejected = errors.New("kernel rejected command")
+)
+
+const commandIDLen = 16
+
+type Commander interface {
+	Send(ctx context.Context, kernelID ids.ID, cmd model.KernelCommand) (model.KernelReply, error)
+}
+
+// CommandKey - ключ маршрутизации команд ядра в обменнике
+func CommandKey(kernelID ids.ID) string {
+	return "cmd." + kernelID.String()
+}
+
+// QueueName - очередь команд ядра, её слушает контейнер ядра
+func QueueName(kernelID ids.ID) string {
+	return "noted-kernel-cmd." + kernelID.String()
+}
+
+func NewCommand(cmdType string) model.KernelCommand {
+	return model.KernelCommand{ID: string(rnd.NotSafeGenRandomString(commandIDLen)), Type: cmdType}
+}
+
+func replyError(reply model.KernelReply) error {
+	if reply.OK {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrRejected, reply.Error)
+}
+
+// New выбирает транспорт команд по конфигу
+func New(transport string, rabbit *RabbitCommander, http *HTTPCommander, logger *slog.Logger) (Transport, error) {
+	switch transport {
+	case configs.CommandsTransportRabbit:
+		return rabbit, nil
+	case configs.CommandsTransportHTTP:
+		return http, nil
+	case configs.CommandsTransportRabbitHTTP:
+		return NewFallback(rabbit, http, logger), nil
+	default:
+		return nil, fmt.Errorf("unknown commands transport %q", transport)
+	}
+}
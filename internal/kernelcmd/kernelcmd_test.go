@@ -0,0 +1,229 @@
+package kernelcmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dnonakolesax/noted-runner/internal/configs"
+	"github.com/dnonakolesax/noted-runner/internal/httpclient"
+	"github.com/dnonakolesax/noted-runner/internal/ids"
+	"github.com/dnonakolesax/noted-runner/internal/metrics"
+	"github.com/dnonakolesax/noted-runner/internal/model"
+	"github.com/dnonakolesax/noted-runner/internal/rabbit"
+	"github.com/dnonakolesax/noted-runner/internal/rabbit/rabbittest"
+	"github.com/prometheus/client_golang/prometheus"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const exchange = "noted-kernels"
+
+var kernelID = ids.MustParse("0b3c9a52-6f2e-4d0c-9a57-2f8b1c3d4e5f")
+
+var commandsConfig = &configs.CommandsConfig{Timeout: 100 * time.Millisecond, Retries: 3, RetryDelay: time.Millisecond}
+
+// newRPC поднимает брокер в памяти, очередь реплики и цикл, передающий ответы ядер в HandleReply
+func newRPC(t *testing.T) (*rabbittest.Server, *RabbitCommander) {
+	t.Helper()
+	server := rabbittest.NewServer()
+	healthy := &atomic.Bool{}
+	rq := rabbit.NewRabbit("amqp://test", rabbit.Topology{Exchange: exchange, Queue: "noted-runner.test"},
+		&configs.RabbitConfig{ReconnectBaseDelay: time.Millisecond, ReconnectMaxDelay: time.Millisecond},
+		server.Dial, healthy, slog.Default())
+	t.Cleanup(rq.Close)
+
+	rc := NewRabbitCommander(rq, commandsConfig, slog.Default())
+	go func() {
+		for msg := range rq.Queue {
+			rc.HandleReply(msg)
+			_ = msg.Ack(false)
+		}
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !healthy.Load() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for connection")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return server, rc
+}
+
+type received struct {
+	mu       sync.Mutex
+	commands []model.KernelCommand
+}
+
+func (r *received) get() []model.KernelCommand {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]model.KernelCommand(nil), r.commands...)
+}
+
+// fakeKernel слушает очередь команд и отвечает на них; skip первых команд остаются без ответа
+func fakeKernel(t *testing.T, server *rabbittest.Server, skip int, ok bool) *received {
+	t.Helper()
+	deliveries, err := server.Consume(QueueName(kernelID))
+	if err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+	rcv := &received{}
+	go func() {
+		for d := range deliveries {
+			var cmd model.KernelCommand
+			_ = json.Unmarshal(d.Body, &cmd)
+			rcv.mu.Lock()
+			rcv.commands = append(rcv.commands, cmd)
+			n := len(rcv.commands)
+			rcv.mu.Unlock()
+			if n <= skip {
+				continue
+			}
+			reply, _ := json.Marshal(model.KernelReply{ID: cmd.ID, OK: ok, Error: "boom", Data: json.RawMessage(`{"vars":1}`)})
+			server.Publish("", d.ReplyTo, amqp.Publishing{CorrelationId: d.CorrelationId, Body: reply})
+		}
+	}()
+	return rcv
+}
+
+func TestRabbitCommander(t *testing.T) {
+	server, rc := newRPC(t)
+	if err := rc.Open(kernelID); err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+	kernel := fakeKernel(t, server, 0, true)
+
+	reply, err := rc.Send(context.Background(), kernelID, NewCommand(model.CommandInspect))
+	if err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+	if string(reply.Data) != `{"vars":1}` || len(kernel.get()) != 1 {
+		t.Fatalf("unexpected reply %+v after %d commands", reply, len(kernel.get()))
+	}
+
+	if err := rc.Close(kernelID); err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+	if server.HasQueue(QueueName(kernelID)) {
+		t.Fatalf("command queue is not deleted")
+	}
+}
+
+func TestRabbitCommanderRetries(t *testing.T) {
+	server, rc := newRPC(t)
+	if err := rc.Open(kernelID); err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+	kernel := fakeKernel(t, server, 1, true)
+
+	cmd := NewCommand(model.CommandExecute)
+	if _, err := rc.Send(context.Background(), kernelID, cmd); err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+	commands := kernel.get()
+	if len(commands) != 2 || commands[0].ID != cmd.ID || commands[1].ID != cmd.ID {
+		t.Fatalf("expected the same command to be sent twice, got %+v", commands)
+	}
+}
+
+func TestRabbitCommanderTimeout(t *testing.T) {
+	server, rc := newRPC(t)
+	if err := rc.Open(kernelID); err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+	kernel := fakeKernel(t, server, 100, true)
+
+	_, err := rc.Send(context.Background(), kernelID, NewCommand(model.CommandExecute))
+	if !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("expected ErrUnavailable, got %v", err)
+	}
+	if len(kernel.get()) != commandsConfig.Retries {
+		t.Fatalf("expected %d attempts, got %d", commandsConfig.Retries, len(kernel.get()))
+	}
+}
+
+func TestRabbitCommanderRejected(t *testing.T) {
+	server, rc := newRPC(t)
+	if err := rc.Open(kernelID); err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+	fakeKernel(t, server, 0, false)
+
+	_, err := rc.Send(context.Background(), kernelID, NewCommand(model.CommandExecute))
+	if !errors.Is(err, ErrRejected) || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("expected ErrRejected, got %v", err)
+	}
+}
+
+func newHTTPCommander(t *testing.T, handler http.HandlerFunc) *HTTPCommander {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	client, err := httpclient.NewWithRetry(&configs.HTTPClientConfig{RequestTimeout: time.Second,
+		RetryPolicy: configs.HTTPRetryPolicyConfig{MaxAttempts: 1}},
+		metrics.NewHTTPRequestMetrics(prometheus.NewRegistry(), "test"), slog.Default())
+	if err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+	hc := NewHTTPCommander(client, "", "")
+	hc.baseURL = func(ids.ID) string { return server.URL }
+	return hc
+}
+
+func TestHTTPCommander(t *testing.T) {
+	var path, blockID string
+	hc := newHTTPCommander(t, func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		blockID = r.URL.Query().Get("block_id")
+	})
+
+	cmd := NewCommand(model.CommandExecute)
+	cmd.BlockID = "4bcb102d-d663-4bec-86b4-86e978b5b54c"
+	reply, err := hc.Send(context.Background(), kernelID, cmd)
+	if err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+	if !reply.OK || path != "/run" || blockID != cmd.BlockID {
+		t.Fatalf("unexpected request %s?block_id=%s, reply %+v", path, blockID, reply)
+	}
+
+	hc = newHTTPCommander(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+		_, _ = w.Write([]byte("block is running"))
+	})
+	_, err = hc.Send(context.Background(), kernelID, NewCommand(model.CommandExecute))
+	if !errors.Is(err, ErrRejected) || !strings.Contains(err.Error(), "block is running") {
+		t.Fatalf("expected ErrRejected, got %v", err)
+	}
+}
+
+func TestFallback(t *testing.T) {
+	server, rc := newRPC(t)
+	server.SetDown(true)
+
+	var calls atomic.Int64
+	hc := newHTTPCommander(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	})
+	fallback := NewFallback(rc, hc, slog.Default())
+
+	if err := fallback.Open(kernelID); err != nil {
+		t.Fatalf("open must not fail while the fallback transport works: %s", err.Error())
+	}
+	if _, err := fallback.Send(context.Background(), kernelID, NewCommand(model.CommandInterrupt)); err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("fallback transport was not used")
+	}
+}
@@ -0,0 +1,147 @@
+package kernelcmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dnonakolesax/noted-runner/internal/configs"
+	"github.com/dnonakolesax/noted-runner/internal/ids"
+	"github.com/dnonakolesax/noted-runner/internal/logger"
+	"github.com/dnonakolesax/noted-runner/internal/model"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var errReplyTimeout = errors.New("no reply from kernel")
+
+type Broker interface {
+	Send(key string, msg amqp.Publishing) error
+	DeclareQueue(name string, key string) error
+	DeleteQueue(name string) error
+	QueueName() string
+}
+
+// RabbitCommander - RPC поверх RabbitMQ: команда уходит в очередь ядра с reply-to очереди реплики
+// и correlation id, равным ID команды. Ответ сопоставляется с ожидающим вызовом в HandleReply
+type RabbitCommander struct {
+	broker  Broker
+	config  *configs.CommandsConfig
+	mu      sync.Mutex
+	pending map[string]chan model.KernelReply
+	logger  *slog.Logger
+}
+
+func NewRabbitCommander(broker Broker, config *configs.CommandsConfig, logger *slog.Logger) *RabbitCommander {
+	return &RabbitCommander{broker: broker, config: config, pending: make(map[string]chan model.KernelReply),
+		logger: logger}
+}
+
+// Open объявляет очередь команд ядра до старта контейнера, чтобы команды дожидались его запуска
+func (rc *RabbitCommander) Open(kernelID ids.ID) error {
+	return rc.broker.DeclareQueue(QueueName(kernelID), CommandKey(kernelID))
+}
+
+func (rc *RabbitCommander) Close(kernelID ids.ID) error {
+	return rc.broker.DeleteQueue(QueueName(kernelID))
+}
+
+func (rc *RabbitCommander) Send(ctx context.Context, kernelID ids.ID, cmd model.KernelCommand) (model.KernelReply, error) {
+	body, err := json.Marshal(cmd)
+	if err != nil {
+		return model.KernelReply{}, err
+	}
+
+	wait := make(chan model.KernelReply, 1)
+	rc.mu.Lock()
+	rc.pending[cmd.ID] = wait
+	rc.mu.Unlock()
+	defer func() {
+		rc.mu.Lock()
+		delete(rc.pending, cmd.ID)
+		rc.mu.Unlock()
+	}()
+
+	msg := amqp.Publishing{
+		ContentType:   "application/json",
+		CorrelationId: cmd.ID,
+		MessageId:     cmd.ID,
+		ReplyTo:       rc.broker.QueueName(),
+		Type:          cmd.Type,
+		// команда, которую ядро не забрало за время ожидания, уже никому не нужна
+		Expiration: strconv.FormatInt(rc.config.Timeout.Milliseconds(), 10),
+		Body:       body,
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= rc.config.Retries; attempt++ {
+		if attempt > 1 {
+			rc.logger.Warn("retrying kernel command", slog.String("type", cmd.Type),
+				slog.Int("attempt", attempt), logger.LogError(lastErr))
+			err = sleepCtx(ctx, rc.config.RetryDelay)
+			if err != nil {
+				return model.KernelReply{}, err
+			}
+		}
+
+		err = rc.broker.Send(CommandKey(kernelID), msg)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		timer := time.NewTimer(rc.config.Timeout)
+		select {
+		case reply := <-wait:
+			timer.Stop()
+			return reply, replyError(reply)
+		case <-timer.C:
+			lastErr = errReplyTimeout
+		case <-ctx.Done():
+			timer.Stop()
+			return model.KernelReply{}, ctx.Err()
+		}
+	}
+	return model.KernelReply{}, fmt.Errorf("%w: %w", ErrUnavailable, lastErr)
+}
+
+// HandleReply передаёт ответ ядра ожидающему вызову. Возвращает false для неизвестных и опоздавших ответов
+func (rc *RabbitCommander) HandleReply(msg amqp.Delivery) bool {
+	var reply model.KernelReply
+	err := json.Unmarshal(msg.Body, &reply)
+	if err != nil {
+		rc.logger.Error("error unmarshaling kernel reply", logger.LogError(err))
+		return false
+	}
+	if reply.ID == "" {
+		reply.ID = msg.CorrelationId
+	}
+
+	rc.mu.Lock()
+	wait, ok := rc.pending[msg.CorrelationId]
+	rc.mu.Unlock()
+	if !ok {
+		return false
+	}
+	select {
+	case wait <- reply:
+	default:
+		// ответ на повторную отправку той же команды
+	}
+	return true
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,42 @@
+package languages
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/dnonakolesax/noted-runner/internal/model"
+	"github.com/dnonakolesax/noted-runner/internal/preproc"
+)
+
+// GoHandler собирает блоки Go препроцессором preproc
+type GoHandler struct {
+	magics *preproc.Magics
+}
+
+func (h *GoHandler) Prepare(req Request) (Prepared, error) {
+	block := preproc.NewBlock(req.BlockID, req.Source, req.Types)
+	if h.magics != nil {
+		block.SetMagics(h.magics)
+	}
+
+	err := block.Parse()
+	if err != nil {
+		return Prepared{}, fmt.Errorf("error parsing block: %s", err)
+	}
+
+	effective, err := block.Override(req.Params)
+	if err != nil {
+		return Prepared{}, err
+	}
+
+	prepared := Prepared{Parameters: effective, Warnings: block.Warnings(), Commit: block.Commit}
+	if req.Mode == model.ModeTest {
+		prepared.Code, err = block.FormTestFunc(req.Attempt)
+		return prepared, err
+	}
+	prepared.Code = block.FormExportFunc(req.Attempt)
+	if prepared.Code == "" {
+		return prepared, errors.New("error forming block code")
+	}
+	return prepared, nil
+}
@@ -0,0 +1,88 @@
+// Package languages собирает блоки разных языков в плагины ядра. У каждого языка свой обработчик
+// со своим препроцессором; все они выдают файл с функцией Export_block_<id>_<попытка>, которую
+// ядро вызывает с funcMap и varMap, так что блоки на разных языках видят одни переменные ядра
+package languages
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/dnonakolesax/noted-runner/internal/preproc"
+)
+
+const (
+	Go       = "go"
+	Markdown = "markdown"
+	SQL      = "sql"
+)
+
+// ErrUnsupportedMode - язык не умеет запускаться в этом режиме, например тесты у Markdown
+var ErrUnsupportedMode = errors.New("run mode is not supported for this language")
+
+// Request - всё, что нужно обработчику, чтобы собрать блок
+type Request struct {
+	BlockID string
+	Source  string
+	Attempt string
+	Mode    string
+	Params  map[string]json.RawMessage
+	// Types - типы ядра; обработчик может их дополнить, как это делает Go
+	Types *preproc.KernelTypes
+}
+
+// Prepared - исходник плагина и то, что о блоке стоит сообщить клиенту
+type Prepared struct {
+	Code       string
+	Parameters map[string]string
+	Warnings   []string
+	// Commit, если задан, вызывается после выполнения блока: так применяются изменения типов ядра,
+	// которые нельзя делать до запуска, например %reset
+	Commit func()
+}
+
+// Handler собирает блок своего языка в исходник плагина
+type Handler interface {
+	Prepare(req Request) (Prepared, error)
+}
+
+// Handlers - обработчики по языкам блоков
+type Handlers map[string]Handler
+
+// NewHandlers возвращает обработчики Go, Markdown и SQL; magics - команды, доступные блокам Go
+func NewHandlers(magics *preproc.Magics) Handlers {
+	return Handlers{
+		Go:       &GoHandler{magics: magics},
+		Markdown: &MarkdownHandler{},
+		SQL:      &SQLHandler{},
+	}
+}
+
+// Register добавляет обработчик языка или заменяет прежний
+func (h Handlers) Register(language string, handler Handler) {
+	h[strings.ToLower(language)] = handler
+}
+
+// Lookup находит обработчик языка блока; блок без языка написан на Go
+func (h Handlers) Lookup(language string) (Handler, bool) {
+	if language == "" {
+		language = Go
+	}
+	handler, ok := h[strings.ToLower(language)]
+	return handler, ok
+}
+
+// exportFunc - заголовок функции, которую вызывает ядро
+func exportFunc(blockID string, attempt string, funcMap string, varMap string) string {
+	return fmt.Sprintf("func Export_block_%s_%s(%s *map[string]any, %s *map[string]any) {\n",
+		strings.ReplaceAll(blockID, "-", "_"), attempt, funcMap, varMap)
+}
+
+// noParams - параметры бывают только у блока параметров на Go
+func noParams(req Request) error {
+	if len(req.Params) != 0 {
+		return preproc.ErrNotParameters
+	}
+	return nil
+}
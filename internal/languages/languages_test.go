@@ -0,0 +1,169 @@
+package languages
+
+import (
+	"encoding/json"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dnonakolesax/noted-runner/internal/batch"
+	"github.com/dnonakolesax/noted-runner/internal/model"
+	"github.com/dnonakolesax/noted-runner/internal/preproc"
+)
+
+const peopleBlock = `type Person struct {
+	Name string
+	Age  int
+	City string
+}
+
+people := []Person{
+	{Name: "Ann", Age: 31, City: "Moscow"},
+	{Name: "Bob", Age: 25, City: "Kazan"},
+	{Name: "Eve", Age: 42, City: "Moscow"},
+	{Name: "Dan", Age: 19, City: "Kazan"},
+	{Name: "Kim", Age: 37, City: "Omsk"},
+}
+total := len(people)`
+
+// peopleTypes - типы ядра после блока peopleBlock
+func peopleTypes(t *testing.T) *preproc.KernelTypes {
+	t.Helper()
+	types := preproc.NewKernelTypes()
+	block := preproc.NewBlock("1a2b3c4d-d663-4bec-86b4-86e978b5b54c", peopleBlock, types)
+	if err := block.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	return types
+}
+
+func TestLookup(t *testing.T) {
+	handlers := NewHandlers(preproc.DefaultMagics())
+	for _, lang := range []string{"", "go", "Markdown", "SQL"} {
+		if _, ok := handlers.Lookup(lang); !ok {
+			t.Errorf("no handler for %q", lang)
+		}
+	}
+	if _, ok := handlers.Lookup("python"); ok {
+		t.Error("python has a handler")
+	}
+}
+
+func TestSQLErrors(t *testing.T) {
+	types := peopleTypes(t)
+	cases := map[string]string{
+		"SELECT Salary FROM people":                             "Salary",
+		"SELECT * FROM staff":                                   "staff",
+		"SELECT * FROM total":                                   "total",
+		"SELECT City, Name, COUNT(*) FROM people GROUP BY City": "Name",
+		"SELECT Name FROM people WHERE COUNT(*) > 1":            "COUNT",
+		"SELECT Name FROM people WHERE":                         "",
+		"SELECT Name FROM people; SELECT Age FROM people":       "",
+		"DELETE FROM people":                                    "",
+	}
+	for src, mention := range cases {
+		_, err := (&SQLHandler{}).Prepare(Request{BlockID: "b", Source: src, Attempt: "at1", Mode: model.ModeRun,
+			Types: types})
+		if !errors.Is(err, ErrQuery) {
+			t.Errorf("%q: expected ErrQuery, got %v", src, err)
+			continue
+		}
+		if !strings.Contains(err.Error(), mention) {
+			t.Errorf("%q: error doesn't mention %s: %v", src, mention, err)
+		}
+	}
+
+	_, err := (&SQLHandler{}).Prepare(Request{BlockID: "b", Source: "SELECT * FROM people", Attempt: "at1",
+		Mode: model.ModeTest, Types: types})
+	if !errors.Is(err, ErrUnsupportedMode) {
+		t.Errorf("expected ErrUnsupportedMode, got %v", err)
+	}
+	_, err = (&SQLHandler{}).Prepare(Request{BlockID: "b", Source: "SELECT * FROM people", Attempt: "at1",
+		Mode: model.ModeRun, Types: types, Params: map[string]json.RawMessage{"x": json.RawMessage(`1`)}})
+	if !errors.Is(err, preproc.ErrNotParameters) {
+		t.Errorf("expected ErrNotParameters, got %v", err)
+	}
+}
+
+func TestMarkdownErrors(t *testing.T) {
+	types := peopleTypes(t)
+	cases := map[string]string{
+		"Total: {{.count}}": "count",
+		"{{range .people}}{{.Name}} {{$.cities}}{{end}}": "cities",
+		"{{if .total}}": "",
+	}
+	for src, mention := range cases {
+		_, err := (&MarkdownHandler{}).Prepare(Request{BlockID: "b", Source: src, Attempt: "at1",
+			Mode: model.ModeRun, Types: types})
+		if !errors.Is(err, ErrTemplate) {
+			t.Errorf("%q: expected ErrTemplate, got %v", src, err)
+			continue
+		}
+		if !strings.Contains(err.Error(), mention) {
+			t.Errorf("%q: error doesn't mention %s: %v", src, mention, err)
+		}
+	}
+	// поля элементов внутри range не проверяются: точка там - элемент, а не ядро
+	_, err := (&MarkdownHandler{}).Prepare(Request{BlockID: "b", Source: "{{range .people}}{{.Name}}{{end}}",
+		Attempt: "at1", Mode: model.ModeRun, Types: types})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestKernel собирает настоящие плагины, поэтому нужен go и CGO
+func TestKernel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds plugins")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is not installed")
+	}
+	handlers := NewHandlers(preproc.DefaultMagics())
+	types := preproc.NewKernelTypes()
+	kernel := batch.NewLocalKernel(t.TempDir(), time.Minute)
+	run := func(blockID string, lang string, src string) string {
+		t.Helper()
+		handler, _ := handlers.Lookup(lang)
+		prepared, err := handler.Prepare(Request{BlockID: blockID, Source: src, Attempt: "at1",
+			Mode: model.ModeRun, Types: types})
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := kernel.Exec(blockID, prepared.Code, "Export_block_"+strings.ReplaceAll(blockID, "-", "_")+"_at1")
+		if err != nil && strings.Contains(err.Error(), "plugin") {
+			t.Skipf("plugins are not supported here: %s", err)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	run("1a2b3c4d-d663-4bec-86b4-86e978b5b54c", Go, peopleBlock)
+
+	out := run("2a2b3c4d-d663-4bec-86b4-86e978b5b54c", SQL, `SELECT city, COUNT(*) AS n, AVG(age) AS "avg age"
+FROM people
+WHERE age >= 20 AND name NOT LIKE 'K%'
+GROUP BY city
+ORDER BY n DESC, city
+LIMIT 5`)
+	want := "| city | n | avg age |\n| --- | --- | --- |\n| Moscow | 2 | 36.5 |\n| Kazan | 1 | 25 |\n\n2 rows\n"
+	if out != want {
+		t.Errorf("got\n%s\nexpected\n%s", out, want)
+	}
+
+	out = run("3a2b3c4d-d663-4bec-86b4-86e978b5b54c", SQL, "SELECT Name, Age * 2 FROM people ORDER BY Age LIMIT 1")
+	want = "| Name | Age * 2 |\n| --- | --- |\n| Dan | 38 |\n\n1 row\n"
+	if out != want {
+		t.Errorf("got\n%s\nexpected\n%s", out, want)
+	}
+
+	out = run("4a2b3c4d-d663-4bec-86b4-86e978b5b54c", Markdown,
+		"# {{.total}} people\n{{range .people}}{{if gt .Age 40}}- {{.Name}}\n{{end}}{{end}}")
+	if want = "# 5 people\n- Eve\n"; out != want {
+		t.Errorf("got\n%s\nexpected\n%s", out, want)
+	}
+}
@@ -0,0 +1,139 @@
+package languages
+
+import (
+	"errors"
+	"fmt"
+	"go/format"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/dnonakolesax/noted-runner/internal/model"
+	"github.com/dnonakolesax/noted-runner/internal/preproc"
+)
+
+// ErrTemplate - блок Markdown не разбирается как шаблон или ссылается на неизвестную переменную
+var ErrTemplate = errors.New("bad markdown template")
+
+// MarkdownHandler выполняет блок как text/template, данные шаблона - переменные ядра:
+// {{.total}} подставляет переменную total. Результат блока - получившийся Markdown
+type MarkdownHandler struct{}
+
+const markdownImports = `package main
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+`
+
+func (h *MarkdownHandler) Prepare(req Request) (Prepared, error) {
+	if req.Mode != model.ModeRun {
+		return Prepared{}, fmt.Errorf("%w: %s for %s", ErrUnsupportedMode, req.Mode, Markdown)
+	}
+	if err := noParams(req); err != nil {
+		return Prepared{}, err
+	}
+	if err := checkTemplate(req.Source, req.Types); err != nil {
+		return Prepared{}, err
+	}
+
+	var sb strings.Builder
+	sb.WriteString(markdownImports)
+	sb.WriteString(exportFunc(req.BlockID, req.Attempt, "_", "varMap"))
+	fmt.Fprintf(&sb, "tmpl := template.Must(template.New(%q).Option(\"missingkey=error\").Parse(%s))\n",
+		req.BlockID, strconv.Quote(req.Source))
+	sb.WriteString(`var out strings.Builder
+	if err := tmpl.Execute(&out, *varMap); err != nil {
+		panic(err)
+	}
+	fmt.Print(out.String())
+}
+`)
+	code, err := format.Source([]byte(sb.String()))
+	if err != nil {
+		return Prepared{}, fmt.Errorf("error forming block code: %w", err)
+	}
+	return Prepared{Code: string(code)}, nil
+}
+
+// checkTemplate разбирает шаблон заранее, чтобы ошибка пришла при сборке, а не из ядра. Имена проверяются
+// там, где точка - сами переменные ядра: вне range и with, а также в $.name
+func checkTemplate(src string, types *preproc.KernelTypes) error {
+	tmpl, err := template.New(Markdown).Parse(src)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrTemplate, err)
+	}
+	if tmpl.Tree == nil {
+		return nil
+	}
+	var unknown []string
+	check := func(name string) {
+		if _, ok := types.VarType(name); !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	walkTemplate(tmpl.Tree.Root, true, check)
+	if len(unknown) == 0 {
+		return nil
+	}
+	known := strings.Join(types.Vars(), ", ")
+	if known == "" {
+		known = "none"
+	}
+	return fmt.Errorf("%w: %s is not a kernel variable, kernel variables: %s", ErrTemplate,
+		strings.Join(unknown, ", "), known)
+}
+
+func walkTemplate(node parse.Node, root bool, check func(string)) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			walkTemplate(child, root, check)
+		}
+	case *parse.ActionNode:
+		walkPipe(n.Pipe, root, check)
+	case *parse.IfNode:
+		walkPipe(n.Pipe, root, check)
+		walkTemplate(n.List, root, check)
+		walkTemplate(n.ElseList, root, check)
+	case *parse.RangeNode:
+		walkPipe(n.Pipe, root, check)
+		walkTemplate(n.List, false, check)
+		walkTemplate(n.ElseList, root, check)
+	case *parse.WithNode:
+		walkPipe(n.Pipe, root, check)
+		walkTemplate(n.List, false, check)
+		walkTemplate(n.ElseList, root, check)
+	case *parse.TemplateNode:
+		walkPipe(n.Pipe, root, check)
+	}
+}
+
+func walkPipe(pipe *parse.PipeNode, root bool, check func(string)) {
+	if pipe == nil {
+		return
+	}
+	for _, cmd := range pipe.Cmds {
+		for _, arg := range cmd.Args {
+			switch a := arg.(type) {
+			case *parse.FieldNode:
+				if root {
+					check(a.Ident[0])
+				}
+			case *parse.VariableNode:
+				if a.Ident[0] == "$" && len(a.Ident) > 1 {
+					check(a.Ident[1])
+				}
+			case *parse.PipeNode:
+				walkPipe(a, root, check)
+			}
+		}
+	}
+}
@@ -0,0 +1,422 @@
+package languages
+
+import (
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"strings"
+
+	"github.com/dnonakolesax/noted-runner/internal/model"
+	"github.com/dnonakolesax/noted-runner/internal/preproc"
+)
+
+// SQLHandler выполняет SELECT над срезом структур из переменных ядра: FROM называет переменную,
+// столбцы - экспортируемые поля структуры (без учёта регистра). Запрос проверяется по типам ядра
+// и превращается в код Go, который перебирает срез через reflect. Результат - таблица Markdown
+type SQLHandler struct{}
+
+func (h *SQLHandler) Prepare(req Request) (Prepared, error) {
+	if req.Mode != model.ModeRun {
+		return Prepared{}, fmt.Errorf("%w: %s for %s", ErrUnsupportedMode, req.Mode, SQL)
+	}
+	if err := noParams(req); err != nil {
+		return Prepared{}, err
+	}
+	q, err := parseSQL(req.Source)
+	if err != nil {
+		return Prepared{}, err
+	}
+	fields, err := structFields(req.Types, q.from)
+	if err != nil {
+		return Prepared{}, err
+	}
+	body, err := newSQLGen(q, fields).generate()
+	if err != nil {
+		return Prepared{}, err
+	}
+
+	src := "package main\n\nimport (\n\t\"cmp\"\n\t\"fmt\"\n\t\"reflect\"\n\t\"regexp\"\n\t\"sort\"\n\t\"strconv\"\n" +
+		"\t\"strings\"\n\t\"time\"\n)\n\n" + exportFunc(req.BlockID, req.Attempt, "_", "varMap") + body + "}\n" + sqlRuntime
+	code, err := format.Source([]byte(src))
+	if err != nil {
+		return Prepared{}, fmt.Errorf("error forming block code: %w", err)
+	}
+	return Prepared{Code: string(code)}, nil
+}
+
+// structFields возвращает экспортируемые поля элементов среза name по порядку объявления
+func structFields(kt *preproc.KernelTypes, name string) ([]string, error) {
+	tp, ok := kt.VarType(name)
+	if !ok {
+		known := strings.Join(kt.Vars(), ", ")
+		if known == "" {
+			known = "none"
+		}
+		return nil, fmt.Errorf("%w: %s is not a kernel variable, kernel variables: %s", ErrQuery, name, known)
+	}
+	expr, err := parser.ParseExpr(tp)
+	if err != nil {
+		return nil, fmt.Errorf("%w: can't read type %s of %s", ErrQuery, tp, name)
+	}
+	slice, ok := expr.(*ast.ArrayType)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s is %s, SQL blocks query slices of structs", ErrQuery, name, tp)
+	}
+
+	elem := slice.Elt
+	// именованные типы раскрываются по объявлениям ядра; глубина ограничена на случай циклов
+	for range 8 {
+		if star, ok := elem.(*ast.StarExpr); ok {
+			elem = star.X
+		}
+		ident, ok := elem.(*ast.Ident)
+		if !ok {
+			break
+		}
+		decl, ok := kt.TypeDecl(ident.Name)
+		if !ok {
+			break
+		}
+		file, err := parser.ParseFile(token.NewFileSet(), "", "package main\n"+decl, parser.SkipObjectResolution)
+		if err != nil || len(file.Decls) != 1 {
+			break
+		}
+		elem = file.Decls[0].(*ast.GenDecl).Specs[0].(*ast.TypeSpec).Type
+	}
+	st, ok := elem.(*ast.StructType)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s is %s, SQL blocks query slices of structs", ErrQuery, name, tp)
+	}
+
+	var fields []string
+	for _, field := range st.Fields.List {
+		names := field.Names
+		if len(names) == 0 {
+			// встроенное поле доступно по имени своего типа
+			if ident := embeddedName(field.Type); ident != nil {
+				names = []*ast.Ident{ident}
+			}
+		}
+		for _, ident := range names {
+			if ident.IsExported() {
+				fields = append(fields, ident.Name)
+			}
+		}
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("%w: elements of %s have no exported fields", ErrQuery, name)
+	}
+	return fields, nil
+}
+
+func embeddedName(expr ast.Expr) *ast.Ident {
+	switch e := expr.(type) {
+	case *ast.StarExpr:
+		return embeddedName(e.X)
+	case *ast.Ident:
+		return e
+	case *ast.SelectorExpr:
+		return e.Sel
+	}
+	return nil
+}
+
+// sqlContext - где вычисляется выражение: row - имя строки в коде, where - в WHERE или GROUP BY,
+// агрегатов там нет, aggregate - внутри агрегата, где доступны все столбцы
+type sqlContext struct {
+	row       string
+	where     bool
+	aggregate bool
+}
+
+type sqlGen struct {
+	q       *sqlQuery
+	fields  []string
+	grouped bool            // строки сворачиваются в группы: есть GROUP BY или агрегаты
+	keys    map[string]bool // поля из GROUP BY
+}
+
+func newSQLGen(q *sqlQuery, fields []string) *sqlGen {
+	g := &sqlGen{q: q, fields: fields, keys: make(map[string]bool), grouped: len(q.groupBy) != 0}
+	for _, item := range q.items {
+		g.grouped = g.grouped || hasAggregate(item.expr)
+	}
+	return g
+}
+
+func hasAggregate(e sqlExpr) bool {
+	switch x := e.(type) {
+	case sqlCall:
+		return true
+	case sqlUnary:
+		return hasAggregate(x.x)
+	case sqlBinary:
+		return hasAggregate(x.l) || hasAggregate(x.r)
+	case sqlLike:
+		return hasAggregate(x.x)
+	case sqlIsNull:
+		return hasAggregate(x.x)
+	case sqlIn:
+		if hasAggregate(x.x) {
+			return true
+		}
+		for _, item := range x.list {
+			if hasAggregate(item) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// generate возвращает тело функции экспорта: описание запроса для sqlRun
+func (g *sqlGen) generate() (string, error) {
+	q := g.q
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "sqlRun(*varMap, sqlSelect{\nfrom: %q,\n", q.from)
+
+	if q.where != nil {
+		cond, err := g.cond(q.where, sqlContext{row: "row", where: true})
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&sb, "where: func(row any) bool { return %s },\n", cond)
+	}
+	if len(q.groupBy) != 0 {
+		keys := make([]string, 0, len(q.groupBy))
+		for _, expr := range q.groupBy {
+			col, ok := expr.(sqlColumn)
+			if !ok {
+				return "", fmt.Errorf("%w: GROUP BY accepts only columns", ErrQuery)
+			}
+			field, err := g.field(col.name)
+			if err != nil {
+				return "", err
+			}
+			g.keys[field] = true
+			keys = append(keys, fmt.Sprintf("sqlField(row, %q)", field))
+		}
+		fmt.Fprintf(&sb, "group: func(row any) []any { return []any{%s} },\n", strings.Join(keys, ", "))
+	}
+	if g.grouped {
+		sb.WriteString("grouped: true,\n")
+	}
+
+	items := q.items
+	if q.star {
+		if g.grouped {
+			return "", fmt.Errorf("%w: SELECT * can't be used with GROUP BY", ErrQuery)
+		}
+		for _, field := range g.fields {
+			items = append(items, sqlItem{expr: sqlColumn{name: field}, text: field})
+		}
+	}
+	columns := make([]string, 0, len(items))
+	values := make([]string, 0, len(items))
+	itemCtx := sqlContext{row: "rows[0]"}
+	for _, item := range items {
+		value, err := g.value(item.expr, itemCtx)
+		if err != nil {
+			return "", err
+		}
+		values = append(values, value)
+		columns = append(columns, strconv.Quote(header(item)))
+	}
+
+	orders := make([]string, 0, len(q.orderBy))
+	for _, order := range q.orderBy {
+		col, err := g.orderColumn(order, items)
+		if err != nil {
+			return "", err
+		}
+		if col < 0 {
+			// ORDER BY по выражению не из SELECT: его значение - скрытый столбец после видимых
+			value, err := g.value(order.expr, itemCtx)
+			if err != nil {
+				return "", err
+			}
+			col = len(values)
+			values = append(values, value)
+		}
+		orders = append(orders, fmt.Sprintf("{col: %d, desc: %t}", col, order.desc))
+	}
+
+	fmt.Fprintf(&sb, "columns: []string{%s},\n", strings.Join(columns, ", "))
+	fmt.Fprintf(&sb, "project: func(rows []any) []any { return []any{%s} },\n", strings.Join(values, ", "))
+	if len(orders) != 0 {
+		fmt.Fprintf(&sb, "order: []sqlOrder{%s},\n", strings.Join(orders, ", "))
+	}
+	fmt.Fprintf(&sb, "limit: %d,\noffset: %d,\n})\n", q.limit, q.offset)
+	return sb.String(), nil
+}
+
+// header - заголовок столбца результата: псевдоним, имя столбца или текст выражения
+func header(item sqlItem) string {
+	if item.alias != "" {
+		return item.alias
+	}
+	if col, ok := item.expr.(sqlColumn); ok {
+		return col.name
+	}
+	return item.text
+}
+
+// orderColumn находит столбец результата для ORDER BY: по номеру, имени или тексту выражения; -1 - не нашёлся
+func (g *sqlGen) orderColumn(order sqlOrder, items []sqlItem) (int, error) {
+	if lit, ok := order.expr.(sqlLiteral); ok {
+		pos, ok := lit.value.(int64)
+		if !ok || pos < 1 || pos > int64(len(items)) {
+			return 0, fmt.Errorf("%w: ORDER BY %s is not a result column number", ErrQuery, order.text)
+		}
+		return int(pos) - 1, nil
+	}
+	for idx, item := range items {
+		if strings.EqualFold(order.text, header(item)) || strings.EqualFold(order.text, item.text) {
+			return idx, nil
+		}
+	}
+	if hasAggregate(order.expr) && !g.grouped {
+		return 0, fmt.Errorf("%w: ORDER BY %s uses an aggregate without GROUP BY", ErrQuery, order.text)
+	}
+	return -1, nil
+}
+
+// field находит поле структуры по столбцу: сначала точное совпадение, потом без учёта регистра
+func (g *sqlGen) field(name string) (string, error) {
+	for _, field := range g.fields {
+		if field == name {
+			return field, nil
+		}
+	}
+	for _, field := range g.fields {
+		if strings.EqualFold(field, name) {
+			return field, nil
+		}
+	}
+	return "", fmt.Errorf("%w: no column %s in %s, columns: %s", ErrQuery, name, g.q.from,
+		strings.Join(g.fields, ", "))
+}
+
+// value - код выражения со значением типа any
+func (g *sqlGen) value(e sqlExpr, ctx sqlContext) (string, error) {
+	switch x := e.(type) {
+	case sqlColumn:
+		field, err := g.field(x.name)
+		if err != nil {
+			return "", err
+		}
+		if g.grouped && !ctx.where && !ctx.aggregate && !g.keys[field] {
+			return "", fmt.Errorf("%w: column %s must be in GROUP BY or inside an aggregate", ErrQuery, x.name)
+		}
+		return fmt.Sprintf("sqlField(%s, %q)", ctx.row, field), nil
+	case sqlLiteral:
+		return goLiteral(x.value), nil
+	case sqlUnary:
+		if x.op == "-" {
+			v, err := g.value(x.x, ctx)
+			return "sqlNeg(" + v + ")", err
+		}
+	case sqlBinary:
+		switch x.op {
+		case "+", "-", "*", "/":
+			l, err := g.value(x.l, ctx)
+			if err != nil {
+				return "", err
+			}
+			r, err := g.value(x.r, ctx)
+			return fmt.Sprintf("sqlArith(%q, %s, %s)", x.op, l, r), err
+		}
+	case sqlCall:
+		if ctx.where {
+			return "", fmt.Errorf("%w: aggregate %s is not allowed in WHERE and GROUP BY", ErrQuery, x.fn)
+		}
+		if ctx.aggregate {
+			return "", fmt.Errorf("%w: aggregate %s can't be nested", ErrQuery, x.fn)
+		}
+		arg := "nil"
+		if x.arg != nil {
+			v, err := g.value(x.arg, sqlContext{row: "row", aggregate: true})
+			if err != nil {
+				return "", err
+			}
+			arg = "func(row any) any { return " + v + " }"
+		}
+		return fmt.Sprintf("sqlAgg(%q, rows, %s)", strings.ToLower(x.fn), arg), nil
+	}
+	cond, err := g.cond(e, ctx)
+	return "any(" + cond + ")", err
+}
+
+// cond - код выражения с результатом bool
+func (g *sqlGen) cond(e sqlExpr, ctx sqlContext) (string, error) {
+	switch x := e.(type) {
+	case sqlUnary:
+		if x.op == "NOT" {
+			c, err := g.cond(x.x, ctx)
+			return "!" + c, err
+		}
+	case sqlBinary:
+		switch x.op {
+		case "AND", "OR":
+			l, err := g.cond(x.l, ctx)
+			if err != nil {
+				return "", err
+			}
+			r, err := g.cond(x.r, ctx)
+			op := " && "
+			if x.op == "OR" {
+				op = " || "
+			}
+			return "(" + l + op + r + ")", err
+		case "=", "<>", "<", "<=", ">", ">=":
+			l, err := g.value(x.l, ctx)
+			if err != nil {
+				return "", err
+			}
+			r, err := g.value(x.r, ctx)
+			return fmt.Sprintf("sqlCmp(%q, %s, %s)", x.op, l, r), err
+		}
+	case sqlLike:
+		v, err := g.value(x.x, ctx)
+		return fmt.Sprintf("sqlLike(%s, %q, %t)", v, x.pattern, x.not), err
+	case sqlIn:
+		v, err := g.value(x.x, ctx)
+		if err != nil {
+			return "", err
+		}
+		list := make([]string, 0, len(x.list))
+		for _, item := range x.list {
+			iv, err := g.value(item, ctx)
+			if err != nil {
+				return "", err
+			}
+			list = append(list, iv)
+		}
+		return fmt.Sprintf("sqlIn(%s, %t, %s)", v, x.not, strings.Join(list, ", ")), nil
+	case sqlIsNull:
+		v, err := g.value(x.x, ctx)
+		if x.not {
+			return "(" + v + " != nil)", err
+		}
+		return "(" + v + " == nil)", err
+	}
+	v, err := g.value(e, ctx)
+	return "sqlTrue(" + v + ")", err
+}
+
+func goLiteral(value any) string {
+	switch v := value.(type) {
+	case int64:
+		return fmt.Sprintf("int64(%d)", v)
+	case float64:
+		return "float64(" + strconv.FormatFloat(v, 'g', -1, 64) + ")"
+	case string:
+		return strconv.Quote(v)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return "nil"
+}
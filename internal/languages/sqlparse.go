@@ -0,0 +1,560 @@
+package languages
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrQuery - запрос SQL не разбирается или не подходит к данным ядра
+var ErrQuery = errors.New("bad sql query")
+
+// Запрос блока SQL:
+//
+//	SELECT * | выражение [AS имя], ... FROM переменная
+//	[WHERE условие] [GROUP BY столбец, ...] [ORDER BY выражение [ASC | DESC], ...] [LIMIT n [OFFSET m]]
+//
+// В выражениях есть столбцы (поля структур), числа, 'строки', TRUE, FALSE, NULL, + - * /, сравнения,
+// AND, OR, NOT, [NOT] LIKE, [NOT] IN (...), IS [NOT] NULL и агрегаты COUNT, SUM, AVG, MIN, MAX
+type sqlQuery struct {
+	star    bool
+	items   []sqlItem
+	from    string
+	where   sqlExpr
+	groupBy []sqlExpr
+	orderBy []sqlOrder
+	limit   int64 // -1 - без ограничения
+	offset  int64
+}
+
+type sqlItem struct {
+	expr  sqlExpr
+	alias string
+	text  string // выражение, как оно записано в запросе
+}
+
+type sqlOrder struct {
+	expr sqlExpr
+	text string
+	desc bool
+}
+
+type sqlExpr interface{}
+
+type (
+	sqlColumn struct {
+		name string
+	}
+	sqlLiteral struct {
+		value any // int64, float64, string, bool или nil
+	}
+	sqlUnary struct {
+		op string // NOT или -
+		x  sqlExpr
+	}
+	sqlBinary struct {
+		op   string // AND, OR, =, <>, <, <=, >, >=, +, -, *, /
+		l, r sqlExpr
+	}
+	sqlLike struct {
+		x       sqlExpr
+		pattern string
+		not     bool
+	}
+	sqlIn struct {
+		x    sqlExpr
+		list []sqlExpr
+		not  bool
+	}
+	sqlIsNull struct {
+		x   sqlExpr
+		not bool
+	}
+	sqlCall struct {
+		fn  string  // COUNT, SUM, AVG, MIN, MAX
+		arg sqlExpr // nil у COUNT(*)
+	}
+)
+
+var sqlAggregates = map[string]bool{"COUNT": true, "SUM": true, "AVG": true, "MIN": true, "MAX": true}
+
+type sqlTokenKind int
+
+const (
+	sqlEOF sqlTokenKind = iota
+	sqlIdent
+	sqlKeyword
+	sqlNumber
+	sqlString
+	sqlOp
+)
+
+var sqlKeywords = map[string]bool{"SELECT": true, "FROM": true, "WHERE": true, "GROUP": true, "BY": true,
+	"ORDER": true, "ASC": true, "DESC": true, "LIMIT": true, "OFFSET": true, "AND": true, "OR": true, "NOT": true,
+	"LIKE": true, "IN": true, "IS": true, "AS": true, "TRUE": true, "FALSE": true, "NULL": true}
+
+type sqlToken struct {
+	kind sqlTokenKind
+	text string // ключевые слова - в верхнем регистре
+	pos  int
+}
+
+func lexSQL(src string) ([]sqlToken, error) {
+	var tokens []sqlToken
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case strings.HasPrefix(src[i:], "--"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case isNameByte(src[i]) && !unicode.IsDigit(c):
+			start := i
+			for i < len(src) && isNameByte(src[i]) {
+				i++
+			}
+			word := src[start:i]
+			if upper := strings.ToUpper(word); sqlKeywords[upper] || sqlAggregates[upper] {
+				tokens = append(tokens, sqlToken{kind: sqlKeyword, text: upper, pos: start})
+			} else {
+				tokens = append(tokens, sqlToken{kind: sqlIdent, text: word, pos: start})
+			}
+		case c == '"':
+			end := strings.IndexByte(src[i+1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("%w: unterminated quoted name at %d", ErrQuery, i)
+			}
+			tokens = append(tokens, sqlToken{kind: sqlIdent, text: src[i+1 : i+1+end], pos: i})
+			i += end + 2
+		case unicode.IsDigit(c) || (c == '.' && i+1 < len(src) && unicode.IsDigit(rune(src[i+1]))):
+			start := i
+			for i < len(src) && (unicode.IsDigit(rune(src[i])) || src[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, sqlToken{kind: sqlNumber, text: src[start:i], pos: start})
+		case c == '\'':
+			var sb strings.Builder
+			start := i
+			for i++; ; i++ {
+				if i >= len(src) {
+					return nil, fmt.Errorf("%w: unterminated string at %d", ErrQuery, start)
+				}
+				if src[i] == '\'' {
+					if i+1 < len(src) && src[i+1] == '\'' {
+						sb.WriteByte('\'')
+						i++
+						continue
+					}
+					i++
+					break
+				}
+				sb.WriteByte(src[i])
+			}
+			tokens = append(tokens, sqlToken{kind: sqlString, text: sb.String(), pos: start})
+		default:
+			op := ""
+			for _, candidate := range []string{"<=", ">=", "<>", "!=", "=", "<", ">", "+", "-", "*", "/", "(", ")",
+				",", ";"} {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("%w: unexpected %q at %d", ErrQuery, c, i)
+			}
+			tokens = append(tokens, sqlToken{kind: sqlOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(tokens, sqlToken{kind: sqlEOF, pos: len(src)}), nil
+}
+
+// isNameByte - байт имени; байты многобайтовых символов UTF-8 считаются буквами, как в именах Go
+func isNameByte(c byte) bool {
+	return c == '_' || c >= utf8.RuneSelf || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c))
+}
+
+type sqlParser struct {
+	src    string
+	tokens []sqlToken
+	pos    int
+}
+
+func parseSQL(src string) (*sqlQuery, error) {
+	tokens, err := lexSQL(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &sqlParser{src: src, tokens: tokens}
+	q, err := p.query()
+	if err != nil {
+		return nil, err
+	}
+	p.accept(sqlOp, ";")
+	if tok := p.peek(); tok.kind != sqlEOF {
+		return nil, p.errorf("unexpected %s, only one SELECT is allowed", describe(tok))
+	}
+	return q, nil
+}
+
+func (p *sqlParser) peek() sqlToken {
+	return p.tokens[p.pos]
+}
+
+func (p *sqlParser) next() sqlToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != sqlEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *sqlParser) accept(kind sqlTokenKind, text string) bool {
+	if tok := p.peek(); tok.kind == kind && tok.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *sqlParser) expect(kind sqlTokenKind, text string) error {
+	if !p.accept(kind, text) {
+		return p.errorf("expected %s, got %s", text, describe(p.peek()))
+	}
+	return nil
+}
+
+func (p *sqlParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrQuery, fmt.Sprintf(format, args...))
+}
+
+func describe(tok sqlToken) string {
+	switch tok.kind {
+	case sqlEOF:
+		return "end of query"
+	case sqlString:
+		return strconv.Quote(tok.text)
+	}
+	return tok.text
+}
+
+func (p *sqlParser) query() (*sqlQuery, error) {
+	q := &sqlQuery{limit: -1}
+	if err := p.expect(sqlKeyword, "SELECT"); err != nil {
+		return nil, err
+	}
+	if p.accept(sqlOp, "*") {
+		q.star = true
+	} else {
+		for {
+			start := p.peek().pos
+			expr, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			item := sqlItem{expr: expr, text: strings.TrimSpace(p.src[start:p.peek().pos])}
+			if p.accept(sqlKeyword, "AS") {
+				tok := p.next()
+				if tok.kind != sqlIdent {
+					return nil, p.errorf("expected a name after AS, got %s", describe(tok))
+				}
+				item.alias = tok.text
+			}
+			q.items = append(q.items, item)
+			if !p.accept(sqlOp, ",") {
+				break
+			}
+		}
+	}
+
+	if err := p.expect(sqlKeyword, "FROM"); err != nil {
+		return nil, err
+	}
+	tok := p.next()
+	if tok.kind != sqlIdent {
+		return nil, p.errorf("expected a kernel variable after FROM, got %s", describe(tok))
+	}
+	q.from = tok.text
+
+	var err error
+	if p.accept(sqlKeyword, "WHERE") {
+		if q.where, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	if p.accept(sqlKeyword, "GROUP") {
+		if err = p.expect(sqlKeyword, "BY"); err != nil {
+			return nil, err
+		}
+		for {
+			expr, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			q.groupBy = append(q.groupBy, expr)
+			if !p.accept(sqlOp, ",") {
+				break
+			}
+		}
+	}
+	if p.accept(sqlKeyword, "ORDER") {
+		if err = p.expect(sqlKeyword, "BY"); err != nil {
+			return nil, err
+		}
+		for {
+			start := p.peek().pos
+			expr, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			order := sqlOrder{expr: expr, text: strings.TrimSpace(p.src[start:p.peek().pos])}
+			if p.accept(sqlKeyword, "DESC") {
+				order.desc = true
+			} else {
+				p.accept(sqlKeyword, "ASC")
+			}
+			q.orderBy = append(q.orderBy, order)
+			if !p.accept(sqlOp, ",") {
+				break
+			}
+		}
+	}
+	if p.accept(sqlKeyword, "LIMIT") {
+		if q.limit, err = p.count("LIMIT"); err != nil {
+			return nil, err
+		}
+		if p.accept(sqlKeyword, "OFFSET") {
+			if q.offset, err = p.count("OFFSET"); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return q, nil
+}
+
+func (p *sqlParser) count(clause string) (int64, error) {
+	tok := p.next()
+	n, err := strconv.ParseInt(tok.text, 10, 64)
+	if tok.kind != sqlNumber || err != nil || n < 0 {
+		return 0, p.errorf("%s expects a non-negative integer, got %s", clause, describe(tok))
+	}
+	return n, nil
+}
+
+// expr разбирает выражение по старшинству: OR, AND, NOT, сравнения, + -, * /, унарный минус
+func (p *sqlParser) expr() (sqlExpr, error) {
+	return p.binary(0)
+}
+
+var (
+	sqlLevels      = [][]string{{"OR"}, {"AND"}}
+	sqlComparisons = map[string]bool{"=": true, "<>": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true}
+)
+
+func (p *sqlParser) binary(level int) (sqlExpr, error) {
+	if level == len(sqlLevels) {
+		return p.not()
+	}
+	l, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		if tok.kind != sqlKeyword || tok.text != sqlLevels[level][0] {
+			return l, nil
+		}
+		p.next()
+		r, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		l = sqlBinary{op: tok.text, l: l, r: r}
+	}
+}
+
+func (p *sqlParser) not() (sqlExpr, error) {
+	if p.accept(sqlKeyword, "NOT") {
+		x, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return sqlUnary{op: "NOT", x: x}, nil
+	}
+	return p.comparison()
+}
+
+func (p *sqlParser) comparison() (sqlExpr, error) {
+	l, err := p.additive()
+	if err != nil {
+		return nil, err
+	}
+	tok := p.peek()
+	switch {
+	case tok.kind == sqlOp && sqlComparisons[tok.text]:
+		p.next()
+		r, err := p.additive()
+		if err != nil {
+			return nil, err
+		}
+		op := tok.text
+		if op == "!=" {
+			op = "<>"
+		}
+		return sqlBinary{op: op, l: l, r: r}, nil
+	case tok.kind == sqlKeyword && tok.text == "IS":
+		p.next()
+		not := p.accept(sqlKeyword, "NOT")
+		if err = p.expect(sqlKeyword, "NULL"); err != nil {
+			return nil, err
+		}
+		return sqlIsNull{x: l, not: not}, nil
+	}
+
+	not := p.accept(sqlKeyword, "NOT")
+	switch {
+	case p.accept(sqlKeyword, "LIKE"):
+		tok := p.next()
+		if tok.kind != sqlString {
+			return nil, p.errorf("LIKE expects a 'pattern', got %s", describe(tok))
+		}
+		return sqlLike{x: l, pattern: tok.text, not: not}, nil
+	case p.accept(sqlKeyword, "IN"):
+		if err = p.expect(sqlOp, "("); err != nil {
+			return nil, err
+		}
+		in := sqlIn{x: l, not: not}
+		for {
+			x, err := p.additive()
+			if err != nil {
+				return nil, err
+			}
+			in.list = append(in.list, x)
+			if !p.accept(sqlOp, ",") {
+				break
+			}
+		}
+		if err = p.expect(sqlOp, ")"); err != nil {
+			return nil, err
+		}
+		return in, nil
+	case not:
+		return nil, p.errorf("expected LIKE or IN after NOT, got %s", describe(p.peek()))
+	}
+	return l, nil
+}
+
+func (p *sqlParser) additive() (sqlExpr, error) {
+	l, err := p.multiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == sqlOp && (p.peek().text == "+" || p.peek().text == "-") {
+		op := p.next().text
+		r, err := p.multiplicative()
+		if err != nil {
+			return nil, err
+		}
+		l = sqlBinary{op: op, l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *sqlParser) multiplicative() (sqlExpr, error) {
+	l, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == sqlOp && (p.peek().text == "*" || p.peek().text == "/") {
+		op := p.next().text
+		r, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l = sqlBinary{op: op, l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *sqlParser) unary() (sqlExpr, error) {
+	if p.accept(sqlOp, "-") {
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		switch lit := x.(type) {
+		case sqlLiteral:
+			switch v := lit.value.(type) {
+			case int64:
+				return sqlLiteral{value: -v}, nil
+			case float64:
+				return sqlLiteral{value: -v}, nil
+			}
+		}
+		return sqlUnary{op: "-", x: x}, nil
+	}
+	return p.primary()
+}
+
+func (p *sqlParser) primary() (sqlExpr, error) {
+	tok := p.next()
+	switch tok.kind {
+	case sqlNumber:
+		if n, err := strconv.ParseInt(tok.text, 10, 64); err == nil {
+			return sqlLiteral{value: n}, nil
+		}
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, p.errorf("bad number %s", tok.text)
+		}
+		return sqlLiteral{value: f}, nil
+	case sqlString:
+		return sqlLiteral{value: tok.text}, nil
+	case sqlIdent:
+		return sqlColumn{name: tok.text}, nil
+	case sqlKeyword:
+		switch tok.text {
+		case "TRUE", "FALSE":
+			return sqlLiteral{value: tok.text == "TRUE"}, nil
+		case "NULL":
+			return sqlLiteral{}, nil
+		}
+		if sqlAggregates[tok.text] {
+			return p.call(tok.text)
+		}
+	case sqlOp:
+		if tok.text == "(" {
+			x, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			if err = p.expect(sqlOp, ")"); err != nil {
+				return nil, err
+			}
+			return x, nil
+		}
+	}
+	return nil, p.errorf("unexpected %s", describe(tok))
+}
+
+func (p *sqlParser) call(fn string) (sqlExpr, error) {
+	if err := p.expect(sqlOp, "("); err != nil {
+		return nil, err
+	}
+	call := sqlCall{fn: fn}
+	if fn == "COUNT" && p.accept(sqlOp, "*") {
+		return call, p.expect(sqlOp, ")")
+	}
+	arg, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	call.arg = arg
+	return call, p.expect(sqlOp, ")")
+}
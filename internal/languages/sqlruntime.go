@@ -0,0 +1,403 @@
+package languages
+
+// sqlRuntime - функции, которыми пользуется код запроса SQL. Значения столбцов приводятся к int64,
+// float64, string, bool, time.Time или nil; NULL в сравнениях, LIKE и IN даёт ложь, как в SQL
+const sqlRuntime = `
+type sqlSelect struct {
+	from    string
+	where   func(row any) bool
+	group   func(row any) []any
+	grouped bool
+	columns []string
+	project func(rows []any) []any
+	order   []sqlOrder
+	limit   int
+	offset  int
+}
+
+type sqlOrder struct {
+	col  int
+	desc bool
+}
+
+func sqlRun(vars map[string]any, q sqlSelect) {
+	table := reflect.ValueOf(vars[q.from])
+	for table.Kind() == reflect.Pointer && !table.IsNil() {
+		table = table.Elem()
+	}
+	if table.Kind() != reflect.Slice && table.Kind() != reflect.Array {
+		panic(fmt.Sprintf("%s is %T, not a slice", q.from, vars[q.from]))
+	}
+
+	var groups [][]any
+	index := make(map[string]int)
+	for i := range table.Len() {
+		row := table.Index(i).Interface()
+		if q.where != nil && !q.where(row) {
+			continue
+		}
+		switch {
+		case q.group != nil:
+			key := fmt.Sprintf("%#v", q.group(row))
+			idx, ok := index[key]
+			if !ok {
+				idx = len(groups)
+				index[key] = idx
+				groups = append(groups, nil)
+			}
+			groups[idx] = append(groups[idx], row)
+		case q.grouped:
+			if len(groups) == 0 {
+				groups = append(groups, nil)
+			}
+			groups[0] = append(groups[0], row)
+		default:
+			groups = append(groups, []any{row})
+		}
+	}
+	// агрегаты без GROUP BY дают одну строку и на пустой выборке
+	if q.grouped && q.group == nil && len(groups) == 0 {
+		groups = append(groups, nil)
+	}
+
+	rows := make([][]any, 0, len(groups))
+	for _, group := range groups {
+		rows = append(rows, q.project(group))
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		for _, o := range q.order {
+			if c := sqlCompare(rows[i][o.col], rows[j][o.col]); c != 0 {
+				return (c < 0) != o.desc
+			}
+		}
+		return false
+	})
+	rows = rows[min(q.offset, len(rows)):]
+	if q.limit >= 0 {
+		rows = rows[:min(q.limit, len(rows))]
+	}
+	sqlPrint(q.columns, rows)
+}
+
+func sqlField(row any, name string) any {
+	v := reflect.ValueOf(row)
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("row %v is %T, not a struct", row, row))
+	}
+	return sqlValue(v.FieldByName(name))
+}
+
+func sqlValue(v reflect.Value) any {
+	switch v.Kind() {
+	case reflect.Invalid:
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return int64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return sqlValue(v.Elem())
+	}
+	return v.Interface()
+}
+
+// sqlCompare упорядочивает значения; NULL меньше всех
+func sqlCompare(a any, b any) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	switch x := a.(type) {
+	case int64:
+		switch y := b.(type) {
+		case int64:
+			return cmp.Compare(x, y)
+		case float64:
+			return cmp.Compare(float64(x), y)
+		}
+	case float64:
+		switch y := b.(type) {
+		case int64:
+			return cmp.Compare(x, float64(y))
+		case float64:
+			return cmp.Compare(x, y)
+		}
+	case string:
+		switch y := b.(type) {
+		case string:
+			return strings.Compare(x, y)
+		case time.Time:
+			return -sqlCompare(b, a)
+		}
+	case bool:
+		if y, ok := b.(bool); ok {
+			return cmp.Compare(strconv.FormatBool(x), strconv.FormatBool(y))
+		}
+	case time.Time:
+		switch y := b.(type) {
+		case time.Time:
+			return x.Compare(y)
+		case string:
+			if t, ok := sqlTime(y); ok {
+				return x.Compare(t)
+			}
+		}
+	}
+	panic(fmt.Sprintf("can't compare %v (%T) with %v (%T)", a, a, b, b))
+}
+
+// sqlTime читает даты в строковых литералах, чтобы их можно было сравнивать с time.Time
+func sqlTime(s string) (time.Time, bool) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func sqlCmp(op string, a any, b any) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	c := sqlCompare(a, b)
+	switch op {
+	case "=":
+		return c == 0
+	case "<>":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	}
+	return c >= 0
+}
+
+func sqlArith(op string, a any, b any) any {
+	if a == nil || b == nil {
+		return nil
+	}
+	x, xInt := a.(int64)
+	y, yInt := b.(int64)
+	if xInt && yInt {
+		switch op {
+		case "+":
+			return x + y
+		case "-":
+			return x - y
+		case "*":
+			return x * y
+		}
+		if y == 0 {
+			panic("division by zero")
+		}
+		return x / y
+	}
+	fx, xOk := sqlFloat(a)
+	fy, yOk := sqlFloat(b)
+	if !xOk || !yOk {
+		panic(fmt.Sprintf("can't apply %s to %v (%T) and %v (%T)", op, a, a, b, b))
+	}
+	switch op {
+	case "+":
+		return fx + fy
+	case "-":
+		return fx - fy
+	case "*":
+		return fx * fy
+	}
+	return fx / fy
+}
+
+func sqlFloat(v any) (float64, bool) {
+	switch x := v.(type) {
+	case int64:
+		return float64(x), true
+	case float64:
+		return x, true
+	}
+	return 0, false
+}
+
+func sqlNeg(v any) any {
+	switch x := v.(type) {
+	case nil:
+		return nil
+	case int64:
+		return -x
+	case float64:
+		return -x
+	}
+	panic(fmt.Sprintf("can't negate %v (%T)", v, v))
+}
+
+func sqlTrue(v any) bool {
+	switch x := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return x
+	}
+	panic(fmt.Sprintf("%v (%T) is not a boolean", v, v))
+}
+
+var sqlPatterns = make(map[string]*regexp.Regexp)
+
+func sqlLike(v any, pattern string, not bool) bool {
+	if v == nil {
+		return false
+	}
+	s, ok := v.(string)
+	if !ok {
+		panic(fmt.Sprintf("LIKE needs a string, got %v (%T)", v, v))
+	}
+	re, ok := sqlPatterns[pattern]
+	if !ok {
+		var sb strings.Builder
+		sb.WriteString("(?s)^")
+		for _, r := range pattern {
+			switch r {
+			case '%':
+				sb.WriteString(".*")
+			case '_':
+				sb.WriteString(".")
+			default:
+				sb.WriteString(regexp.QuoteMeta(string(r)))
+			}
+		}
+		sb.WriteString("$")
+		re = regexp.MustCompile(sb.String())
+		sqlPatterns[pattern] = re
+	}
+	return re.MatchString(s) != not
+}
+
+func sqlIn(v any, not bool, list ...any) bool {
+	if v == nil {
+		return false
+	}
+	for _, item := range list {
+		if item != nil && sqlCompare(v, item) == 0 {
+			return !not
+		}
+	}
+	return not
+}
+
+// sqlAgg считает агрегат по строкам группы; NULL пропускаются, SUM и AVG пустой группы - NULL
+func sqlAgg(fn string, rows []any, arg func(row any) any) any {
+	if arg == nil {
+		return int64(len(rows))
+	}
+	var values []any
+	for _, row := range rows {
+		if v := arg(row); v != nil {
+			values = append(values, v)
+		}
+	}
+	switch fn {
+	case "count":
+		return int64(len(values))
+	case "min", "max":
+		var best any
+		for _, v := range values {
+			c := sqlCompare(v, best)
+			if best == nil || (fn == "min" && c < 0) || (fn == "max" && c > 0) {
+				best = v
+			}
+		}
+		return best
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	var (
+		isum   int64
+		fsum   float64
+		floats bool
+	)
+	for _, v := range values {
+		switch x := v.(type) {
+		case int64:
+			isum += x
+		case float64:
+			fsum += x
+			floats = true
+		default:
+			panic(fmt.Sprintf("%s needs numbers, got %v (%T)", strings.ToUpper(fn), v, v))
+		}
+	}
+	if fn == "sum" && !floats {
+		return isum
+	}
+	if fn == "sum" {
+		return fsum + float64(isum)
+	}
+	return (fsum + float64(isum)) / float64(len(values))
+}
+
+func sqlPrint(columns []string, rows [][]any) {
+	var sb strings.Builder
+	sb.WriteString("|")
+	for _, col := range columns {
+		sb.WriteString(" " + sqlCell(col) + " |")
+	}
+	sb.WriteString("\n|")
+	for range columns {
+		sb.WriteString(" --- |")
+	}
+	sb.WriteString("\n")
+	for _, row := range rows {
+		sb.WriteString("|")
+		for idx := range columns {
+			sb.WriteString(" " + sqlCell(sqlFormat(row[idx])) + " |")
+		}
+		sb.WriteString("\n")
+	}
+	if len(rows) == 1 {
+		sb.WriteString("\n1 row\n")
+	} else {
+		fmt.Fprintf(&sb, "\n%d rows\n", len(rows))
+	}
+	fmt.Print(sb.String())
+}
+
+func sqlFormat(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return "NULL"
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	case time.Time:
+		return x.Format(time.RFC3339)
+	}
+	return fmt.Sprint(v)
+}
+
+func sqlCell(s string) string {
+	s = strings.ReplaceAll(s, "|", "\\|")
+	return strings.Join(strings.Fields(s), " ")
+}
+`
@@ -0,0 +1,32 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type WSMetrics struct {
+	ActiveConnections prometheus.Gauge
+	HeartbeatTimeouts prometheus.Counter
+}
+
+func NewWSMetrics(reg *prometheus.Registry, name string) *WSMetrics {
+	activeConnections := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: name + "_active_connections",
+		Help: "The number of open " + name + " connections.",
+	})
+
+	heartbeatTimeouts := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: name + "_heartbeat_timeouts",
+		Help: "The total number of " + name + " connections dropped for missing heartbeats.",
+	})
+
+	reg.MustRegister(
+		activeConnections,
+		heartbeatTimeouts,
+	)
+
+	return &WSMetrics{
+		ActiveConnections: activeConnections,
+		HeartbeatTimeouts: heartbeatTimeouts,
+	}
+}
@@ -3,24 +3,38 @@ package middlewares
 import (
 	"context"
 	"log/slog"
+	"strconv"
 	"strings"
 
 	"github.com/dnonakolesax/noted-runner/internal/consts"
+	"github.com/dnonakolesax/noted-runner/internal/model"
 	access "github.com/dnonakolesax/noted-runner/internal/usecase/access/proto"
 	"github.com/valyala/fasthttp"
 	"google.golang.org/grpc/metadata"
 )
 
+// Auditor записывает отказы в доступе в журнал аудита
+type Auditor interface {
+	Event(eventType string, userID string, kernelID string, detail string)
+}
+
 type AccessMW struct {
-	logger *slog.Logger
-	client access.AcessServiceClient
+	logger  *slog.Logger
+	client  access.AcessServiceClient
+	auditor Auditor
 }
 
-func NewAccessMW(client access.AcessServiceClient, logger *slog.Logger) *AccessMW {
-	return &AccessMW{logger: logger, client: client}
+func NewAccessMW(client access.AcessServiceClient, auditor Auditor, logger *slog.Logger) *AccessMW {
+	return &AccessMW{logger: logger, client: client, auditor: auditor}
 }
 
+// MW пропускает запрос, если у пользователя есть право на выполнение блокнота
 func (am *AccessMW) MW(h fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return am.Require("x", h)
+}
+
+// Require пропускает запрос, если у пользователя есть все права из rights: r, w и x
+func (am *AccessMW) Require(rights string, h fasthttp.RequestHandler) fasthttp.RequestHandler {
 	return fasthttp.RequestHandler(func(ctx *fasthttp.RequestCtx) {
 		trace := string(ctx.Request.Header.Peek(consts.HTTPHeaderXRequestID))
 		contex := context.WithValue(context.Background(), consts.TraceContextKey, trace)
@@ -28,19 +42,50 @@ func (am *AccessMW) MW(h fasthttp.RequestHandler) fasthttp.RequestHandler {
 		header := metadata.New(map[string]string{"trace_id": string(ctx.Request.Header.Peek("X-Request-Id"))})
 
 		pCtx := metadata.NewOutgoingContext(context.Background(), header)
-		access, err := am.client.FileAccessCtx(pCtx, &access.AccessRequest{UserID: userID.(string), FileID: string(ctx.QueryArgs().Peek("kernel-id"))})
+		access, err := am.client.FileAccessCtx(pCtx, &access.AccessRequest{UserID: userID.(string), FileID: kernelID(ctx)})
 
 		if err != nil {
 			am.logger.ErrorContext(contex, "error introspecting", slog.String(consts.ErrorLoggerKey, err.Error()))
+			am.auditor.Event(model.AuditAccessDenied, userID.(string), kernelID(ctx),
+				denied(ctx, "access check failed"))
 			ctx.SetStatusCode(fasthttp.StatusUnauthorized)
 			return
 		}
 
-		if !strings.Contains(access.Access, "x") {
-			am.logger.WarnContext(contex, "user has no right to execute", slog.String("access", access.Access))
+		if missing := missingRights(access.Access, rights); missing != "" {
+			am.logger.WarnContext(contex, "user has not enough rights", slog.String("access", access.Access),
+				slog.String("required", rights))
+			am.auditor.Event(model.AuditAccessDenied, userID.(string), kernelID(ctx),
+				denied(ctx, "no right to "+missing+", access "+strconv.Quote(access.Access)))
 			ctx.SetStatusCode(fasthttp.StatusUnauthorized)
 			return
 		}
 		h(ctx)
 	})
 }
+
+// missingRights называет первое из прав rights, которого нет в access
+func missingRights(access string, rights string) string {
+	for _, right := range rights {
+		if !strings.ContainsRune(access, right) {
+			return rightNames[right]
+		}
+	}
+	return ""
+}
+
+var rightNames = map[rune]string{'r': "read", 'w': "write", 'x': "execute"}
+
+// denied описывает отказ для журнала: какой запрос отклонён и почему
+func denied(ctx *fasthttp.RequestCtx, reason string) string {
+	return string(ctx.Method()) + " " + string(ctx.Path()) + ": " + reason
+}
+
+// kernelID берётся из query вебсокета или из пути REST-ручки
+func kernelID(ctx *fasthttp.RequestCtx) string {
+	if id := ctx.QueryArgs().Peek("kernel-id"); id != nil {
+		return string(id)
+	}
+	id, _ := ctx.UserValue("kernel-id").(string)
+	return id
+}
@@ -0,0 +1,34 @@
+package middlewares
+
+import (
+	"log/slog"
+
+	"github.com/dnonakolesax/noted-runner/internal/consts"
+	"github.com/valyala/fasthttp"
+)
+
+// AdminMW пропускает только пользователей из списка администраторов. Ставится после AuthMW
+type AdminMW struct {
+	logger *slog.Logger
+	admins map[string]struct{}
+}
+
+func NewAdminMW(admins []string, logger *slog.Logger) *AdminMW {
+	set := make(map[string]struct{}, len(admins))
+	for _, admin := range admins {
+		set[admin] = struct{}{}
+	}
+	return &AdminMW{logger: logger, admins: set}
+}
+
+func (am *AdminMW) MW(h fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return fasthttp.RequestHandler(func(ctx *fasthttp.RequestCtx) {
+		userID, _ := ctx.Request.UserValue(consts.CtxUserIDKey).(string)
+		if _, ok := am.admins[userID]; !ok {
+			am.logger.Warn("user is not an admin", slog.String("id", userID))
+			ctx.SetStatusCode(fasthttp.StatusForbidden)
+			return
+		}
+		h(ctx)
+	})
+}
@@ -0,0 +1,132 @@
+package middlewares
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/dnonakolesax/noted-runner/internal/consts"
+	"github.com/dnonakolesax/noted-runner/internal/model"
+	access "github.com/dnonakolesax/noted-runner/internal/usecase/access/proto"
+	auth "github.com/dnonakolesax/noted-runner/internal/usecase/auth/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// GRPCAuthMW проверяет вызовы gRPC по тем же правилам, что AuthMW и AccessMW: токены пользователя
+// приходят в метаданных, а на ядро из запроса нужно право на выполнение
+type GRPCAuthMW struct {
+	logger  *slog.Logger
+	auth    auth.AuthServiceClient
+	access  access.AcessServiceClient
+	auditor Auditor
+}
+
+func NewGRPCAuthMW(authClient auth.AuthServiceClient, accessClient access.AcessServiceClient, auditor Auditor,
+	logger *slog.Logger) *GRPCAuthMW {
+	return &GRPCAuthMW{logger: logger, auth: authClient, access: accessClient, auditor: auditor}
+}
+
+// kernelRequest - запрос, адресованный ядру; геттер генерирует protoc
+type kernelRequest interface {
+	GetKernelID() string
+}
+
+func (am *GRPCAuthMW) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := am.authorize(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// Stream проверяет потоковые вызовы: запрос читается уже внутри обработчика, поэтому проверка
+// выполняется при получении первого сообщения
+func (am *GRPCAuthMW) Stream() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &authStream{ServerStream: ss, mw: am, ctx: ss.Context()})
+	}
+}
+
+type authStream struct {
+	grpc.ServerStream
+	mw         *GRPCAuthMW
+	ctx        context.Context
+	authorized bool
+}
+
+func (as *authStream) Context() context.Context {
+	return as.ctx
+}
+
+func (as *authStream) RecvMsg(m any) error {
+	err := as.ServerStream.RecvMsg(m)
+	if err != nil || as.authorized {
+		return err
+	}
+	ctx, err := as.mw.authorize(as.ctx, m)
+	if err != nil {
+		return err
+	}
+	as.ctx = ctx
+	as.authorized = true
+	return nil
+}
+
+func (am *GRPCAuthMW) authorize(ctx context.Context, req any) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	trace := first(md, consts.GRPCTraceMetaKey)
+	logCtx := context.WithValue(ctx, consts.TraceContextKey, trace)
+
+	at := first(md, consts.GRPCATMetaKey)
+	if at == "" {
+		am.logger.WarnContext(logCtx, "no at passed")
+	}
+	rt := first(md, consts.GRPCRTMetaKey)
+	if rt == "" {
+		am.logger.WarnContext(logCtx, "no rt passed")
+		return nil, status.Error(codes.Unauthenticated, "no refresh token passed")
+	}
+
+	pCtx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs(consts.GRPCTraceMetaKey, trace))
+	tokens, err := am.auth.AuthUserIDCtx(pCtx, &auth.UserTokens{Auth: at, Refresh: rt})
+	if err != nil {
+		am.logger.ErrorContext(logCtx, "error introspecting", slog.String(consts.ErrorLoggerKey, err.Error()))
+		return nil, status.Error(codes.Unauthenticated, "invalid tokens")
+	}
+	if tokens.At != nil && tokens.Rt != nil && tokens.It != nil {
+		err = grpc.SetHeader(ctx, metadata.Pairs(consts.GRPCATMetaKey, *tokens.At, consts.GRPCRTMetaKey, *tokens.Rt,
+			consts.GRPCIDTMetaKey, *tokens.It))
+		if err != nil {
+			am.logger.WarnContext(logCtx, "error returning refreshed tokens", slog.String(consts.ErrorLoggerKey, err.Error()))
+		}
+	}
+
+	if kr, ok := req.(kernelRequest); ok {
+		rights, err := am.access.FileAccessCtx(pCtx, &access.AccessRequest{UserID: tokens.ID, FileID: kr.GetKernelID()})
+		if err != nil {
+			am.logger.ErrorContext(logCtx, "error introspecting", slog.String(consts.ErrorLoggerKey, err.Error()))
+			am.auditor.Event(model.AuditAccessDenied, tokens.ID, kr.GetKernelID(), "grpc: access check failed")
+			return nil, status.Error(codes.Unauthenticated, "access check failed")
+		}
+		if !strings.Contains(rights.Access, "x") {
+			am.logger.WarnContext(logCtx, "user has no right to execute", slog.String("access", rights.Access))
+			am.auditor.Event(model.AuditAccessDenied, tokens.ID, kr.GetKernelID(),
+				"grpc: no right to execute, access "+strconv.Quote(rights.Access))
+			return nil, status.Error(codes.PermissionDenied, "no right to execute")
+		}
+	}
+	return context.WithValue(ctx, consts.UserIDContextKey, tokens.ID), nil
+}
+
+func first(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) != 0 {
+		return values[0]
+	}
+	return ""
+}
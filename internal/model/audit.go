@@ -0,0 +1,41 @@
+package model
+
+import "time"
+
+const (
+	AuditKernelStart  = "kernel.start"
+	AuditKernelStop   = "kernel.stop"
+	AuditAccessDenied = "access.denied"
+)
+
+// ExecutionRecord - запись журнала о выполнении блока. Heads - версия блока в automerge,
+// SourceHash - sha256 текста блока, CodePath - файл
+// со сгенерированным кодом; CompileMS и RunMS разделяют время сборки плагина и работы блока в ядре
+type ExecutionRecord struct {
+	ID         string    `json:"id"`
+	UserID     string    `json:"user_id"`
+	KernelID   string    `json:"kernel_id"`
+	BlockID    string    `json:"block_id"`
+	Attempt    string    `json:"attempt,omitempty"`
+	Mode       string    `json:"mode,omitempty"`
+	Heads      []string  `json:"heads,omitempty"`
+	SourceHash string    `json:"source_hash,omitempty"`
+	CodePath   string    `json:"code_path,omitempty"`
+	Status     string    `json:"status"`
+	Output     string    `json:"output,omitempty"`
+	Truncated  bool      `json:"truncated,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	CompileMS  int64     `json:"compile_ms"`
+	RunMS      int64     `json:"run_ms"`
+}
+
+// AuditEvent - событие журнала аудита: запуск и остановка ядер, отказы в доступе
+type AuditEvent struct {
+	Time     time.Time `json:"time"`
+	Type     string    `json:"type"`
+	UserID   string    `json:"user_id,omitempty"`
+	KernelID string    `json:"kernel_id,omitempty"`
+	Detail   string    `json:"detail,omitempty"`
+}
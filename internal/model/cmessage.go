@@ -0,0 +1,25 @@
+package model
+
+import "encoding/json"
+
+// ClientMessage - команда от клиента по вебсокету. Для совместимости сообщение,
+// которое не разбирается как JSON, считается id блока для запуска
+type ClientMessage struct {
+	Type    string `json:"type"`
+	BlockID string `json:"block_id"`
+	// Parameters переопределяют переменные блока параметров при запуске
+	Parameters map[string]json.RawMessage `json:"parameters,omitempty"`
+	// Heads - версия блока для запуска; без них выполняется текущая
+	Heads []string `json:"heads,omitempty"`
+	// Mode - режим запуска: run (по умолчанию) или test
+	Mode string `json:"mode,omitempty"`
+}
+
+const (
+	ClientRun       = "run"
+	ClientDelete    = "delete"
+	ClientInterrupt = "interrupt"
+	ClientInspect   = "inspect"
+	// ClientShutdown - явное завершение сессии: ядро останавливается сразу, без ожидания переподключения
+	ClientShutdown = "shutdown"
+)
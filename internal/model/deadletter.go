@@ -0,0 +1,28 @@
+package model
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	// DeadLetterKey - ключ, с которым раннер публикует сообщения, которые не смог разобрать или доставить
+	DeadLetterKey = "dead-letter"
+	// DeadLetterQueue - общая для всех реплик очередь мёртвых писем
+	DeadLetterQueue = "noted-runner.dead-letters"
+
+	HeaderParseError  = "x-parse-error"
+	HeaderOriginalKey = "x-original-routing-key"
+)
+
+// ErrCompleted - результат ядра уже засчитан своему запуску, но клиенту не доставлен. Такое сообщение
+// нельзя возвращать в очередь: повторная доставка засчитала бы его следующему запуску того же блока
+var ErrCompleted = errors.New("kernel result is completed but not delivered")
+
+// DeadLetter - сообщение ядра, которое не удалось разобрать или доставить
+type DeadLetter struct {
+	RoutingKey string    `json:"routing_key"`
+	Error      string    `json:"error"`
+	Body       string    `json:"body"`
+	Timestamp  time.Time `json:"timestamp"`
+}
@@ -0,0 +1,79 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	ExecutionRunning = "running"
+	ExecutionDone    = "done"
+	ExecutionFailed  = "failed"
+)
+
+// ExecuteRequest - запуск блока через REST. Без async ответ ждёт результата не дольше Timeout,
+// после чего возвращается ID выполнения, по которому результат можно забрать позже
+type ExecuteRequest struct {
+	BlockID string `json:"block_id"`
+	Async   bool   `json:"async,omitempty"`
+	Timeout string `json:"timeout,omitempty"`
+	// Parameters переопределяют переменные блока параметров
+	Parameters map[string]json.RawMessage `json:"parameters,omitempty"`
+	// Heads - версия блока (heads automerge в hex), которую нужно выполнить; без них - текущая
+	Heads []string `json:"heads,omitempty"`
+	// Mode - режим запуска: run (по умолчанию) или test
+	Mode string `json:"mode,omitempty"`
+}
+
+// Execution - состояние запуска блока, начатого через REST
+type Execution struct {
+	ID         string     `json:"id"`
+	KernelID   string     `json:"kernel_id"`
+	BlockID    string     `json:"block_id"`
+	Status     string     `json:"status"`
+	Result     string     `json:"result,omitempty"`
+	Fail       bool       `json:"fail"`
+	Warnings   []string   `json:"warnings,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// Parameters - действующие параметры запуска блока параметров: имя -> выражение Go
+	Parameters map[string]string `json:"parameters,omitempty"`
+	// Heads - версия блока, которая выполнялась
+	Heads []string `json:"heads,omitempty"`
+	// Tests - результаты тестов и бенчмарков запуска в режиме тестов
+	Tests *TestReport `json:"tests,omitempty"`
+}
+
+// BlockRun - что препроцессор сообщил об отправленном в ядро блоке
+type BlockRun struct {
+	Warnings   []string
+	Parameters map[string]string
+	// Mode - режим запуска, ModeRun или ModeTest
+	Mode string
+	// Attempt, Heads, SourceHash, CodePath и CompileTime заполняются по мере сборки, в том числе при ошибке
+	Attempt     string
+	Heads       []string
+	SourceHash  string
+	CodePath    string
+	CompileTime time.Duration
+}
+
+// KernelStatus - состояние ядра пользователя. State - ответ ядра на inspect, Error - почему его нет
+type KernelStatus struct {
+	KernelID  string          `json:"kernel_id"`
+	Connected bool            `json:"connected"`
+	Seq       uint64          `json:"seq"`
+	State     json.RawMessage `json:"state,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// KernelCreated - ответ на создание ядра; с токеном к сессии можно подключиться по вебсокету
+type KernelCreated struct {
+	KernelID string `json:"kernel_id"`
+	Token    string `json:"session_token"`
+}
+
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
@@ -0,0 +1,39 @@
+package model
+
+// Раскладки экспорта блокнота: один main.go или пакет с тестом, который выполняет блоки
+const (
+	ExportMain    = "main"
+	ExportPackage = "package"
+)
+
+// SkippedBlock - блок, который не вошёл в экспортированную программу
+type SkippedBlock struct {
+	BlockID string
+	Reason  string
+}
+
+// NotebookExport - блокнот, собранный в программу на Go. Files - путь файла -> содержимое
+type NotebookExport struct {
+	Files   map[string]string
+	Skipped []SkippedBlock
+}
+
+// CellNote - что случилось с ячейкой .ipynb при импорте. Cell - номер ячейки с единицы
+type CellNote struct {
+	Cell int    `json:"cell"`
+	Note string `json:"note"`
+}
+
+// ImportedBlock - блок, созданный из ячейки
+type ImportedBlock struct {
+	Cell     int    `json:"cell"`
+	BlockID  string `json:"block_id"`
+	Language string `json:"language"`
+}
+
+// NotebookImport - итог импорта .ipynb: блоки в порядке ячеек, правки в них и непереносимые ячейки
+type NotebookImport struct {
+	Blocks  []ImportedBlock `json:"blocks"`
+	Notes   []CellNote      `json:"notes,omitempty"`
+	Skipped []CellNote      `json:"skipped,omitempty"`
+}
@@ -0,0 +1,27 @@
+package model
+
+import "encoding/json"
+
+const (
+	CommandExecute   = "execute"
+	CommandInterrupt = "interrupt"
+	CommandInspect   = "inspect"
+)
+
+// KernelCommand - команда ядру. ID не меняется между повторными отправками,
+// по нему ядро отбрасывает дубликаты
+type KernelCommand struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	BlockID string `json:"block_id,omitempty"`
+	UserID  string `json:"user_id,omitempty"`
+	Attempt string `json:"attempt,omitempty"`
+}
+
+// KernelReply - подтверждение команды от ядра
+type KernelReply struct {
+	ID    string          `json:"id"`
+	OK    bool            `json:"ok"`
+	Error string          `json:"error,omitempty"`
+	Data  json.RawMessage `json:"data,omitempty"`
+}
@@ -1,8 +1,64 @@
 package model
 
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+)
+
 type KernelMessage struct {
-	KernelID string `json:"kernel_id"`
-	BlockID  string `json:"block_id"`
-	Result   string `json:"result"`
-	Fail     bool   `json:"fail"`
+	KernelID string   `json:"kernel_id"`
+	BlockID  string   `json:"block_id"`
+	Result   string   `json:"result"`
+	Fail     bool     `json:"fail"`
+	Warnings []string `json:"warnings,omitempty"`
+	// Parameters - действующие параметры, если запускался блок параметров
+	Parameters map[string]string `json:"parameters,omitempty"`
+	// Heads - версия блока, от которой получен результат: клиент сравнивает её с текущей
+	Heads []string `json:"heads,omitempty"`
+	// Tests - результаты тестов и бенчмарков, если блок запускался в режиме тестов; Result тогда - их таблица
+	Tests *TestReport `json:"tests,omitempty"`
+	// Seq - номер сообщения в сессии, по нему клиент догоняет пропущенное после переподключения
+	Seq uint64 `json:"seq,omitempty"`
+	// Raw - сообщение ядра в том виде, в каком оно пришло. Поля, которых раннер не знает,
+	// пересылаются клиенту без изменений после полей структуры
+	Raw map[string]json.RawMessage `json:"-"`
+}
+
+// MarshalJSON дописывает к полям структуры поля Raw, которых среди них нет
+func (m KernelMessage) MarshalJSON() ([]byte, error) {
+	type plain KernelMessage
+	data, err := json.Marshal(plain(m))
+	if err != nil || len(m.Raw) == 0 {
+		return data, err
+	}
+	var known map[string]json.RawMessage
+	err = json.Unmarshal(data, &known)
+	if err != nil {
+		return nil, err
+	}
+	extra := make([]string, 0, len(m.Raw))
+	for key := range m.Raw {
+		if _, ok := known[key]; !ok {
+			extra = append(extra, key)
+		}
+	}
+	if len(extra) == 0 {
+		return data, nil
+	}
+	sort.Strings(extra)
+
+	var buf bytes.Buffer
+	buf.Write(data[:len(data)-1])
+	for idx, key := range extra {
+		if idx != 0 || len(known) != 0 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(key)
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(m.Raw[key])
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
 }
@@ -0,0 +1,39 @@
+package model
+
+import "time"
+
+// RunSkipped - запуск по расписанию пропущен: предыдущий ещё не закончился или блокнот открыт
+const RunSkipped = "skipped"
+
+// ScheduleRequest - создание расписания. Spec - выражение cron из пяти полей, @daily и т.п. или "@every 1h";
+// Timezone - зона IANA, в которой считается Spec (по умолчанию UTC). Blocks задаёт порядок блоков,
+// без него выполняются все блоки блокнота в порядке их ID
+type ScheduleRequest struct {
+	Spec     string   `json:"spec"`
+	Timezone string   `json:"timezone,omitempty"`
+	Blocks   []string `json:"blocks,omitempty"`
+}
+
+// Schedule - расписание блокнота; запуски идут от имени его владельца
+type Schedule struct {
+	ID        string    `json:"id"`
+	KernelID  string    `json:"kernel_id"`
+	UserID    string    `json:"user_id"`
+	Spec      string    `json:"spec"`
+	Timezone  string    `json:"timezone,omitempty"`
+	Blocks    []string  `json:"blocks,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	NextRun   time.Time `json:"next_run"`
+}
+
+// ScheduledRun - запись о запуске по расписанию вместе с результатами блоков
+type ScheduledRun struct {
+	ID         string      `json:"id"`
+	ScheduleID string      `json:"schedule_id"`
+	KernelID   string      `json:"kernel_id"`
+	Status     string      `json:"status"`
+	Error      string      `json:"error,omitempty"`
+	StartedAt  time.Time   `json:"started_at"`
+	FinishedAt *time.Time  `json:"finished_at,omitempty"`
+	Executions []Execution `json:"executions,omitempty"`
+}
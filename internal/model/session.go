@@ -0,0 +1,13 @@
+package model
+
+const SessionOpened = "session"
+
+// SessionMessage - первое сообщение клиенту после подключения. С токеном и номером последнего
+// полученного сообщения клиент может переподключиться к той же сессии
+type SessionMessage struct {
+	Type     string `json:"type"`
+	Token    string `json:"session_token"`
+	KernelID string `json:"kernel_id"`
+	Seq      uint64 `json:"seq"`
+	Resumed  bool   `json:"resumed"`
+}
@@ -0,0 +1,47 @@
+package model
+
+// Режимы запуска блока: ModeRun выполняет блок, ModeTest после этого запускает его тесты и бенчмарки
+const (
+	ModeRun  = "run"
+	ModeTest = "test"
+)
+
+const (
+	TestPass = "pass"
+	TestFail = "fail"
+	TestSkip = "skip"
+)
+
+// TestReport - результат запуска блока в режиме тестов. Output - вывод блока вне тестов
+type TestReport struct {
+	Tests      []TestResult      `json:"tests,omitempty"`
+	Benchmarks []BenchmarkResult `json:"benchmarks,omitempty"`
+	Output     string            `json:"output,omitempty"`
+}
+
+// TestResult - тест или подтест (TestX/case). Output - то, что тест напечатал сам
+type TestResult struct {
+	Name     string        `json:"name"`
+	Status   string        `json:"status"`
+	Seconds  float64       `json:"seconds"`
+	Messages []TestMessage `json:"messages,omitempty"`
+	Output   string        `json:"output,omitempty"`
+}
+
+// TestMessage - сообщение t.Log, t.Error и других; Line - строка блока, 0 - если сообщение не из кода блока
+type TestMessage struct {
+	Line int    `json:"line,omitempty"`
+	Text string `json:"text"`
+}
+
+// BenchmarkResult - итог бенчмарка с -benchmem; Metrics - значения b.ReportMetric по единицам
+type BenchmarkResult struct {
+	Name        string             `json:"name"`
+	Status      string             `json:"status"`
+	N           int64              `json:"n,omitempty"`
+	NsPerOp     float64            `json:"ns_per_op,omitempty"`
+	BytesPerOp  int64              `json:"bytes_per_op,omitempty"`
+	AllocsPerOp int64              `json:"allocs_per_op,omitempty"`
+	Metrics     map[string]float64 `json:"metrics,omitempty"`
+	Messages    []TestMessage      `json:"messages,omitempty"`
+}
@@ -0,0 +1,40 @@
+package mount
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+var ErrUnsafePath = errors.New("unsafe path")
+
+// Root - каталог, смонтированный в ядра. Все пути к файлам блоков строятся только через него
+type Root struct {
+	base string
+}
+
+func NewRoot(base string) *Root {
+	return &Root{base: filepath.Clean(base)}
+}
+
+// Path собирает путь внутри корня. Каждый элемент - одно имя файла или каталога:
+// разделители, "." и ".." запрещены, а итоговый путь обязан остаться внутри корня
+func (r *Root) Path(elems ...string) (string, error) {
+	for _, elem := range elems {
+		if elem == "" || elem == "." || elem == ".." || strings.ContainsAny(elem, "/\\\x00") {
+			return "", fmt.Errorf("%w: %q", ErrUnsafePath, elem)
+		}
+	}
+
+	path := filepath.Join(append([]string{r.base}, elems...)...)
+	rel, err := filepath.Rel(r.base, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %q", ErrUnsafePath, path)
+	}
+	return path, nil
+}
+
+func (r *Root) Base() string {
+	return r.base
+}
@@ -0,0 +1,35 @@
+package mount
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPath(t *testing.T) {
+	root := NewRoot("/noted/codes/kernels/")
+
+	path, err := root.Path("4bcb102d-d663-4bec-86b4-86e978b5b54c", "block_1.go")
+	if err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+	if path != "/noted/codes/kernels/4bcb102d-d663-4bec-86b4-86e978b5b54c/block_1.go" {
+		t.Fatalf("got %s", path)
+	}
+
+	malicious := [][]string{
+		{"..", "x"},
+		{"../../x"},
+		{"kernel", "../../../etc/passwd"},
+		{"kernel", "block_../../x"},
+		{"/etc/passwd"},
+		{"kernel", ""},
+		{"kernel", "."},
+		{"kernel\x00", "x"},
+		{"kernel\\..\\..\\x"},
+	}
+	for _, elems := range malicious {
+		if _, err := root.Path(elems...); !errors.Is(err, ErrUnsafePath) {
+			t.Fatalf("path %q: got %v, expected ErrUnsafePath", elems, err)
+		}
+	}
+}
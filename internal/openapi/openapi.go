@@ -0,0 +1,187 @@
+// Package openapi собирает описание OpenAPI 3 из таблицы маршрутов, по которой
+// регистрируются обработчики, поэтому описание не расходится с кодом
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dnonakolesax/noted-runner/internal/consts"
+)
+
+const version = "3.0.3"
+
+// Route - описание одной ручки. Request и Response - значения типов тела запроса и ответа, nil - без тела.
+// ContentType - тип тела ответа, по умолчанию JSON
+type Route struct {
+	Method      string
+	Path        string
+	Summary     string
+	Query       []string
+	Request     any
+	Response    any
+	ContentType string
+	Status      int
+	Errors      []int
+	Public      bool
+}
+
+// Document строит описание API; пути маршрутов считаются относительно basePath
+func Document(title string, apiVersion string, basePath string, routes []Route) map[string]any {
+	paths := make(map[string]any)
+	for _, route := range routes {
+		path := basePath + route.Path
+		item, ok := paths[path].(map[string]any)
+		if !ok {
+			item = make(map[string]any)
+			paths[path] = item
+		}
+		item[strings.ToLower(route.Method)] = operation(route)
+	}
+
+	return map[string]any{
+		"openapi": version,
+		"info":    map[string]any{"title": title, "version": apiVersion},
+		"paths":   paths,
+		"components": map[string]any{
+			"securitySchemes": map[string]any{
+				"cookieAuth": map[string]any{"type": "apiKey", "in": "cookie", "name": consts.RTCookieKey},
+			},
+		},
+	}
+}
+
+func operation(route Route) map[string]any {
+	op := map[string]any{"summary": route.Summary}
+
+	var params []any
+	for _, name := range pathParams(route.Path) {
+		params = append(params, map[string]any{
+			"name": name, "in": "path", "required": true, "schema": map[string]any{"type": "string"},
+		})
+	}
+	for _, name := range route.Query {
+		params = append(params, map[string]any{
+			"name": name, "in": "query", "required": false, "schema": map[string]any{"type": "string"},
+		})
+	}
+	if len(params) != 0 {
+		op["parameters"] = params
+	}
+
+	if route.Request != nil {
+		op["requestBody"] = map[string]any{
+			"required": true,
+			"content":  map[string]any{"application/json": map[string]any{"schema": Schema(route.Request)}},
+		}
+	}
+
+	status := route.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := map[string]any{"description": http.StatusText(status)}
+	if route.Response != nil {
+		contentType := route.ContentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		success["content"] = map[string]any{contentType: map[string]any{"schema": Schema(route.Response)}}
+	}
+	responses := map[string]any{strconv.Itoa(status): success}
+	for _, code := range route.Errors {
+		responses[strconv.Itoa(code)] = map[string]any{"description": http.StatusText(code)}
+	}
+	op["responses"] = responses
+
+	if !route.Public {
+		op["security"] = []any{map[string]any{"cookieAuth": []any{}}}
+	}
+	return op
+}
+
+func pathParams(path string) []string {
+	var params []string
+	for _, segment := range strings.Split(path, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			params = append(params, strings.Trim(segment, "{}"))
+		}
+	}
+	return params
+}
+
+var (
+	timeType = reflect.TypeFor[time.Time]()
+	rawType  = reflect.TypeFor[json.RawMessage]()
+)
+
+// Schema описывает тип значения v в виде JSON Schema по его json-тегам
+func Schema(v any) map[string]any {
+	return schema(reflect.TypeOf(v))
+}
+
+func schema(t reflect.Type) map[string]any {
+	switch t {
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case rawType:
+		return map[string]any{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schema(t.Elem())
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schema(t.Elem())}
+	case reflect.Struct:
+		return structSchema(t)
+	default:
+		return map[string]any{}
+	}
+}
+
+func structSchema(t reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	var required []string
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = schema(field.Type)
+		if !strings.Contains(opts, "omitempty") && field.Type.Kind() != reflect.Pointer {
+			required = append(required, name)
+		}
+	}
+
+	s := map[string]any{"type": "object", "properties": properties}
+	if len(required) != 0 {
+		s["required"] = required
+	}
+	return s
+}
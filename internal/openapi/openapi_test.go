@@ -0,0 +1,85 @@
+package openapi
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+type request struct {
+	BlockID string   `json:"block_id"`
+	Async   bool     `json:"async,omitempty"`
+	Tags    []string `json:"tags"`
+	hidden  string
+}
+
+type response struct {
+	ID         string         `json:"id"`
+	FinishedAt *time.Time     `json:"finished_at"`
+	Meta       map[string]int `json:"meta,omitempty"`
+	Skipped    string         `json:"-"`
+	Nested     []request      `json:"nested"`
+}
+
+func TestDocument(t *testing.T) {
+	doc := Document("test", "1", "/api/v1/compiler", []Route{
+		{Method: http.MethodPost, Path: "/kernels/{kernel-id}/executions", Summary: "run",
+			Request: request{}, Response: response{}, Errors: []int{http.StatusNotFound}},
+		{Method: http.MethodDelete, Path: "/kernels/{kernel-id}", Status: http.StatusNoContent, Public: true},
+		{Method: http.MethodGet, Path: "/kernels/{kernel-id}/events", Response: response{},
+			ContentType: "text/event-stream"},
+	})
+
+	paths := doc["paths"].(map[string]any)
+	op := paths["/api/v1/compiler/kernels/{kernel-id}/executions"].(map[string]any)["post"].(map[string]any)
+	params := op["parameters"].([]any)
+	if len(params) != 1 || params[0].(map[string]any)["name"] != "kernel-id" {
+		t.Fatalf("unexpected parameters: %v", params)
+	}
+	responses := op["responses"].(map[string]any)
+	if _, ok := responses["200"]; !ok {
+		t.Fatalf("no default success response: %v", responses)
+	}
+	if _, ok := responses["404"]; !ok {
+		t.Fatalf("no error response: %v", responses)
+	}
+	if _, ok := op["security"]; !ok {
+		t.Fatalf("private route without security")
+	}
+
+	del := paths["/api/v1/compiler/kernels/{kernel-id}"].(map[string]any)["delete"].(map[string]any)
+	if _, ok := del["responses"].(map[string]any)["204"]; !ok {
+		t.Fatalf("custom status is not used")
+	}
+	if _, ok := del["security"]; ok {
+		t.Fatalf("public route requires auth")
+	}
+
+	events := paths["/api/v1/compiler/kernels/{kernel-id}/events"].(map[string]any)["get"].(map[string]any)
+	content := events["responses"].(map[string]any)["200"].(map[string]any)["content"].(map[string]any)
+	if _, ok := content["text/event-stream"]; !ok {
+		t.Fatalf("custom content type is not used: %v", content)
+	}
+}
+
+func TestSchema(t *testing.T) {
+	s := Schema(response{})
+	props := s["properties"].(map[string]any)
+	if len(props) != 4 {
+		t.Fatalf("unexpected properties: %v", props)
+	}
+	if props["finished_at"].(map[string]any)["format"] != "date-time" {
+		t.Fatalf("time is not a date-time: %v", props["finished_at"])
+	}
+	if props["meta"].(map[string]any)["additionalProperties"].(map[string]any)["type"] != "integer" {
+		t.Fatalf("unexpected map schema: %v", props["meta"])
+	}
+	nested := props["nested"].(map[string]any)["items"].(map[string]any)
+	required := nested["required"].([]string)
+	if len(required) != 2 || required[0] != "block_id" || required[1] != "tags" {
+		t.Fatalf("unexpected required fields: %v", required)
+	}
+	if req := s["required"].([]string); len(req) != 2 {
+		t.Fatalf("optional fields marked as required: %v", req)
+	}
+}
@@ -0,0 +1,205 @@
+// Package outbox хранит результаты ядер, которые некому отдать: клиент отключён.
+// На каждое ядро держится ограниченная очередь в памяти, а при включённом сбросе на диск -
+// ещё и файл под каталогом ядра в точке монтирования. При переподключении очередь отдаётся целиком
+package outbox
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+
+	"github.com/dnonakolesax/noted-runner/internal/configs"
+	"github.com/dnonakolesax/noted-runner/internal/mount"
+)
+
+var ErrFull = errors.New("outbox is full")
+
+const (
+	spillFile = "outbox.jsonl"
+	maxLine   = 64 * 1024 * 1024
+)
+
+type box struct {
+	memory  [][]byte
+	spilled int
+}
+
+type Outbox struct {
+	config *configs.OutboxConfig
+	root   *mount.Root
+	mu     sync.Mutex
+	boxes  map[string]*box
+	logger *slog.Logger
+}
+
+func NewOutbox(config *configs.OutboxConfig, root *mount.Root, logger *slog.Logger) *Outbox {
+	return &Outbox{config: config, root: root, boxes: make(map[string]*box), logger: logger}
+}
+
+// Push сохраняет сообщение для ядра. Когда память и диск заполнены, новое сообщение отбрасывается
+func (o *Outbox) Push(kernelID string, msg []byte) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	b, ok := o.boxes[kernelID]
+	if !ok {
+		b = &box{spilled: o.spilledBefore(kernelID)}
+		o.boxes[kernelID] = b
+	}
+
+	// пока на диске что-то лежит, новые сообщения идут туда же, иначе нарушится порядок
+	if len(b.memory) < o.config.MemoryLimit && b.spilled == 0 {
+		b.memory = append(b.memory, msg)
+		return nil
+	}
+	if b.spilled >= o.config.DiskLimit {
+		return fmt.Errorf("%w: kernel %s", ErrFull, kernelID)
+	}
+
+	err := o.spill(kernelID, msg)
+	if err != nil {
+		return err
+	}
+	b.spilled++
+	return nil
+}
+
+// Drain забирает все сообщения ядра в порядке поступления
+func (o *Outbox) Drain(kernelID string) ([][]byte, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	b, ok := o.boxes[kernelID]
+	if !ok {
+		b = &box{spilled: o.spilledBefore(kernelID)}
+	}
+	delete(o.boxes, kernelID)
+
+	msgs := b.memory
+	if b.spilled == 0 {
+		return msgs, nil
+	}
+	spilled, err := o.unspill(kernelID)
+	return append(msgs, spilled...), err
+}
+
+// Drop выбрасывает сообщения ядра, которые больше некому отдавать
+func (o *Outbox) Drop(kernelID string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	delete(o.boxes, kernelID)
+	if o.config.DiskLimit == 0 {
+		return
+	}
+	path, err := o.root.Path(kernelID, spillFile)
+	if err != nil {
+		return
+	}
+	err = os.Remove(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		o.logger.Error("error removing outbox file", slog.String("error", err.Error()), slog.String("file", path))
+	}
+}
+
+func (o *Outbox) Len(kernelID string) int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	b, ok := o.boxes[kernelID]
+	if !ok {
+		return 0
+	}
+	return len(b.memory) + b.spilled
+}
+
+// spilledBefore считает сообщения, сброшенные на диск до перезапуска раннера
+func (o *Outbox) spilledBefore(kernelID string) int {
+	if o.config.DiskLimit == 0 {
+		return 0
+	}
+	path, err := o.root.Path(kernelID, spillFile)
+	if err != nil {
+		return 0
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	count := 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, maxLine)
+	for scanner.Scan() {
+		count++
+	}
+	return count
+}
+
+func (o *Outbox) spill(kernelID string, msg []byte) error {
+	dir, err := o.root.Path(kernelID)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(dir, 0o777)
+	if err != nil {
+		return err
+	}
+	path, err := o.root.Path(kernelID, spillFile)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	// строка JSON не содержит переводов строк, так что одно сообщение - одна строка
+	line, err := json.Marshal(string(msg))
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+func (o *Outbox) unspill(kernelID string) ([][]byte, error) {
+	path, err := o.root.Path(kernelID, spillFile)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+		err := os.Remove(path)
+		if err != nil {
+			o.logger.Error("error removing outbox file", slog.String("error", err.Error()), slog.String("file", path))
+		}
+	}()
+
+	var msgs [][]byte
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, maxLine)
+	for scanner.Scan() {
+		var msg string
+		err = json.Unmarshal(scanner.Bytes(), &msg)
+		if err != nil {
+			return msgs, err
+		}
+		msgs = append(msgs, []byte(msg))
+	}
+	return msgs, scanner.Err()
+}
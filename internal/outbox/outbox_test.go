@@ -0,0 +1,102 @@
+package outbox
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/dnonakolesax/noted-runner/internal/configs"
+	"github.com/dnonakolesax/noted-runner/internal/mount"
+)
+
+const kernelID = "0b3c9a52-6f2e-4d0c-9a57-2f8b1c3d4e5f"
+
+func push(t *testing.T, o *Outbox, from int, to int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		if err := o.Push(kernelID, []byte(`{"result":"`+strconv.Itoa(i)+"\n"+`"}`)); err != nil {
+			t.Fatalf("push %d: %v", i, err)
+		}
+	}
+}
+
+func checkDrain(t *testing.T, o *Outbox, n int) {
+	t.Helper()
+	msgs, err := o.Drain(kernelID)
+	if err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+	if len(msgs) != n {
+		t.Fatalf("expected %d messages, got %d", n, len(msgs))
+	}
+	for i, msg := range msgs {
+		if string(msg) != `{"result":"`+strconv.Itoa(i)+"\n"+`"}` {
+			t.Fatalf("message %d out of order: %q", i, msg)
+		}
+	}
+}
+
+func TestMemoryOnly(t *testing.T) {
+	o := NewOutbox(&configs.OutboxConfig{MemoryLimit: 3}, mount.NewRoot(t.TempDir()), slog.Default())
+
+	push(t, o, 0, 3)
+	if err := o.Push(kernelID, []byte("overflow")); !errors.Is(err, ErrFull) {
+		t.Fatalf("expected ErrFull, got %v", err)
+	}
+	checkDrain(t, o, 3)
+	checkDrain(t, o, 0)
+}
+
+func TestSpill(t *testing.T) {
+	dir := t.TempDir()
+	o := NewOutbox(&configs.OutboxConfig{MemoryLimit: 2, DiskLimit: 3}, mount.NewRoot(dir), slog.Default())
+
+	push(t, o, 0, 5)
+	if o.Len(kernelID) != 5 {
+		t.Fatalf("expected 5 messages, got %d", o.Len(kernelID))
+	}
+	if _, err := os.Stat(filepath.Join(dir, kernelID, spillFile)); err != nil {
+		t.Fatalf("nothing spilled to disk: %v", err)
+	}
+	if err := o.Push(kernelID, []byte("overflow")); !errors.Is(err, ErrFull) {
+		t.Fatalf("expected ErrFull, got %v", err)
+	}
+
+	checkDrain(t, o, 5)
+	if _, err := os.Stat(filepath.Join(dir, kernelID, spillFile)); !os.IsNotExist(err) {
+		t.Fatalf("spill file is not removed after drain")
+	}
+}
+
+func TestSpillSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	config := &configs.OutboxConfig{MemoryLimit: 0, DiskLimit: 10}
+
+	push(t, NewOutbox(config, mount.NewRoot(dir), slog.Default()), 0, 3)
+
+	restarted := NewOutbox(config, mount.NewRoot(dir), slog.Default())
+	push(t, restarted, 3, 4)
+	checkDrain(t, restarted, 4)
+}
+
+func TestUnsafeKernelID(t *testing.T) {
+	o := NewOutbox(&configs.OutboxConfig{DiskLimit: 1}, mount.NewRoot(t.TempDir()), slog.Default())
+	if err := o.Push("../../x", []byte("a")); !errors.Is(err, mount.ErrUnsafePath) {
+		t.Fatalf("expected ErrUnsafePath, got %v", err)
+	}
+}
+
+func TestDrop(t *testing.T) {
+	dir := t.TempDir()
+	o := NewOutbox(&configs.OutboxConfig{MemoryLimit: 1, DiskLimit: 3}, mount.NewRoot(dir), slog.Default())
+
+	push(t, o, 0, 3)
+	o.Drop(kernelID)
+	if _, err := os.Stat(filepath.Join(dir, kernelID, spillFile)); !os.IsNotExist(err) {
+		t.Fatalf("spill file is not removed after drop")
+	}
+	checkDrain(t, o, 0)
+}
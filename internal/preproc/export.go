@@ -0,0 +1,356 @@
+package preproc
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"go/version"
+	"maps"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+const (
+	exportHeader = "// Code exported by noted-runner from notebook blocks.\n"
+	mainFunc     = "func main()"
+	testFunc     = "func TestNotebook(_ *testing.T)"
+	unusedPrefix = "declared and not used: "
+)
+
+var ErrPackageName = errors.New("invalid package name")
+
+// Skipped - блок, который не удалось перенести в программу, и причина
+type Skipped struct {
+	BlockID string
+	Reason  string
+}
+
+// Program собирает блоки блокнота в одну программу на Go: функции, типы, константы и методы выносятся
+// на верхний уровень, а переменные и инструкции в порядке блоков попадают в main.
+// Блок, с которым программа не собирается, пропускается и попадает в Skipped
+type Program struct {
+	types   *KernelTypes
+	decls   []string          // объявления верхнего уровня, по куску на блок
+	body    []string          // инструкции main, по куску на блок
+	owners  map[string]string // имя верхнего уровня -> блок
+	vars    map[string]string // переменная main -> блок
+	unused  []string          // переменные main, которые никто не читает
+	skipped []Skipped
+}
+
+func NewProgram() *Program {
+	return &Program{
+		types:  NewKernelTypes(),
+		owners: make(map[string]string),
+		vars:   make(map[string]string),
+	}
+}
+
+// Add дописывает блок в конец программы и сообщает, вошёл ли он в неё
+func (p *Program) Add(blockID string, content string) bool {
+	err := p.add(blockID, content)
+	if err != nil {
+		p.Skip(blockID, err.Error())
+		return false
+	}
+	return true
+}
+
+// Skip отмечает блок пропущенным, например если его не удалось загрузить
+func (p *Program) Skip(blockID string, reason string) {
+	p.skipped = append(p.skipped, Skipped{BlockID: blockID, Reason: reason})
+}
+
+func (p *Program) Skipped() []Skipped {
+	return p.skipped
+}
+
+func (p *Program) add(blockID string, content string) error {
+	if strings.TrimSpace(content) == "" {
+		return nil
+	}
+	kt := p.types.clone()
+	content = PlainMagics(content)
+	block := NewBlock(blockID, content, kt)
+	err := block.Parse()
+	if err != nil {
+		return err
+	}
+	err = p.checkNames(block)
+	if err != nil {
+		return err
+	}
+	content, err = p.redeclare(block)
+	if err != nil {
+		return err
+	}
+
+	decls, body := block.split(content)
+	mark := "// block " + blockID + "\n"
+	nextDecls, nextBody := p.decls, p.body
+	if decls != "" {
+		nextDecls = append(slices.Clone(p.decls), mark+decls)
+	}
+	if body != "" {
+		nextBody = append(slices.Clone(p.body), mark+body)
+	}
+	nextVars := maps.Clone(p.vars)
+	for _, name := range block.vnames {
+		nextVars[name] = blockID
+	}
+
+	unused, err := check(render("main", "", nextDecls, mainFunc, nextBody, nil), nextVars)
+	if err != nil {
+		return err
+	}
+
+	p.types, p.decls, p.body, p.vars, p.unused = kt, nextDecls, nextBody, nextVars, unused
+	for _, name := range slices.Concat(block.fnames, block.snames, block.cnames, block.gnames, block.mnames) {
+		p.owners[name] = blockID
+	}
+	return nil
+}
+
+// checkNames не даёт переопределять объявления верхнего уровня: в ядре новое определение заменяет
+// старое, а в программе имя объявляется один раз
+func (p *Program) checkNames(b *Block) error {
+	for _, name := range slices.Concat(b.fnames, b.snames, b.cnames, b.gnames, b.mnames) {
+		if name == "main" {
+			return errors.New("main is reserved for the program entry point")
+		}
+		if owner, ok := p.owners[name]; ok {
+			return fmt.Errorf("%s is already declared in block %s", name, owner)
+		}
+		if owner, ok := p.vars[name]; ok {
+			return fmt.Errorf("%s is already a variable of block %s", name, owner)
+		}
+	}
+	for _, name := range b.vnames {
+		if owner, ok := p.owners[name]; ok {
+			return fmt.Errorf("variable %s would hide %s declared in block %s", name, name, owner)
+		}
+	}
+	return nil
+}
+
+// redeclare превращает повторное x := ... в присваивание: в main переменная объявляется один раз.
+// Переменную, которая сменила тип или объявлена заново через var, так не перенести
+func (p *Program) redeclare(b *Block) (string, error) {
+	content := []byte(b.content)
+	for _, st := range b.splitStatements() {
+		if st.first != token.VAR && !(st.first == token.IDENT && st.define) {
+			continue
+		}
+		names := declaredNames(st, b.content[st.start:st.end])
+		fresh := false
+		for _, name := range names {
+			owner, ok := p.vars[name]
+			if !ok {
+				fresh = true
+				continue
+			}
+			if st.first == token.VAR {
+				return "", fmt.Errorf("variable %s of block %s is declared again with var", name, owner)
+			}
+			if old, updated := p.types.vars[name], b.types.vars[name]; old != updated {
+				return "", fmt.Errorf("variable %s of block %s changes type from %s to %s", name, owner, old, updated)
+			}
+		}
+		if st.first == token.IDENT && !fresh && len(names) != 0 {
+			// ":=" -> "= " сохраняет смещения и номера строк
+			content[st.defineAt], content[st.defineAt+1] = '=', ' '
+		}
+	}
+	return string(content), nil
+}
+
+// declaredNames возвращает переменные, которые объявляет инструкция var или :=, кроме _
+func declaredNames(st *statement, src string) []string {
+	var idents []*ast.Ident
+	if st.first == token.VAR {
+		file, err := parser.ParseFile(token.NewFileSet(), "", filePrefix+src, parser.SkipObjectResolution)
+		if err != nil || len(file.Decls) != 1 {
+			return nil
+		}
+		gd, ok := file.Decls[0].(*ast.GenDecl)
+		if !ok {
+			return nil
+		}
+		for _, spec := range gd.Specs {
+			idents = append(idents, spec.(*ast.ValueSpec).Names...)
+		}
+	} else {
+		file, err := parser.ParseFile(token.NewFileSet(), "", funcPrefix+src+"\n}", parser.SkipObjectResolution)
+		if err != nil {
+			return nil
+		}
+		body := file.Decls[0].(*ast.FuncDecl).Body
+		if len(body.List) != 1 {
+			return nil
+		}
+		assign, ok := body.List[0].(*ast.AssignStmt)
+		if !ok {
+			return nil
+		}
+		for _, lhs := range assign.Lhs {
+			if ident, ok := lhs.(*ast.Ident); ok {
+				idents = append(idents, ident)
+			}
+		}
+	}
+
+	names := make([]string, 0, len(idents))
+	for _, ident := range idents {
+		if ident.Name != "_" {
+			names = append(names, ident.Name)
+		}
+	}
+	return names
+}
+
+// split делит блок на объявления верхнего уровня и инструкции по тем же видам строк, что и FormExportFunc
+func (b *Block) split(content string) (string, string) {
+	var decls, body strings.Builder
+	for i, text := range strings.Split(content, "\n") {
+		switch b.lineKinds[i+1] {
+		case KindFuncName, KindFuncBody, KindTypeDecl, KindConstDecl:
+			decls.WriteString(text + "\n")
+		case KindVarDecl, KindOther:
+			body.WriteString(text + "\n")
+		}
+	}
+	return decls.String(), body.String()
+}
+
+// check собирает программу через go/types и возвращает переменные main, которые никто не читает:
+// в ядре их забирает карта переменных, а в программе это ошибка компиляции
+func check(code string, vars map[string]string) ([]string, error) {
+	code = clearImports(code)
+	if code == "" {
+		return nil, errors.New("can't format the program")
+	}
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "main.go", code, parser.SkipObjectResolution)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		unused   []string
+		firstErr error
+	)
+	conf := types.Config{
+		Importer: pkgImporter{},
+		Error: func(err error) {
+			var terr types.Error
+			if !errors.As(err, &terr) {
+				firstErr = cmp.Or(firstErr, err)
+				return
+			}
+			if name, ok := strings.CutPrefix(terr.Msg, unusedPrefix); ok {
+				if _, known := vars[name]; known {
+					unused = append(unused, name)
+					return
+				}
+			}
+			firstErr = cmp.Or(firstErr, fmt.Errorf("program doesn't compile with this block: %s", terr.Msg))
+		},
+	}
+	_, _ = conf.Check("main", fset, []*ast.File{file}, nil)
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	slices.Sort(unused)
+	return slices.Compact(unused), nil
+}
+
+// render склеивает файл пакета pkg: объявления и, если fn задана, функцию с инструкциями блоков
+func render(pkg string, header string, decls []string, fn string, body []string, unused []string) string {
+	var sb strings.Builder
+	sb.WriteString(header)
+	sb.WriteString(strings.Replace(baseCopypaste, "package main", "package "+pkg, 1))
+	for _, decl := range decls {
+		sb.WriteString(decl)
+	}
+	if fn == "" {
+		return sb.String()
+	}
+	sb.WriteString("\n" + fn + " {\n")
+	for _, stmts := range body {
+		sb.WriteString(stmts)
+	}
+	for _, name := range unused {
+		sb.WriteString("_ = " + name + "\n")
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+func (p *Program) header() string {
+	header := exportHeader
+	if len(p.skipped) == 0 {
+		return header
+	}
+	header += "//\n// Skipped blocks:\n"
+	for _, skipped := range p.skipped {
+		reason := strings.Join(strings.Fields(skipped.Reason), " ")
+		header += fmt.Sprintf("//   %s: %s\n", skipped.BlockID, reason)
+	}
+	return header
+}
+
+// Main возвращает программу одним файлом main.go
+func (p *Program) Main() (string, error) {
+	src := clearImports(render("main", p.header(), p.decls, mainFunc, p.body, p.unused))
+	if src == "" {
+		return "", errors.New("can't format the program")
+	}
+	return src, nil
+}
+
+// Package возвращает программу пакетом name: объявления лежат в name.go, а инструкции блоков
+// выполняет тест TestNotebook из name_test.go. Ключи - пути файлов, включая go.mod
+func (p *Program) Package(name string) (map[string]string, error) {
+	if !token.IsIdentifier(name) || name == "main" {
+		return nil, fmt.Errorf("%w: %s", ErrPackageName, name)
+	}
+	src := clearImports(render(name, p.header(), p.decls, "", nil, nil))
+	test := clearImports(render(name, exportHeader, nil, testFunc, p.body, p.unused))
+	if src == "" || test == "" {
+		return nil, errors.New("can't format the program")
+	}
+	return map[string]string{name + ".go": src, name + "_test.go": test, "go.mod": goMod(name)}, nil
+}
+
+func goMod(module string) string {
+	mod := "module " + module + "\n"
+	if lang := version.Lang(runtime.Version()); lang != "" {
+		mod += "\ngo " + strings.TrimPrefix(lang, "go") + "\n"
+	}
+	return mod
+}
+
+func (kt *KernelTypes) clone() *KernelTypes {
+	res := &KernelTypes{
+		vars:     maps.Clone(kt.vars),
+		funcs:    maps.Clone(kt.funcs),
+		types:    maps.Clone(kt.types),
+		consts:   maps.Clone(kt.consts),
+		generics: maps.Clone(kt.generics),
+		methods:  make(map[string]map[string]string, len(kt.methods)),
+		owners:   maps.Clone(kt.owners),
+		users:    make(map[string]map[string]bool, len(kt.users)),
+	}
+	for tp, methods := range kt.methods {
+		res.methods[tp] = maps.Clone(methods)
+	}
+	for name, users := range kt.users {
+		res.users[name] = maps.Clone(users)
+	}
+	return res
+}
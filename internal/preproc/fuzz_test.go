@@ -0,0 +1,74 @@
+package preproc
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/txtar"
+)
+
+// fuzzSeeds собирает исходники блоков из testdata и несколько заведомо кривых вариантов
+func fuzzSeeds(f *testing.F) []string {
+	seeds := []string{
+		"",
+		"fmt.Println(\"uzbek\")",
+		"f := func() {}",
+		"func() {}()",
+		"func (",
+		"a, b := 1",
+		"type (",
+		"const A = A\nx := A",
+		"var x, y = 1",
+		"func (r *T[K, V]) M() {}",
+		globalSource,
+	}
+
+	files, err := filepath.Glob("testdata/*.txtar")
+	if err != nil {
+		f.Fatalf("%s", err.Error())
+	}
+	for _, name := range files {
+		archive, err := txtar.ParseFile(name)
+		if err != nil {
+			f.Fatalf("%s", err.Error())
+		}
+		for _, file := range archive.Files {
+			if strings.HasSuffix(file.Name, ".go") || strings.HasSuffix(file.Name, ".golden") {
+				seeds = append(seeds, string(file.Data))
+			}
+		}
+	}
+	return seeds
+}
+
+func FuzzParse(f *testing.F) {
+	for _, seed := range fuzzSeeds(f) {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, source string) {
+		types := NewKernelTypes()
+		types.vars["a"] = "int"
+		types.funcs["abc"] = "func() (int, string)"
+
+		block := NewBlock("1", source, types)
+		if block.Parse() != nil {
+			return
+		}
+		_ = block.FormExportFunc("1")
+		_, _ = block.FormTestFunc("1")
+	})
+}
+
+func FuzzClearImports(f *testing.F) {
+	for _, seed := range fuzzSeeds(f) {
+		f.Add(seed)
+		f.Add(baseCopypaste + seed)
+	}
+
+	f.Fuzz(func(t *testing.T, code string) {
+		block := NewBlock("1", "", NewKernelTypes())
+		_ = block.ClearImports(code)
+	})
+}
@@ -0,0 +1,597 @@
+package preproc
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/tools/go/packages"
+)
+
+var knownTypes map[string]any = map[string]any{
+	"bool": struct{}{},
+
+	"string": struct{}{},
+	"byte":   struct{}{},
+	"rune":   struct{}{},
+
+	"int":        struct{}{},
+	"int8":       struct{}{},
+	"int16":      struct{}{},
+	"int32":      struct{}{},
+	"int64":      struct{}{},
+	"uint":       struct{}{},
+	"uint8":      struct{}{},
+	"uint16":     struct{}{},
+	"uint32":     struct{}{},
+	"uint64":     struct{}{},
+	"uintptr":    struct{}{},
+	"float32":    struct{}{},
+	"float64":    struct{}{},
+	"complex64":  struct{}{},
+	"complex128": struct{}{},
+	"any":        struct{}{},
+	"error":      struct{}{},
+}
+
+var basicLitTypes = map[token.Token]string{
+	token.INT:    "int",
+	token.FLOAT:  "float64",
+	token.IMAG:   "complex128",
+	token.CHAR:   "rune",
+	token.STRING: "string",
+}
+
+// valueTypes выводит типы переменных, объявленных через := или var без типа
+func (b *Block) valueTypes(values []ast.Expr, names int) ([]string, error) {
+	if len(values) == 1 && names > 1 {
+		return b.multiValueTypes(values[0])
+	}
+
+	res := make([]string, 0, len(values))
+	for _, value := range values {
+		tp, err := b.exprType(value)
+		if err != nil {
+			return []string{}, err
+		}
+		res = append(res, tp)
+	}
+	return res, nil
+}
+
+// checkTypes проверяет инструкцию через go/types на фоне всего, что уже известно о ядре.
+// Используется, когда простого разбора выражения не хватает (обобщённые функции, методы, поля)
+func (b *Block) checkTypes(stmt string, names []*ast.Ident) ([]string, error) {
+	var sb strings.Builder
+	sb.WriteString(baseCopypaste)
+	for _, src := range b.kernelDecls() {
+		sb.WriteString(src + "\n")
+	}
+	for _, name := range sortedKeys(b.types.vars) {
+		fmt.Fprintf(&sb, "var %s %s\n", name, b.types.vars[name])
+	}
+	for _, name := range sortedKeys(b.types.funcs) {
+		fmt.Fprintf(&sb, "var %s %s\n", name, b.types.funcs[name])
+	}
+	sb.WriteString("func _() {\n" + stmt + "\n}\n")
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", sb.String(), parser.SkipObjectResolution)
+	if err != nil {
+		return []string{}, err
+	}
+	filterImports(file, collectUsedNames(file))
+
+	info := &types.Info{Scopes: make(map[ast.Node]*types.Scope)}
+	conf := types.Config{
+		Importer: pkgImporter{},
+		Error:    func(error) {},
+	}
+	pkg, _ := conf.Check("main", fset, []*ast.File{file}, info)
+
+	fd, ok := file.Decls[len(file.Decls)-1].(*ast.FuncDecl)
+	if !ok || info.Scopes[fd.Type] == nil {
+		return []string{}, fmt.Errorf("can't check statement %s", stmt)
+	}
+	scope := info.Scopes[fd.Type]
+	qualifier := func(p *types.Package) string {
+		if p == pkg {
+			return ""
+		}
+		return p.Name()
+	}
+
+	res := make([]string, 0, len(names))
+	for _, name := range names {
+		if name.Name == "_" {
+			res = append(res, "")
+			continue
+		}
+		obj := scope.Lookup(name.Name)
+		if obj == nil || obj.Type() == types.Typ[types.Invalid] {
+			return []string{}, fmt.Errorf("can't infer type of %s", name.Name)
+		}
+		res = append(res, types.TypeString(obj.Type(), qualifier))
+	}
+	return res, nil
+}
+
+// kernelDecls возвращает исходники всех общих объявлений ядра
+func (b *Block) kernelDecls() []string {
+	srcs := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, kind := range []map[string]string{b.types.types, b.types.consts, b.types.generics} {
+		for _, name := range sortedKeys(kind) {
+			if !seen[kind[name]] {
+				seen[kind[name]] = true
+				srcs = append(srcs, kind[name])
+			}
+		}
+	}
+	for _, tp := range sortedKeys(b.types.methods) {
+		for _, method := range sortedKeys(b.types.methods[tp]) {
+			srcs = append(srcs, b.types.methods[tp][method])
+		}
+	}
+	return srcs
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (b *Block) multiValueTypes(expr ast.Expr) ([]string, error) {
+	switch e := expr.(type) {
+	case *ast.CallExpr:
+		return b.callResultTypes(e)
+	case *ast.TypeAssertExpr:
+		return []string{types.ExprString(e.Type), "bool"}, nil
+	case *ast.IndexExpr:
+		tp, err := b.exprType(e)
+		if err != nil {
+			return []string{}, err
+		}
+		return []string{tp, "bool"}, nil
+	case *ast.UnaryExpr:
+		if e.Op == token.ARROW {
+			tp, err := b.exprType(e)
+			if err != nil {
+				return []string{}, err
+			}
+			return []string{tp, "bool"}, nil
+		}
+	case *ast.ParenExpr:
+		return b.multiValueTypes(e.X)
+	}
+	return []string{}, fmt.Errorf("can't infer types of %s", types.ExprString(expr))
+}
+
+func (b *Block) exprType(expr ast.Expr) (string, error) {
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		return basicLitTypes[e.Kind], nil
+	case *ast.Ident:
+		return b.identType(e.Name)
+	case *ast.CompositeLit:
+		if e.Type != nil {
+			return types.ExprString(e.Type), nil
+		}
+	case *ast.FuncLit:
+		return types.ExprString(e.Type), nil
+	case *ast.ParenExpr:
+		return b.exprType(e.X)
+	case *ast.TypeAssertExpr:
+		if e.Type != nil {
+			return types.ExprString(e.Type), nil
+		}
+	case *ast.UnaryExpr:
+		switch e.Op {
+		case token.AND:
+			tp, err := b.exprType(e.X)
+			if err != nil {
+				return "", err
+			}
+			return "*" + tp, nil
+		case token.NOT:
+			return "bool", nil
+		case token.ARROW:
+			tp, err := b.exprType(e.X)
+			if err != nil {
+				return "", err
+			}
+			if ch, ok := parseTypeExpr(tp).(*ast.ChanType); ok {
+				return types.ExprString(ch.Value), nil
+			}
+		default:
+			return b.exprType(e.X)
+		}
+	case *ast.StarExpr:
+		tp, err := b.exprType(e.X)
+		if err != nil {
+			return "", err
+		}
+		if strings.HasPrefix(tp, "*") {
+			return tp[1:], nil
+		}
+	case *ast.BinaryExpr:
+		switch e.Op {
+		case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ, token.LAND, token.LOR:
+			return "bool", nil
+		case token.SHL, token.SHR:
+			return b.exprType(e.X)
+		default:
+			// у нетипизированной константы слева тип берётся из правого операнда
+			if _, ok := e.X.(*ast.BasicLit); ok {
+				if tp, err := b.exprType(e.Y); err == nil {
+					return tp, nil
+				}
+			}
+			return b.exprType(e.X)
+		}
+	case *ast.IndexExpr:
+		tp, err := b.exprType(e.X)
+		if err != nil {
+			return "", err
+		}
+		if tp == "string" {
+			return "byte", nil
+		}
+		switch t := parseTypeExpr(tp).(type) {
+		case *ast.ArrayType:
+			return types.ExprString(t.Elt), nil
+		case *ast.MapType:
+			return types.ExprString(t.Value), nil
+		}
+	case *ast.SliceExpr:
+		return b.exprType(e.X)
+	case *ast.SelectorExpr:
+		if pkg, ok := e.X.(*ast.Ident); ok && b.isPackage(pkg.Name) {
+			return b.packageMemberType(pkg.Name, e.Sel.Name)
+		}
+	case *ast.CallExpr:
+		res, err := b.callResultTypes(e)
+		if err != nil {
+			return "", err
+		}
+		if len(res) == 1 {
+			return res[0], nil
+		}
+	}
+	return "", fmt.Errorf("can't infer type of %s", types.ExprString(expr))
+}
+
+func (b *Block) identType(name string) (string, error) {
+	switch name {
+	case "true", "false":
+		return "bool", nil
+	case "iota":
+		return "int", nil
+	}
+	if tp, ok := b.types.vars[name]; ok {
+		return tp, nil
+	}
+	if sig, ok := b.types.funcs[name]; ok {
+		return sig, nil
+	}
+	if _, ok := b.types.consts[name]; ok {
+		return b.constType(name)
+	}
+	return "", fmt.Errorf("can't infer type of %s", name)
+}
+
+// constType находит тип константы с учётом неявного повторения выражений в группе
+func (b *Block) constType(name string) (string, error) {
+	// const A = B; const B = A не должно уводить в бесконечную рекурсию
+	if b.resolving[name] {
+		return "", fmt.Errorf("can't infer type of %s", name)
+	}
+	if b.resolving == nil {
+		b.resolving = make(map[string]bool)
+	}
+	b.resolving[name] = true
+	defer delete(b.resolving, name)
+
+	file, err := parser.ParseFile(token.NewFileSet(), "", filePrefix+b.types.consts[name], parser.SkipObjectResolution)
+	if err != nil {
+		return "", err
+	}
+	for _, decl := range file.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok {
+			continue
+		}
+		var (
+			lastType   ast.Expr
+			lastValues []ast.Expr
+		)
+		for _, spec := range gd.Specs {
+			vs := spec.(*ast.ValueSpec)
+			if vs.Type != nil || len(vs.Values) != 0 {
+				lastType = vs.Type
+				lastValues = vs.Values
+			}
+			for idx, ident := range vs.Names {
+				if ident.Name != name {
+					continue
+				}
+				if lastType != nil {
+					return types.ExprString(lastType), nil
+				}
+				if idx < len(lastValues) {
+					return b.exprType(lastValues[idx])
+				}
+			}
+		}
+	}
+	return "", fmt.Errorf("can't infer type of %s", name)
+}
+
+func (b *Block) callResultTypes(call *ast.CallExpr) ([]string, error) {
+	switch fun := call.Fun.(type) {
+	case *ast.Ident:
+		if sig, ok := b.types.funcs[fun.Name]; ok {
+			return funcResultTypes(sig)
+		}
+		if _, ok := knownTypes[fun.Name]; ok {
+			return []string{fun.Name}, nil
+		}
+		if _, ok := b.types.types[fun.Name]; ok {
+			return []string{fun.Name}, nil
+		}
+		return b.builtinResultTypes(fun.Name, call.Args)
+	case *ast.SelectorExpr:
+		if pkg, ok := fun.X.(*ast.Ident); ok && b.isPackage(pkg.Name) {
+			return b.packageCallTypes(pkg.Name, fun.Sel.Name, call.Args)
+		}
+	case *ast.FuncLit:
+		return fieldTypes(fun.Type.Results), nil
+	case *ast.ParenExpr, *ast.ArrayType, *ast.MapType, *ast.ChanType, *ast.FuncType, *ast.StarExpr:
+		// преобразование типа
+		return []string{types.ExprString(unparen(fun))}, nil
+	}
+	return []string{}, fmt.Errorf("can't resolve func result type for %s", types.ExprString(call.Fun))
+}
+
+func (b *Block) builtinResultTypes(name string, args []ast.Expr) ([]string, error) {
+	switch name {
+	case "len", "cap", "copy":
+		return []string{"int"}, nil
+	case "make":
+		if len(args) > 0 {
+			return []string{types.ExprString(args[0])}, nil
+		}
+	case "new":
+		if len(args) > 0 {
+			return []string{"*" + types.ExprString(args[0])}, nil
+		}
+	case "append", "min", "max":
+		if len(args) > 0 {
+			tp, err := b.exprType(args[0])
+			if err != nil {
+				return []string{}, err
+			}
+			return []string{tp}, nil
+		}
+	}
+	return []string{}, fmt.Errorf("can't resolve func result type for %s", name)
+}
+
+// isPackage сообщает, обращается ли имя к пакету, а не к переменной ядра
+func (b *Block) isPackage(name string) bool {
+	if _, ok := b.types.vars[name]; ok {
+		return false
+	}
+	_, ok := importPathOf(name)
+	return ok
+}
+
+func (b *Block) packageCallTypes(pkgName string, name string, args []ast.Expr) ([]string, error) {
+	obj, err := packageMember(pkgName, name)
+	if err != nil {
+		return []string{}, err
+	}
+
+	switch o := obj.(type) {
+	case *types.TypeName:
+		return []string{typeString(o.Type())}, nil
+	case *types.Func:
+		sig := o.Type().(*types.Signature)
+		res := sig.Results()
+		if res.Len() == 0 {
+			return []string{}, fmt.Errorf("function %s has no results", name)
+		}
+
+		out := make([]string, 0, res.Len())
+		for i := range res.Len() {
+			t := res.At(i).Type()
+			if hasTypeParam(t) {
+				// slices.Clone и подобные возвращают тип первого аргумента
+				if sig.Params().Len() > 0 && types.Identical(t, sig.Params().At(0).Type()) && len(args) > 0 {
+					tp, err := b.exprType(args[0])
+					if err != nil {
+						return []string{}, err
+					}
+					out = append(out, tp)
+					continue
+				}
+				return []string{}, fmt.Errorf("can't resolve func result type for %s.%s", pkgName, name)
+			}
+			out = append(out, typeString(t))
+		}
+		return out, nil
+	}
+	return []string{}, fmt.Errorf("%s.%s is not a function", pkgName, name)
+}
+
+func (b *Block) packageMemberType(pkgName string, name string) (string, error) {
+	obj, err := packageMember(pkgName, name)
+	if err != nil {
+		return "", err
+	}
+
+	switch o := obj.(type) {
+	case *types.Var, *types.Const:
+		return typeString(types.Default(o.Type())), nil
+	case *types.Func:
+		if o.Type().(*types.Signature).TypeParams().Len() == 0 {
+			return typeString(o.Type()), nil
+		}
+	}
+	return "", fmt.Errorf("can't infer type of %s.%s", pkgName, name)
+}
+
+func funcResultTypes(sig string) ([]string, error) {
+	expr, err := parser.ParseExpr(sig)
+	if err != nil {
+		return []string{}, err
+	}
+	ft, ok := expr.(*ast.FuncType)
+	if !ok {
+		return []string{}, fmt.Errorf("not a function signature: %s", sig)
+	}
+	res := fieldTypes(ft.Results)
+	if len(res) == 0 {
+		return []string{}, fmt.Errorf("function %s has no results", sig)
+	}
+	return res, nil
+}
+
+func parseTypeExpr(tp string) ast.Expr {
+	expr, err := parser.ParseExpr(tp)
+	if err != nil {
+		return nil
+	}
+	return expr
+}
+
+func unparen(expr ast.Expr) ast.Expr {
+	if p, ok := expr.(*ast.ParenExpr); ok {
+		return unparen(p.X)
+	}
+	return expr
+}
+
+func typeString(t types.Type) string {
+	return types.TypeString(t, func(p *types.Package) string { return p.Name() })
+}
+
+func hasTypeParam(t types.Type) bool {
+	switch tt := t.(type) {
+	case *types.TypeParam:
+		return true
+	case *types.Pointer:
+		return hasTypeParam(tt.Elem())
+	case *types.Slice:
+		return hasTypeParam(tt.Elem())
+	case *types.Array:
+		return hasTypeParam(tt.Elem())
+	case *types.Chan:
+		return hasTypeParam(tt.Elem())
+	case *types.Map:
+		return hasTypeParam(tt.Key()) || hasTypeParam(tt.Elem())
+	default:
+		return false
+	}
+}
+
+var (
+	importPathsOnce sync.Once
+	importPaths     map[string]string
+
+	pkgCacheMu sync.Mutex
+	pkgCache   = make(map[string]*types.Package)
+)
+
+// importPathOf сопоставляет имя пакета пути импорта из baseCopypaste
+func importPathOf(name string) (string, bool) {
+	importPathsOnce.Do(func() {
+		importPaths = make(map[string]string)
+		file, err := parser.ParseFile(token.NewFileSet(), "", baseCopypaste, parser.ImportsOnly)
+		if err != nil {
+			return
+		}
+		for _, imp := range file.Imports {
+			importPath := strings.Trim(imp.Path.Value, `"`)
+			if _, ok := importPaths[path.Base(importPath)]; !ok {
+				importPaths[path.Base(importPath)] = importPath
+			}
+		}
+	})
+	importPath, ok := importPaths[name]
+	return importPath, ok
+}
+
+// Importable сообщает, получит ли блок пакет importPath под его обычным именем: блоки не импортируют
+// пакеты сами, а имя вроде rand ведёт к одному пути из baseCopypaste
+func Importable(importPath string) bool {
+	name := path.Base(importPath)
+	if len(name) > 1 && name[0] == 'v' && strings.Trim(name[1:], "0123456789") == "" {
+		name = path.Base(path.Dir(importPath))
+	}
+	resolved, ok := importPathOf(name)
+	return ok && resolved == importPath
+}
+
+type pkgImporter struct{}
+
+func (pkgImporter) Import(importPath string) (*types.Package, error) {
+	return loadPackage(importPath)
+}
+
+func (pkgImporter) ImportFrom(importPath string, _ string, _ types.ImportMode) (*types.Package, error) {
+	return loadPackage(importPath)
+}
+
+func packageMember(pkgName string, name string) (types.Object, error) {
+	importPath, ok := importPathOf(pkgName)
+	if !ok {
+		return nil, fmt.Errorf("package %s not found", pkgName)
+	}
+	pkg, err := loadPackage(importPath)
+	if err != nil {
+		return nil, err
+	}
+	obj := pkg.Scope().Lookup(name)
+	if obj == nil || !obj.Exported() {
+		return nil, fmt.Errorf("%s not found in %s", name, importPath)
+	}
+	return obj, nil
+}
+
+func loadPackage(importPath string) (*types.Package, error) {
+	pkgCacheMu.Lock()
+	defer pkgCacheMu.Unlock()
+
+	if pkg, ok := pkgCache[importPath]; ok {
+		return pkg, nil
+	}
+
+	cfg := &packages.Config{
+		Mode: packages.NeedTypes | packages.NeedImports | packages.NeedDeps,
+	}
+
+	pkgs, err := packages.Load(cfg, importPath)
+	if err != nil {
+		return nil, err
+	}
+	if packages.PrintErrors(pkgs) > 0 {
+		return nil, fmt.Errorf("packages contain errors")
+	}
+	if len(pkgs) == 0 || pkgs[0].Types == nil {
+		return nil, fmt.Errorf("package not found")
+	}
+
+	pkgCache[importPath] = pkgs[0].Types
+	return pkgs[0].Types, nil
+}
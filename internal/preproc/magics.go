@@ -0,0 +1,443 @@
+package preproc
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dnonakolesax/noted-runner/internal/configs"
+)
+
+// Магические команды в стиле IPython. Строка блока, которая начинается с %, - команда для строки,
+// %% в первой строке блока - команда для всего блока. Parse заменяет каждую команду одной строкой Go,
+// поэтому номера строк блока не меняются
+const (
+	MagicTime   = "time"
+	MagicTimeit = "timeit"
+	MagicEnv    = "env"
+	MagicReset  = "reset"
+	MagicWho    = "who"
+)
+
+// magicNames - все команды, которые умеет раннер; cellMagics - те, что работают и для всего блока
+var (
+	magicNames = []string{MagicEnv, MagicReset, MagicTime, MagicTimeit, MagicWho}
+	cellMagics = []string{MagicTime}
+)
+
+// reservedPrefix - префикс имён сгенерированного кода: блоку они недоступны, иначе он добрался бы
+// до пакетов, которые импортируются под этими именами
+const reservedPrefix = "_noted"
+
+var (
+	ErrUnknownMagic = errors.New("unknown magic")
+	ErrBadMagic     = errors.New("bad magic")
+	ErrReservedName = errors.New("reserved name")
+)
+
+// Magics - какие магические команды доступны блокам и с какими ограничениями
+type Magics struct {
+	enabled   map[string]bool
+	env       []string
+	timeitRun time.Duration
+}
+
+func NewMagics(cfg *configs.MagicsConfig) (*Magics, error) {
+	m := &Magics{enabled: make(map[string]bool), env: slices.Sorted(slices.Values(cfg.Env)),
+		timeitRun: cfg.TimeitRun}
+	for _, name := range cfg.Enabled {
+		name = strings.TrimLeft(name, "%")
+		if !slices.Contains(magicNames, name) {
+			return nil, fmt.Errorf("%w %%%s in config, known: %s", ErrUnknownMagic, name, listMagics(magicNames))
+		}
+		m.enabled[name] = true
+	}
+	if m.timeitRun <= 0 {
+		m.timeitRun = 200 * time.Millisecond
+	}
+	return m, nil
+}
+
+// DefaultMagics включает все команды, но не разрешает менять окружение ядра
+func DefaultMagics() *Magics {
+	m, _ := NewMagics(&configs.MagicsConfig{Enabled: magicNames})
+	return m
+}
+
+// IsMagic сообщает, знает ли раннер команду из строки вида "%time x := f()" или "%%time"
+func IsMagic(line string) bool {
+	name, cell, _ := splitMagic(line)
+	if cell {
+		return slices.Contains(cellMagics, name)
+	}
+	return slices.Contains(magicNames, name)
+}
+
+func (m *Magics) available() []string {
+	var names []string
+	for _, name := range magicNames {
+		if m.enabled[name] {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func listMagics(names []string) string {
+	if len(names) == 0 {
+		return "none"
+	}
+	return "%" + strings.Join(names, ", %")
+}
+
+// SetMagics задаёт доступные блоку команды; по умолчанию действуют DefaultMagics
+func (b *Block) SetMagics(m *Magics) {
+	b.magics = m
+}
+
+// splitMagic делит строку команды на имя и аргументы
+func splitMagic(line string) (string, bool, string) {
+	line = strings.TrimSpace(line)
+	rest, cell := strings.CutPrefix(line, "%%")
+	if !cell {
+		rest = strings.TrimPrefix(line, "%")
+	}
+	name, args, _ := strings.Cut(rest, " ")
+	return name, cell, strings.TrimSpace(args)
+}
+
+// magicLines находит строки-команды: те, что начинаются с % вне скобок, строк и комментариев.
+// Заодно проверяет, что блок не использует зарезервированные имена
+func magicLines(content string) (map[int]bool, int, error) {
+	fset := token.NewFileSet()
+	file := fset.AddFile(BlockFile, -1, len(content))
+	var s scanner.Scanner
+	s.Init(file, []byte(content), nil, scanner.ScanComments)
+
+	magics := make(map[int]bool)
+	firstCode := 0 // первая строка кода, не считая команд и комментариев
+	depth, lastLine := 0, 0
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok == token.SEMICOLON && lit == "\n" {
+			continue
+		}
+		line := file.Line(pos)
+		if line != lastLine && depth == 0 && !magics[line] {
+			switch {
+			case tok == token.REM:
+				magics[line] = true
+			case tok != token.COMMENT && firstCode == 0:
+				firstCode = line
+			}
+		}
+		lastLine = line
+		switch {
+		case magics[line]:
+			// скобки в аргументах команды не продолжают её на следующие строки
+		case tok == token.LPAREN || tok == token.LBRACK || tok == token.LBRACE:
+			depth++
+		case tok == token.RPAREN || tok == token.RBRACK || tok == token.RBRACE:
+			depth = max(depth-1, 0)
+		}
+		if tok == token.IDENT {
+			if strings.HasPrefix(lit, reservedPrefix) {
+				return nil, 0, fmt.Errorf("%w %s on line %d: names starting with %s are used by the runner",
+					ErrReservedName, lit, line, reservedPrefix)
+			}
+		}
+	}
+	return magics, firstCode, nil
+}
+
+// expandMagics заменяет команды блока кодом Go. После %reset остальной код блока разбирается
+// в пустых типах, а типы ядра заменяет только Commit
+func (b *Block) expandMagics() error {
+	magics, firstCode, err := magicLines(b.content)
+	if err != nil {
+		return err
+	}
+	if len(magics) == 0 {
+		return nil
+	}
+
+	lines := strings.Split(b.content, "\n")
+	firstMagic := slices.Min(slices.Collect(maps.Keys(magics)))
+	for i, text := range lines {
+		lineNum := i + 1
+		if !magics[lineNum] {
+			continue
+		}
+		name, cell, args := splitMagic(text)
+		if !b.magics.enabled[name] {
+			return fmt.Errorf("%w %s on line %d, available: %s", ErrUnknownMagic, strings.Fields(text)[0], lineNum,
+				listMagics(b.magics.available()))
+		}
+		beforeCode := firstCode == 0 || lineNum < firstCode
+		if cell {
+			lines[i], err = b.cellMagic(name, args, beforeCode && lineNum == firstMagic)
+		} else {
+			lines[i], err = b.lineMagic(name, args, beforeCode)
+		}
+		if err != nil {
+			return fmt.Errorf("%w on line %d: %s", ErrBadMagic, lineNum, err)
+		}
+	}
+	b.content = strings.Join(lines, "\n")
+	if b.timed {
+		b.content += "\n_notedToc()"
+	}
+	b.magicCode = true
+	return nil
+}
+
+// cellMagic - команда для всего блока, она должна стоять первой
+func (b *Block) cellMagic(name string, args string, first bool) (string, error) {
+	if !slices.Contains(cellMagics, name) {
+		return "", fmt.Errorf("%%%%%s works only as a line magic, use %%%s", name, name)
+	}
+	if !first {
+		return "", fmt.Errorf("%%%%%s must be the first line of the block", name)
+	}
+	if args != "" {
+		return "", fmt.Errorf("%%%%%s takes no arguments, the block itself is timed", name)
+	}
+	b.timed = true
+	return "_notedTic()", nil
+}
+
+// lineMagic возвращает строку Go, которой заменяется команда; first - что до неё в блоке нет кода
+func (b *Block) lineMagic(name string, args string, first bool) (string, error) {
+	switch name {
+	case MagicTime:
+		stmt := stripComment(args)
+		if stmt == "" {
+			return "", errors.New("%time needs a statement to time")
+		}
+		return "_notedTic(); " + stmt + "; _notedToc()", nil
+	case MagicTimeit:
+		stmt, err := timeitStatement(stripComment(args))
+		if err != nil {
+			return "", err
+		}
+		return "_notedTimeit(func() { " + stmt + " })", nil
+	case MagicEnv:
+		return b.envMagic(args)
+	case MagicReset:
+		if args != "" && args != "-f" {
+			return "", fmt.Errorf("%%reset takes no arguments except -f, got %s", args)
+		}
+		if !first {
+			return "", errors.New("%reset must come before the block code")
+		}
+		b.kernel = b.types
+		b.types = NewKernelTypes()
+		b.reset = true
+		return "", nil
+	case MagicWho:
+		return "fmt.Print(" + strconv.Quote(b.who(args)) + ")", nil
+	}
+	return "", fmt.Errorf("%%%s is not implemented", name)
+}
+
+// Commit применяет к ядру %reset. Ядро очищается, только когда блок выполнен, поэтому вызывать
+// Commit нужно после выполнения: если блок не собрался, типы ядра остаются прежними
+func (b *Block) Commit() {
+	if b.kernel == nil {
+		return
+	}
+	*b.kernel = *b.types
+	b.types = b.kernel
+	b.kernel = nil
+}
+
+// envMagic: %env печатает разрешённые переменные, %env NAME - одну переменную,
+// %env NAME=value или %env NAME value меняет её
+func (b *Block) envMagic(args string) (string, error) {
+	if args == "" {
+		quoted := make([]string, 0, len(b.magics.env))
+		for _, name := range b.magics.env {
+			quoted = append(quoted, strconv.Quote(name))
+		}
+		return "_notedEnv(" + strings.Join(quoted, ", ") + ")", nil
+	}
+
+	name, value, set := strings.Cut(args, "=")
+	if !set {
+		name, value, set = strings.Cut(args, " ")
+	}
+	name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+	if !slices.Contains(b.magics.env, name) {
+		if len(b.magics.env) == 0 {
+			return "", fmt.Errorf("environment variable %s is not allowed, the runner allows none", name)
+		}
+		return "", fmt.Errorf("environment variable %s is not allowed, allowed: %s", name,
+			strings.Join(b.magics.env, ", "))
+	}
+	if !set {
+		return "_notedEnv(" + strconv.Quote(name) + ")", nil
+	}
+	return "_notedSetenv(" + strconv.Quote(name) + ", " + strconv.Quote(value) + ")", nil
+}
+
+// who перечисляет переменные ядра с типами на момент запуска блока; filter оставляет переменные одного типа
+func (b *Block) who(filter string) string {
+	var sb strings.Builder
+	for _, name := range slices.Sorted(maps.Keys(b.types.vars)) {
+		if tp := b.types.vars[name]; filter == "" || tp == filter {
+			fmt.Fprintf(&sb, "%s\t%s\n", name, tp)
+		}
+	}
+	if sb.Len() == 0 {
+		return "Interactive namespace is empty.\n"
+	}
+	return sb.String()
+}
+
+// stripComment убирает комментарий в конце аргументов команды: после них дописывается код
+func stripComment(src string) string {
+	fset := token.NewFileSet()
+	file := fset.AddFile("", -1, len(src))
+	var s scanner.Scanner
+	s.Init(file, []byte(src), nil, scanner.ScanComments)
+	end := 0
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok == token.COMMENT || (tok == token.SEMICOLON && lit == "\n") {
+			continue
+		}
+		if lit == "" {
+			lit = tok.String()
+		}
+		end = file.Offset(pos) + len(lit)
+	}
+	return strings.TrimSpace(src[:end])
+}
+
+// timeitStatement готовит инструкцию к повторам в замыкании: x := f() превращается в _ = f(),
+// как и в IPython, %timeit не оставляет переменных
+func timeitStatement(stmt string) (string, error) {
+	if stmt == "" {
+		return "", errors.New("%timeit needs a statement to time")
+	}
+	src := funcPrefix + stmt + "\n}"
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.SkipObjectResolution)
+	if err != nil {
+		return "", fmt.Errorf("%%timeit: %s", err)
+	}
+	body := file.Decls[0].(*ast.FuncDecl).Body.List
+	if len(body) != 1 {
+		return stmt, nil
+	}
+	assign, ok := body[0].(*ast.AssignStmt)
+	if !ok || assign.Tok != token.DEFINE {
+		return stmt, nil
+	}
+	rhs := fset.Position(assign.Rhs[0].Pos()).Offset - len(funcPrefix)
+	return strings.Repeat("_, ", len(assign.Lhs)-1) + "_ = " + stmt[rhs:], nil
+}
+
+// PlainMagics убирает команды из блока для программы без раннера: инструкция %time остаётся
+// как есть, остальные команды превращаются в комментарии
+func PlainMagics(content string) string {
+	magics, _, err := magicLines(content)
+	if err != nil || len(magics) == 0 {
+		return content
+	}
+	lines := strings.Split(content, "\n")
+	for i, text := range lines {
+		if !magics[i+1] {
+			continue
+		}
+		name, cell, args := splitMagic(text)
+		if name == MagicTime && !cell && args != "" {
+			lines[i] = args
+			continue
+		}
+		lines[i] = "// " + strings.TrimSpace(text)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// magicImports - пакеты, которые нужны только magicRunner
+const magicImports = `
+import (
+	_notedos "os"
+)
+`
+
+// magicRunner - функции, которые вызывает код команд. Длительность замера %timeit дописывает runner()
+const magicRunner = `
+//line noted.go:1
+var _notedStarts []time.Time
+
+func _notedTic() {
+	_notedStarts = append(_notedStarts, time.Now())
+}
+
+func _notedToc() {
+	last := len(_notedStarts) - 1
+	fmt.Printf("Wall time: %s\n", time.Since(_notedStarts[last]))
+	_notedStarts = _notedStarts[:last]
+}
+
+// _notedTimeit подбирает число повторов так, чтобы замер длился не меньше _notedTimeitRun,
+// и печатает лучшее из трёх замеров время одного повтора
+func _notedTimeit(f func()) {
+	n := 1
+	for {
+		start := time.Now()
+		for range n {
+			f()
+		}
+		if time.Since(start) >= _notedTimeitRun || n >= 1e9 {
+			break
+		}
+		n *= 10
+	}
+	best := time.Duration(-1)
+	for range 3 {
+		start := time.Now()
+		for range n {
+			f()
+		}
+		if elapsed := time.Since(start); best < 0 || elapsed < best {
+			best = elapsed
+		}
+	}
+	fmt.Printf("%d loops, best of 3: %s per loop\n", n, best/time.Duration(n))
+}
+
+func _notedEnv(names ...string) {
+	for _, name := range names {
+		fmt.Printf("%s=%s\n", name, _notedos.Getenv(name))
+	}
+}
+
+func _notedSetenv(name string, value string) {
+	if err := _notedos.Setenv(name, value); err != nil {
+		fmt.Printf("env: %s\n", err)
+		return
+	}
+	fmt.Printf("env: %s=%s\n", name, value)
+}
+`
+
+func (m *Magics) runner() string {
+	return magicRunner + fmt.Sprintf("\nconst _notedTimeitRun = %d\n", m.timeitRun)
+}
@@ -0,0 +1,183 @@
+package preproc
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// define записывает определение имени, убирая его из остальных видов: имя в ядре одно,
+// и если a была переменной, а стала функцией, переменной a больше нет
+func (b *Block) define(kind map[string]string, name string, value string) {
+	old := b.types.describe(name)
+	b.types.drop(name)
+	kind[name] = value
+	b.noteChange(name, old, b.types.describe(name))
+}
+
+func (b *Block) defineMethod(tp string, name string, src string) {
+	if b.types.methods[tp] == nil {
+		b.types.methods[tp] = make(map[string]string)
+	}
+	old := b.types.methods[tp][name]
+	b.types.methods[tp][name] = src
+	b.noteChange(tp+"."+name, old, src)
+}
+
+func (b *Block) noteChange(name string, old string, updated string) {
+	if old == "" || old == updated {
+		return
+	}
+	if _, ok := b.changed[name]; !ok {
+		b.changed[name] = old
+	}
+}
+
+func (b *Block) defined() []string {
+	return slices.Concat(b.vnames, b.fnames, b.snames, b.cnames, b.gnames, b.mnames)
+}
+
+// releaseStale удаляет из ядра имена, которые блок определял при прошлом запуске, а теперь нет.
+// Вызывается до collectReused, чтобы блок не подставил сам себе своё старое определение
+func (b *Block) releaseStale() {
+	kt := b.types
+	defined := b.defined()
+	for _, name := range sortedKeys(kt.owners) {
+		if kt.owners[name] != b.id || slices.Contains(defined, name) {
+			continue
+		}
+		dependants := kt.release(name, b.id)
+		if len(dependants) == 0 {
+			continue
+		}
+		b.invalidated = append(b.invalidated, dependants...)
+		b.warnings = append(b.warnings, fmt.Sprintf("%s is no longer defined by block %s, re-run dependent blocks: %s",
+			name, b.id, strings.Join(dependants, ", ")))
+	}
+}
+
+// track переносит в ядро, какой блок чем владеет и что использует, и собирает предупреждения
+// о конфликтах имён и об изменениях, после которых зависимые блоки нужно перезапустить
+func (b *Block) track() {
+	kt := b.types
+	defined := b.defined()
+
+	seen := make(map[string]bool)
+	for _, name := range defined {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		if owner, ok := kt.owners[name]; ok && owner != b.id {
+			b.warnings = append(b.warnings, fmt.Sprintf("%s is already defined in block %s, redefined by block %s",
+				name, owner, b.id))
+		}
+		kt.owners[name] = b.id
+	}
+
+	for _, users := range kt.users {
+		delete(users, b.id)
+	}
+	for _, name := range slices.Concat(b.reusedVars, b.reusedFuncs, b.reusedDecls) {
+		if kt.users[name] == nil {
+			kt.users[name] = make(map[string]bool)
+		}
+		kt.users[name][b.id] = true
+	}
+
+	invalidated := make(map[string]bool)
+	for _, dep := range b.invalidated {
+		invalidated[dep] = true
+	}
+	for _, name := range sortedKeys(b.changed) {
+		dependants := kt.dependants(name, b.id)
+		if len(dependants) == 0 {
+			continue
+		}
+		for _, dep := range dependants {
+			invalidated[dep] = true
+		}
+		b.warnings = append(b.warnings, fmt.Sprintf("definition of %s changed, re-run dependent blocks: %s",
+			name, strings.Join(dependants, ", ")))
+	}
+	b.invalidated = sortedKeys(invalidated)
+}
+
+// Warnings возвращает предупреждения, собранные при разборе блока
+func (b *Block) Warnings() []string {
+	return b.warnings
+}
+
+// Invalidated возвращает блоки, собранные под прежние определения имён, которые изменил этот блок
+func (b *Block) Invalidated() []string {
+	return b.invalidated
+}
+
+// Forget удаляет из ядра всё, что определил блок, и возвращает блоки, которые этим пользовались
+func (kt *KernelTypes) Forget(blockID string) []string {
+	dependants := make(map[string]bool)
+	for name, owner := range kt.owners {
+		if owner != blockID {
+			continue
+		}
+		for _, dep := range kt.release(name, blockID) {
+			dependants[dep] = true
+		}
+	}
+	for _, users := range kt.users {
+		delete(users, blockID)
+	}
+	return sortedKeys(dependants)
+}
+
+// release удаляет из ядра имя, которым владел блок, и возвращает другие блоки, которые им пользовались
+func (kt *KernelTypes) release(name string, blockID string) []string {
+	dependants := kt.dependants(name, blockID)
+	if tp, method, ok := strings.Cut(name, "."); ok {
+		delete(kt.methods[tp], method)
+	} else {
+		kt.drop(name)
+	}
+	delete(kt.owners, name)
+	delete(kt.users, name)
+	return dependants
+}
+
+// dependants возвращает блоки, кроме except, использующие имя; методы используются вместе со своим типом
+func (kt *KernelTypes) dependants(name string, except string) []string {
+	if tp, _, ok := strings.Cut(name, "."); ok {
+		name = tp
+	}
+	res := make([]string, 0, len(kt.users[name]))
+	for user := range kt.users[name] {
+		if user != except {
+			res = append(res, user)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (kt *KernelTypes) describe(name string) string {
+	if tp, ok := kt.vars[name]; ok {
+		return "var " + tp
+	}
+	if sig, ok := kt.funcs[name]; ok {
+		return "func " + sig
+	}
+	for _, kind := range []map[string]string{kt.types, kt.consts, kt.generics} {
+		if src, ok := kind[name]; ok {
+			return src
+		}
+	}
+	return ""
+}
+
+func (kt *KernelTypes) drop(name string) {
+	delete(kt.vars, name)
+	delete(kt.funcs, name)
+	delete(kt.types, name)
+	delete(kt.consts, name)
+	delete(kt.generics, name)
+}
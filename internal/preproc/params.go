@@ -0,0 +1,132 @@
+package preproc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"maps"
+	"strconv"
+	"strings"
+)
+
+// ParametersTag в первой строке блока делает его блоком параметров: переменные, объявленные в нём,
+// можно переопределить при запуске, как параметры в papermill
+const ParametersTag = "//noted:parameters"
+
+var (
+	ErrNotParameters    = errors.New("block is not a parameters block")
+	ErrUnknownParameter = errors.New("unknown parameter")
+	ErrInvalidParameter = errors.New("invalid parameter value")
+)
+
+func IsParameters(content string) bool {
+	for line := range strings.SplitSeq(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			return line == ParametersTag
+		}
+	}
+	return false
+}
+
+// Parameters возвращает типы переменных, объявленных в блоке; вызывается после Parse
+func (b *Block) Parameters() map[string]string {
+	params := make(map[string]string, len(b.vnames))
+	for _, name := range b.vnames {
+		params[name] = b.types.vars[name]
+	}
+	return params
+}
+
+// Override подставляет значения параметров после их объявлений и возвращает действующие параметры:
+// переопределённые значения и исходные выражения остальных. Значения - JSON-строки, числа и булевы,
+// каждое проверяется на совместимость с типом, который вывел Parse
+func (b *Block) Override(values map[string]json.RawMessage) (map[string]string, error) {
+	if !IsParameters(b.content) {
+		if len(values) == 0 {
+			return nil, nil
+		}
+		return nil, ErrNotParameters
+	}
+
+	params := b.Parameters()
+	effective := maps.Clone(b.defaults)
+	for _, name := range sortedKeys(values) {
+		tp, ok := params[name]
+		if !ok {
+			return nil, fmt.Errorf("%w %s", ErrUnknownParameter, name)
+		}
+		lit, err := paramLiteral(values[name])
+		if err == nil {
+			err = b.checkParam(tp, lit)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w %s of type %s: %s", ErrInvalidParameter, name, tp, err)
+		}
+		b.overrides = append(b.overrides, name+" = "+lit)
+		effective[name] = lit
+	}
+	return effective, nil
+}
+
+func paramLiteral(raw json.RawMessage) (string, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var value any
+	err := dec.Decode(&value)
+	if err != nil {
+		return "", err
+	}
+	switch v := value.(type) {
+	case string:
+		return strconv.Quote(v), nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	default:
+		return "", errors.New("only strings, numbers and booleans are supported")
+	}
+}
+
+// checkParam проверяет присваивание значения параметру через go/types на фоне объявлений ядра,
+// так что подходят и именованные типы вроде time.Duration
+func (b *Block) checkParam(tp string, lit string) error {
+	var sb strings.Builder
+	sb.WriteString(baseCopypaste)
+	for _, src := range b.kernelDecls() {
+		sb.WriteString(src + "\n")
+	}
+	fmt.Fprintf(&sb, "var _ %s = %s\n", tp, lit)
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", sb.String(), parser.SkipObjectResolution)
+	if err != nil {
+		return err
+	}
+	filterImports(file, collectUsedNames(file))
+
+	var checkErr error
+	conf := types.Config{
+		Importer: pkgImporter{},
+		Error: func(err error) {
+			if checkErr == nil {
+				checkErr = err
+			}
+		},
+	}
+	_, _ = conf.Check("main", fset, []*ast.File{file}, nil)
+	if checkErr != nil {
+		// позиция указывает в служебный исходник, пользователю она ничего не скажет
+		var typeErr types.Error
+		if errors.As(checkErr, &typeErr) {
+			return errors.New(typeErr.Msg)
+		}
+	}
+	return checkErr
+}
@@ -11,413 +11,583 @@ import (
 	"go/types"
 	"log/slog"
 	"slices"
+	"sort"
 	"strings"
-
-	"golang.org/x/tools/go/packages"
 )
 
 type KernelTypes struct {
-	vars  map[string]string
-	funcs map[string]string
-	types map[string]string
+	vars     map[string]string            // переменная -> тип
+	funcs    map[string]string            // функция -> сигнатура
+	types    map[string]string            // тип -> исходник объявления
+	consts   map[string]string            // константа -> исходник объявления (вместе с группой)
+	generics map[string]string            // обобщённая функция -> исходник
+	methods  map[string]map[string]string // тип -> метод -> исходник
+	owners   map[string]string            // имя (или "Тип.Метод") -> блок, который его определил
+	users    map[string]map[string]bool   // имя -> блоки, которые его используют
 }
 
 func NewKernelTypes() *KernelTypes {
-	mv := make(map[string]string)
-	mf := make(map[string]string)
-	mt := make(map[string]string)
 	return &KernelTypes{
-		vars:  mv,
-		funcs: mf,
-		types: mt,
+		vars:     make(map[string]string),
+		funcs:    make(map[string]string),
+		types:    make(map[string]string),
+		consts:   make(map[string]string),
+		generics: make(map[string]string),
+		methods:  make(map[string]map[string]string),
+		owners:   make(map[string]string),
+		users:    make(map[string]map[string]bool),
 	}
 }
 
-var knownTypes map[string]any = map[string]any{
-	"bool": struct{}{},
+// Vars возвращает имена переменных ядра по порядку
+func (kt *KernelTypes) Vars() []string {
+	return sortedKeys(kt.vars)
+}
 
-	"string": struct{}{},
-	"byte":   struct{}{},
-	"rune":   struct{}{},
+// VarType возвращает тип переменной ядра так, как он записан в коде
+func (kt *KernelTypes) VarType(name string) (string, bool) {
+	tp, ok := kt.vars[name]
+	return tp, ok
+}
 
-	"int64":   struct{}{},
-	"int32":   struct{}{},
-	"int":     struct{}{},
-	"float32": struct{}{},
-	"float64": struct{}{},
+// TypeDecl возвращает исходник объявления типа ядра
+func (kt *KernelTypes) TypeDecl(name string) (string, bool) {
+	src, ok := kt.types[name]
+	return src, ok
 }
 
 type Block struct {
-	content       string
-	lineKinds     map[int]map[Kind]bool
-	fnames        []string
-	vnames        []string
-	snames        []string
-	id            string
-	efaceRequired bool
-	types         *KernelTypes
-	reusedFuncs   []string
-	reusedVars    []string
-	reusedStructs []string
-	structDefines map[string]string
+	content     string
+	lineKinds   map[int]Kind
+	fnames      []string
+	vnames      []string
+	snames      []string
+	cnames      []string
+	gnames      []string
+	mnames      []string // методы в виде "Тип.Метод"
+	tnames      []string // тесты
+	bnames      []string // бенчмарки
+	id          string
+	types       *KernelTypes
+	reusedFuncs []string
+	reusedVars  []string
+	reusedDecls []string // типы, константы и обобщённые функции из других блоков
+	resolving   map[string]bool
+	changed     map[string]string // имена, чьё определение блок изменил -> прежнее определение
+	warnings    []string
+	invalidated []string
+	defaults    map[string]string // переменная -> исходное выражение значения
+	overrides   []string          // присваивания переопределённых параметров
+	magics      *Magics
+	magicCode   bool         // в блоке были команды, и ему нужен magicRunner
+	timed       bool         // %%time: весь блок выполняется под замером
+	reset       bool         // %reset: перед блоком ядро очищается
+	kernel      *KernelTypes // при %reset - типы ядра, которые Commit заменит типами блока
+	valueCode   bool         // блок достаёт значения с типами ядра, и ему нужен valueRunner
 }
 
 func NewBlock(id string, content string, types *KernelTypes) *Block {
-	fnames := make([]string, 0)
-	vnames := make([]string, 0)
-	snames := make([]string, 0)
-	lineKinds := make(map[int]map[Kind]bool)
-
-	return &Block{content: content, lineKinds: lineKinds, fnames: fnames, vnames: vnames, snames: snames, id: id, types: types}
+	return &Block{
+		content:   content,
+		lineKinds: make(map[int]Kind),
+		fnames:    make([]string, 0),
+		vnames:    make([]string, 0),
+		snames:    make([]string, 0),
+		cnames:    make([]string, 0),
+		gnames:    make([]string, 0),
+		mnames:    make([]string, 0),
+		id:        id,
+		types:     types,
+		changed:   make(map[string]string),
+		warnings:  make([]string, 0),
+		defaults:  make(map[string]string),
+		magics:    DefaultMagics(),
+	}
 }
 
 type Kind string
 
 const (
-	KindFuncName = "func-name"
-	KindFuncBody = "func-body"
-	KindVarDecl  = "var-decl"
-	KindOther    = "other"
+	KindFuncName  = "func-name"
+	KindFuncBody  = "func-body"
+	KindTypeDecl  = "type-decl"
+	KindConstDecl = "const-decl"
+	KindVarDecl   = "var-decl"
+	KindOther     = "other"
 )
 
-type identCandidate struct {
-	name string
-	line int
+// statement - инструкция верхнего уровня блока
+type statement struct {
+	first     token.Token
+	start     int // смещение первого токена
+	end       int // смещение конца последнего токена
+	startLine int
+	endLine   int
+	define    bool // есть := вне скобок
+	defineAt  int  // смещение первого := вне скобок
+	idents    []string
 }
 
+const (
+	filePrefix = "package main\n"
+	funcPrefix = "package main\nfunc _() {\n"
+)
+
 func (b *Block) Parse() error {
-	fset := token.NewFileSet()
-	file := fset.AddFile("snippet.go", -1, len(b.content))
+	err := b.expandMagics()
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(b.content, "\n")
 
-	var s scanner.Scanner
-	s.Init(file, []byte(b.content), nil, scanner.ScanComments)
+	bodyIdents := make([]string, 0)
+	allIdents := make([]string, 0)
+	for _, st := range b.splitStatements() {
+		kind, err := b.parseStatement(st, b.content[st.start:st.end])
+		if err != nil {
+			return err
+		}
+		b.markLines(st, kind)
+
+		allIdents = append(allIdents, st.idents...)
+		if kind == KindVarDecl || kind == KindOther {
+			bodyIdents = append(bodyIdents, st.idents...)
+		}
+	}
+	b.releaseStale()
+	b.collectReused(bodyIdents, allIdents)
+	b.track()
 
-	mark := func(line int, k Kind) bool {
-		if line == 0 {
-			return false
+	for i, text := range lines {
+		lineNum := i + 1
+		if strings.TrimSpace(text) == "" {
+			continue
 		}
-		if b.lineKinds[line] == nil {
-			b.lineKinds[line] = make(map[Kind]bool)
+		if _, ok := b.lineKinds[lineNum]; !ok {
+			b.lineKinds[lineNum] = KindOther
 		}
-		_, ok := b.lineKinds[line][k]
-		b.lineKinds[line][k] = true
-		return !ok
 	}
+	return nil
+}
+
+// splitStatements делит блок на инструкции верхнего уровня по точкам с запятой вне скобок
+func (b *Block) splitStatements() []*statement {
+	fset := token.NewFileSet()
+	file := fset.AddFile("snippet.go", -1, len(b.content))
+
+	var s scanner.Scanner
+	s.Init(file, []byte(b.content), nil, scanner.ScanComments)
 
 	var (
-		braceDepth         int  // общая глубина фигурных скобок
-		funcSignatureOpen  bool // после func ... до {
-		insideFunc         bool // сейчас внутри тела функции
-		insideType         bool
-		funcLevel          int  = -1
-		typeLevel          int  = -1
-		lastWasFuncKeyword bool // предыдущий токен был "func"
-		lastWasTypeKeyword bool
-		cTypeName          string
-
-		inVarDecl         bool             // внутри var-объявления
-		pendingCandidates []identCandidate // кандидаты для :=
+		stmts   []*statement
+		cur     *statement
+		depth   int // общая глубина скобок
+		prevTok token.Token
 	)
-	lines := strings.Split(b.content, "\n")
-
-	b.reusedFuncs = make([]string, 0)
-	b.reusedVars = make([]string, 0)
 	for {
 		pos, tok, lit := s.Scan()
 		if tok == token.EOF {
 			break
 		}
-		position := fset.Position(pos)
-		line := position.Line
+		if tok == token.COMMENT {
+			continue
+		}
+		if tok == token.SEMICOLON && depth == 0 {
+			cur = nil
+			continue
+		}
 
-		if insideFunc {
-			mark(line, KindFuncBody)
+		offset := file.Offset(pos)
+		if cur == nil {
+			cur = &statement{first: tok, start: offset, startLine: file.Line(pos)}
+			stmts = append(stmts, cur)
+			prevTok = token.ILLEGAL
 		}
 
 		switch tok {
-		case token.FUNC:
-			lastWasFuncKeyword = true
-			funcSignatureOpen = true
-
-		case token.TYPE:
-			lastWasTypeKeyword = true
-
-		case token.IDENT:
-			if lastWasFuncKeyword {
-				mark(line, KindFuncName)
-				b.fnames = append(b.fnames, lit)
-				lastWasFuncKeyword = false
-				b.types.funcs[lit] = "func"
-				break
+		case token.LPAREN, token.LBRACK, token.LBRACE:
+			depth++
+		case token.RPAREN, token.RBRACK, token.RBRACE:
+			if depth > 0 {
+				depth--
 			}
-
-			if lastWasTypeKeyword {
-				b.snames = append(b.snames, lit)
-				cTypeName = lit
-				lastWasTypeKeyword = false
-				insideType = true
-				b.types.types[cTypeName] = "type"
-				break
+		case token.DEFINE:
+			if depth == 0 && !cur.define {
+				cur.define = true
+				cur.defineAt = offset
 			}
-
-			if funcSignatureOpen {
-				if _, ok := knownTypes[lit]; ok {
-					b.types.funcs[b.fnames[len(b.fnames)-1]] = b.types.funcs[b.fnames[len(b.fnames)-1]] + lit
-				}
+		case token.IDENT:
+			// поля и методы после точки не являются именами ядра
+			if prevTok != token.PERIOD {
+				cur.idents = append(cur.idents, lit)
 			}
+		default:
+		}
 
-			if insideType {
-				b.types.types[cTypeName] = b.types.types[cTypeName] + lit
-			}
+		size := len(lit)
+		if size == 0 {
+			size = len(tok.String())
+		}
+		cur.end = min(offset+size, len(b.content))
+		prevTok = tok
+	}
 
-			if inVarDecl {
-				ok := mark(line, KindVarDecl)
-				if ok {
-					b.vnames = append(b.vnames, lit)
-				}
-			} else {
-				pendingCandidates = append(pendingCandidates, identCandidate{
-					name: lit,
-					line: line,
-				})
-			}
+	for _, st := range stmts {
+		st.endLine = file.Line(file.Pos(max(st.end-1, st.start)))
+	}
+	return stmts
+}
 
-			if _, ok := b.types.vars[lit]; ok {
-				b.reusedVars = append(b.reusedVars, lit)
-			}
+func (b *Block) markLines(st *statement, kind Kind) {
+	for line := st.startLine; line <= st.endLine; line++ {
+		if _, ok := b.lineKinds[line]; ok {
+			continue
+		}
+		if kind == KindFuncName && line != st.startLine {
+			b.lineKinds[line] = KindFuncBody
+			continue
+		}
+		b.lineKinds[line] = kind
+	}
+}
 
-			if _, ok := b.types.funcs[lit]; ok {
-				b.reusedFuncs = append(b.reusedFuncs, lit)
-			}
+func (b *Block) parseStatement(st *statement, src string) (Kind, error) {
+	switch st.first {
+	case token.FUNC:
+		file, err := parser.ParseFile(token.NewFileSet(), "", filePrefix+src, parser.SkipObjectResolution)
+		if err != nil || len(file.Decls) != 1 {
+			// функциональный литерал, а не объявление
+			return KindOther, nil
+		}
+		fd, ok := file.Decls[0].(*ast.FuncDecl)
+		if !ok {
+			return KindOther, nil
+		}
+		b.addFunc(fd, src)
+		return KindFuncName, nil
 
-		case token.VAR:
-			inVarDecl = true
-			pendingCandidates = nil
+	case token.TYPE, token.CONST, token.VAR:
+		fset := token.NewFileSet()
+		wrapped := filePrefix + src
+		file, err := parser.ParseFile(fset, "", wrapped, parser.SkipObjectResolution)
+		if err != nil {
+			return "", fmt.Errorf("error parsing declaration at line %d: %w", st.startLine, err)
+		}
+		if len(file.Decls) != 1 {
+			return "", fmt.Errorf("error parsing declaration at line %d", st.startLine)
+		}
+		gd, ok := file.Decls[0].(*ast.GenDecl)
+		if !ok {
+			return "", fmt.Errorf("error parsing declaration at line %d", st.startLine)
+		}
 
-		case token.DEFINE:
-			totalLine := ""
-			for _, c := range pendingCandidates {
-				ok := mark(line, KindVarDecl)
-				b.vnames = append(b.vnames, c.name)
-				if ok {
-					totalLine += lines[c.line-1]
+		switch gd.Tok {
+		case token.TYPE:
+			for _, spec := range gd.Specs {
+				ts := spec.(*ast.TypeSpec)
+				b.snames = append(b.snames, ts.Name.Name)
+				b.define(b.types.types, ts.Name.Name, "type "+nodeSource(fset, wrapped, ts))
+			}
+			return KindTypeDecl, nil
+		case token.CONST:
+			for _, spec := range gd.Specs {
+				for _, name := range spec.(*ast.ValueSpec).Names {
+					if name.Name == "_" {
+						continue
+					}
+					b.cnames = append(b.cnames, name.Name)
+					b.define(b.types.consts, name.Name, src)
 				}
 			}
-			tp, err := b.parseLineType(totalLine)
-
-			if err != nil {
-				return err
+			return KindConstDecl, nil
+		default:
+			for _, spec := range gd.Specs {
+				vs := spec.(*ast.ValueSpec)
+				err := b.addVarSpec(vs, "var "+nodeSource(fset, wrapped, vs), st.startLine)
+				if err != nil {
+					return "", err
+				}
 			}
+			return KindVarDecl, nil
+		}
 
-			if len(tp) != len(pendingCandidates) {
-				return fmt.Errorf("%s", "variables decl and val don't match at line "+totalLine)
-			}
+	case token.IDENT:
+		if !st.define {
+			return KindOther, nil
+		}
+		err := b.addDefine(src, st.startLine)
+		if err != nil {
+			return "", err
+		}
+		return KindVarDecl, nil
+	default:
+		return KindOther, nil
+	}
+}
 
-			for idx, val := range pendingCandidates {
-				name := val.name
-				b.types.vars[name] = tp[idx]
-			}
+func (b *Block) addFunc(fd *ast.FuncDecl, src string) {
+	name := fd.Name.Name
+	switch {
+	case fd.Recv != nil && len(fd.Recv.List) > 0:
+		recv := receiverTypeName(fd.Recv.List[0].Type)
+		b.defineMethod(recv, name, src)
+		b.mnames = append(b.mnames, recv+"."+name)
+	case name == "init" || name == "_":
+		// init выполняется при загрузке плагина и в ядре не хранится
+	case fd.Type.TypeParams != nil && fd.Type.TypeParams.NumFields() > 0:
+		// обобщённую функцию нельзя положить в map без инстанцирования, поэтому переносим её исходник
+		b.gnames = append(b.gnames, name)
+		b.define(b.types.generics, name, src)
+	default:
+		b.fnames = append(b.fnames, name)
+		b.define(b.types.funcs, name, funcSignature(fd.Type))
+		b.addTest(fd)
+	}
+}
 
-			pendingCandidates = nil
+func (b *Block) addVarSpec(spec *ast.ValueSpec, src string, line int) error {
+	var tp []string
+	if spec.Type != nil {
+		for range spec.Names {
+			tp = append(tp, types.ExprString(spec.Type))
+		}
+	} else {
+		var err error
+		tp, err = b.inferTypes(spec.Values, spec.Names, src)
+		if err != nil {
+			return err
+		}
+	}
+	b.addDefaults(spec.Names, spec.Values)
+	return b.addVars(spec.Names, tp, line)
+}
 
-		case token.SEMICOLON:
-			inVarDecl = false
-			pendingCandidates = nil
-			lastWasFuncKeyword = false
+func (b *Block) addDefine(src string, line int) error {
+	file, err := parser.ParseFile(token.NewFileSet(), "", funcPrefix+src+"\n}", parser.SkipObjectResolution)
+	if err != nil {
+		return fmt.Errorf("error parsing statement at line %d: %w", line, err)
+	}
+	body := file.Decls[0].(*ast.FuncDecl).Body
+	if len(body.List) != 1 {
+		return fmt.Errorf("not valid statement at line %d", line)
+	}
+	assign, ok := body.List[0].(*ast.AssignStmt)
+	if !ok || assign.Tok != token.DEFINE {
+		return fmt.Errorf("not valid statement at line %d", line)
+	}
 
-		case token.LBRACE:
-			braceDepth++
-			if funcSignatureOpen {
-				insideFunc = true
-				funcSignatureOpen = false
-				funcLevel = braceDepth
-			}
-			if insideType {
-				typeLevel = braceDepth
-			}
+	names := make([]*ast.Ident, 0, len(assign.Lhs))
+	for _, lhs := range assign.Lhs {
+		ident, ok := lhs.(*ast.Ident)
+		if !ok {
+			return fmt.Errorf("not valid statement at line %d", line)
+		}
+		names = append(names, ident)
+	}
 
-		case token.RBRACE:
-			if braceDepth > 0 {
-				braceDepth--
-			}
-			if insideFunc && braceDepth < funcLevel {
-				insideFunc = false
-				funcLevel = -1
-			}
-			if insideType && braceDepth < typeLevel {
-				insideType = false
-				typeLevel = -1
-			}
-		case token.LPAREN:
-			if funcSignatureOpen {
-				b.types.funcs[b.fnames[len(b.fnames)-1]] = b.types.funcs[b.fnames[len(b.fnames)-1]] + "("
-			}
-		case token.RPAREN:
-			if funcSignatureOpen {
-				b.types.funcs[b.fnames[len(b.fnames)-1]] = b.types.funcs[b.fnames[len(b.fnames)-1]] + ")"
-			}
-		case token.COMMA:
-			if funcSignatureOpen {
-				b.types.funcs[b.fnames[len(b.fnames)-1]] = b.types.funcs[b.fnames[len(b.fnames)-1]] + ","
-			}
+	tp, err := b.inferTypes(assign.Rhs, names, src)
+	if err != nil {
+		return err
+	}
+	b.addDefaults(names, assign.Rhs)
+	return b.addVars(names, tp, line)
+}
 
-		default:
-			// остальные токены пропустить
+// addDefaults запоминает выражения значений; у a, b := f() выражения отдельной переменной нет
+func (b *Block) addDefaults(names []*ast.Ident, values []ast.Expr) {
+	if len(names) != len(values) {
+		return
+	}
+	for idx, name := range names {
+		if name.Name == "_" {
+			continue
 		}
+		b.defaults[name.Name] = types.ExprString(values[idx])
 	}
+}
 
-	for i, text := range lines {
-		lineNum := i + 1
-		if strings.TrimSpace(text) == "" {
+func (b *Block) inferTypes(values []ast.Expr, names []*ast.Ident, src string) ([]string, error) {
+	tp, err := b.valueTypes(values, len(names))
+	if err == nil {
+		return tp, nil
+	}
+	checked, checkErr := b.checkTypes(src, names)
+	if checkErr != nil {
+		return []string{}, err
+	}
+	return checked, nil
+}
+
+func (b *Block) addVars(names []*ast.Ident, tp []string, line int) error {
+	if len(tp) != len(names) {
+		return fmt.Errorf("variables decl and val don't match at line %d", line)
+	}
+	for idx, name := range names {
+		if name.Name == "_" {
 			continue
 		}
-		if len(b.lineKinds[lineNum]) == 0 {
-			mark(lineNum, KindOther)
-		}
+		b.vnames = append(b.vnames, name.Name)
+		b.define(b.types.vars, name.Name, tp[idx])
 	}
-	// fmt.Printf("\n-----funcs-----\n")
-	// for k, v := range b.types.funcs {
-	// 	fmt.Printf("%s %s \n", k, v)
-	// }
-	// fmt.Printf("-----/funcs-----\n")
-	// fmt.Printf("\n-----vars-----\n")
-	// for k, v := range b.types.vars {
-	// 	fmt.Printf("%s %s \n", k, v)
-	// }
-	// fmt.Printf("-----/vars-----\n")
 	return nil
 }
 
-func (b *Block) parseLineType(totalLine string) ([]string, error) {
-	splitted := strings.Split(totalLine, ":=")
-	if len(splitted) != 2 {
-		return []string{}, fmt.Errorf("not valid statement")
+func (b *Block) collectReused(bodyIdents []string, allIdents []string) {
+	b.reusedFuncs = make([]string, 0)
+	b.reusedVars = make([]string, 0)
+	b.reusedDecls = make([]string, 0)
+
+	for _, name := range bodyIdents {
+		if _, ok := b.types.vars[name]; ok && !slices.Contains(b.vnames, name) {
+			b.reusedVars = append(b.reusedVars, name)
+		}
+		if _, ok := b.types.funcs[name]; ok && !slices.Contains(b.fnames, name) {
+			b.reusedFuncs = append(b.reusedFuncs, name)
+		}
 	}
-	val := strings.Split(splitted[1], ",")
-	res := make([]string, 0)
+	for _, name := range allIdents {
+		if b.isSharedDecl(name) && !b.definesDecl(name) {
+			b.reusedDecls = append(b.reusedDecls, name)
+		}
+	}
+}
 
-	for _, value := range val {
-		expr, err := parser.ParseExpr(value)
+func (b *Block) isSharedDecl(name string) bool {
+	_, isType := b.types.types[name]
+	_, isConst := b.types.consts[name]
+	_, isGeneric := b.types.generics[name]
+	return isType || isConst || isGeneric
+}
 
-		if err != nil {
-			return []string{}, err
-		}
+func (b *Block) definesDecl(name string) bool {
+	return slices.Contains(b.snames, name) || slices.Contains(b.cnames, name) || slices.Contains(b.gnames, name)
+}
 
-		switch v := expr.(type) {
-		case *ast.BasicLit:
-			res = append(res, strings.ToLower(v.Kind.String()))
-		default:
-			typ := ""
-			typLen := 0
-			for i := 0; i < len(value); i++ {
-				if value[i] == ' ' {
-					typLen++
-					continue
-				}
-				if value[i] != '&' {
-					break
-				}
-				typ += "*"
-			}
-			if value[len(value)-1] == '}' {
-				for i := len(typ) + typLen; i < len(value); i++ {
-					if value[i] == '{' {
-						break
-					}
-					typ += string(value[i])
-				}
-			} else if value[len(value)-1] == ')' {
-				fname := ""
-				for i := len(typ) + typLen; i < len(value); i++ {
-					if value[i] == '(' {
-						break
-					}
-					fname += string(value[i])
-				}
-				fnameSplitted := strings.SplitN(fname, ".", 2)
-				if len(fnameSplitted) == 2 {
-					importPath := fnameSplitted[0]
-					funcName := fnameSplitted[1]
-					loadedType, err := loadFuncResultType(importPath, funcName)
-					if err != nil {
-						return []string{}, err
-					}
-					typ += loadedType
-				} else {
-					if b.types.funcs[fname] != "" {
-						fmt.Println(b.types.funcs[fname])
-						types := strings.SplitAfterN(b.types.funcs[fname], ")", 2)
-						if len(types) != 2 {
-							return []string{}, fmt.Errorf("can't resolve func result type for %s", fname)
-						}
-						fmt.Println(types)
-						if strings.HasPrefix(types[1], "(") && strings.HasSuffix(types[1], ")") {
-							types[1] = strings.TrimSuffix(strings.TrimPrefix(types[1], "("), ")")
-						}
-
-						typesArr := strings.Split(types[1], ",")
-
-						if len(typesArr) == 0 {
-							return []string{}, fmt.Errorf("can't resolve func result type for %s", fname)
-						} else if len(typesArr) == 1 {
-							typ += strings.TrimSpace(typesArr[0])
-						} else {
-							typ = ""
-							for _, t := range typesArr {
-								res = append(res, strings.TrimSpace(t))
-							}
-						}
-					}
-					//return []string{}, fmt.Errorf("can't resolve func result type for %s", fname)
-				}
-			}
-			if typ != "" {
-				res = append(res, typ)
+// declSources возвращает исходники, которые нужно перенести в блок, чтобы name был в нём объявлен
+func (b *Block) declSources(name string) []string {
+	srcs := make([]string, 0)
+	if src, ok := b.types.types[name]; ok {
+		srcs = append(srcs, src)
+		methods := make([]string, 0, len(b.types.methods[name]))
+		for method := range b.types.methods[name] {
+			if !slices.Contains(b.mnames, name+"."+method) {
+				methods = append(methods, method)
 			}
 		}
+		sort.Strings(methods)
+		for _, method := range methods {
+			srcs = append(srcs, b.types.methods[name][method])
+		}
 	}
-	return res, nil
-}
-
-func loadFuncResultType(importPath, funcName string) (string, error) {
-	cfg := &packages.Config{
-		Mode: packages.NeedTypes | packages.NeedTypesInfo | packages.NeedSyntax,
+	if src, ok := b.types.consts[name]; ok {
+		srcs = append(srcs, src)
 	}
-
-	pkgs, err := packages.Load(cfg, importPath)
-	if err != nil {
-		return "", err
+	if src, ok := b.types.generics[name]; ok {
+		srcs = append(srcs, src)
 	}
-	if packages.PrintErrors(pkgs) > 0 {
-		return "", fmt.Errorf("packages contain errors")
+	return srcs
+}
+
+// sharedDecls собирает объявления из других блоков, от которых зависит текущий, включая транзитивные
+func (b *Block) sharedDecls() string {
+	queue := slices.Clone(b.reusedDecls)
+	for _, name := range b.reusedVars {
+		queue = append(queue, scanIdents(b.types.vars[name])...)
 	}
-	if len(pkgs) == 0 {
-		return "", fmt.Errorf("package not found")
+	for _, name := range b.reusedFuncs {
+		queue = append(queue, scanIdents(b.types.funcs[name])...)
 	}
 
-	scope := pkgs[0].Types.Scope()
-	obj := scope.Lookup(funcName)
-	if obj == nil {
-		return "", fmt.Errorf("func %s not found in %s", funcName, importPath)
+	var sb strings.Builder
+	visited := make(map[string]bool)
+	emitted := make(map[string]bool)
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if visited[name] || b.definesDecl(name) {
+			continue
+		}
+		visited[name] = true
+		for _, src := range b.declSources(name) {
+			if emitted[src] {
+				continue
+			}
+			emitted[src] = true
+			sb.WriteString(src + "\n")
+			queue = append(queue, scanIdents(src)...)
+		}
 	}
+	return sb.String()
+}
+
+func scanIdents(src string) []string {
+	fset := token.NewFileSet()
+	file := fset.AddFile("", -1, len(src))
+
+	var s scanner.Scanner
+	s.Init(file, []byte(src), nil, 0)
 
-	fn, ok := obj.(*types.Func)
-	if !ok {
-		return "", fmt.Errorf("%s is not a function", funcName)
+	idents := make([]string, 0)
+	prevTok := token.ILLEGAL
+	for {
+		_, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok == token.IDENT && prevTok != token.PERIOD {
+			idents = append(idents, lit)
+		}
+		prevTok = tok
 	}
+	return idents
+}
+
+func nodeSource(fset *token.FileSet, src string, node ast.Node) string {
+	return src[fset.Position(node.Pos()).Offset:fset.Position(node.End()).Offset]
+}
 
-	sig, ok := fn.Type().(*types.Signature)
-	if !ok {
-		return "", fmt.Errorf("not a function signature")
+func receiverTypeName(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.StarExpr:
+		return receiverTypeName(e.X)
+	case *ast.ParenExpr:
+		return receiverTypeName(e.X)
+	case *ast.IndexExpr:
+		return receiverTypeName(e.X)
+	case *ast.IndexListExpr:
+		return receiverTypeName(e.X)
+	case *ast.Ident:
+		return e.Name
+	default:
+		return types.ExprString(expr)
 	}
+}
 
-	res := sig.Results()
-	if res.Len() == 0 {
-		return "", fmt.Errorf("function %s has no results", funcName)
+func funcSignature(ft *ast.FuncType) string {
+	sig := "func(" + strings.Join(fieldTypes(ft.Params), ", ") + ")"
+	results := fieldTypes(ft.Results)
+	switch len(results) {
+	case 0:
+	case 1:
+		sig += " " + results[0]
+	default:
+		sig += " (" + strings.Join(results, ", ") + ")"
 	}
+	return sig
+}
 
-	// Берём тип первого результата
-	return res.At(0).Type().String(), nil
+func fieldTypes(fields *ast.FieldList) []string {
+	res := make([]string, 0)
+	if fields == nil {
+		return res
+	}
+	for _, field := range fields.List {
+		tp := types.ExprString(field.Type)
+		for range max(len(field.Names), 1) {
+			res = append(res, tp)
+		}
+	}
+	return res
 }
 
 func collectUsedNames(f *ast.File) map[string]bool {
@@ -531,6 +701,11 @@ func isImportUsed(imp *ast.ImportSpec, usedNames map[string]bool, f *ast.File) b
 }
 
 func (b *Block) ClearImports(code string) string {
+	return clearImports(code)
+}
+
+// clearImports убирает из файла неиспользуемые импорты и форматирует его
+func clearImports(code string) string {
 	//fmt.Println(code)
 	fset := token.NewFileSet()
 	f, err := parser.ParseFile(fset, "", code, parser.ParseComments)
@@ -559,17 +734,34 @@ func (b *Block) ClearImports(code string) string {
 }
 
 func (b *Block) FormExportFunc(attempt string) string {
-	funcDefs := baseCopypaste
+	return b.formExport(attempt, false)
+}
+
+// formExport собирает файл плагина; с tests строки блока получают директивы номеров строк,
+// а функция экспорта в конце запускает тесты и бенчмарки блока
+func (b *Block) formExport(attempt string, tests bool) string {
+	funcDefs := baseCopypaste + b.sharedDecls()
+	var directives map[int]string
+	if tests {
+		funcDefs = strings.Replace(funcDefs, "\n)\n", "\n)\n"+testImports, 1)
+		directives = b.lineDirectives()
+	}
+	if b.magicCode {
+		funcDefs = strings.Replace(funcDefs, "\n)\n", "\n)\n"+magicImports, 1)
+	}
 	fMapName := "_"
 	vMapName := "_"
-	if len(b.fnames) != 0 || len(b.reusedFuncs) != 0 {
+	if len(b.fnames) != 0 || len(b.reusedFuncs) != 0 || b.reset {
 		fMapName = "funcMap"
 	}
-	if len(b.vnames) != 0 || len(b.reusedVars) != 0 {
+	if len(b.vnames) != 0 || len(b.reusedVars) != 0 || b.reset {
 		vMapName = "varMap"
 	}
 	bFname := strings.ReplaceAll(b.id, "-", "_")
 	mains := fmt.Sprintf("func Export_block_%s_%s(%s *map[string]any, %s *map[string]any){\n", bFname, attempt, fMapName, vMapName)
+	if b.reset {
+		mains += "\tclear(*funcMap)\n\tclear(*varMap)\n"
+	}
 	if len(b.fnames) != 0 || len(b.reusedFuncs) != 0 {
 		mains += "\tfuncsMap := *funcMap \n"
 	}
@@ -589,7 +781,7 @@ func (b *Block) FormExportFunc(attempt string) string {
 			}
 			_, placed := fPlaced[funcName]
 			if ok && !placed {
-				mains += fmt.Sprintf("\t%s := funcsMap[\"%s\"].(%s)\n", funcName, funcName, b.types.funcs[funcName])
+				mains += fmt.Sprintf("\t%s := %s\n", funcName, b.reuse("funcsMap", funcName, b.types.funcs[funcName]))
 				fPlaced[funcName] = struct{}{}
 			}
 		}
@@ -606,7 +798,7 @@ func (b *Block) FormExportFunc(attempt string) string {
 			}
 			_, placed := vPlaced[varName]
 			if ok && !placed {
-				mains += fmt.Sprintf("\t%s := varsMap[\"%s\"].(%s)\n", varName, varName, b.types.vars[varName])
+				mains += fmt.Sprintf("\t%s := %s\n", varName, b.reuse("varsMap", varName, b.types.vars[varName]))
 				vPlaced[varName] = struct{}{}
 			}
 		}
@@ -614,15 +806,27 @@ func (b *Block) FormExportFunc(attempt string) string {
 
 	for i, text := range lines {
 		lineNum := i + 1
-		for k := range b.lineKinds[lineNum] {
-			switch k {
-			case KindFuncName, KindFuncBody:
-				funcDefs += text + "\n"
-			case KindVarDecl, KindOther:
-				mains += "\t" + text + "\n"
-			}
+		switch b.lineKinds[lineNum] {
+		case KindFuncName, KindFuncBody, KindTypeDecl, KindConstDecl:
+			funcDefs += directives[lineNum] + text + "\n"
+		case KindVarDecl, KindOther:
+			// отступы расставит format.Node, а лишний таб испортил бы многострочные raw-строки
+			mains += directives[lineNum] + text + "\n"
 		}
 	}
+	if tests {
+		funcDefs += testRunner
+	}
+	if b.magicCode {
+		funcDefs += b.magics.runner()
+	}
+	if b.valueCode {
+		funcDefs = strings.Replace(funcDefs, "\n)\n", "\n)\n"+valueImports, 1)
+		funcDefs += valueRunner
+	}
+	for _, override := range b.overrides {
+		mains += override + "\n"
+	}
 	if len(b.fnames) != 0 {
 		fused := make(map[string]struct{})
 		for _, fname := range b.fnames {
@@ -643,7 +847,9 @@ func (b *Block) FormExportFunc(attempt string) string {
 			vused[vname] = struct{}{}
 		}
 	}
+	if tests {
+		mains += b.testCalls()
+	}
 
-	//slog.Info(funcDefs + mains + "}\n")
 	return b.ClearImports(funcDefs + mains + "}\n")
 }
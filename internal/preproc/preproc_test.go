@@ -1,10 +1,24 @@
 package preproc
 
 import (
+	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
-	"strconv"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	gotypes "go/types"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+
+	"github.com/dnonakolesax/noted-runner/internal/configs"
+	"golang.org/x/tools/txtar"
 )
 
 var globalSource string = `
@@ -65,152 +79,409 @@ func TestParse(t *testing.T) {
 
 		code := block.FormExportFunc("1")
 
-		fmt.Printf("code: %s", code)
+		err = typeCheck(code)
+		if err != nil {
+			t.Fatalf("generated code doesn't compile: %v\n%s", err, code)
+		}
 	}
 }
 
-func TestMultiple(t *testing.T) {
-	type MultipleCase struct {
-		sources  []string
-		expected error
-	}
-	cases := []MultipleCase{
-		// {
-		// 	source: globalSource,
-		// 	expected: nil,
-		// },
-		{
-			sources:  []string{"a, b := 10, 20", "fmt.Println(a)\nfmt.Println(b)", "z := math.Abs(-1.0)", "fmt.Println(z)"},
-			expected: nil,
-		},
-	}
+var update = flag.Bool("update", false, "перезаписать golden-файлы в testdata")
 
-	for _, testCase := range cases {
-		types := NewKernelTypes()
+// TestGolden прогоняет блоки из testdata/*.txtar по порядку в одном ядре. Для каждого blockN.go
+// сгенерированный код сравнивается с blockN.golden, состояние ядра после блока - с blockN.types,
+// а предупреждения - с blockN.warnings.
+// go test ./internal/preproc -run TestGolden -update перезаписывает ожидаемые значения
+func TestGolden(t *testing.T) {
+	files, err := filepath.Glob("testdata/*.txtar")
+	if err != nil {
+		t.Fatalf("%s", err.Error())
+	}
 
-		for idx, source := range testCase.sources {
-			sidx := strconv.Itoa(idx)
-			block := NewBlock(sidx, source, types)
+	for _, name := range files {
+		t.Run(filepath.Base(name), func(t *testing.T) {
+			archive, err := txtar.ParseFile(name)
+			if err != nil {
+				t.Fatalf("%s", err.Error())
+			}
 
-			err := block.Parse()
+			expected := make(map[string]string)
+			for _, file := range archive.Files {
+				expected[file.Name] = string(file.Data)
+			}
 
-			if !errors.Is(err, testCase.expected) {
-				t.Fatalf("testparse got error %v, expected %v \n", err, testCase.expected)
+			types := NewKernelTypes()
+			updated := make([]txtar.File, 0, len(archive.Files))
+			for _, file := range archive.Files {
+				if !strings.HasSuffix(file.Name, ".go") {
+					continue
+				}
+				// blockN.go и blockN.v2.go - это разные версии одного блока N
+				base := strings.TrimSuffix(file.Name, ".go")
+				id := strings.TrimPrefix(strings.SplitN(base, ".", 2)[0], "block")
+				block := NewBlock(id, string(file.Data), types)
+
+				err := block.Parse()
+				if err != nil {
+					t.Fatalf("%s: parse error %v", file.Name, err)
+				}
+
+				full := block.FormExportFunc("1")
+				code := elideValueRunner(full)
+				state := dumpKernelTypes(types)
+				warnings := strings.Join(block.Warnings(), "\n")
+				updated = append(updated, file,
+					txtar.File{Name: base + ".golden", Data: []byte(code)},
+					txtar.File{Name: base + ".types", Data: []byte(state)})
+				if warnings != "" {
+					warnings += "\n"
+					updated = append(updated, txtar.File{Name: base + ".warnings", Data: []byte(warnings)})
+				}
+
+				err = typeCheck(full)
+				if err != nil {
+					t.Errorf("%s: generated code doesn't compile: %v\n%s", file.Name, err, full)
+				}
+				if *update {
+					continue
+				}
+				if code != expected[base+".golden"] {
+					t.Errorf("%s: got\n%s\nexpected\n%s", file.Name, code, expected[base+".golden"])
+				}
+				if state != expected[base+".types"] {
+					t.Errorf("%s: kernel types got\n%s\nexpected\n%s", file.Name, state, expected[base+".types"])
+				}
+				if warnings != expected[base+".warnings"] {
+					t.Errorf("%s: warnings got\n%s\nexpected\n%s", file.Name, warnings, expected[base+".warnings"])
+				}
 			}
 
-			code := block.FormExportFunc("1")
+			if *update {
+				archive.Files = updated
+				err := os.WriteFile(name, txtar.Format(archive), 0o644)
+				if err != nil {
+					t.Fatalf("%s", err.Error())
+				}
+			}
+		})
+	}
+}
 
-			fmt.Printf("code for %s: %s", sidx, code)
-		}
+// elideValueRunner заменяет в golden-файлах одинаковые для всех блоков функции valueRunner одной строкой
+func elideValueRunner(code string) string {
+	start := strings.Index(code, "//line noted.go:1\nfunc _notedAs[")
+	last := strings.Index(code, "\nfunc _notedPkg(")
+	if start < 0 || last < 0 {
+		return code
 	}
+	end := last + strings.Index(code[last:], "\n}\n") + len("\n}\n")
+	return code[:start] + "// valueRunner\n" + code[end:]
+}
 
+// typeCheck проверяет сгенерированный файл так же, как его увидит go build -buildmode=plugin
+func typeCheck(code string) error {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "block.go", code, 0)
+	if err != nil {
+		return err
+	}
+	conf := gotypes.Config{Importer: importer.Default()}
+	_, err = conf.Check("main", fset, []*ast.File{file}, nil)
+	return err
 }
 
-func TestImport (t *testing.T) {
-	type ImportCase struct {
-		sources  []string
-		expected error
+func dumpKernelTypes(kt *KernelTypes) string {
+	var sb strings.Builder
+	for _, name := range sortedKeys(kt.vars) {
+		fmt.Fprintf(&sb, "var %s %s [%s]\n", name, kt.vars[name], kt.owners[name])
 	}
-	cases := []ImportCase{
-		// {
-		// 	source: globalSource,
-		// 	expected: nil,
-		// },
-		{
-			sources:  []string{"mx := &sync.Mutex{}", "cnd := sync.NewCond(mx)", "cnd.L.Lock()\ncnd.Wait()\ncnd.L.Unlock()"},
-			expected: nil,
-		},
+	for _, name := range sortedKeys(kt.funcs) {
+		fmt.Fprintf(&sb, "func %s %s [%s]\n", name, kt.funcs[name], kt.owners[name])
 	}
+	for _, name := range sortedKeys(kt.types) {
+		fmt.Fprintf(&sb, "type %s [%s]\n", name, kt.owners[name])
+	}
+	for _, name := range sortedKeys(kt.consts) {
+		fmt.Fprintf(&sb, "const %s [%s]\n", name, kt.owners[name])
+	}
+	for _, name := range sortedKeys(kt.generics) {
+		fmt.Fprintf(&sb, "generic %s [%s]\n", name, kt.owners[name])
+	}
+	for _, tp := range sortedKeys(kt.methods) {
+		for _, method := range sortedKeys(kt.methods[tp]) {
+			fmt.Fprintf(&sb, "method %s.%s [%s]\n", tp, method, kt.owners[tp+"."+method])
+		}
+	}
+	return sb.String()
+}
 
-	for _, testCase := range cases {
-		types := NewKernelTypes()
-
-		for idx, source := range testCase.sources {
-			sidx := strconv.Itoa(idx)
-			block := NewBlock(sidx, source, types)
-
-			err := block.Parse()
-
-			if !errors.Is(err, testCase.expected) {
-				t.Fatalf("testparse got error %v, expected %v \n", err, testCase.expected)
-			}
-
-			code := block.FormExportFunc("1")
+func TestForget(t *testing.T) {
+	types := NewKernelTypes()
+	sources := map[string]string{
+		"1": "a := 1\nfunc f() int {\nreturn 2\n}\ntype P struct {\nX int\n}",
+		"2": "b := f() + a\np := P{X: b}",
+		"3": "fmt.Println(b)",
+	}
+	for _, id := range []string{"1", "2", "3"} {
+		err := NewBlock(id, sources[id], types).Parse()
+		if err != nil {
+			t.Fatalf("block %s: %v", id, err)
+		}
+	}
 
-			fmt.Printf("code for %s: %s", sidx, code)
+	dependants := types.Forget("1")
+	if !slices.Equal(dependants, []string{"2"}) {
+		t.Fatalf("forget got dependants %v, expected [2]", dependants)
+	}
+	for _, name := range []string{"a", "f", "P"} {
+		if types.describe(name) != "" {
+			t.Fatalf("%s is still defined after forget", name)
 		}
 	}
+	if types.vars["b"] != "int" || types.owners["b"] != "2" {
+		t.Fatalf("definitions of other blocks must be kept")
+	}
 
+	// блок, использовавший забытые имена, больше их не подставляет
+	block := NewBlock("4", "fmt.Println(a)\nf()\nvar q P", types)
+	err := block.Parse()
+	if err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+	if len(block.reusedVars) != 0 || len(block.reusedFuncs) != 0 || len(block.reusedDecls) != 0 {
+		t.Fatalf("forgotten names are still reused: %v %v %v", block.reusedVars, block.reusedFuncs, block.reusedDecls)
+	}
 }
 
-func TestFunc (t *testing.T) {
-	type FuncCase struct {
-		sources  []string
-		expected error
+func TestOverride(t *testing.T) {
+	types := NewKernelTypes()
+	err := NewBlock("0", "type Region string", types).Parse()
+	if err != nil {
+		t.Fatal(err)
 	}
-	cases := []FuncCase{
-		// {
-		// 	source: globalSource,
-		// 	expected: nil,
-		// },
-		{
-			sources:  []string{"func abc() (int, string){\nreturn 2,\"3\"\n}\na,b:=abc()", "fmt.Println(a)\nfmt.Println(b)"},
-			expected: nil,
-		},
+	src := ParametersTag + "\nregion := Region(\"eu\")\nlimit := 10\nvar timeout = time.Second\nratio := 0.5"
+	block := NewBlock("1", src, types)
+	err = block.Parse()
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	for _, testCase := range cases {
-		types := NewKernelTypes()
-
-		for idx, source := range testCase.sources {
-			sidx := strconv.Itoa(idx)
-			block := NewBlock(sidx, source, types)
-
-			err := block.Parse()
+	effective, err := block.Override(map[string]json.RawMessage{
+		"region": json.RawMessage(`"us"`), "limit": json.RawMessage(`20`), "timeout": json.RawMessage(`5000`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"region": `"us"`, "limit": "20", "timeout": "5000", "ratio": "0.5"}
+	if !maps.Equal(effective, want) {
+		t.Fatalf("got effective parameters %v, expected %v", effective, want)
+	}
+	code := block.FormExportFunc("at1")
+	if !strings.Contains(code, `region = "us"`) || strings.Index(code, "limit = 20") < strings.Index(code, "limit := 10") {
+		t.Fatalf("overrides are not placed after declarations:\n%s", code)
+	}
 
-			if !errors.Is(err, testCase.expected) {
-				t.Fatalf("testparse got error %v, expected %v \n", err, testCase.expected)
-			}
+	for name, value := range map[string]string{"limit": `1.5`, "region": `1`, "ratio": `"x"`, "limit ": `[1]`} {
+		block := NewBlock("1", src, types)
+		_ = block.Parse()
+		_, err := block.Override(map[string]json.RawMessage{strings.TrimSpace(name): json.RawMessage(value)})
+		if !errors.Is(err, ErrInvalidParameter) {
+			t.Fatalf("%s = %s is accepted: %v", name, value, err)
+		}
+	}
+	_, err = block.Override(map[string]json.RawMessage{"missing": json.RawMessage(`1`)})
+	if !errors.Is(err, ErrUnknownParameter) {
+		t.Fatalf("unknown parameter is accepted: %v", err)
+	}
+	other := NewBlock("2", "x := 1", types)
+	_ = other.Parse()
+	if _, err = other.Override(map[string]json.RawMessage{"x": json.RawMessage(`2`)}); !errors.Is(err, ErrNotParameters) {
+		t.Fatalf("plain block accepts parameters: %v", err)
+	}
+}
 
-			code := block.FormExportFunc("1")
+func TestExport(t *testing.T) {
+	program := NewProgram()
+	blocks := []struct {
+		id      string
+		src     string
+		skipped bool
+	}{
+		{"1", "type Point struct {\nX, Y int\n}\nfunc (p Point) Sum() int {\nreturn p.X + p.Y\n}\nfunc double(x int) int {\nreturn x * 2\n}", false},
+		{"2", "p := Point{X: 1, Y: 2}\nn := double(p.Sum())\nfmt.Println(n)", false},
+		{"3", "n := 10\nfmt.Println(n)", false},
+		{"4", "n := \"ten\"", true},
+		{"5", "func double(x int) int {\nreturn x\n}", true},
+		{"6", "fmt.Println(missing)", true},
+		{"7", "s := strings.Repeat(\"a\", 2)", false},
+	}
+	for _, block := range blocks {
+		if added := program.Add(block.id, block.src); added == block.skipped {
+			t.Fatalf("block %s: added %v, skipped %v", block.id, added, program.Skipped())
+		}
+	}
+	var skipped []string
+	for _, s := range program.Skipped() {
+		skipped = append(skipped, s.BlockID)
+	}
+	if !slices.Equal(skipped, []string{"4", "5", "6"}) {
+		t.Fatalf("got skipped blocks %v", program.Skipped())
+	}
 
-			fmt.Printf("code for %s: %s", sidx, code)
+	code, err := program.Main()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = typeCheck(code); err != nil {
+		t.Fatalf("exported program doesn't compile: %v\n%s", err, code)
+	}
+	for _, part := range []string{"n = 10", "_ = s", "//   4: variable n", `"strings"`} {
+		if !strings.Contains(code, part) {
+			t.Fatalf("program has no %q:\n%s", part, code)
 		}
 	}
+
+	files, err := program.Package("notebook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(files["notebook_test.go"], "func TestNotebook(") ||
+		strings.Contains(files["notebook_test.go"], "func main(") ||
+		!strings.Contains(files["notebook.go"], "func double(") || !strings.HasPrefix(files["go.mod"], "module notebook") {
+		t.Fatalf("unexpected package layout: %v", files)
+	}
+	if _, err = program.Package("main"); !errors.Is(err, ErrPackageName) {
+		t.Fatalf("package main is accepted: %v", err)
+	}
 }
 
-func TestStruct(t *testing.T) {
-	type StructCase struct {
-		sources  []string
-		expected error
+func TestTests(t *testing.T) {
+	src := `func TestSum(t *testing.T) {
+	if 1+2 != 3 {
+		t.Error(` + "`1+2\n!= 3`" + `)
 	}
-	cases := []StructCase{
-		// {
-		// 	source: globalSource,
-		// 	expected: nil,
-		// },
-		{
-			sources:  []string{"type AAA struct {\na int\nb string\n}\na:=AAA{1,\"dheit\"}", "fmt.Println(a)"},
-			expected: nil,
-		},
+}
+func Test(t *testing.T) {}
+func TestMain(m *testing.M) {}
+func Testify(t *testing.T) {}
+func TestHelper(t *testing.T, n int) {}
+func BenchmarkSum(b *testing.B) {}
+func Benchmark_sum(b *testing.B) {}
+x := 1`
+	block := NewBlock("1", src, NewKernelTypes())
+	err := block.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(block.Tests(), []string{"TestSum", "Test"}) ||
+		!slices.Equal(block.Benchmarks(), []string{"BenchmarkSum", "Benchmark_sum"}) {
+		t.Fatalf("got tests %v and benchmarks %v", block.Tests(), block.Benchmarks())
 	}
 
-	for _, testCase := range cases {
-		types := NewKernelTypes()
+	code, err := block.FormTestFunc("at1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = typeCheck(code); err != nil {
+		t.Fatalf("generated code doesn't compile: %v\n%s", err, code)
+	}
+	for _, want := range []string{"/*line block:2*/ if 1+2 != 3", "1+2\n!= 3", "/*line block:13*/ x := 1",
+		`_notedRunTests([]testing.InternalTest{{Name: "TestSum", F: TestSum}, {Name: "Test", F: Test}}`} {
+		if !strings.Contains(code, want) {
+			t.Errorf("no %q in generated code:\n%s", want, code)
+		}
+	}
+	if strings.Contains(code, "/*line block:4*/") {
+		t.Errorf("line directive inside a raw string:\n%s", code)
+	}
 
-		for idx, source := range testCase.sources {
-			sidx := strconv.Itoa(idx)
-			block := NewBlock(sidx, source, types)
+	plain := NewBlock("2", "x := 2", NewKernelTypes())
+	_ = plain.Parse()
+	if _, err = plain.FormTestFunc("at1"); !errors.Is(err, ErrNoTests) {
+		t.Fatalf("block without tests is accepted: %v", err)
+	}
+}
 
-			err := block.Parse()
+func TestMagics(t *testing.T) {
+	magics, err := NewMagics(&configs.MagicsConfig{Enabled: []string{"time", "timeit", "env", "reset", "who"},
+		Env: []string{"TZ"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kt := NewKernelTypes()
+	first := NewBlock("1", "n := 1\ns := \"a\"", kt)
+	if err = first.Parse(); err != nil {
+		t.Fatal(err)
+	}
 
-			if !errors.Is(err, testCase.expected) {
-				t.Fatalf("testparse got error %v, expected %v \n", err, testCase.expected)
-			}
+	src := `%%time
+%env TZ=UTC
+// комментарий
+%who
+%who string
+x := n + 1
+%time y := x * 2 // до конца строки
+%timeit z := y + 1
+fmt.Println(x, y, ` + "`%time\n`" + `)`
+	block := NewBlock("2", src, kt)
+	block.SetMagics(magics)
+	if err = block.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	code := block.FormExportFunc("at1")
+	if err = typeCheck(code); err != nil {
+		t.Fatalf("generated code doesn't compile: %v\n%s", err, code)
+	}
+	for _, want := range []string{`_notedSetenv("TZ", "UTC")`, `fmt.Print("n\tint\ns\tstring\n")`,
+		`fmt.Print("s\tstring\n")`, "_notedTic()\n\ty := x * 2\n\t_notedToc()", "_notedTimeit(func() { _ = y + 1 })",
+		"`%time\n`)\n\t_notedToc()\n", `varsMap["y"] = y`} {
+		if !strings.Contains(code, want) {
+			t.Errorf("no %q in generated code:\n%s", want, code)
+		}
+	}
 
-			code := block.FormExportFunc("1")
+	reset := NewBlock("3", "%reset -f\n%who\nw := 1", kt)
+	if err = reset.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	code = reset.FormExportFunc("at1")
+	if !strings.Contains(code, "clear(*funcMap)") || !strings.Contains(code, "Interactive namespace is empty") {
+		t.Errorf("kernel is not reset:\n%s", code)
+	}
+	if dump := dumpKernelTypes(kt); !strings.Contains(dump, "var n ") {
+		t.Errorf("kernel types are reset before the block ran:\n%s", dump)
+	}
+	reset.Commit()
+	if dump := dumpKernelTypes(kt); strings.Contains(dump, "var n ") || !strings.Contains(dump, "var w ") {
+		t.Errorf("kernel types are not reset:\n%s", dump)
+	}
+	broken := NewBlock("5", "%reset\nz := w +", kt)
+	if err = broken.Parse(); err == nil {
+		t.Error("broken block is parsed")
+	}
+	if _, ok := kt.VarType("w"); !ok {
+		t.Error("failed block reset the kernel types")
+	}
 
-			fmt.Printf("code for %s: %s", sidx, code)
+	cases := map[string]error{
+		"%timeout 1":                 ErrUnknownMagic,
+		"%%timeit\nx := 1":           ErrBadMagic,
+		"x := 1\n%%time":             ErrBadMagic,
+		"x := 1\n%reset":             ErrBadMagic,
+		"%env HOME=/":                ErrBadMagic,
+		"%time":                      ErrBadMagic,
+		"%time _notedos.Exit(1)":     ErrReservedName,
+		"_notedSetenv(\"A\", \"b\")": ErrReservedName,
+	}
+	for src, want := range cases {
+		bad := NewBlock("4", src, NewKernelTypes())
+		bad.SetMagics(magics)
+		if err = bad.Parse(); !errors.Is(err, want) {
+			t.Errorf("%q: expected %v, got %v", src, want, err)
 		}
 	}
+
+	plain := PlainMagics("%%time\n%time y := 2\n%who\nfmt.Println(y)")
+	if plain != "// %%time\ny := 2\n// %who\nfmt.Println(y)" {
+		t.Errorf("unexpected plain block %q", plain)
+	}
 }
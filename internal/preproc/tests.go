@@ -0,0 +1,228 @@
+package preproc
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/scanner"
+	"go/token"
+	"go/types"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// BlockFile - имя файла в позициях кода блока при запуске тестов: сообщения t.Errorf и паники
+// ссылаются на block:строка, где строка считается от начала блока
+const BlockFile = "block"
+
+// testImports - пакеты, которые нужны только testRunner: под своими именами они недоступны коду блока
+const testImports = `
+import (
+	_notedio "io"
+	_notedruntime "runtime"
+)
+`
+
+var ErrNoTests = errors.New("block has no test or benchmark functions")
+
+// testRunner выполняет тесты и бенчмарки блока через testing.MainStart. testDeps - неэкспортируемый
+// интерфейс пакета testing, поэтому его реализация повторяет testing/internal/testdeps той версии Go,
+// которой собираются ядро и плагины. Флаги testing общие для всего ядра: на время запуска они
+// сбрасываются к значениям по умолчанию. Паника в тесте уронила бы ядро, поэтому она становится
+// ошибкой теста
+const testRunner = `
+//line noted.go:1
+type _notedCorpusEntry = struct {
+	Parent     string
+	Path       string
+	Data       []byte
+	Values     []any
+	Generation int
+	IsSeed     bool
+}
+
+type _notedDeps struct{}
+
+func (_notedDeps) ImportPath() string                        { return "" }
+func (_notedDeps) ModulePath() string                        { return "" }
+func (_notedDeps) MatchString(_, _ string) (bool, error)     { return true, nil }
+func (_notedDeps) SetPanicOnExit0(bool)                      {}
+func (_notedDeps) StartCPUProfile(_notedio.Writer) error     { return errors.New("profiling is not supported") }
+func (_notedDeps) StopCPUProfile()                           {}
+func (_notedDeps) StartTestLog(_notedio.Writer)              {}
+func (_notedDeps) StopTestLog() error                        { return nil }
+func (_notedDeps) WriteProfileTo(string, _notedio.Writer, int) error { return nil }
+func (_notedDeps) CoordinateFuzzing(time.Duration, int64, time.Duration, int64, int, []_notedCorpusEntry,
+	[]reflect.Type, string, string) error {
+	return errors.New("fuzzing is not supported")
+}
+func (_notedDeps) RunFuzzWorker(func(_notedCorpusEntry) error) error {
+	return errors.New("fuzzing is not supported")
+}
+func (_notedDeps) ReadCorpus(string, []reflect.Type) ([]_notedCorpusEntry, error) { return nil, nil }
+func (_notedDeps) CheckCorpus([]any, []reflect.Type) error                       { return nil }
+func (_notedDeps) ResetCoverage()                                                {}
+func (_notedDeps) SnapshotCoverage()                                             {}
+func (_notedDeps) InitRuntimeCoverage() (string, func(string, string) (string, error), func() float64) {
+	return "", nil, nil
+}
+
+func _notedRunTests(tests []testing.InternalTest, benchmarks []testing.InternalBenchmark) {
+	testing.Init()
+	if !flag.Parsed() {
+		_ = flag.CommandLine.Parse(nil)
+	}
+	saved := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		if strings.HasPrefix(f.Name, "test.") {
+			saved[f.Name] = f.Value.String()
+			_ = f.Value.Set(f.DefValue)
+		}
+	})
+	defer func() {
+		for name, value := range saved {
+			_ = flag.Set(name, value)
+		}
+	}()
+	_ = flag.Set("test.v", "true")
+	_ = flag.Set("test.benchmem", "true")
+
+	for idx, test := range tests {
+		tests[idx].F = func(t *testing.T) {
+			defer func() {
+				if r := recover(); r != nil {
+					_notedPanicked(t, r)
+				}
+			}()
+			test.F(t)
+		}
+	}
+	for idx, bench := range benchmarks {
+		benchmarks[idx].F = func(b *testing.B) {
+			defer func() {
+				if r := recover(); r != nil {
+					_notedPanicked(b, r)
+				}
+			}()
+			bench.F(b)
+		}
+	}
+	if len(tests) != 0 {
+		testing.MainStart(_notedDeps{}, tests, nil, nil, nil).Run()
+	}
+	if len(benchmarks) != 0 {
+		_ = flag.Set("test.bench", ".")
+		testing.MainStart(_notedDeps{}, nil, benchmarks, nil, nil).Run()
+	}
+}
+
+// _notedPanicked выводит панику как сообщение теста со строкой блока, где она случилась
+func _notedPanicked(tb testing.TB, r any) {
+	pcs := make([]uintptr, 64)
+	frames := _notedruntime.CallersFrames(pcs[:_notedruntime.Callers(3, pcs)])
+	for more := true; more; {
+		var frame _notedruntime.Frame
+		frame, more = frames.Next()
+		if frame.File == "` + BlockFile + `" || strings.HasSuffix(frame.File, "/` + BlockFile + `") {
+			fmt.Printf("    ` + BlockFile + `:%d: panic: %v\n", frame.Line, r)
+			tb.Fail()
+			return
+		}
+	}
+	tb.Errorf("panic: %v", r)
+}
+`
+
+// Tests возвращает тесты блока: функции TestX(t *testing.T), как их находит go test
+func (b *Block) Tests() []string {
+	return b.tnames
+}
+
+// Benchmarks возвращает бенчмарки блока: функции BenchmarkX(b *testing.B)
+func (b *Block) Benchmarks() []string {
+	return b.bnames
+}
+
+// FormTestFunc собирает блок как FormExportFunc, но после инструкций блока запускает его тесты
+// и бенчмарки. Их вывод - вывод go test -v -benchmem, позиции в нём даны строками блока
+func (b *Block) FormTestFunc(attempt string) (string, error) {
+	if len(b.tnames) == 0 && len(b.bnames) == 0 {
+		return "", ErrNoTests
+	}
+	code := b.formExport(attempt, true)
+	if code == "" {
+		return "", errors.New("error forming block code")
+	}
+	return code, nil
+}
+
+// addTest запоминает функцию, если go test счёл бы её тестом или бенчмарком
+func (b *Block) addTest(fd *ast.FuncDecl) {
+	switch {
+	case isTestFunc(fd, "Test", "*testing.T"):
+		b.tnames = append(b.tnames, fd.Name.Name)
+	case isTestFunc(fd, "Benchmark", "*testing.B"):
+		b.bnames = append(b.bnames, fd.Name.Name)
+	}
+}
+
+func isTestFunc(fd *ast.FuncDecl, prefix string, param string) bool {
+	rest, ok := strings.CutPrefix(fd.Name.Name, prefix)
+	if !ok || fd.Type.Results.NumFields() != 0 || fd.Type.Params.NumFields() != 1 {
+		return false
+	}
+	// TestMain и Testify - не тесты: после префикса не должно быть строчной буквы
+	if r, _ := utf8.DecodeRuneInString(rest); rest != "" && unicode.IsLower(r) {
+		return false
+	}
+	return types.ExprString(fd.Type.Params.List[0].Type) == param
+}
+
+// testCalls - вызов testRunner с тестами и бенчмарками блока
+func (b *Block) testCalls() string {
+	var sb strings.Builder
+	sb.WriteString("_notedRunTests([]testing.InternalTest{")
+	for _, name := range b.tnames {
+		fmt.Fprintf(&sb, "{Name: %q, F: %s}, ", name, name)
+	}
+	sb.WriteString("}, []testing.InternalBenchmark{")
+	for _, name := range b.bnames {
+		fmt.Fprintf(&sb, "{Name: %q, F: %s}, ", name, name)
+	}
+	sb.WriteString("})\n")
+	return sb.String()
+}
+
+// lineDirectives возвращает для строк блока директивы /*line block:N*/, которые сохраняют номера строк
+// после форматирования. Строки, начало которых попадает внутрь многострочной raw-строки
+// или комментария, не трогаются: директива изменила бы их содержимое
+func (b *Block) lineDirectives() map[int]string {
+	fset := token.NewFileSet()
+	file := fset.AddFile(BlockFile, -1, len(b.content))
+	var s scanner.Scanner
+	s.Init(file, []byte(b.content), nil, scanner.ScanComments)
+
+	inside := make(map[int]bool)
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok == token.SEMICOLON || !strings.Contains(lit, "\n") {
+			continue
+		}
+		line := file.Line(pos)
+		for i := range strings.Count(lit, "\n") {
+			inside[line+i+1] = true
+		}
+	}
+
+	directives := make(map[int]string)
+	for i := range strings.Count(b.content, "\n") + 1 {
+		if line := i + 1; !inside[line] {
+			directives[line] = fmt.Sprintf("/*line %s:%d*/", BlockFile, line)
+		}
+	}
+	return directives
+}
@@ -0,0 +1,128 @@
+package preproc
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Каждый плагин - отдельный пакет main, поэтому типы ядра объявлены в нём заново, и *main.T
+// одного блока для другого блока - другой тип: обычное приведение из funcMap и varMap паникует.
+// Значения с такими типами достаются через _notedAs: он сверяет устройство типов через reflect
+// и, если оно совпадает, берёт то же значение уже со своим типом
+
+// valueImports - пакеты, которые нужны только valueRunner
+const valueImports = `
+import (
+	_notedreflect "reflect"
+	_notedstrings "strings"
+)
+`
+
+const valueRunner = `
+//line noted.go:1
+func _notedAs[T any](name string, v any) T {
+	if t, ok := v.(T); ok {
+		return t
+	}
+	var zero T
+	to := _notedreflect.TypeOf(&zero).Elem()
+	if v == nil {
+		switch to.Kind() {
+		case _notedreflect.Interface, _notedreflect.Pointer, _notedreflect.Map, _notedreflect.Slice,
+			_notedreflect.Func, _notedreflect.Chan:
+			return zero
+		}
+	}
+	if v == nil || !_notedSameType(_notedreflect.TypeOf(v), to, make(map[[2]_notedreflect.Type]bool)) {
+		panic(fmt.Sprintf("%s is %T, not %s", name, v, to))
+	}
+	from := _notedreflect.New(_notedreflect.TypeOf(v))
+	from.Elem().Set(_notedreflect.ValueOf(v))
+	return *(*T)(from.UnsafePointer())
+}
+
+func _notedSameType(a _notedreflect.Type, b _notedreflect.Type, seen map[[2]_notedreflect.Type]bool) bool {
+	if a == b {
+		return true
+	}
+	if a.Kind() != b.Kind() || a.Name() != b.Name() || _notedPkg(a) != _notedPkg(b) || a.Size() != b.Size() {
+		return false
+	}
+	key := [2]_notedreflect.Type{a, b}
+	if seen[key] {
+		return true
+	}
+	seen[key] = true
+	switch a.Kind() {
+	case _notedreflect.Array:
+		return a.Len() == b.Len() && _notedSameType(a.Elem(), b.Elem(), seen)
+	case _notedreflect.Chan:
+		return a.ChanDir() == b.ChanDir() && _notedSameType(a.Elem(), b.Elem(), seen)
+	case _notedreflect.Pointer, _notedreflect.Slice:
+		return _notedSameType(a.Elem(), b.Elem(), seen)
+	case _notedreflect.Map:
+		return _notedSameType(a.Key(), b.Key(), seen) && _notedSameType(a.Elem(), b.Elem(), seen)
+	case _notedreflect.Struct:
+		if a.NumField() != b.NumField() {
+			return false
+		}
+		for i := range a.NumField() {
+			fa, fb := a.Field(i), b.Field(i)
+			if fa.Name != fb.Name || fa.Offset != fb.Offset || fa.Anonymous != fb.Anonymous || fa.Tag != fb.Tag ||
+				!_notedSameType(fa.Type, fb.Type, seen) {
+				return false
+			}
+		}
+	case _notedreflect.Func:
+		if a.NumIn() != b.NumIn() || a.NumOut() != b.NumOut() || a.IsVariadic() != b.IsVariadic() {
+			return false
+		}
+		for i := range a.NumIn() {
+			if !_notedSameType(a.In(i), b.In(i), seen) {
+				return false
+			}
+		}
+		for i := range a.NumOut() {
+			if !_notedSameType(a.Out(i), b.Out(i), seen) {
+				return false
+			}
+		}
+	case _notedreflect.Interface:
+		if a.NumMethod() != b.NumMethod() {
+			return false
+		}
+		for i := range a.NumMethod() {
+			ma, mb := a.Method(i), b.Method(i)
+			if ma.Name != mb.Name || !_notedSameType(ma.Type, mb.Type, seen) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// _notedPkg - пакет типа; пакеты main всех плагинов считаются одним пакетом блоков
+func _notedPkg(t _notedreflect.Type) string {
+	if _notedstrings.HasPrefix(t.PkgPath(), "plugin/unnamed-") {
+		return "main"
+	}
+	return t.PkgPath()
+}
+`
+
+// usesKernelTypes сообщает, что тип tp ссылается на типы ядра и его значения нужно доставать через _notedAs
+func (b *Block) usesKernelTypes(tp string) bool {
+	return slices.ContainsFunc(scanIdents(tp), func(name string) bool {
+		_, ok := b.types.types[name]
+		return ok
+	})
+}
+
+// reuse возвращает выражение, которым блок достаёт name из mapName
+func (b *Block) reuse(mapName string, name string, tp string) string {
+	if b.usesKernelTypes(tp) {
+		b.valueCode = true
+		return fmt.Sprintf("_notedAs[%s](%q, %s[%q])", tp, name, mapName, name)
+	}
+	return fmt.Sprintf("%s[\"%s\"].(%s)", mapName, name, tp)
+}
@@ -1,72 +1,420 @@
 package rabbit
 
 import (
+	"context"
+	"errors"
 	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/dnonakolesax/noted-runner/internal/configs"
 	"github.com/dnonakolesax/noted-runner/internal/logger"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+var (
+	ErrNotConnected   = errors.New("rabbitmq is not connected")
+	errConsumerClosed = errors.New("consumer channel closed")
+)
+
+// Topology - что раннер объявляет в брокере при каждом (пере)подключении
+type Topology struct {
+	Exchange string
+	Queue    string
+	Keys     []string
+	// Shared - общие для всех реплик очереди (имя -> ключ), например очередь мёртвых писем
+	Shared map[string]string
+}
+
+// RabbitQueue держит соединение с RabbitMQ: переподключается с экспоненциальной задержкой,
+// заново объявляет обменник, очередь и все привязки и продолжает отдавать сообщения в Queue.
+// Сообщения нужно подтверждать вручную
 type RabbitQueue struct {
-	conn    *amqp.Connection
-	channel *amqp.Channel
-	logger  *slog.Logger
-	Queue   <-chan amqp.Delivery
+	address  string
+	topology Topology
+	config   *configs.RabbitConfig
+	dial     Dialer
+	healthy  *atomic.Bool
+	logger   *slog.Logger
+
+	mu      sync.Mutex
+	conn    Connection
+	channel Channel
+	keys    map[string]bool
+
+	deliveries chan amqp.Delivery
+	done       chan struct{}
+	closeOnce  sync.Once
+	Queue      <-chan amqp.Delivery
+}
+
+func NewRabbit(address string, topology Topology, config *configs.RabbitConfig, dial Dialer,
+	healthy *atomic.Bool, rmqLogger *slog.Logger) *RabbitQueue {
+	keys := make(map[string]bool, len(topology.Keys))
+	for _, key := range topology.Keys {
+		keys[key] = true
+	}
+	deliveries := make(chan amqp.Delivery)
+	rq := &RabbitQueue{
+		address:    address,
+		topology:   topology,
+		config:     config,
+		dial:       dial,
+		healthy:    healthy,
+		logger:     rmqLogger,
+		keys:       keys,
+		deliveries: deliveries,
+		done:       make(chan struct{}),
+		Queue:      deliveries,
+	}
+	go rq.supervise()
+	return rq
+}
+
+// Bind привязывает очередь реплики к ключу. Без соединения привязка запоминается
+// и будет объявлена при переподключении
+func (rq *RabbitQueue) Bind(key string) error {
+	rq.mu.Lock()
+	defer rq.mu.Unlock()
+
+	rq.keys[key] = true
+	if rq.channel == nil {
+		return nil
+	}
+	return rq.channel.QueueBind(rq.topology.Queue, key, rq.topology.Exchange, false, nil)
 }
 
-func NewRabbit(address string, chanName string, rmqLogger *slog.Logger) (*RabbitQueue, error) {
-	conn, err := amqp.Dial(address)
+func (rq *RabbitQueue) Unbind(key string) error {
+	rq.mu.Lock()
+	defer rq.mu.Unlock()
+
+	delete(rq.keys, key)
+	if rq.channel == nil {
+		return nil
+	}
+	return rq.channel.QueueUnbind(rq.topology.Queue, key, rq.topology.Exchange, nil)
+}
+
+func (rq *RabbitQueue) Publish(key string, body []byte) error {
+	rq.mu.Lock()
+	defer rq.mu.Unlock()
+
+	if rq.channel == nil {
+		return ErrNotConnected
+	}
+	return rq.channel.PublishWithContext(context.Background(), rq.topology.Exchange, key, false, false,
+		amqp.Publishing{ContentType: "text/plain", Body: body})
+}
+
+// Send публикует сообщение в обменник раннера
+func (rq *RabbitQueue) Send(key string, msg amqp.Publishing) error {
+	rq.mu.Lock()
+	defer rq.mu.Unlock()
+
+	if rq.channel == nil {
+		return ErrNotConnected
+	}
+	return rq.channel.PublishWithContext(context.Background(), rq.topology.Exchange, key, false, false, msg)
+}
+
+// DeclareQueue объявляет долговечную очередь, привязанную к ключу, например очередь команд ядра.
+// Сообщения ждут в ней, пока ядро не начнёт её слушать
+func (rq *RabbitQueue) DeclareQueue(name string, key string) error {
+	rq.mu.Lock()
+	defer rq.mu.Unlock()
+
+	if rq.channel == nil {
+		return ErrNotConnected
+	}
+	_, err := rq.channel.QueueDeclare(name, true, false, false, false, rq.queueArgs())
 	if err != nil {
-		rmqLogger.Error("unable to open connect to RabbitMQ server", logger.LogError(err))
-		return nil, err
+		return err
 	}
+	return rq.channel.QueueBind(name, key, rq.topology.Exchange, false, nil)
+}
+
+func (rq *RabbitQueue) DeleteQueue(name string) error {
+	rq.mu.Lock()
+	defer rq.mu.Unlock()
+
+	if rq.channel == nil {
+		return ErrNotConnected
+	}
+	_, err := rq.channel.QueueDelete(name, false, false, false)
+	return err
+}
+
+// Peek возвращает до limit сообщений из головы очереди, не забирая их: сообщения читаются
+// в отдельном канале без подтверждения и возвращаются в очередь при его закрытии
+func (rq *RabbitQueue) Peek(name string, limit int) ([]amqp.Delivery, error) {
+	rq.mu.Lock()
+	conn := rq.conn
+	rq.mu.Unlock()
 
+	if conn == nil {
+		return nil, ErrNotConnected
+	}
 	ch, err := conn.Channel()
 	if err != nil {
-		rmqLogger.Error("failed to open a channel", logger.LogError(err))
 		return nil, err
 	}
+	defer func() {
+		_ = ch.Close()
+	}()
+
+	var msgs []amqp.Delivery
+	for len(msgs) < limit {
+		msg, ok, err := ch.Get(name, false)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+func (rq *RabbitQueue) Purge(name string) (int, error) {
+	rq.mu.Lock()
+	defer rq.mu.Unlock()
+
+	if rq.channel == nil {
+		return 0, ErrNotConnected
+	}
+	return rq.channel.QueuePurge(name, false)
+}
+
+// QueueName - очередь реплики, в неё же ядра присылают ответы на команды
+func (rq *RabbitQueue) QueueName() string {
+	return rq.topology.Queue
+}
+
+func (rq *RabbitQueue) Close() {
+	rq.closeOnce.Do(func() {
+		close(rq.done)
+		rq.disconnect()
+	})
+}
+
+func (rq *RabbitQueue) supervise() {
+	defer close(rq.deliveries)
+
+	attempt := 0
+	for {
+		messages, closed, err := rq.connect()
+		if err != nil {
+			rq.disconnect()
+			attempt++
+			delay := rq.backoffDelay(attempt)
+			rq.logger.Error("error connecting to RabbitMQ", logger.LogError(err),
+				slog.Duration("retry in", delay))
+			if !rq.sleep(delay) {
+				return
+			}
+			continue
+		}
+
+		attempt = 0
+		rq.healthy.Store(true)
+		rq.logger.Info("RabbitMQ connection established")
+
+		err = rq.forward(messages, closed)
+		rq.healthy.Store(false)
+		rq.disconnect()
+		if err == nil {
+			return
+		}
+		rq.logger.Error("RabbitMQ connection lost", logger.LogError(err))
+	}
+}
+
+func (rq *RabbitQueue) connect() (<-chan amqp.Delivery, <-chan *amqp.Error, error) {
+	conn, err := rq.dial(rq.address)
+	if err != nil {
+		return nil, nil, err
+	}
+	rq.mu.Lock()
+	defer rq.mu.Unlock()
+	rq.conn = conn
 
-	q, err := ch.QueueDeclare(
-		chanName, // name
-		false,    // durable
-		false,    // delete when unused
-		false,    // exclusive
-		false,    // no-wait
-		nil,      // arguments
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, nil, err
+	}
+	rq.channel = ch
+
+	closed := make(chan *amqp.Error, 2)
+	conn.NotifyClose(forwardClose(closed))
+	ch.NotifyClose(forwardClose(closed))
+
+	err = ch.ExchangeDeclare(
+		rq.topology.Exchange, // name
+		"topic",              // type
+		true,                 // durable
+		false,                // auto-deleted
+		false,                // internal
+		false,                // no-wait
+		nil,                  // arguments
 	)
 	if err != nil {
-		rmqLogger.Error("failed to declare a queue", logger.LogError(err))
-		return nil, err
+		return nil, nil, err
+	}
+
+	err = ch.Qos(rq.config.Prefetch, 0, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, err = ch.QueueDeclare(
+		rq.topology.Queue, // name
+		true,              // durable
+		false,             // delete when unused
+		false,             // exclusive
+		false,             // no-wait
+		rq.queueArgs(),    // arguments
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for key := range rq.keys {
+		err = ch.QueueBind(rq.topology.Queue, key, rq.topology.Exchange, false, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for name, key := range rq.topology.Shared {
+		_, err = ch.QueueDeclare(name, true, false, false, false, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = ch.QueueBind(name, key, rq.topology.Exchange, false, nil)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
 
 	messages, err := ch.Consume(
-		q.Name, // queue
-		"",     // consumer
-		true,   // auto-ack
-		false,  // exclusive
-		false,  // no-local
-		false,  // no-wait
-		nil,    // args
+		rq.topology.Queue, // queue
+		"",                // consumer
+		false,             // auto-ack
+		false,             // exclusive
+		false,             // no-local
+		false,             // no-wait
+		nil,               // args
 	)
 	if err != nil {
-		rmqLogger.Error("failed to register a consumer", logger.LogError(err))
-		return nil, err
+		return nil, nil, err
 	}
-	return &RabbitQueue{conn: conn, channel: ch, Queue: messages}, nil
+	return messages, closed, nil
 }
 
-func (rq *RabbitQueue) Close() {
+func (rq *RabbitQueue) queueArgs() amqp.Table {
+	if rq.config.QueueExpires <= 0 {
+		return nil
+	}
+	return amqp.Table{"x-expires": rq.config.QueueExpires.Milliseconds()}
+}
+
+func (rq *RabbitQueue) forward(messages <-chan amqp.Delivery, closed <-chan *amqp.Error) error {
+	for {
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				if rq.closing() {
+					return nil
+				}
+				return errConsumerClosed
+			}
+			select {
+			case rq.deliveries <- msg:
+			case <-rq.done:
+				return nil
+			}
+		case err := <-closed:
+			if rq.closing() {
+				return nil
+			}
+			return err
+		case <-rq.done:
+			return nil
+		}
+	}
+}
+
+func (rq *RabbitQueue) disconnect() {
+	rq.mu.Lock()
+	defer rq.mu.Unlock()
+
 	if rq.channel != nil {
 		err := rq.channel.Close()
-		if err != nil {
+		if err != nil && !errors.Is(err, amqp.ErrClosed) {
 			rq.logger.Error("error closing rq chan", logger.LogError(err))
 		}
+		rq.channel = nil
 	}
 	if rq.conn != nil {
 		err := rq.conn.Close()
-		if err != nil {
+		if err != nil && !errors.Is(err, amqp.ErrClosed) {
 			rq.logger.Error("error closing rq conn", logger.LogError(err))
 		}
+		rq.conn = nil
 	}
 }
+
+func (rq *RabbitQueue) closing() bool {
+	select {
+	case <-rq.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (rq *RabbitQueue) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-rq.done:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func (rq *RabbitQueue) backoffDelay(attempt int) time.Duration {
+	// экспоненциальная задержка: Base * 2^(attempt-1), но не больше MaxDelay
+	d := rq.config.ReconnectBaseDelay
+	for i := 1; i < attempt && d < rq.config.ReconnectMaxDelay; i++ {
+		d *= 2
+	}
+	if d > rq.config.ReconnectMaxDelay {
+		d = rq.config.ReconnectMaxDelay
+	}
+	// джиттер ~±20%, криптостойкость не нужна
+	jitterFrac := 0.2
+	j := time.Duration(float64(d) * (rand.Float64()*2*jitterFrac - jitterFrac)) //nolint:gosec // см выше ^
+	return d + j
+}
+
+// forwardClose превращает закрытие без ошибки (штатное) в ErrClosed, чтобы оба уведомления
+// соединения и канала можно было слушать через один канал
+func forwardClose(out chan<- *amqp.Error) chan *amqp.Error {
+	in := make(chan *amqp.Error, 1)
+	go func() {
+		err, ok := <-in
+		if !ok {
+			err = amqp.ErrClosed
+		}
+		select {
+		case out <- err:
+		default:
+		}
+	}()
+	return in
+}
@@ -0,0 +1,192 @@
+package rabbit_test
+
+import (
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dnonakolesax/noted-runner/internal/configs"
+	"github.com/dnonakolesax/noted-runner/internal/rabbit"
+	"github.com/dnonakolesax/noted-runner/internal/rabbit/rabbittest"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	exchange = "noted-kernels"
+	queue    = "noted-runner.test"
+)
+
+var config = &configs.RabbitConfig{
+	ReconnectBaseDelay: 5 * time.Millisecond,
+	ReconnectMaxDelay:  20 * time.Millisecond,
+	Prefetch:           8,
+	QueueExpires:       time.Minute,
+}
+
+func newQueue(t *testing.T, server *rabbittest.Server, healthy *atomic.Bool) *rabbit.RabbitQueue {
+	t.Helper()
+	rq := rabbit.NewRabbit("amqp://test", rabbit.Topology{Exchange: exchange, Queue: queue, Keys: []string{"owner.*"}},
+		config, server.Dial, healthy, slog.Default())
+	t.Cleanup(rq.Close)
+	return rq
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func receive(t *testing.T, rq *rabbit.RabbitQueue) amqp.Delivery {
+	t.Helper()
+	select {
+	case msg := <-rq.Queue:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for a message")
+		return amqp.Delivery{}
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	server := rabbittest.NewServer()
+	healthy := &atomic.Bool{}
+	rq := newQueue(t, server, healthy)
+	waitFor(t, "connection", healthy.Load)
+
+	if server.QueueArgs(queue)["x-expires"] != time.Minute.Milliseconds() {
+		t.Fatalf("queue is declared without x-expires: %v", server.QueueArgs(queue))
+	}
+	if err := rq.Bind("kernel.1"); err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+
+	server.Publish(exchange, "kernel.1", amqp.Publishing{Body: []byte("first")})
+	msg := receive(t, rq)
+	if string(msg.Body) != "first" {
+		t.Fatalf("unexpected message %q", msg.Body)
+	}
+
+	server.Restart()
+	waitFor(t, "reconnection", healthy.Load)
+
+	// неподтверждённое до падения сообщение приходит снова
+	msg = receive(t, rq)
+	if string(msg.Body) != "first" || !msg.Redelivered {
+		t.Fatalf("expected redelivery of the first message, got %q (redelivered %v)", msg.Body, msg.Redelivered)
+	}
+	if err := msg.Ack(false); err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+
+	// привязки восстановлены после переподключения
+	server.Publish(exchange, "kernel.1", amqp.Publishing{Body: []byte("second")})
+	msg = receive(t, rq)
+	if string(msg.Body) != "second" {
+		t.Fatalf("unexpected message %q", msg.Body)
+	}
+	if err := msg.Ack(false); err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+	if server.Acked() != 2 {
+		t.Fatalf("expected 2 acks, got %d", server.Acked())
+	}
+}
+
+func TestBrokerDown(t *testing.T) {
+	server := rabbittest.NewServer()
+	server.SetDown(true)
+	healthy := &atomic.Bool{}
+	rq := newQueue(t, server, healthy)
+
+	time.Sleep(50 * time.Millisecond)
+	if healthy.Load() {
+		t.Fatalf("healthy while the broker is down")
+	}
+	if err := rq.Publish("owner.1", []byte("a")); !errors.Is(err, rabbit.ErrNotConnected) {
+		t.Fatalf("expected ErrNotConnected, got %v", err)
+	}
+	if err := rq.Bind("kernel.1"); err != nil {
+		t.Fatalf("bind without connection: %s", err.Error())
+	}
+
+	server.SetDown(false)
+	waitFor(t, "connection", healthy.Load)
+	if !server.Bound(queue, exchange, "kernel.1") || !server.Bound(queue, exchange, "owner.*") {
+		t.Fatalf("bindings made while disconnected were not declared")
+	}
+
+	if err := rq.Unbind("kernel.1"); err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+	server.SetDown(true)
+	waitFor(t, "health flag to drop", func() bool { return !healthy.Load() })
+	server.SetDown(false)
+	waitFor(t, "reconnection", func() bool { return healthy.Load() && server.Bound(queue, exchange, "owner.*") })
+	if server.Bound(queue, exchange, "kernel.1") {
+		t.Fatalf("removed binding was restored")
+	}
+}
+
+func TestClose(t *testing.T) {
+	server := rabbittest.NewServer()
+	healthy := &atomic.Bool{}
+	rq := newQueue(t, server, healthy)
+	waitFor(t, "connection", healthy.Load)
+
+	rq.Close()
+	select {
+	case _, ok := <-rq.Queue:
+		if ok {
+			t.Fatalf("unexpected message after close")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("queue is not closed")
+	}
+	if healthy.Load() {
+		t.Fatalf("healthy after close")
+	}
+}
+
+func TestPeekAndPurgeShared(t *testing.T) {
+	server := rabbittest.NewServer()
+	healthy := &atomic.Bool{}
+	rq := rabbit.NewRabbit("amqp://test", rabbit.Topology{Exchange: exchange, Queue: queue,
+		Shared: map[string]string{"noted-runner.dead-letters": "dead-letter"}},
+		config, server.Dial, healthy, slog.Default())
+	t.Cleanup(rq.Close)
+	waitFor(t, "connection", healthy.Load)
+
+	if !server.Bound("noted-runner.dead-letters", exchange, "dead-letter") {
+		t.Fatalf("shared queue is not bound")
+	}
+	for _, body := range []string{"a", "b", "c"} {
+		if err := rq.Publish("dead-letter", []byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	msgs, err := rq.Peek("noted-runner.dead-letters", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 || string(msgs[0].Body) != "a" || string(msgs[1].Body) != "b" {
+		t.Fatalf("unexpected peek: %v", msgs)
+	}
+	waitFor(t, "peeked messages to return", func() bool { return server.QueueLen("noted-runner.dead-letters") == 3 })
+
+	purged, err := rq.Purge("noted-runner.dead-letters")
+	if err != nil || purged != 3 {
+		t.Fatalf("purged %d: %v", purged, err)
+	}
+	if server.QueueLen("noted-runner.dead-letters") != 0 {
+		t.Fatalf("queue was not purged")
+	}
+}
@@ -0,0 +1,717 @@
+// Package rabbittest - брокер AMQP в памяти процесса для тестов: topic- и default-обменники,
+// очереди с привязками, ручные подтверждения, prefetch и имитация падения брокера
+package rabbittest
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dnonakolesax/noted-runner/internal/rabbit"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var ErrDown = errors.New("rabbittest: broker is down")
+
+const consumerBuffer = 1024
+
+type message struct {
+	exchange    string
+	key         string
+	publishing  amqp.Publishing
+	redelivered bool
+}
+
+type binding struct {
+	exchange string
+	key      string
+}
+
+type queue struct {
+	name      string
+	durable   bool
+	exclusive *conn
+	args      amqp.Table
+	bindings  map[binding]bool
+	pending   []message
+	consumers []*consumer
+	next      int
+}
+
+type consumer struct {
+	tag     string
+	ch      *channel
+	autoAck bool
+	out     chan amqp.Delivery
+}
+
+type Server struct {
+	mu        sync.Mutex
+	down      bool
+	exchanges map[string]string
+	queues    map[string]*queue
+	conns     map[*conn]bool
+	seq       int
+	acked     int
+	nacked    int
+}
+
+func NewServer() *Server {
+	return &Server{exchanges: make(map[string]string), queues: make(map[string]*queue), conns: make(map[*conn]bool)}
+}
+
+// Dial подходит как rabbit.Dialer
+func (s *Server) Dial(_ string) (rabbit.Connection, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.down {
+		return nil, ErrDown
+	}
+	c := &conn{server: s}
+	s.conns[c] = true
+	return c, nil
+}
+
+// Restart имитирует перезапуск брокера: все соединения рвутся с ошибкой, недолговечные очереди пропадают
+func (s *Server) Restart() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.dropConns()
+	for name, q := range s.queues {
+		if !q.durable {
+			delete(s.queues, name)
+		}
+	}
+}
+
+// SetDown рвёт соединения и запрещает новые, пока брокер не поднимут обратно
+func (s *Server) SetDown(down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.down = down
+	if down {
+		s.dropConns()
+	}
+}
+
+// Publish публикует сообщение так, как это сделало бы ядро
+func (s *Server) Publish(exchange string, key string, msg amqp.Publishing) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.route(message{exchange: exchange, key: key, publishing: msg})
+}
+
+// DeclareQueue объявляет очередь и привязывает её к ключу, например чтобы перехватывать публикации раннера
+func (s *Server) DeclareQueue(name string, exchange string, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q := s.declare(name, true, nil, nil)
+	if exchange != "" {
+		q.bindings[binding{exchange: exchange, key: key}] = true
+	}
+}
+
+// Get забирает сообщение из очереди без подписчиков
+func (s *Server) Get(name string) (amqp.Publishing, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q, ok := s.queues[name]
+	if !ok || len(q.pending) == 0 {
+		return amqp.Publishing{}, false
+	}
+	msg := q.pending[0]
+	q.pending = q.pending[1:]
+	return msg.publishing, true
+}
+
+func (s *Server) QueueLen(name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if q, ok := s.queues[name]; ok {
+		return len(q.pending)
+	}
+	return 0
+}
+
+func (s *Server) HasQueue(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.queues[name]
+	return ok
+}
+
+// Consume подписывается на очередь от имени внешнего клиента, например ядра.
+// Сообщения подтверждаются автоматически
+func (s *Server) Consume(name string) (<-chan amqp.Delivery, error) {
+	c, err := s.Dial("")
+	if err != nil {
+		return nil, err
+	}
+	ch, err := c.Channel()
+	if err != nil {
+		return nil, err
+	}
+	return ch.Consume(name, "", true, false, false, false, nil)
+}
+
+func (s *Server) QueueArgs(name string) amqp.Table {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if q, ok := s.queues[name]; ok {
+		return q.args
+	}
+	return nil
+}
+
+func (s *Server) Bound(name string, exchange string, key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q, ok := s.queues[name]
+	return ok && q.bindings[binding{exchange: exchange, key: key}]
+}
+
+func (s *Server) Acked() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.acked
+}
+
+func (s *Server) Nacked() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.nacked
+}
+
+func (s *Server) dropConns() {
+	for c := range s.conns {
+		c.close(&amqp.Error{Code: amqp.ConnectionForced, Reason: "CONNECTION_FORCED - broker forced connection closure", Server: true})
+	}
+}
+
+func (s *Server) declare(name string, durable bool, exclusive *conn, args amqp.Table) *queue {
+	if name == "" {
+		s.seq++
+		name = "amq.gen-" + strconv.Itoa(s.seq)
+	}
+	q, ok := s.queues[name]
+	if !ok {
+		q = &queue{name: name, durable: durable, exclusive: exclusive, args: args, bindings: make(map[binding]bool)}
+		s.queues[name] = q
+	}
+	return q
+}
+
+func (s *Server) route(msg message) {
+	if msg.exchange == "" {
+		if q, ok := s.queues[msg.key]; ok {
+			q.pending = append(q.pending, msg)
+			s.dispatch(q)
+		}
+		return
+	}
+	for _, q := range s.queues {
+		for b := range q.bindings {
+			if b.exchange == msg.exchange && matchTopic(b.key, msg.key) {
+				q.pending = append(q.pending, msg)
+				s.dispatch(q)
+				break
+			}
+		}
+	}
+}
+
+// dispatch раздаёт ожидающие сообщения подписчикам по кругу с учётом prefetch
+func (s *Server) dispatch(q *queue) {
+	for len(q.pending) > 0 {
+		delivered := false
+		for range q.consumers {
+			cons := q.consumers[q.next%len(q.consumers)]
+			q.next++
+			if !cons.autoAck && cons.ch.prefetch > 0 && len(cons.ch.unacked) >= cons.ch.prefetch {
+				continue
+			}
+			if len(cons.out) == cap(cons.out) {
+				continue
+			}
+			msg := q.pending[0]
+			q.pending = q.pending[1:]
+			cons.ch.tag++
+			if !cons.autoAck {
+				cons.ch.unacked[cons.ch.tag] = unacked{queue: q, msg: msg}
+			}
+			cons.out <- delivery(cons, msg)
+			delivered = true
+			break
+		}
+		if !delivered {
+			return
+		}
+	}
+}
+
+func delivery(cons *consumer, msg message) amqp.Delivery {
+	p := msg.publishing
+	return amqp.Delivery{
+		Acknowledger:    cons.ch,
+		Headers:         p.Headers,
+		ContentType:     p.ContentType,
+		ContentEncoding: p.ContentEncoding,
+		DeliveryMode:    p.DeliveryMode,
+		Priority:        p.Priority,
+		CorrelationId:   p.CorrelationId,
+		ReplyTo:         p.ReplyTo,
+		Expiration:      p.Expiration,
+		MessageId:       p.MessageId,
+		Timestamp:       p.Timestamp,
+		Type:            p.Type,
+		UserId:          p.UserId,
+		AppId:           p.AppId,
+		ConsumerTag:     cons.tag,
+		DeliveryTag:     cons.ch.tag,
+		Redelivered:     msg.redelivered,
+		Exchange:        msg.exchange,
+		RoutingKey:      msg.key,
+		Body:            p.Body,
+	}
+}
+
+// matchTopic сопоставляет ключ с шаблоном topic-обменника: * - ровно одно слово, # - ноль и более
+func matchTopic(pattern string, key string) bool {
+	return matchWords(strings.Split(pattern, "."), strings.Split(key, "."))
+}
+
+func matchWords(pattern []string, key []string) bool {
+	if len(pattern) == 0 {
+		return len(key) == 0
+	}
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(key); i++ {
+			if matchWords(pattern[1:], key[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(key) > 0 && matchWords(pattern[1:], key[1:])
+	default:
+		return len(key) > 0 && pattern[0] == key[0] && matchWords(pattern[1:], key[1:])
+	}
+}
+
+type conn struct {
+	server   *Server
+	channels []*channel
+	notify   []chan *amqp.Error
+	closed   bool
+}
+
+func (c *conn) Channel() (rabbit.Channel, error) {
+	c.server.mu.Lock()
+	defer c.server.mu.Unlock()
+
+	if c.closed {
+		return nil, amqp.ErrClosed
+	}
+	ch := &channel{conn: c, unacked: make(map[uint64]unacked)}
+	c.channels = append(c.channels, ch)
+	return ch, nil
+}
+
+func (c *conn) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	c.server.mu.Lock()
+	defer c.server.mu.Unlock()
+
+	if c.closed {
+		close(receiver)
+		return receiver
+	}
+	c.notify = append(c.notify, receiver)
+	return receiver
+}
+
+func (c *conn) Close() error {
+	c.server.mu.Lock()
+	defer c.server.mu.Unlock()
+
+	if c.closed {
+		return amqp.ErrClosed
+	}
+	c.close(nil)
+	return nil
+}
+
+// close закрывает соединение под блокировкой сервера; err == nil - штатное закрытие
+func (c *conn) close(err *amqp.Error) {
+	if c.closed {
+		return
+	}
+	c.closed = true
+	for _, ch := range c.channels {
+		ch.close(err)
+	}
+	for _, receiver := range c.notify {
+		if err != nil {
+			receiver <- err
+		}
+		close(receiver)
+	}
+	for name, q := range c.server.queues {
+		if q.exclusive == c {
+			delete(c.server.queues, name)
+		}
+	}
+	delete(c.server.conns, c)
+}
+
+type unacked struct {
+	queue *queue
+	msg   message
+}
+
+type channel struct {
+	conn      *conn
+	prefetch  int
+	tag       uint64
+	unacked   map[uint64]unacked
+	consumers []*consumer
+	notify    []chan *amqp.Error
+	closed    bool
+}
+
+func (ch *channel) ExchangeDeclare(name, kind string, _, _, _, _ bool, _ amqp.Table) error {
+	s := ch.conn.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+	if existing, ok := s.exchanges[name]; ok && existing != kind {
+		return &amqp.Error{Code: amqp.PreconditionFailed, Reason: "PRECONDITION_FAILED - inequivalent arg 'type'"}
+	}
+	s.exchanges[name] = kind
+	return nil
+}
+
+func (ch *channel) QueueDeclare(name string, durable, _, exclusive, _ bool, args amqp.Table) (amqp.Queue, error) {
+	s := ch.conn.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ch.closed {
+		return amqp.Queue{}, amqp.ErrClosed
+	}
+	var owner *conn
+	if exclusive {
+		owner = ch.conn
+	}
+	q := s.declare(name, durable, owner, args)
+	return amqp.Queue{Name: q.name, Messages: len(q.pending), Consumers: len(q.consumers)}, nil
+}
+
+func (ch *channel) QueueBind(name, key, exchange string, _ bool, _ amqp.Table) error {
+	s := ch.conn.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+	q, ok := s.queues[name]
+	if !ok {
+		return &amqp.Error{Code: amqp.NotFound, Reason: "NOT_FOUND - no queue '" + name + "'"}
+	}
+	if _, ok := s.exchanges[exchange]; !ok {
+		return &amqp.Error{Code: amqp.NotFound, Reason: "NOT_FOUND - no exchange '" + exchange + "'"}
+	}
+	q.bindings[binding{exchange: exchange, key: key}] = true
+	return nil
+}
+
+func (ch *channel) QueueUnbind(name, key, exchange string, _ amqp.Table) error {
+	s := ch.conn.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+	if q, ok := s.queues[name]; ok {
+		delete(q.bindings, binding{exchange: exchange, key: key})
+	}
+	return nil
+}
+
+func (ch *channel) QueueDelete(name string, _, _, _ bool) (int, error) {
+	s := ch.conn.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ch.closed {
+		return 0, amqp.ErrClosed
+	}
+	q, ok := s.queues[name]
+	if !ok {
+		return 0, nil
+	}
+	for _, cons := range q.consumers {
+		cons.ch.dropConsumer(cons)
+	}
+	delete(s.queues, name)
+	return len(q.pending), nil
+}
+
+func (ch *channel) QueuePurge(name string, _ bool) (int, error) {
+	s := ch.conn.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ch.closed {
+		return 0, amqp.ErrClosed
+	}
+	q, ok := s.queues[name]
+	if !ok {
+		return 0, &amqp.Error{Code: amqp.NotFound, Reason: "NOT_FOUND - no queue '" + name + "'"}
+	}
+	purged := len(q.pending)
+	q.pending = nil
+	return purged, nil
+}
+
+func (ch *channel) Get(name string, autoAck bool) (amqp.Delivery, bool, error) {
+	s := ch.conn.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ch.closed {
+		return amqp.Delivery{}, false, amqp.ErrClosed
+	}
+	q, ok := s.queues[name]
+	if !ok {
+		return amqp.Delivery{}, false, &amqp.Error{Code: amqp.NotFound, Reason: "NOT_FOUND - no queue '" + name + "'"}
+	}
+	if len(q.pending) == 0 {
+		return amqp.Delivery{}, false, nil
+	}
+	msg := q.pending[0]
+	q.pending = q.pending[1:]
+	ch.tag++
+	if !autoAck {
+		ch.unacked[ch.tag] = unacked{queue: q, msg: msg}
+	}
+	return delivery(&consumer{ch: ch}, msg), true, nil
+}
+
+// dropConsumer отписывает подписчика удалённой очереди
+func (ch *channel) dropConsumer(cons *consumer) {
+	for i, c := range ch.consumers {
+		if c == cons {
+			ch.consumers = append(ch.consumers[:i], ch.consumers[i+1:]...)
+			close(cons.out)
+			return
+		}
+	}
+}
+
+func (ch *channel) Qos(prefetchCount, _ int, _ bool) error {
+	s := ch.conn.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ch.prefetch = prefetchCount
+	return nil
+}
+
+func (ch *channel) Consume(name, tag string, autoAck, _, _, _ bool, _ amqp.Table) (<-chan amqp.Delivery, error) {
+	s := ch.conn.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ch.closed {
+		return nil, amqp.ErrClosed
+	}
+	q, ok := s.queues[name]
+	if !ok {
+		return nil, &amqp.Error{Code: amqp.NotFound, Reason: "NOT_FOUND - no queue '" + name + "'"}
+	}
+	if tag == "" {
+		s.seq++
+		tag = "ctag-" + strconv.Itoa(s.seq)
+	}
+	cons := &consumer{tag: tag, ch: ch, autoAck: autoAck, out: make(chan amqp.Delivery, consumerBuffer)}
+	q.consumers = append(q.consumers, cons)
+	ch.consumers = append(ch.consumers, cons)
+	s.dispatch(q)
+	return cons.out, nil
+}
+
+func (ch *channel) PublishWithContext(_ context.Context, exchange, key string, _, _ bool, msg amqp.Publishing) error {
+	s := ch.conn.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+	if _, ok := s.exchanges[exchange]; exchange != "" && !ok {
+		return &amqp.Error{Code: amqp.NotFound, Reason: "NOT_FOUND - no exchange '" + exchange + "'"}
+	}
+	s.route(message{exchange: exchange, key: key, publishing: msg})
+	return nil
+}
+
+func (ch *channel) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	s := ch.conn.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ch.closed {
+		close(receiver)
+		return receiver
+	}
+	ch.notify = append(ch.notify, receiver)
+	return receiver
+}
+
+func (ch *channel) Close() error {
+	s := ch.conn.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+	ch.close(nil)
+	return nil
+}
+
+// close закрывает канал под блокировкой сервера и возвращает неподтверждённые сообщения в очереди
+func (ch *channel) close(err *amqp.Error) {
+	if ch.closed {
+		return
+	}
+	ch.closed = true
+	for _, cons := range ch.consumers {
+		for _, q := range ch.conn.server.queues {
+			for i, c := range q.consumers {
+				if c == cons {
+					q.consumers = append(q.consumers[:i], q.consumers[i+1:]...)
+					break
+				}
+			}
+		}
+		close(cons.out)
+	}
+	ch.consumers = nil
+	for _, u := range ch.sortedUnacked() {
+		u.msg.redelivered = true
+		u.queue.pending = append([]message{u.msg}, u.queue.pending...)
+	}
+	ch.unacked = make(map[uint64]unacked)
+	for _, receiver := range ch.notify {
+		if err != nil {
+			receiver <- err
+		}
+		close(receiver)
+	}
+	for _, q := range ch.conn.server.queues {
+		ch.conn.server.dispatch(q)
+	}
+}
+
+// sortedUnacked возвращает неподтверждённые сообщения в обратном порядке доставки,
+// чтобы после возврата в голову очереди они сохранили исходный порядок
+func (ch *channel) sortedUnacked() []unacked {
+	tags := make([]uint64, 0, len(ch.unacked))
+	for tag := range ch.unacked {
+		tags = append(tags, tag)
+	}
+	slices.Sort(tags)
+	slices.Reverse(tags)
+	res := make([]unacked, 0, len(tags))
+	for _, tag := range tags {
+		res = append(res, ch.unacked[tag])
+	}
+	return res
+}
+
+func (ch *channel) Ack(tag uint64, multiple bool) error {
+	s := ch.conn.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return ch.settle(tag, multiple, func(u unacked) { s.acked++ })
+}
+
+func (ch *channel) Nack(tag uint64, multiple bool, requeue bool) error {
+	s := ch.conn.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return ch.settle(tag, multiple, func(u unacked) {
+		s.nacked++
+		if requeue {
+			u.msg.redelivered = true
+			u.queue.pending = append(u.queue.pending, u.msg)
+		} else {
+			s.deadLetter(u)
+		}
+	})
+}
+
+func (ch *channel) Reject(tag uint64, requeue bool) error {
+	return ch.Nack(tag, false, requeue)
+}
+
+// deadLetter отправляет отброшенное сообщение в x-dead-letter-exchange очереди, если он задан
+func (s *Server) deadLetter(u unacked) {
+	dlx, ok := u.queue.args["x-dead-letter-exchange"].(string)
+	if !ok {
+		return
+	}
+	key := u.msg.key
+	if dlk, ok := u.queue.args["x-dead-letter-routing-key"].(string); ok {
+		key = dlk
+	}
+	msg := u.msg
+	msg.exchange = dlx
+	msg.key = key
+	msg.redelivered = false
+	s.route(msg)
+}
+
+func (ch *channel) settle(tag uint64, multiple bool, f func(u unacked)) error {
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+	if _, ok := ch.unacked[tag]; !ok {
+		return &amqp.Error{Code: amqp.PreconditionFailed, Reason: "PRECONDITION_FAILED - unknown delivery tag " +
+			strconv.FormatUint(tag, 10)}
+	}
+	for t, u := range ch.unacked {
+		if t == tag || (multiple && t < tag) {
+			delete(ch.unacked, t)
+			f(u)
+		}
+	}
+	for _, q := range ch.conn.server.queues {
+		ch.conn.server.dispatch(q)
+	}
+	return nil
+}
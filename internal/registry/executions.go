@@ -0,0 +1,199 @@
+package registry
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dnonakolesax/noted-runner/internal/model"
+	"github.com/dnonakolesax/noted-runner/internal/rnd"
+)
+
+const executionIDLen = 16
+
+// Execution - запуск блока, результат которого ждут через REST
+type Execution struct {
+	UserID string
+
+	mu    sync.Mutex
+	state model.Execution
+	done  chan struct{}
+}
+
+// Done закрывается, когда выполнение завершилось
+func (e *Execution) Done() <-chan struct{} {
+	return e.done
+}
+
+func (e *Execution) Snapshot() model.Execution {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.state
+}
+
+// AddWarnings дописывает предупреждения препроцессора к запуску
+func (e *Execution) AddWarnings(warnings []string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.state.Warnings = append(e.state.Warnings, warnings...)
+}
+
+// SetParameters запоминает действующие параметры запуска
+func (e *Execution) SetParameters(params map[string]string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.state.Parameters = params
+}
+
+// SetHeads запоминает версию блока, которая выполняется
+func (e *Execution) SetHeads(heads []string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.state.Heads = heads
+}
+
+func (e *Execution) finish(update func(state *model.Execution)) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.state.Status != model.ExecutionRunning {
+		return false
+	}
+	update(&e.state)
+	now := time.Now()
+	e.state.FinishedAt = &now
+	close(e.done)
+	return true
+}
+
+// Executions - потокобезопасный реестр запусков. Результат ядра приходит без ID запуска,
+// поэтому он достаётся самому старому незавершённому запуску того же блока
+type Executions struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	byID    map[string]*Execution
+	pending map[string][]*Execution
+}
+
+func NewExecutions(ttl time.Duration) *Executions {
+	return &Executions{ttl: ttl, byID: make(map[string]*Execution), pending: make(map[string][]*Execution)}
+}
+
+func (ex *Executions) Start(kernelID string, blockID string, userID string) *Execution {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+
+	ex.prune()
+	e := &Execution{
+		UserID: userID,
+		state: model.Execution{
+			ID:        string(rnd.NotSafeGenRandomString(executionIDLen)),
+			KernelID:  kernelID,
+			BlockID:   blockID,
+			Status:    model.ExecutionRunning,
+			StartedAt: time.Now(),
+		},
+		done: make(chan struct{}),
+	}
+	ex.byID[e.state.ID] = e
+	key := kernelID + "/" + blockID
+	ex.pending[key] = append(ex.pending[key], e)
+	return e
+}
+
+// Complete отдаёт результат ядра ожидающему его запуску. Возвращает false, если результата никто не ждал
+func (ex *Executions) Complete(msg model.KernelMessage) bool {
+	ex.mu.Lock()
+	key := msg.KernelID + "/" + msg.BlockID
+	queue := ex.pending[key]
+	if len(queue) == 0 {
+		ex.mu.Unlock()
+		return false
+	}
+	e := queue[0]
+	ex.unqueue(key, e)
+	ex.mu.Unlock()
+
+	return e.finish(func(state *model.Execution) {
+		state.Result = msg.Result
+		state.Fail = msg.Fail
+		state.Tests = msg.Tests
+		state.Warnings = append(state.Warnings, msg.Warnings...)
+		if msg.Fail {
+			state.Status = model.ExecutionFailed
+		} else {
+			state.Status = model.ExecutionDone
+		}
+	})
+}
+
+// Fail завершает запуск с ошибкой, например если блок не удалось отправить ядру
+func (ex *Executions) Fail(e *Execution, err error) {
+	state := e.Snapshot()
+	ex.mu.Lock()
+	ex.unqueue(state.KernelID+"/"+state.BlockID, e)
+	ex.mu.Unlock()
+
+	e.finish(func(state *model.Execution) {
+		state.Status = model.ExecutionFailed
+		state.Error = err.Error()
+	})
+}
+
+// FailKernel завершает с ошибкой все незавершённые запуски ядра: ядро остановлено или перезапущено
+func (ex *Executions) FailKernel(kernelID string, err error) {
+	ex.mu.Lock()
+	var failed []*Execution
+	for key, queue := range ex.pending {
+		if strings.HasPrefix(key, kernelID+"/") {
+			failed = append(failed, queue...)
+			delete(ex.pending, key)
+		}
+	}
+	ex.mu.Unlock()
+
+	for _, e := range failed {
+		e.finish(func(state *model.Execution) {
+			state.Status = model.ExecutionFailed
+			state.Error = err.Error()
+		})
+	}
+}
+
+func (ex *Executions) Get(id string) (*Execution, bool) {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+
+	e, ok := ex.byID[id]
+	return e, ok
+}
+
+func (ex *Executions) unqueue(key string, e *Execution) {
+	queue := ex.pending[key]
+	for i := range queue {
+		if queue[i] == e {
+			queue = append(queue[:i], queue[i+1:]...)
+			break
+		}
+	}
+	if len(queue) == 0 {
+		delete(ex.pending, key)
+		return
+	}
+	ex.pending[key] = queue
+}
+
+// prune забывает завершённые запуски старше ttl. Вызывать под mu
+func (ex *Executions) prune() {
+	deadline := time.Now().Add(-ex.ttl)
+	for id, e := range ex.byID {
+		state := e.Snapshot()
+		if state.FinishedAt != nil && state.FinishedAt.Before(deadline) {
+			delete(ex.byID, id)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package registry
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/dnonakolesax/noted-runner/internal/preproc"
+)
+
+// Kernel - состояние одного запущенного ядра пользователя.
+// Всё, кроме ContainerID и Types, меняется только под Lock
+type Kernel struct {
+	sync.Mutex
+	KernelID    string
+	ContainerID string
+	Types       *preproc.KernelTypes
+	attempts    map[string]int
+}
+
+// NextAttempt увеличивает счётчик компиляций блока. Вызывать под Lock. Счётчик не сбрасывается
+// и после удаления блока: ядро не загружает плагин с уже открытым путём повторно, и блок, созданный
+// заново с тем же ID, выполнил бы старый код
+func (k *Kernel) NextAttempt(blockID string) int {
+	k.attempts[blockID]++
+	return k.attempts[blockID]
+}
+
+// ErrReserved - ядро уже запускается или запущено, возможно другим пользователем
+var ErrReserved = errors.New("kernel is already started")
+
+// Kernels - потокобезопасный реестр запущенных ядер
+type Kernels struct {
+	mu       sync.RWMutex
+	kernels  map[string]*Kernel
+	starting map[string]bool
+}
+
+func NewKernels() *Kernels {
+	return &Kernels{kernels: make(map[string]*Kernel), starting: make(map[string]bool)}
+}
+
+// Reserve занимает ядро kernelID на время запуска. Ядро не занять, пока его запускает кто-то другой
+// или пока оно есть в реестре у любого пользователя. Поднимать и убирать контейнер и очередь команд
+// может только тот, кто занял ядро
+func (ks *Kernels) Reserve(kernelID string) bool {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if ks.starting[kernelID] {
+		return false
+	}
+	for _, kernel := range ks.kernels {
+		if kernel.KernelID == kernelID {
+			return false
+		}
+	}
+	ks.starting[kernelID] = true
+	return true
+}
+
+// Unreserve снимает резерв после запуска: удачно запущенное ядро дальше занято записью в реестре
+func (ks *Kernels) Unreserve(kernelID string) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	delete(ks.starting, kernelID)
+}
+
+// Attach регистрирует новое ядро с чистым состоянием. Если ядро с таким ключом уже есть,
+// возвращается оно и false
+func (ks *Kernels) Attach(key string, kernelID string, containerID string) (*Kernel, bool) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if kernel, ok := ks.kernels[key]; ok {
+		return kernel, false
+	}
+	kernel := &Kernel{
+		KernelID:    kernelID,
+		ContainerID: containerID,
+		Types:       preproc.NewKernelTypes(),
+		attempts:    make(map[string]int),
+	}
+	ks.kernels[key] = kernel
+	return kernel, true
+}
+
+func (ks *Kernels) Lookup(key string) (*Kernel, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	kernel, ok := ks.kernels[key]
+	return kernel, ok
+}
+
+// Evict удаляет ядро из реестра и возвращает его, чтобы вызывающий мог остановить контейнер
+func (ks *Kernels) Evict(key string) (*Kernel, bool) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	kernel, ok := ks.kernels[key]
+	delete(ks.kernels, key)
+	return kernel, ok
+}
+
+// EvictKernel удаляет состояние ядра всех пользователей
+func (ks *Kernels) EvictKernel(kernelID string) []*Kernel {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	var evicted []*Kernel
+	for key, kernel := range ks.kernels {
+		if kernel.KernelID == kernelID {
+			evicted = append(evicted, kernel)
+			delete(ks.kernels, key)
+		}
+	}
+	return evicted
+}
+
+func (ks *Kernels) Len() int {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	return len(ks.kernels)
+}
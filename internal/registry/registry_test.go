@@ -0,0 +1,159 @@
+package registry
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dnonakolesax/noted-runner/internal/model"
+)
+
+func TestKernelsStress(t *testing.T) {
+	kernels := NewKernels()
+	var wg sync.WaitGroup
+	var attached atomic.Int64
+
+	for i := range 64 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			key := "kernel" + strconv.Itoa(i%8)
+			for j := range 200 {
+				if _, ok := kernels.Attach(key, key, "container"); ok {
+					attached.Add(1)
+				}
+				if kernel, ok := kernels.Lookup(key); ok {
+					kernel.Lock()
+					kernel.NextAttempt("block" + strconv.Itoa(j%4))
+					kernel.Types.Forget("block")
+					kernel.Unlock()
+				}
+				if j%50 == 0 {
+					kernels.Evict(key)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if attached.Load() < 8 {
+		t.Fatalf("expected every kernel to be attached at least once, got %d", attached.Load())
+	}
+	if kernels.Len() > 8 {
+		t.Fatalf("expected at most 8 kernels, got %d", kernels.Len())
+	}
+}
+
+func TestKernelsAttachOnce(t *testing.T) {
+	kernels := NewKernels()
+	var wg sync.WaitGroup
+	var attached atomic.Int64
+
+	for range 64 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok := kernels.Attach("kernel", "kernel", "container"); ok {
+				attached.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if attached.Load() != 1 {
+		t.Fatalf("expected exactly one attach, got %d", attached.Load())
+	}
+}
+
+func TestKernelsReserve(t *testing.T) {
+	kernels := NewKernels()
+	var wg sync.WaitGroup
+	var reserved atomic.Int64
+
+	for range 64 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if kernels.Reserve("kernel1") {
+				reserved.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if reserved.Load() != 1 {
+		t.Fatalf("expected exactly one reservation, got %d", reserved.Load())
+	}
+
+	// запущенное ядро занято для всех пользователей и после снятия резерва
+	kernels.Attach("kernel1user1", "kernel1", "container")
+	kernels.Unreserve("kernel1")
+	if kernels.Reserve("kernel1") {
+		t.Fatal("reserved a kernel another user has started")
+	}
+	kernels.Evict("kernel1user1")
+	if !kernels.Reserve("kernel1") || !kernels.Reserve("kernel2") {
+		t.Fatal("stopped kernel is still reserved")
+	}
+}
+
+func TestKernelAttempts(t *testing.T) {
+	kernel, _ := NewKernels().Attach("kernel", "kernel", "container")
+	kernel.Lock()
+	defer kernel.Unlock()
+
+	if kernel.NextAttempt("b") != 1 || kernel.NextAttempt("b") != 2 {
+		t.Fatalf("attempts are not sequential")
+	}
+	if kernel.NextAttempt("c") != 1 || kernel.NextAttempt("b") != 3 {
+		t.Fatalf("attempts are not counted per block")
+	}
+}
+
+func TestKernelsEvictKernel(t *testing.T) {
+	kernels := NewKernels()
+	kernels.Attach("kernel1user1", "kernel1", "container")
+	kernels.Attach("kernel1user2", "kernel1", "container")
+	kernels.Attach("kernel2user1", "kernel2", "container")
+
+	if evicted := kernels.EvictKernel("kernel1"); len(evicted) != 2 {
+		t.Fatalf("expected 2 evicted kernels, got %d", len(evicted))
+	}
+	if _, ok := kernels.Lookup("kernel2user1"); !ok || kernels.Len() != 1 {
+		t.Fatalf("evicted a foreign kernel")
+	}
+}
+
+func TestExecutions(t *testing.T) {
+	executions := NewExecutions(time.Hour)
+	first := executions.Start("kernel", "block", "user")
+	second := executions.Start("kernel", "block", "user")
+	other := executions.Start("kernel", "other", "user")
+
+	if !executions.Complete(model.KernelMessage{KernelID: "kernel", BlockID: "block", Result: "1"}) {
+		t.Fatalf("result was not matched")
+	}
+	select {
+	case <-first.Done():
+	default:
+		t.Fatalf("results must go to the oldest execution first")
+	}
+	if state := first.Snapshot(); state.Status != model.ExecutionDone || state.Result != "1" {
+		t.Fatalf("unexpected state: %+v", state)
+	}
+
+	executions.FailKernel("kernel", errors.New("stopped"))
+	for _, e := range []*Execution{second, other} {
+		if state := e.Snapshot(); state.Status != model.ExecutionFailed || state.Error != "stopped" {
+			t.Fatalf("pending execution was not failed: %+v", state)
+		}
+	}
+	if executions.Complete(model.KernelMessage{KernelID: "kernel", BlockID: "block"}) {
+		t.Fatalf("result matched a failed execution")
+	}
+	if got, ok := executions.Get(second.Snapshot().ID); !ok || got != second {
+		t.Fatalf("finished execution is not kept")
+	}
+}
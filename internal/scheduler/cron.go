@@ -0,0 +1,158 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidSpec = errors.New("invalid schedule spec")
+
+// Spec - разобранное расписание в формате cron: "минуты часы дни месяцы дни_недели",
+// сокращения @hourly, @daily, @weekly, @monthly, @yearly или интервал "@every 1h30m"
+type Spec struct {
+	minute, hour, dom, month, dow uint64
+	// domAny и dowAny: если ограничены оба поля дней, подходит любое из них, как в cron
+	domAny, dowAny bool
+	every          time.Duration
+}
+
+type field struct {
+	min, max int
+}
+
+var (
+	minutes  = field{0, 59}
+	hours    = field{0, 23}
+	days     = field{1, 31}
+	months   = field{1, 12}
+	weekdays = field{0, 7}
+)
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// minEvery не даёт расписанию запускать блокнот чаще раза в минуту
+const minEvery = time.Minute
+
+func ParseSpec(spec string) (Spec, error) {
+	spec = strings.TrimSpace(spec)
+	if rest, ok := strings.CutPrefix(spec, "@every "); ok {
+		every, err := time.ParseDuration(strings.TrimSpace(rest))
+		if err != nil || every < minEvery {
+			return Spec{}, fmt.Errorf("%w: interval must be a duration of at least %s", ErrInvalidSpec, minEvery)
+		}
+		return Spec{every: every}, nil
+	}
+	if expanded, ok := descriptors[spec]; ok {
+		spec = expanded
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return Spec{}, fmt.Errorf("%w: expected 5 fields, got %d", ErrInvalidSpec, len(fields))
+	}
+	var s Spec
+	var err error
+	bounds := []field{minutes, hours, days, months, weekdays}
+	targets := []*uint64{&s.minute, &s.hour, &s.dom, &s.month, &s.dow}
+	for idx, f := range fields {
+		*targets[idx], err = parseField(f, bounds[idx])
+		if err != nil {
+			return Spec{}, fmt.Errorf("%w: field %q: %s", ErrInvalidSpec, f, err)
+		}
+	}
+	// воскресенье можно записать и как 0, и как 7
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domAny = fields[2] == "*"
+	s.dowAny = fields[4] == "*"
+	return s, nil
+}
+
+// parseField разбирает список через запятую из *, чисел и диапазонов, у каждого может быть шаг /n
+func parseField(f string, bounds field) (uint64, error) {
+	var bits uint64
+	for part := range strings.SplitSeq(f, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepStr)
+			if err != nil || step <= 0 {
+				return 0, errors.New("invalid step")
+			}
+		}
+
+		lo, hi := bounds.min, bounds.max
+		if rng != "*" {
+			from, to, isRange := strings.Cut(rng, "-")
+			var err error
+			lo, err = strconv.Atoi(from)
+			if err != nil {
+				return 0, errors.New("invalid value")
+			}
+			hi = lo
+			if isRange {
+				hi, err = strconv.Atoi(to)
+				if err != nil {
+					return 0, errors.New("invalid range")
+				}
+			} else if hasStep {
+				hi = bounds.max
+			}
+		}
+		if lo < bounds.min || hi > bounds.max || lo > hi {
+			return 0, fmt.Errorf("values must be within %d-%d", bounds.min, bounds.max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// Next возвращает ближайший момент срабатывания строго после t; время считается в зоне t
+func (s Spec) Next(t time.Time) time.Time {
+	if s.every > 0 {
+		return t.Add(s.every)
+	}
+
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// расписание вроде "0 0 30 2 *" не сработает никогда, поэтому поиск ограничен
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (s Spec) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<int(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
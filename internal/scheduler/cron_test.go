@@ -0,0 +1,49 @@
+package scheduler
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSpecNext(t *testing.T) {
+	from := time.Date(2026, time.January, 30, 10, 17, 42, 0, time.UTC) // пятница
+	cases := []struct {
+		spec string
+		next string
+	}{
+		{"*/15 * * * *", "2026-01-30T10:30:00Z"},
+		{"@hourly", "2026-01-30T11:00:00Z"},
+		{"@daily", "2026-01-31T00:00:00Z"},
+		{"30 9 * * 1-5", "2026-02-02T09:30:00Z"},
+		{"0 0 * * 7", "2026-02-01T00:00:00Z"},
+		{"0 12 31 * *", "2026-01-31T12:00:00Z"},
+		{"0 12 31 2,4 *", "2026-01-30T10:17:42Z"}, // никогда: нулевое время
+		{"0 6 1 * 5", "2026-02-01T06:00:00Z"},     // 1-е число или пятница
+		{"0,45 10 * * *", "2026-01-30T10:45:00Z"},
+		{"@every 90m", "2026-01-30T11:47:42Z"},
+	}
+	for _, c := range cases {
+		spec, err := ParseSpec(c.spec)
+		if err != nil {
+			t.Fatalf("%s: %v", c.spec, err)
+		}
+		got := spec.Next(from)
+		if c.spec == "0 12 31 2,4 *" {
+			if !got.IsZero() {
+				t.Fatalf("%s: impossible spec fired at %s", c.spec, got)
+			}
+			continue
+		}
+		if got.Format(time.RFC3339) != c.next {
+			t.Fatalf("%s: got %s, expected %s", c.spec, got.Format(time.RFC3339), c.next)
+		}
+	}
+
+	for _, bad := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *",
+		"@every 10s", "@every soon", "@often"} {
+		if _, err := ParseSpec(bad); !errors.Is(err, ErrInvalidSpec) {
+			t.Fatalf("%q is accepted: %v", bad, err)
+		}
+	}
+}
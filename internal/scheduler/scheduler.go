@@ -0,0 +1,471 @@
+// Package scheduler запускает блокноты по расписанию: в назначенное время от имени владельца
+// расписания поднимается ядро, по очереди выполняются блоки, а результаты сохраняются в историю запусков
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	// зоны расписаний не должны зависеть от tzdata в образе
+	_ "time/tzdata"
+
+	"github.com/dnonakolesax/noted-runner/internal/blocksource"
+	"github.com/dnonakolesax/noted-runner/internal/configs"
+	"github.com/dnonakolesax/noted-runner/internal/ids"
+	"github.com/dnonakolesax/noted-runner/internal/logger"
+	"github.com/dnonakolesax/noted-runner/internal/model"
+	"github.com/dnonakolesax/noted-runner/internal/mount"
+	"github.com/dnonakolesax/noted-runner/internal/registry"
+	"github.com/dnonakolesax/noted-runner/internal/rnd"
+	"github.com/dnonakolesax/noted-runner/internal/session"
+	access "github.com/dnonakolesax/noted-runner/internal/usecase/access/proto"
+)
+
+var (
+	ErrUnknownSchedule = errors.New("unknown schedule")
+	ErrInvalidTimezone = errors.New("invalid timezone")
+	ErrInvalidBlock    = errors.New("invalid block id: expected UUID")
+
+	errShutdown = errors.New("runner is shutting down")
+	errNoBlocks = errors.New("notebook has no blocks")
+	errNoAccess = errors.New("schedule owner has no right to execute the notebook")
+	errOpened   = errors.New("notebook is open in another session, scheduled run skipped")
+)
+
+const (
+	idLen = 16
+	// idleWait - как долго спать, если расписаний нет; новое расписание всё равно будит планировщик
+	idleWait = time.Hour
+)
+
+// Kernels - операции с ядрами, общие с REST и gRPC. OpenKernel атомарно занимает ядро и возвращает
+// session.ErrExists, если его уже открыл или открывает кто-то другой
+type Kernels interface {
+	OpenKernel(kernelID ids.ID, userID ids.ID) (*session.Session, error)
+	RunExecution(kernelID ids.ID, blockID ids.ID, userID ids.ID,
+		params map[string]json.RawMessage, heads []string, mode string) (*registry.Execution, error)
+	InterruptKernel(kernelID ids.ID, userID ids.ID) error
+	StopSession(kernelID ids.ID, userID ids.ID) error
+}
+
+// Auditor записывает отказы в доступе в журнал аудита
+type Auditor interface {
+	Event(eventType string, userID string, kernelID string, detail string)
+}
+
+// BlockLister возвращает блоки блокнота для расписаний без явного порядка
+type BlockLister func(kernelID ids.ID) ([]ids.ID, error)
+
+// NotebookBlocks ищет файлы блоков в каталоге ядра и упорядочивает их по ID
+func NotebookBlocks(root *mount.Root) BlockLister {
+	loader := blocksource.NewFileLoader(root)
+	return func(kernelID ids.ID) ([]ids.ID, error) {
+		names, err := loader.List(kernelID.String())
+		if err != nil {
+			return nil, err
+		}
+		var blocks []ids.ID
+		for _, name := range names {
+			if blockID, err := ids.Parse(name); err == nil {
+				blocks = append(blocks, blockID)
+			}
+		}
+		return blocks, nil
+	}
+}
+
+type entry struct {
+	schedule model.Schedule
+	spec     Spec
+	loc      *time.Location
+}
+
+type Scheduler struct {
+	store   *Store
+	kernels Kernels
+	blocks  BlockLister
+	access  access.AcessServiceClient
+	auditor Auditor
+	config  *configs.SchedulerConfig
+	logger  *slog.Logger
+
+	mu        sync.Mutex
+	schedules map[string]*entry
+	running   map[string]bool
+	slots     chan struct{}
+	wake      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewScheduler загружает сохранённые расписания. Запуски, пропущенные, пока раннер не работал,
+// не догоняются: следующий считается от текущего момента. Право владельца на выполнение блокнота
+// проверяется сервисом доступа перед каждым запуском
+func NewScheduler(store *Store, kernels Kernels, blocks BlockLister, accessClient access.AcessServiceClient,
+	auditor Auditor, config *configs.SchedulerConfig, logger *slog.Logger) (*Scheduler, error) {
+	s := &Scheduler{
+		store:     store,
+		kernels:   kernels,
+		blocks:    blocks,
+		access:    accessClient,
+		auditor:   auditor,
+		config:    config,
+		logger:    logger,
+		schedules: make(map[string]*entry),
+		running:   make(map[string]bool),
+		slots:     make(chan struct{}, max(config.MaxConcurrent, 1)),
+		wake:      make(chan struct{}, 1),
+	}
+	return s, s.load(time.Now())
+}
+
+func (s *Scheduler) load(now time.Time) error {
+	saved, err := s.store.Schedules()
+	if err != nil {
+		return err
+	}
+	for _, schedule := range saved {
+		e, err := newEntry(schedule)
+		if err != nil {
+			s.logger.Error("skipping invalid schedule", logger.LogError(err), slog.String("schedule", schedule.ID))
+			continue
+		}
+		if e.schedule.NextRun.Before(now) {
+			e.schedule.NextRun = e.next(now)
+		}
+		s.schedules[schedule.ID] = e
+	}
+	return nil
+}
+
+func newEntry(schedule model.Schedule) (*entry, error) {
+	spec, err := ParseSpec(schedule.Spec)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := time.LoadLocation(schedule.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidTimezone, schedule.Timezone)
+	}
+	return &entry{schedule: schedule, spec: spec, loc: loc}, nil
+}
+
+func (e *entry) next(after time.Time) time.Time {
+	return e.spec.Next(after.In(e.loc))
+}
+
+func (s *Scheduler) Create(kernelID ids.ID, userID ids.ID, req model.ScheduleRequest) (model.Schedule, error) {
+	for _, block := range req.Blocks {
+		if _, err := ids.Parse(block); err != nil {
+			return model.Schedule{}, ErrInvalidBlock
+		}
+	}
+	e, err := newEntry(model.Schedule{
+		ID:        string(rnd.NotSafeGenRandomString(idLen)),
+		KernelID:  kernelID.String(),
+		UserID:    userID.String(),
+		Spec:      req.Spec,
+		Timezone:  req.Timezone,
+		Blocks:    req.Blocks,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return model.Schedule{}, err
+	}
+	e.schedule.NextRun = e.next(e.schedule.CreatedAt)
+	if e.schedule.NextRun.IsZero() {
+		return model.Schedule{}, fmt.Errorf("%w: it never fires", ErrInvalidSpec)
+	}
+
+	s.mu.Lock()
+	s.schedules[e.schedule.ID] = e
+	err = s.persist()
+	if err != nil {
+		delete(s.schedules, e.schedule.ID)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return model.Schedule{}, err
+	}
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return e.schedule, nil
+}
+
+// List возвращает расписания пользователя для блокнота в порядке создания
+func (s *Scheduler) List(kernelID ids.ID, userID ids.ID) []model.Schedule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	schedules := []model.Schedule{}
+	for _, e := range s.schedules {
+		if e.schedule.KernelID == kernelID.String() && e.schedule.UserID == userID.String() {
+			schedules = append(schedules, e.schedule)
+		}
+	}
+	slices.SortFunc(schedules, func(a, b model.Schedule) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return schedules
+}
+
+// Delete удаляет расписание и его историю; уже идущий запуск доработает до конца
+func (s *Scheduler) Delete(kernelID ids.ID, userID ids.ID, scheduleID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.own(kernelID, userID, scheduleID)
+	if !ok {
+		return ErrUnknownSchedule
+	}
+	delete(s.schedules, scheduleID)
+	err := s.persist()
+	if err != nil {
+		s.schedules[scheduleID] = e
+		return err
+	}
+	return s.store.DropRuns(scheduleID)
+}
+
+func (s *Scheduler) Runs(kernelID ids.ID, userID ids.ID, scheduleID string) ([]model.ScheduledRun, error) {
+	s.mu.Lock()
+	_, ok := s.own(kernelID, userID, scheduleID)
+	s.mu.Unlock()
+	if !ok {
+		return nil, ErrUnknownSchedule
+	}
+	return s.store.Runs(scheduleID)
+}
+
+// own вызывается под s.mu; чужое расписание неотличимо от несуществующего
+func (s *Scheduler) own(kernelID ids.ID, userID ids.ID, scheduleID string) (*entry, bool) {
+	e, ok := s.schedules[scheduleID]
+	if !ok || e.schedule.KernelID != kernelID.String() || e.schedule.UserID != userID.String() {
+		return nil, false
+	}
+	return e, true
+}
+
+// persist вызывается под s.mu
+func (s *Scheduler) persist() error {
+	schedules := make([]model.Schedule, 0, len(s.schedules))
+	for _, e := range s.schedules {
+		schedules = append(schedules, e.schedule)
+	}
+	slices.SortFunc(schedules, func(a, b model.Schedule) int { return strings.Compare(a.ID, b.ID) })
+	return s.store.SaveSchedules(schedules)
+}
+
+// Run запускает расписания, пока не отменён ctx, и дожидается идущих запусков
+func (s *Scheduler) Run(ctx context.Context) {
+	for {
+		next := s.tick(ctx, time.Now())
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			s.wg.Wait()
+			return
+		case <-timer.C:
+		case <-s.wake:
+			timer.Stop()
+		}
+	}
+}
+
+// tick запускает наступившие расписания и возвращает время следующего срабатывания
+func (s *Scheduler) tick(ctx context.Context, now time.Time) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	next := now.Add(idleWait)
+	fired := false
+	for _, e := range s.schedules {
+		if !e.schedule.NextRun.After(now) {
+			s.trigger(ctx, e.schedule, now)
+			e.schedule.NextRun = e.next(now)
+			fired = true
+		}
+		if !e.schedule.NextRun.IsZero() && e.schedule.NextRun.Before(next) {
+			next = e.schedule.NextRun
+		}
+	}
+	if fired {
+		err := s.persist()
+		if err != nil {
+			s.logger.Error("error saving schedules", logger.LogError(err))
+		}
+	}
+	return next
+}
+
+// trigger вызывается под s.mu. Если прошлый запуск расписания ещё идёт, новый пропускается
+func (s *Scheduler) trigger(ctx context.Context, schedule model.Schedule, now time.Time) {
+	run := model.ScheduledRun{
+		ID:         string(rnd.NotSafeGenRandomString(idLen)),
+		ScheduleID: schedule.ID,
+		KernelID:   schedule.KernelID,
+		Status:     model.ExecutionRunning,
+		StartedAt:  now,
+	}
+	if s.running[schedule.ID] {
+		s.logger.Warn("previous run is still in progress, skipping", slog.String("schedule", schedule.ID))
+		run.Status = model.RunSkipped
+		run.Error = "previous run is still in progress"
+		run.FinishedAt = &now
+		s.save(run)
+		return
+	}
+
+	s.running[schedule.ID] = true
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.execute(ctx, schedule, run)
+		s.mu.Lock()
+		delete(s.running, schedule.ID)
+		s.mu.Unlock()
+	}()
+}
+
+func (s *Scheduler) execute(ctx context.Context, schedule model.Schedule, run model.ScheduledRun) {
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+		run.StartedAt = time.Now()
+		s.save(run)
+		err := s.runBlocks(ctx, schedule, &run)
+		switch {
+		case errors.Is(err, errOpened):
+			run.Status = model.RunSkipped
+			run.Error = err.Error()
+		case err != nil:
+			run.Status = model.ExecutionFailed
+			run.Error = err.Error()
+		default:
+			run.Status = model.ExecutionDone
+		}
+	case <-ctx.Done():
+		run.Status = model.ExecutionFailed
+		run.Error = errShutdown.Error()
+	}
+
+	finished := time.Now()
+	run.FinishedAt = &finished
+	s.mu.Lock()
+	_, exists := s.schedules[schedule.ID]
+	s.mu.Unlock()
+	// у удалённого расписания истории больше нет
+	if exists {
+		s.save(run)
+	}
+	s.logger.Info("scheduled run finished", slog.String("schedule", schedule.ID), slog.String("status", run.Status))
+}
+
+// runBlocks поднимает ядро от имени владельца расписания и выполняет блоки до первой ошибки.
+// Если блокнот уже открыт, запуск пропускается: выполнять блоки в чужом живом ядре нельзя
+func (s *Scheduler) runBlocks(ctx context.Context, schedule model.Schedule, run *model.ScheduledRun) error {
+	kernelID := ids.MustParse(schedule.KernelID)
+	userID := ids.MustParse(schedule.UserID)
+
+	err := s.checkAccess(ctx, schedule)
+	if err != nil {
+		return err
+	}
+
+	blocks := make([]ids.ID, 0, len(schedule.Blocks))
+	for _, block := range schedule.Blocks {
+		blocks = append(blocks, ids.MustParse(block))
+	}
+	if len(blocks) == 0 {
+		var err error
+		blocks, err = s.blocks(kernelID)
+		if err != nil {
+			return fmt.Errorf("error listing blocks: %w", err)
+		}
+	}
+	if len(blocks) == 0 {
+		return errNoBlocks
+	}
+
+	_, err = s.kernels.OpenKernel(kernelID, userID)
+	if errors.Is(err, session.ErrExists) {
+		return errOpened
+	}
+	if err != nil {
+		return fmt.Errorf("error starting kernel: %w", err)
+	}
+	defer func() {
+		err := s.kernels.StopSession(kernelID, userID)
+		if err != nil {
+			s.logger.Error("error stopping scheduled kernel", logger.LogError(err))
+		}
+	}()
+
+	for _, blockID := range blocks {
+		execution, err := s.kernels.RunExecution(kernelID, blockID, userID, nil, nil, model.ModeRun)
+		if execution == nil {
+			return err
+		}
+		if err == nil {
+			err = s.await(ctx, kernelID, userID, execution)
+		}
+		snapshot := execution.Snapshot()
+		run.Executions = append(run.Executions, snapshot)
+		if err != nil {
+			return err
+		}
+		if snapshot.Status == model.ExecutionFailed {
+			return fmt.Errorf("block %s failed", blockID)
+		}
+	}
+	return nil
+}
+
+// checkAccess повторяет проверку AccessMW: право могли отозвать после создания расписания
+func (s *Scheduler) checkAccess(ctx context.Context, schedule model.Schedule) error {
+	rights, err := s.access.FileAccessCtx(ctx, &access.AccessRequest{UserID: schedule.UserID,
+		FileID: schedule.KernelID})
+	if err != nil {
+		s.auditor.Event(model.AuditAccessDenied, schedule.UserID, schedule.KernelID,
+			"schedule "+schedule.ID+": access check failed")
+		return fmt.Errorf("access check failed: %w", err)
+	}
+	if !strings.Contains(rights.Access, "x") {
+		s.auditor.Event(model.AuditAccessDenied, schedule.UserID, schedule.KernelID,
+			"schedule "+schedule.ID+": no right to execute, access "+strconv.Quote(rights.Access))
+		return errNoAccess
+	}
+	return nil
+}
+
+func (s *Scheduler) await(ctx context.Context, kernelID ids.ID, userID ids.ID, execution *registry.Execution) error {
+	timer := time.NewTimer(s.config.BlockTimeout)
+	defer timer.Stop()
+	select {
+	case <-execution.Done():
+		return nil
+	case <-timer.C:
+		err := s.kernels.InterruptKernel(kernelID, userID)
+		if err != nil {
+			s.logger.Error("error interrupting timed out block", logger.LogError(err))
+		}
+		return fmt.Errorf("block %s timed out after %s", execution.Snapshot().BlockID, s.config.BlockTimeout)
+	case <-ctx.Done():
+		return errShutdown
+	}
+}
+
+func (s *Scheduler) save(run model.ScheduledRun) {
+	err := s.store.SaveRun(run)
+	if err != nil {
+		s.logger.Error("error saving scheduled run", logger.LogError(err), slog.String("schedule", run.ScheduleID))
+	}
+}
@@ -0,0 +1,298 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/dnonakolesax/noted-runner/internal/configs"
+	"github.com/dnonakolesax/noted-runner/internal/ids"
+	"github.com/dnonakolesax/noted-runner/internal/model"
+	"github.com/dnonakolesax/noted-runner/internal/registry"
+	"github.com/dnonakolesax/noted-runner/internal/session"
+	access "github.com/dnonakolesax/noted-runner/internal/usecase/access/proto"
+)
+
+const (
+	testUser   = "7d1e2f3a-4b5c-4d6e-8f90-a1b2c3d4e5f6"
+	testKernel = "0b3c9a52-6f2e-4d0c-9a57-2f8b1c3d4e5f"
+	okBlock    = "4bcb102d-d663-4bec-86b4-86e978b5b54c"
+	failBlock  = "1a2b3c4d-1c2e-4f3a-8b9c-0d1e2f3a4b5c"
+	hangBlock  = "5e6f7a8b-1c2e-4f3a-8b9c-0d1e2f3a4b5c"
+)
+
+// fakeAccess выдаёт владельцу расписания права rights
+type fakeAccess struct {
+	mu     sync.Mutex
+	rights string
+}
+
+func (fa *fakeAccess) FileAccessCtx(_ context.Context, _ *access.AccessRequest,
+	_ ...grpc.CallOption) (*access.AccessData, error) {
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+	return &access.AccessData{Access: fa.rights}, nil
+}
+
+// fakeAuditor запоминает отказы в доступе
+type fakeAuditor struct {
+	mu     sync.Mutex
+	denied []string
+}
+
+func (fa *fakeAuditor) Event(eventType string, _ string, kernelID string, _ string) {
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+	if eventType == model.AuditAccessDenied {
+		fa.denied = append(fa.denied, kernelID)
+	}
+}
+
+// fakeKernels сразу отвечает на okBlock и failBlock, а hangBlock держит, пока не закрыт release
+type fakeKernels struct {
+	executions *registry.Executions
+	release    chan struct{}
+
+	mu      sync.Mutex
+	opened  bool
+	open    int
+	maxOpen int
+	ran     []string
+}
+
+func newFakeKernels() *fakeKernels {
+	return &fakeKernels{executions: registry.NewExecutions(time.Hour), release: make(chan struct{})}
+}
+
+func (fk *fakeKernels) OpenKernel(_ ids.ID, _ ids.ID) (*session.Session, error) {
+	fk.mu.Lock()
+	defer fk.mu.Unlock()
+	if fk.opened {
+		return nil, session.ErrExists
+	}
+	fk.open++
+	fk.maxOpen = max(fk.maxOpen, fk.open)
+	return &session.Session{}, nil
+}
+
+func (fk *fakeKernels) RunExecution(kernelID ids.ID, blockID ids.ID, userID ids.ID,
+	_ map[string]json.RawMessage, _ []string, _ string) (*registry.Execution, error) {
+	fk.mu.Lock()
+	fk.ran = append(fk.ran, blockID.String())
+	fk.mu.Unlock()
+
+	execution := fk.executions.Start(kernelID.String(), blockID.String(), userID.String())
+	msg := model.KernelMessage{KernelID: kernelID.String(), BlockID: blockID.String(), Result: "ok"}
+	switch blockID.String() {
+	case failBlock:
+		msg.Fail = true
+		msg.Result = "panic"
+	case hangBlock:
+		go func() {
+			<-fk.release
+			fk.executions.Complete(msg)
+		}()
+		return execution, nil
+	}
+	fk.executions.Complete(msg)
+	return execution, nil
+}
+
+func (fk *fakeKernels) InterruptKernel(_ ids.ID, _ ids.ID) error {
+	return nil
+}
+
+func (fk *fakeKernels) StopSession(kernelID ids.ID, _ ids.ID) error {
+	fk.mu.Lock()
+	defer fk.mu.Unlock()
+	fk.open--
+	fk.executions.FailKernel(kernelID.String(), errors.New("kernel stopped"))
+	return nil
+}
+
+func newTestScheduler(t *testing.T, dir string, kernels Kernels, maxConcurrent int) *Scheduler {
+	t.Helper()
+	return newAccessScheduler(t, dir, kernels, maxConcurrent, &fakeAccess{rights: "rwx"}, &fakeAuditor{})
+}
+
+func newAccessScheduler(t *testing.T, dir string, kernels Kernels, maxConcurrent int, accessClient *fakeAccess,
+	auditor *fakeAuditor) *Scheduler {
+	t.Helper()
+	store, err := NewStore(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &configs.SchedulerConfig{MaxConcurrent: maxConcurrent, BlockTimeout: time.Minute}
+	s, err := NewScheduler(store, kernels, func(ids.ID) ([]ids.ID, error) {
+		return []ids.ID{ids.MustParse(okBlock)}, nil
+	}, accessClient, auditor, config, slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func create(t *testing.T, s *Scheduler, blocks ...string) model.Schedule {
+	t.Helper()
+	schedule, err := s.Create(ids.MustParse(testKernel), ids.MustParse(testUser),
+		model.ScheduleRequest{Spec: "@daily", Timezone: "Europe/Moscow", Blocks: blocks})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return schedule
+}
+
+func runs(t *testing.T, s *Scheduler, scheduleID string) []model.ScheduledRun {
+	t.Helper()
+	history, err := s.Runs(ids.MustParse(testKernel), ids.MustParse(testUser), scheduleID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return history
+}
+
+func TestScheduledRuns(t *testing.T) {
+	dir := t.TempDir()
+	kernels := newFakeKernels()
+	s := newTestScheduler(t, dir, kernels, 2)
+	ctx := context.Background()
+
+	all := create(t, s)
+	failing := create(t, s, okBlock, failBlock, okBlock)
+	if local := all.NextRun; local.Location().String() != "Europe/Moscow" || local.Hour() != 0 || local.Minute() != 0 {
+		t.Fatalf("daily run is not at Moscow midnight: %s", all.NextRun)
+	}
+
+	next := s.tick(ctx, all.NextRun)
+	s.wg.Wait()
+	if !next.After(all.NextRun) {
+		t.Fatalf("next run is not moved forward: %s", next)
+	}
+
+	done := runs(t, s, all.ID)
+	if len(done) != 1 || done[0].Status != model.ExecutionDone || len(done[0].Executions) != 1 ||
+		done[0].Executions[0].Result != "ok" || done[0].FinishedAt == nil {
+		t.Fatalf("unexpected run: %+v", done)
+	}
+	failed := runs(t, s, failing.ID)
+	if len(failed) != 1 || failed[0].Status != model.ExecutionFailed || len(failed[0].Executions) != 2 {
+		t.Fatalf("run did not stop at the failed block: %+v", failed)
+	}
+	if kernels.open != 0 {
+		t.Fatalf("scheduled kernels are left running: %d", kernels.open)
+	}
+
+	// расписания и история переживают перезапуск раннера
+	restarted := newTestScheduler(t, dir, newFakeKernels(), 2)
+	if list := restarted.List(ids.MustParse(testKernel), ids.MustParse(testUser)); len(list) != 2 ||
+		list[0].ID != all.ID || !list[0].NextRun.After(all.NextRun) {
+		t.Fatalf("schedules are not restored: %+v", list)
+	}
+	if len(runs(t, restarted, all.ID)) != 1 {
+		t.Fatal("run history is not restored")
+	}
+
+	if _, err := restarted.Runs(ids.MustParse(testKernel), ids.MustParse(okBlock), all.ID); !errors.Is(err,
+		ErrUnknownSchedule) {
+		t.Fatalf("foreign user read run history: %v", err)
+	}
+	if err := restarted.Delete(ids.MustParse(testKernel), ids.MustParse(testUser), all.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restarted.Runs(ids.MustParse(testKernel), ids.MustParse(testUser), all.ID); !errors.Is(err,
+		ErrUnknownSchedule) {
+		t.Fatalf("deleted schedule still has history: %v", err)
+	}
+}
+
+func TestOverlapAndConcurrency(t *testing.T) {
+	kernels := newFakeKernels()
+	s := newTestScheduler(t, t.TempDir(), kernels, 1)
+	ctx := context.Background()
+
+	first := create(t, s, hangBlock)
+	second := create(t, s, okBlock)
+	s.tick(ctx, first.NextRun)
+	// первый запуск ещё идёт, поэтому следующее срабатывание пропускается
+	s.tick(ctx, first.NextRun.Add(24*time.Hour))
+
+	close(kernels.release)
+	s.wg.Wait()
+
+	if kernels.maxOpen != 1 {
+		t.Fatalf("concurrency limit is not applied: %d kernels at once", kernels.maxOpen)
+	}
+	// второе расписание ждало очереди за первым, поэтому его следующее срабатывание тоже пропущено
+	for _, schedule := range []model.Schedule{first, second} {
+		statuses := map[string]int{}
+		for _, run := range runs(t, s, schedule.ID) {
+			statuses[run.Status]++
+		}
+		if statuses[model.ExecutionDone] != 1 || statuses[model.RunSkipped] != 1 || len(statuses) != 2 {
+			t.Fatalf("unexpected history of %s: %v", schedule.ID, statuses)
+		}
+	}
+}
+
+func TestAccessAndOpenNotebook(t *testing.T) {
+	kernels := newFakeKernels()
+	rights := &fakeAccess{rights: "rwx"}
+	auditor := &fakeAuditor{}
+	s := newAccessScheduler(t, t.TempDir(), kernels, 1, rights, auditor)
+	ctx := context.Background()
+
+	schedule := create(t, s, okBlock)
+	// право на выполнение отозвали после создания расписания
+	rights.rights = "r"
+	s.tick(ctx, schedule.NextRun)
+	s.wg.Wait()
+	history := runs(t, s, schedule.ID)
+	if len(history) != 1 || history[0].Status != model.ExecutionFailed || len(history[0].Executions) != 0 ||
+		history[0].Error != errNoAccess.Error() {
+		t.Fatalf("run without access is not failed: %+v", history)
+	}
+	if len(auditor.denied) != 1 || auditor.denied[0] != testKernel {
+		t.Fatalf("denied run is not audited: %v", auditor.denied)
+	}
+	if len(kernels.ran) != 0 || kernels.maxOpen != 0 {
+		t.Fatalf("kernel is started without access: %v", kernels.ran)
+	}
+
+	// блокнот открыт пользователем: запуск пропускается, а не выполняется в его сессии
+	rights.rights = "rwx"
+	kernels.opened = true
+	s.tick(ctx, schedule.NextRun.Add(24*time.Hour))
+	s.wg.Wait()
+	history = runs(t, s, schedule.ID)
+	if len(history) != 2 || history[0].Status != model.RunSkipped || history[0].Error != errOpened.Error() {
+		t.Fatalf("run in an open notebook is not skipped: %+v", history)
+	}
+	if len(kernels.ran) != 0 {
+		t.Fatalf("blocks ran in the user's session: %v", kernels.ran)
+	}
+}
+
+func TestCreateValidation(t *testing.T) {
+	s := newTestScheduler(t, t.TempDir(), newFakeKernels(), 1)
+	cases := []struct {
+		req model.ScheduleRequest
+		err error
+	}{
+		{model.ScheduleRequest{Spec: "every day"}, ErrInvalidSpec},
+		{model.ScheduleRequest{Spec: "0 0 30 2 *"}, ErrInvalidSpec},
+		{model.ScheduleRequest{Spec: "@daily", Timezone: "Mars/Olympus"}, ErrInvalidTimezone},
+		{model.ScheduleRequest{Spec: "@daily", Blocks: []string{"../x"}}, ErrInvalidBlock},
+	}
+	for _, c := range cases {
+		_, err := s.Create(ids.MustParse(testKernel), ids.MustParse(testUser), c.req)
+		if !errors.Is(err, c.err) {
+			t.Fatalf("%+v: got %v, expected %v", c.req, err, c.err)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/dnonakolesax/noted-runner/internal/model"
+)
+
+const (
+	schedulesFile = "schedules.json"
+	runsDir       = "runs"
+)
+
+// Store хранит расписания и историю запусков в каталоге на диске реплики. Файлы переписываются
+// целиком через временный файл, поэтому при падении остаётся прежняя версия
+type Store struct {
+	dir     string
+	history int
+	mu      sync.Mutex
+}
+
+// NewStore создаёт хранилище; history - сколько последних запусков помнить на расписание
+func NewStore(dir string, history int) (*Store, error) {
+	err := os.MkdirAll(filepath.Join(dir, runsDir), 0o750)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir, history: history}, nil
+}
+
+func (s *Store) Schedules() ([]model.Schedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var schedules []model.Schedule
+	err := s.read(filepath.Join(s.dir, schedulesFile), &schedules)
+	return schedules, err
+}
+
+func (s *Store) SaveSchedules(schedules []model.Schedule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.write(filepath.Join(s.dir, schedulesFile), schedules)
+}
+
+// SaveRun добавляет запуск в историю расписания или обновляет уже записанный. История упорядочена
+// от последнего запуска к первому
+func (s *Store) SaveRun(run model.ScheduledRun) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := s.runsPath(run.ScheduleID)
+	var runs []model.ScheduledRun
+	err := s.read(path, &runs)
+	if err != nil {
+		return err
+	}
+	updated := false
+	for idx := range runs {
+		if runs[idx].ID == run.ID {
+			runs[idx] = run
+			updated = true
+			break
+		}
+	}
+	if !updated {
+		runs = append(runs, run)
+		slices.SortStableFunc(runs, func(a, b model.ScheduledRun) int { return b.StartedAt.Compare(a.StartedAt) })
+	}
+	if len(runs) > s.history {
+		runs = runs[:s.history]
+	}
+	return s.write(path, runs)
+}
+
+// Runs возвращает историю запусков, начиная с последнего
+func (s *Store) Runs(scheduleID string) ([]model.ScheduledRun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	runs := []model.ScheduledRun{}
+	err := s.read(s.runsPath(scheduleID), &runs)
+	return runs, err
+}
+
+func (s *Store) DropRuns(scheduleID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := os.Remove(s.runsPath(scheduleID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// runsPath не проверяет scheduleID: ID генерирует сам планировщик, а из запроса берётся
+// только ID уже известного расписания
+func (s *Store) runsPath(scheduleID string) string {
+	return filepath.Join(s.dir, runsDir, scheduleID+".json")
+}
+
+func (s *Store) read(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (s *Store) write(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, data, 0o640)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,363 @@
+// Package session хранит сессии пользователей с ядрами. Сессия переживает обрыв соединения:
+// пока идёт grace-период, клиент может вернуться по токену к тому же ядру и дочитать
+// пропущенные сообщения по их номерам
+package session
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/dnonakolesax/noted-runner/internal/configs"
+	"github.com/dnonakolesax/noted-runner/internal/model"
+)
+
+var (
+	ErrUnknown = errors.New("unknown or expired session")
+	ErrBusy    = errors.New("user is already connected to another kernel")
+	ErrExists  = errors.New("kernel already has a session")
+)
+
+const tokenSize = 32
+
+// Sender - подключение клиента, в которое можно писать из любой горутины
+type Sender interface {
+	Send(data []byte) error
+	Close() error
+}
+
+type record struct {
+	seq  uint64
+	data []byte
+}
+
+type Session struct {
+	Token    string
+	UserID   string
+	KernelID string
+
+	mu      sync.Mutex
+	conns   []Sender
+	seq     uint64
+	history []record
+	limit   int
+
+	// attached, timer и ended меняются только под блокировкой хаба
+	attached map[Sender]struct{}
+	timer    *time.Timer
+	ended    bool
+}
+
+// Send нумерует сообщение и отдаёт его всем подключениям сессии - например, вебсокету и SSE сразу.
+// Номер выдаётся под блокировкой сессии, поэтому клиенты получают сообщения строго по порядку.
+// Если ни одно подключение не приняло сообщение, оно уходит в retain
+func (s *Session) Send(msg model.KernelMessage, retain func(data []byte) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	msg.Seq = s.seq
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	delivered := false
+	for _, conn := range s.conns {
+		if conn.Send(data) == nil {
+			delivered = true
+		}
+	}
+	if delivered {
+		s.remember(msg.Seq, data)
+		return nil
+	}
+	return retain(data)
+}
+
+// Conns возвращает текущие подключения сессии
+func (s *Session) Conns() []Sender {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Sender(nil), s.conns...)
+}
+
+func (s *Session) Connected() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.conns) != 0
+}
+
+// Seq - номер последнего выданного сообщения
+func (s *Session) Seq() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.seq
+}
+
+// attach досылает conn сообщения после lastSeq - сначала из истории, затем отложенные pending, -
+// и только потом добавляет его к подключениям, чтобы новые сообщения не обогнали старые.
+// Отложенные сообщения получают и уже подключённые клиенты
+func (s *Session) attach(conn Sender, lastSeq uint64, pending func() [][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, r := range s.history {
+		if r.seq <= lastSeq {
+			continue
+		}
+		err := conn.Send(r.data)
+		if err != nil {
+			return err
+		}
+	}
+	for _, data := range pending() {
+		err := conn.Send(data)
+		if err != nil {
+			return err
+		}
+		for _, other := range s.conns {
+			_ = other.Send(data)
+		}
+		s.remember(seqOf(data), data)
+	}
+	s.conns = append(s.conns, conn)
+	return nil
+}
+
+func (s *Session) detach(conn Sender) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.conns {
+		if s.conns[i] == conn {
+			s.conns = append(s.conns[:i], s.conns[i+1:]...)
+			return
+		}
+	}
+}
+
+func (s *Session) remember(seq uint64, data []byte) {
+	if s.limit <= 0 || seq == 0 {
+		return
+	}
+	if len(s.history) == s.limit {
+		copy(s.history, s.history[1:])
+		s.history = s.history[:len(s.history)-1]
+	}
+	s.history = append(s.history, record{seq: seq, data: data})
+}
+
+// seqOf достаёт номер из отложенного сообщения; у сообщений, пришедших без сессии, его нет
+func seqOf(data []byte) uint64 {
+	var msg struct {
+		Seq uint64 `json:"seq"`
+	}
+	_ = json.Unmarshal(data, &msg)
+	return msg.Seq
+}
+
+// Hub - потокобезопасный реестр сессий: токен -> сессия, ядро -> сессия, пользователь -> подключённая сессия
+type Hub struct {
+	config  *configs.SessionConfig
+	mu      sync.Mutex
+	tokens  map[string]*Session
+	kernels map[string]*Session
+	users   map[string]*Session
+	logger  *slog.Logger
+}
+
+func NewHub(config *configs.SessionConfig, logger *slog.Logger) *Hub {
+	return &Hub{config: config, tokens: make(map[string]*Session), kernels: make(map[string]*Session),
+		users: make(map[string]*Session), logger: logger}
+}
+
+// Open заводит новую сессию с ядром
+func (h *Hub) Open(userID string, kernelID string) (*Session, error) {
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.kernels[kernelID]; ok {
+		return nil, ErrExists
+	}
+	sess := &Session{Token: token, UserID: userID, KernelID: kernelID, limit: h.config.ReplayBuffer,
+		attached: make(map[Sender]struct{})}
+	h.tokens[token] = sess
+	h.kernels[kernelID] = sess
+	return sess, nil
+}
+
+// Resume находит сессию по токену. Чужой токен неотличим от истёкшего
+func (h *Hub) Resume(token string, userID string, kernelID string) (*Session, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sess, ok := h.tokens[token]
+	if !ok || sess.UserID != userID || sess.KernelID != kernelID {
+		return nil, ErrUnknown
+	}
+	return sess, nil
+}
+
+func (h *Hub) Lookup(kernelID string) (*Session, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sess, ok := h.kernels[kernelID]
+	return sess, ok
+}
+
+// Attach подключает conn к сессии, останавливая её grace-период, и досылает ему сообщения после lastSeq.
+// К одной сессии можно подключить несколько клиентов, но пользователь не может держать подключения
+// к разным ядрам одновременно
+func (h *Hub) Attach(sess *Session, conn Sender, lastSeq uint64, pending func() [][]byte) error {
+	h.mu.Lock()
+	if sess.ended {
+		h.mu.Unlock()
+		return ErrUnknown
+	}
+	if other, ok := h.users[sess.UserID]; ok && other != sess {
+		h.mu.Unlock()
+		return ErrBusy
+	}
+	if sess.timer != nil {
+		// если таймер уже сработал, его обработчик увидит, что таймер сменился, и ничего не сделает
+		sess.timer.Stop()
+		sess.timer = nil
+	}
+	sess.attached[conn] = struct{}{}
+	h.users[sess.UserID] = sess
+	h.mu.Unlock()
+
+	err := sess.attach(conn, lastSeq, pending)
+	if err != nil {
+		h.Detach(sess, conn)
+		return err
+	}
+	return nil
+}
+
+// Detach отвязывает подключение, если оно всё ещё подключено к сессии
+func (h *Hub) Detach(sess *Session, conn Sender) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := sess.attached[conn]; !ok {
+		return false
+	}
+	delete(sess.attached, conn)
+	if len(sess.attached) == 0 && h.users[sess.UserID] == sess {
+		delete(h.users, sess.UserID)
+	}
+	sess.detach(conn)
+	return true
+}
+
+// Suspend запускает grace-период сессии, от которой отключились все клиенты. Если никто не вернётся,
+// сессия завершается и вызывается expire. Без grace-периода сессия завершается сразу
+func (h *Hub) Suspend(sess *Session, expire func(sess *Session)) {
+	h.mu.Lock()
+	if sess.ended || len(sess.attached) != 0 || sess.timer != nil {
+		h.mu.Unlock()
+		return
+	}
+	if h.config.GracePeriod <= 0 {
+		h.end(sess)
+		h.mu.Unlock()
+		expire(sess)
+		return
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(h.config.GracePeriod, func() {
+		h.mu.Lock()
+		if sess.timer != timer || sess.ended {
+			h.mu.Unlock()
+			return
+		}
+		h.end(sess)
+		h.mu.Unlock()
+		h.logger.Info("session expired", slog.String("kernel", sess.KernelID))
+		expire(sess)
+	})
+	sess.timer = timer
+	h.mu.Unlock()
+}
+
+// End завершает сессию. Возвращает false, если она уже завершена
+func (h *Hub) End(sess *Session) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if sess.ended {
+		return false
+	}
+	h.end(sess)
+	return true
+}
+
+func (h *Hub) end(sess *Session) {
+	sess.ended = true
+	if sess.timer != nil {
+		sess.timer.Stop()
+		sess.timer = nil
+	}
+	if h.users[sess.UserID] == sess {
+		delete(h.users, sess.UserID)
+	}
+	clear(sess.attached)
+	delete(h.tokens, sess.Token)
+	if h.kernels[sess.KernelID] == sess {
+		delete(h.kernels, sess.KernelID)
+	}
+}
+
+// List возвращает живые сессии пользователя
+func (h *Hub) List(userID string) []*Session {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var sessions []*Session
+	for _, sess := range h.kernels {
+		if sess.UserID == userID {
+			sessions = append(sessions, sess)
+		}
+	}
+	return sessions
+}
+
+func (h *Hub) Connected(userID string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	_, ok := h.users[userID]
+	return ok
+}
+
+// Len - число живых сессий, в том числе ожидающих переподключения
+func (h *Hub) Len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return len(h.kernels)
+}
+
+func newToken() (string, error) {
+	b := make([]byte, tokenSize)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
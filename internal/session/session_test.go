@@ -0,0 +1,237 @@
+package session
+
+import (
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dnonakolesax/noted-runner/internal/configs"
+	"github.com/dnonakolesax/noted-runner/internal/model"
+)
+
+type fakeSender struct {
+	mu     sync.Mutex
+	sent   []string
+	closed bool
+}
+
+func (fs *fakeSender) Send(data []byte) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.closed {
+		return errors.New("closed")
+	}
+	fs.sent = append(fs.sent, string(data))
+	return nil
+}
+
+func (fs *fakeSender) Close() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.closed = true
+	return nil
+}
+
+func (fs *fakeSender) messages() []string {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return append([]string(nil), fs.sent...)
+}
+
+func nothing() [][]byte {
+	return nil
+}
+
+func discard(_ []byte) error {
+	return nil
+}
+
+func newHub(grace time.Duration) *Hub {
+	return NewHub(&configs.SessionConfig{GracePeriod: grace, ReplayBuffer: 3}, slog.Default())
+}
+
+func TestHubStress(t *testing.T) {
+	hub := newHub(time.Hour)
+	var wg sync.WaitGroup
+
+	for i := range 4 {
+		sess, err := hub.Open("user"+strconv.Itoa(i), "kernel"+strconv.Itoa(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for range 200 {
+					conn := &fakeSender{}
+					if hub.Attach(sess, conn, 0, nothing) == nil {
+						hub.Detach(sess, conn)
+						hub.Suspend(sess, func(*Session) {})
+					}
+				}
+			}()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 500 {
+				if sess, ok := hub.Lookup("kernel" + strconv.Itoa(i)); ok {
+					_ = sess.Send(model.KernelMessage{Result: "memes"}, discard)
+				}
+				hub.Connected("user" + strconv.Itoa(i))
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := range 4 {
+		if hub.Connected("user" + strconv.Itoa(i)) {
+			t.Fatalf("user%d is still connected", i)
+		}
+	}
+	if hub.Len() != 4 {
+		t.Fatalf("expected 4 suspended sessions, got %d", hub.Len())
+	}
+}
+
+func TestHubAttach(t *testing.T) {
+	hub := newHub(time.Hour)
+	sess, err := hub.Open("user", "kernel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hub.Open("user", "kernel"); !errors.Is(err, ErrExists) {
+		t.Fatalf("second session for a kernel: %v", err)
+	}
+
+	first := &fakeSender{}
+	second := &fakeSender{}
+	if err := hub.Attach(sess, first, 0, nothing); err != nil {
+		t.Fatalf("first attach failed: %v", err)
+	}
+	if err := hub.Attach(sess, second, 0, nothing); err != nil {
+		t.Fatalf("second attach to the same session failed: %v", err)
+	}
+	_ = sess.Send(model.KernelMessage{Result: "memes"}, discard)
+	if len(first.messages()) != 1 || len(second.messages()) != 1 {
+		t.Fatalf("message was not broadcast: %v %v", first.messages(), second.messages())
+	}
+
+	other, _ := hub.Open("user", "other")
+	if err := hub.Attach(other, &fakeSender{}, 0, nothing); !errors.Is(err, ErrBusy) {
+		t.Fatalf("attach to another kernel: %v", err)
+	}
+
+	if hub.Detach(sess, &fakeSender{}) {
+		t.Fatalf("detach of a stale conn succeeded")
+	}
+	if !hub.Detach(sess, second) || len(sess.Conns()) != 1 || !hub.Connected("user") {
+		t.Fatalf("detach removed the wrong conn")
+	}
+	if !hub.Detach(sess, first) || hub.Connected("user") {
+		t.Fatalf("user is still connected after detach")
+	}
+}
+
+func TestResumeReplaysBySeq(t *testing.T) {
+	hub := newHub(time.Hour)
+	sess, _ := hub.Open("user", "kernel")
+	first := &fakeSender{}
+	if err := hub.Attach(sess, first, 0, nothing); err != nil {
+		t.Fatal(err)
+	}
+
+	var retained [][]byte
+	retain := func(data []byte) error {
+		retained = append(retained, data)
+		return nil
+	}
+	for i := range 4 {
+		if err := sess.Send(model.KernelMessage{Result: strconv.Itoa(i)}, retain); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hub.Detach(sess, first)
+	hub.Suspend(sess, func(*Session) { t.Errorf("session expired during grace period") })
+	if err := sess.Send(model.KernelMessage{Result: "offline"}, retain); err != nil || len(retained) != 1 {
+		t.Fatalf("offline message was not retained: %v", err)
+	}
+
+	if _, err := hub.Resume(sess.Token, "other", "kernel"); !errors.Is(err, ErrUnknown) {
+		t.Fatalf("foreign user resumed a session: %v", err)
+	}
+	resumed, err := hub.Resume(sess.Token, "user", "kernel")
+	if err != nil || resumed != sess {
+		t.Fatalf("resume failed: %v", err)
+	}
+
+	// клиент получил первые два сообщения; третье и четвёртое есть в истории, пятое отложено
+	second := &fakeSender{}
+	if err := hub.Attach(sess, second, 2, func() [][]byte { return retained }); err != nil {
+		t.Fatal(err)
+	}
+	got := second.messages()
+	want := []string{`"seq":3`, `"seq":4`, `"seq":5`}
+	if len(got) != len(want) {
+		t.Fatalf("got %v", got)
+	}
+	for i := range want {
+		if !strings.Contains(got[i], want[i]) {
+			t.Fatalf("message %d: got %s, want %s", i, got[i], want[i])
+		}
+	}
+}
+
+func TestGracePeriod(t *testing.T) {
+	hub := newHub(20 * time.Millisecond)
+	sess, _ := hub.Open("user", "kernel")
+	conn := &fakeSender{}
+	_ = hub.Attach(sess, conn, 0, nothing)
+	hub.Detach(sess, conn)
+
+	expired := make(chan *Session, 1)
+	hub.Suspend(sess, func(s *Session) { expired <- s })
+	// переподключение останавливает grace-период
+	if err := hub.Attach(sess, conn, 0, nothing); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if len(expired) != 0 {
+		t.Fatalf("resumed session expired")
+	}
+
+	hub.Detach(sess, conn)
+	hub.Suspend(sess, func(s *Session) { expired <- s })
+	select {
+	case <-expired:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("session did not expire")
+	}
+	if _, err := hub.Resume(sess.Token, "user", "kernel"); !errors.Is(err, ErrUnknown) {
+		t.Fatalf("expired session resumed: %v", err)
+	}
+	if err := hub.Attach(sess, conn, 0, nothing); !errors.Is(err, ErrUnknown) {
+		t.Fatalf("attached to an expired session: %v", err)
+	}
+	if hub.Len() != 0 {
+		t.Fatalf("expired session is still registered")
+	}
+}
+
+func TestNoGracePeriod(t *testing.T) {
+	hub := newHub(0)
+	sess, _ := hub.Open("user", "kernel")
+	ended := false
+	hub.Suspend(sess, func(*Session) { ended = true })
+	if !ended || hub.Len() != 0 {
+		t.Fatalf("session without grace period was not ended immediately")
+	}
+}
@@ -0,0 +1,280 @@
+// Package testreport разбирает вывод блока, запущенного в режиме тестов (это вывод go test -v -benchmem),
+// и рисует из него таблицы для блокнота
+package testreport
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/dnonakolesax/noted-runner/internal/model"
+	"github.com/dnonakolesax/noted-runner/internal/preproc"
+)
+
+var (
+	eventLine   = regexp.MustCompile(`^=== (RUN|CONT|NAME|PAUSE) +(\S+)$`)
+	resultLine  = regexp.MustCompile(`^ *--- (PASS|FAIL|SKIP): (\S+)(?: \(([0-9.]+)s\))?$`)
+	messageLine = regexp.MustCompile(`^ {4}([^\s:]+):(\d+): (.*)$`)
+	benchLine   = regexp.MustCompile(`^Benchmark\S*$`)
+	headerLine  = regexp.MustCompile(`^(goos|goarch|pkg|cpu): `)
+	procsSuffix = regexp.MustCompile(`-\d+$`)
+)
+
+const continuation = "        "
+
+var statuses = map[string]string{"PASS": model.TestPass, "FAIL": model.TestFail, "SKIP": model.TestSkip}
+
+type parser struct {
+	report  model.TestReport
+	tests   map[string]int
+	benches map[string]int
+	// текущий тест или бенчмарк, к которому относятся сообщения и вывод
+	test    int
+	bench   int
+	message *model.TestMessage
+}
+
+// Parse собирает результаты тестов и бенчмарков из вывода блока. Тест, который начался,
+// но не закончился (ядро прервали), считается упавшим
+func Parse(output string) model.TestReport {
+	p := &parser{tests: make(map[string]int), benches: make(map[string]int), test: -1, bench: -1}
+	for line := range strings.SplitSeq(strings.TrimSuffix(output, "\n"), "\n") {
+		p.line(line)
+	}
+	for idx := range p.report.Tests {
+		if p.report.Tests[idx].Status == "" {
+			p.report.Tests[idx].Status = model.TestFail
+		}
+	}
+	for idx := range p.report.Benchmarks {
+		if p.report.Benchmarks[idx].Status == "" {
+			p.report.Benchmarks[idx].Status = model.TestFail
+		}
+	}
+	return p.report
+}
+
+func (p *parser) line(line string) {
+	if p.message != nil {
+		if rest, ok := strings.CutPrefix(line, continuation); ok {
+			p.message.Text += "\n" + rest
+			return
+		}
+		p.message = nil
+	}
+
+	if m := eventLine.FindStringSubmatch(line); m != nil {
+		p.test, p.bench = p.testIdx(m[2]), -1
+		return
+	}
+	if m := resultLine.FindStringSubmatch(line); m != nil {
+		p.result(m[1], m[2], m[3])
+		return
+	}
+	if m := messageLine.FindStringSubmatch(line); m != nil && (p.test >= 0 || p.bench >= 0) {
+		p.addMessage(m[1], m[2], m[3])
+		return
+	}
+	if benchLine.MatchString(line) {
+		p.test, p.bench = -1, p.benchIdx(line)
+		return
+	}
+	if p.benchResult(line) {
+		return
+	}
+	if line == "PASS" || line == "FAIL" || headerLine.MatchString(line) {
+		return
+	}
+
+	switch {
+	case p.test >= 0:
+		p.report.Tests[p.test].Output += line + "\n"
+	default:
+		p.report.Output += line + "\n"
+	}
+}
+
+func (p *parser) testIdx(name string) int {
+	idx, ok := p.tests[name]
+	if !ok {
+		idx = len(p.report.Tests)
+		p.tests[name] = idx
+		p.report.Tests = append(p.report.Tests, model.TestResult{Name: name})
+	}
+	return idx
+}
+
+func (p *parser) benchIdx(name string) int {
+	idx, ok := p.benches[name]
+	if !ok {
+		idx = len(p.report.Benchmarks)
+		p.benches[name] = idx
+		p.report.Benchmarks = append(p.report.Benchmarks, model.BenchmarkResult{Name: name})
+	}
+	return idx
+}
+
+// result обрабатывает --- PASS/FAIL/SKIP: у тестов после имени идёт время, у бенчмарков его нет
+func (p *parser) result(status string, name string, seconds string) {
+	if _, ok := p.benches[name]; ok || (seconds == "" && strings.HasPrefix(name, "Benchmark")) {
+		idx := p.benchIdx(name)
+		p.report.Benchmarks[idx].Status = statuses[status]
+		p.test, p.bench = -1, idx
+		return
+	}
+	idx := p.testIdx(name)
+	p.report.Tests[idx].Status = statuses[status]
+	p.report.Tests[idx].Seconds, _ = strconv.ParseFloat(seconds, 64)
+	p.test, p.bench = idx, -1
+}
+
+func (p *parser) addMessage(file string, line string, text string) {
+	msg := model.TestMessage{Text: text}
+	if file == preproc.BlockFile {
+		msg.Line, _ = strconv.Atoi(line)
+	} else {
+		msg.Text = file + ":" + line + ": " + text
+	}
+	var messages *[]model.TestMessage
+	if p.test >= 0 {
+		messages = &p.report.Tests[p.test].Messages
+	} else {
+		messages = &p.report.Benchmarks[p.bench].Messages
+	}
+	*messages = append(*messages, msg)
+	p.message = &(*messages)[len(*messages)-1]
+}
+
+// benchResult разбирает строку "BenchmarkX-8  1000  1043 ns/op  16 B/op  1 allocs/op"
+func (p *parser) benchResult(line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "Benchmark") {
+		return false
+	}
+	n, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return false
+	}
+
+	name := fields[0]
+	if p.bench >= 0 {
+		current := p.report.Benchmarks[p.bench].Name
+		if name == current || strings.HasPrefix(name, current+"-") {
+			name = current
+		}
+	}
+	if _, ok := p.benches[name]; !ok {
+		name = procsSuffix.ReplaceAllString(name, "")
+	}
+	res := &p.report.Benchmarks[p.benchIdx(name)]
+	res.Status, res.N = model.TestPass, n
+	for i := 2; i+1 < len(fields); i += 2 {
+		value, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			continue
+		}
+		switch unit := fields[i+1]; unit {
+		case "ns/op":
+			res.NsPerOp = value
+		case "B/op":
+			res.BytesPerOp = int64(value)
+		case "allocs/op":
+			res.AllocsPerOp = int64(value)
+		default:
+			if res.Metrics == nil {
+				res.Metrics = make(map[string]float64)
+			}
+			res.Metrics[unit] = value
+		}
+	}
+	p.test, p.bench = -1, -1
+	return true
+}
+
+// Failed - упал ли хотя бы один тест или бенчмарк
+func Failed(report model.TestReport) bool {
+	for _, test := range report.Tests {
+		if test.Status == model.TestFail {
+			return true
+		}
+	}
+	for _, bench := range report.Benchmarks {
+		if bench.Status == model.TestFail {
+			return true
+		}
+	}
+	return false
+}
+
+// Markdown рисует итог одной строкой и таблицы тестов и бенчмарков; вывод блока и тестов идёт после них
+func Markdown(report model.TestReport) string {
+	var b strings.Builder
+	counts := make(map[string]int)
+	for _, test := range report.Tests {
+		counts[test.Status]++
+	}
+	for _, bench := range report.Benchmarks {
+		counts[bench.Status]++
+	}
+	fmt.Fprintf(&b, "%d passed, %d failed, %d skipped\n", counts[model.TestPass], counts[model.TestFail],
+		counts[model.TestSkip])
+
+	if len(report.Tests) != 0 {
+		b.WriteString("\n| Test | Result | Time | Messages |\n| --- | --- | --- | --- |\n")
+		for _, test := range report.Tests {
+			fmt.Fprintf(&b, "| %s | %s | %.2fs | %s |\n", cell(test.Name), strings.ToUpper(test.Status), test.Seconds,
+				messages(test.Messages))
+		}
+	}
+	if len(report.Benchmarks) != 0 {
+		b.WriteString("\n| Benchmark | Result | Iterations | ns/op | B/op | allocs/op | Messages |\n")
+		b.WriteString("| --- | --- | ---: | ---: | ---: | ---: | --- |\n")
+		for _, bench := range report.Benchmarks {
+			fmt.Fprintf(&b, "| %s | %s | %d | %s | %d | %d | %s |\n", cell(bench.Name), strings.ToUpper(bench.Status),
+				bench.N, nsPerOp(bench.NsPerOp), bench.BytesPerOp, bench.AllocsPerOp, messages(bench.Messages))
+		}
+	}
+
+	if report.Output != "" {
+		fmt.Fprintf(&b, "\nOutput:\n\n```\n%s```\n", report.Output)
+	}
+	for _, test := range report.Tests {
+		if test.Output != "" {
+			fmt.Fprintf(&b, "\nOutput of %s:\n\n```\n%s```\n", test.Name, test.Output)
+		}
+	}
+	return b.String()
+}
+
+func messages(msgs []model.TestMessage) string {
+	parts := make([]string, 0, len(msgs))
+	for _, msg := range msgs {
+		text := cell(msg.Text)
+		if msg.Line != 0 {
+			text = fmt.Sprintf("line %d: %s", msg.Line, text)
+		}
+		parts = append(parts, text)
+	}
+	return strings.Join(parts, "<br>")
+}
+
+// cell экранирует текст для ячейки таблицы Markdown
+func cell(text string) string {
+	text = strings.ReplaceAll(text, "|", `\|`)
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// nsPerOp печатает время операции с той же точностью, что и go test
+func nsPerOp(ns float64) string {
+	switch {
+	case ns == 0 || ns >= 100:
+		return strconv.FormatFloat(ns, 'f', 0, 64)
+	case ns >= 10:
+		return strconv.FormatFloat(ns, 'f', 1, 64)
+	case ns >= 1:
+		return strconv.FormatFloat(ns, 'f', 2, 64)
+	default:
+		return strconv.FormatFloat(ns, 'f', 3, 64)
+	}
+}
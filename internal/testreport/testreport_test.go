@@ -0,0 +1,166 @@
+package testreport
+
+import (
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dnonakolesax/noted-runner/internal/batch"
+	"github.com/dnonakolesax/noted-runner/internal/model"
+	"github.com/dnonakolesax/noted-runner/internal/preproc"
+)
+
+// output - вывод блока с тестами и бенчмарком, как его печатает ядро
+const output = `body
+=== RUN   TestAdd
+    block:5: add(1, 2) = 3
+        want 4
+--- FAIL: TestAdd (0.01s)
+=== RUN   TestPanic
+    block:13: panic: assignment to entry in nil map
+--- FAIL: TestPanic (0.00s)
+=== RUN   TestSub
+=== RUN   TestSub/ok
+    block:16: fine
+=== RUN   TestSub/bad
+    block:17: nope
+--- FAIL: TestSub (0.00s)
+    --- PASS: TestSub/ok (0.00s)
+    --- FAIL: TestSub/bad (0.00s)
+=== RUN   TestPrint
+printed | piped
+--- PASS: TestPrint (0.00s)
+=== RUN   TestSkip
+    block:20: later
+--- SKIP: TestSkip (0.00s)
+FAIL
+goos: linux
+goarch: amd64
+cpu: Intel(R) Xeon(R) Processor @ 2.10GHz
+BenchmarkAdd
+BenchmarkAdd-8   	761999728	         1.613 ns/op	      16 B/op	       1 allocs/op	        42.00 items/op
+BenchmarkBad
+    block:30: nope
+--- FAIL: BenchmarkBad
+PASS
+`
+
+func TestParse(t *testing.T) {
+	report := Parse(output)
+	want := model.TestReport{
+		Tests: []model.TestResult{
+			{Name: "TestAdd", Status: model.TestFail, Seconds: 0.01,
+				Messages: []model.TestMessage{{Line: 5, Text: "add(1, 2) = 3\nwant 4"}}},
+			{Name: "TestPanic", Status: model.TestFail,
+				Messages: []model.TestMessage{{Line: 13, Text: "panic: assignment to entry in nil map"}}},
+			{Name: "TestSub", Status: model.TestFail},
+			{Name: "TestSub/ok", Status: model.TestPass, Messages: []model.TestMessage{{Line: 16, Text: "fine"}}},
+			{Name: "TestSub/bad", Status: model.TestFail, Messages: []model.TestMessage{{Line: 17, Text: "nope"}}},
+			{Name: "TestPrint", Status: model.TestPass, Output: "printed | piped\n"},
+			{Name: "TestSkip", Status: model.TestSkip, Messages: []model.TestMessage{{Line: 20, Text: "later"}}},
+		},
+		Benchmarks: []model.BenchmarkResult{
+			{Name: "BenchmarkAdd", Status: model.TestPass, N: 761999728, NsPerOp: 1.613, BytesPerOp: 16, AllocsPerOp: 1,
+				Metrics: map[string]float64{"items/op": 42}},
+			{Name: "BenchmarkBad", Status: model.TestFail, Messages: []model.TestMessage{{Line: 30, Text: "nope"}}},
+		},
+		Output: "body\n",
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Fatalf("got\n%+v\nexpected\n%+v", report, want)
+	}
+	if !Failed(report) || Failed(model.TestReport{Tests: want.Tests[5:]}) {
+		t.Fatal("Failed doesn't match test statuses")
+	}
+
+	unfinished := Parse("=== RUN   TestLoop\n")
+	if len(unfinished.Tests) != 1 || unfinished.Tests[0].Status != model.TestFail {
+		t.Fatalf("unfinished test is not failed: %+v", unfinished)
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	md := Markdown(Parse(output))
+	for _, want := range []string{
+		"3 passed, 5 failed, 1 skipped\n",
+		"| TestAdd | FAIL | 0.01s | line 5: add(1, 2) = 3 want 4 |\n",
+		"| TestSub/bad | FAIL | 0.00s | line 17: nope |\n",
+		"| BenchmarkAdd | PASS | 761999728 | 1.61 | 16 | 1 |  |\n",
+		"Output:\n\n```\nbody\n```\n",
+		"Output of TestPrint:\n\n```\nprinted | piped\n```\n",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("no %q in\n%s", want, md)
+		}
+	}
+}
+
+// TestBlockTests собирает блок с тестами настоящим плагином, поэтому нужен go и CGO
+func TestBlockTests(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds plugins")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is not installed")
+	}
+	src := `func sum(xs ...int) int {
+	total := 0
+	for _, x := range xs {
+		total += x
+	}
+	return total
+}
+
+func TestSum(t *testing.T) {
+	if got := sum(1, 2); got != 4 {
+		t.Errorf("sum(1, 2) = %d", got)
+	}
+}
+
+func TestPanic(t *testing.T) {
+	var m map[string]int
+	m["x"] = 1
+}
+
+func BenchmarkSum(b *testing.B) {
+	for b.Loop() {
+		sum(1, 2, 3)
+	}
+}
+fmt.Println("block body")`
+	const blockID = "0b7e3f52-6a8c-4d47-9a64-3c1c0f1d2e11"
+	block := preproc.NewBlock(blockID, src, preproc.NewKernelTypes())
+	if err := block.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	code, err := block.FormTestFunc("at1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := batch.NewLocalKernel(t.TempDir(), time.Minute).Exec(blockID, code,
+		"Export_block_"+strings.ReplaceAll(blockID, "-", "_")+"_at1")
+	if err != nil && strings.Contains(err.Error(), "plugin") {
+		t.Skipf("plugins are not supported here: %s", err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := Parse(out)
+	if report.Output != "block body\n" || len(report.Tests) != 2 || len(report.Benchmarks) != 1 {
+		t.Fatalf("unexpected report %+v from\n%s", report, out)
+	}
+	sum, panicked, bench := report.Tests[0], report.Tests[1], report.Benchmarks[0]
+	if sum.Status != model.TestFail ||
+		!reflect.DeepEqual(sum.Messages, []model.TestMessage{{Line: 11, Text: "sum(1, 2) = 3"}}) {
+		t.Errorf("unexpected TestSum result %+v", sum)
+	}
+	if panicked.Status != model.TestFail || len(panicked.Messages) != 1 || panicked.Messages[0].Line != 17 {
+		t.Errorf("unexpected TestPanic result %+v", panicked)
+	}
+	if bench.Status != model.TestPass || bench.N == 0 || bench.NsPerOp == 0 {
+		t.Errorf("unexpected BenchmarkSum result %+v", bench)
+	}
+}
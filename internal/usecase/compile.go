@@ -2,54 +2,92 @@ package usecase
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
-	"net/http"
 	"os"
 	"os/exec"
 	"strconv"
 	"strings"
-	"sync"
+	"time"
 
-	"github.com/automerge/automerge-go"
+	"github.com/dnonakolesax/noted-runner/internal/blocksource"
 	"github.com/dnonakolesax/noted-runner/internal/configs"
 	"github.com/dnonakolesax/noted-runner/internal/docker"
-	"github.com/dnonakolesax/noted-runner/internal/httpclient"
+	"github.com/dnonakolesax/noted-runner/internal/ids"
+	"github.com/dnonakolesax/noted-runner/internal/ipynb"
+	"github.com/dnonakolesax/noted-runner/internal/kernelcmd"
+	"github.com/dnonakolesax/noted-runner/internal/languages"
 	"github.com/dnonakolesax/noted-runner/internal/logger"
+	"github.com/dnonakolesax/noted-runner/internal/model"
+	"github.com/dnonakolesax/noted-runner/internal/mount"
 	"github.com/dnonakolesax/noted-runner/internal/preproc"
+	"github.com/dnonakolesax/noted-runner/internal/registry"
 )
 
+// KernelOwner закрепляет ядро за этой репликой раннера
+type KernelOwner interface {
+	Claim(kernelID ids.ID) error
+	Release(kernelID ids.ID) error
+}
+
+// KernelCommands доставляет команды контейнерам ядер
+type KernelCommands interface {
+	Open(kernelID ids.ID) error
+	Close(kernelID ids.ID) error
+	Send(ctx context.Context, kernelID ids.ID, cmd model.KernelCommand) (model.KernelReply, error)
+}
+
 type Compile struct {
-	client         *docker.DockerClient
-	mountPath      string
-	kernelPrefix   string
-	kernelMuxes    map[string]*sync.Mutex
-	kernelTypes    map[string]*preproc.KernelTypes
-	kernelAttempts map[string]int
-	logger         *slog.Logger
-	sConfig        *configs.ServiceConfig
-	hClient        *httpclient.HTTPClient
-}
-
-func NewCompilerUsecase(client *docker.DockerClient, mountPath string, kernelPrefix string, logger *slog.Logger,
-	sConfig *configs.ServiceConfig, hClient *httpclient.HTTPClient) *Compile {
-	return &Compile{client: client, mountPath: mountPath, kernelPrefix: kernelPrefix,
-		kernelMuxes:    make(map[string]*sync.Mutex),
-		kernelTypes:    map[string]*preproc.KernelTypes{},
-		kernelAttempts: map[string]int{},
-		logger:         logger,
-		sConfig:        sConfig,
-		hClient:        hClient,
-	}
-}
-
-func (uc *Compile) StartKernel(kernelID string, userID string) (string, error) {
-	uc.kernelMuxes[kernelID+userID] = &sync.Mutex{}
-	uc.kernelTypes[kernelID+userID] = preproc.NewKernelTypes()
+	client       *docker.DockerClient
+	owner        KernelOwner
+	commands     KernelCommands
+	root         *mount.Root
+	blocks       blocksource.Loader
+	kernelPrefix string
+	kernels      *registry.Kernels
+	magics       *preproc.Magics
+	languages    languages.Handlers
+	logger       *slog.Logger
+	sConfig      *configs.ServiceConfig
+}
+
+func NewCompilerUsecase(client *docker.DockerClient, owner KernelOwner, commands KernelCommands, mountPath string,
+	kernelPrefix string, magics *preproc.Magics, logger *slog.Logger, sConfig *configs.ServiceConfig) *Compile {
+	root := mount.NewRoot(mountPath)
+	return &Compile{client: client, owner: owner, commands: commands, root: root,
+		blocks:       blocksource.NewFileLoader(root),
+		kernelPrefix: kernelPrefix,
+		kernels:      registry.NewKernels(),
+		magics:       magics,
+		languages:    languages.NewHandlers(magics),
+		logger:       logger,
+		sConfig:      sConfig,
+	}
+}
+
+// StartKernel поднимает контейнер ядра. Одновременные запуски одного ядра не мешают друг другу:
+// все, кроме первого, сразу получают registry.ErrReserved и ничего не трогают
+func (uc *Compile) StartKernel(kernelID ids.ID, userID ids.ID) (string, error) {
+	key := kernelKey(kernelID, userID)
+	if !uc.kernels.Reserve(kernelID.String()) {
+		return "", fmt.Errorf("%w: %s", registry.ErrReserved, kernelID)
+	}
+	defer uc.kernels.Unreserve(kernelID.String())
+
+	err := uc.commands.Open(kernelID)
+	if err != nil {
+		uc.logger.Error("error opening kernel commands", logger.LogError(err))
+		return "", err
+	}
+
 	//id, err := uc.client.Create(fmt.Sprintf("%s%s_u%s", uc.kernelPrefix, kernelID, userID), kernelID)
-	id, err := uc.client.Create(fmt.Sprintf("%s%s", uc.kernelPrefix, kernelID), kernelID)
+	id, err := uc.client.Create(uc.kernelPrefix+kernelID.String(), kernelID)
 	if err != nil {
 		uc.logger.Error("error starting kernel", logger.LogError(err))
+		uc.closeCommands(kernelID)
 		return "", err
 	}
 
@@ -57,58 +95,107 @@ func (uc *Compile) StartKernel(kernelID string, userID string) (string, error) {
 
 	if err != nil {
 		uc.logger.Error("error running kernel", logger.LogError(err))
+		if rmErr := uc.client.Remove(id); rmErr != nil {
+			uc.logger.Error("error removing kernel container", logger.LogError(rmErr), slog.String("container", id))
+		}
+		uc.closeCommands(kernelID)
 		return "", err
 	}
-	return id, nil
-}
-
-func (uc *Compile) RunBlock(kernelID string, blockID string, userID string) error {
-	uc.kernelMuxes[kernelID+userID].Lock()
-	defer uc.kernelMuxes[kernelID+userID].Unlock()
-	att := uc.kernelAttempts[kernelID+userID+blockID] + 1
-	uc.kernelAttempts[kernelID + userID + blockID] = att
 
-	attempt := "at" + strconv.Itoa(att)
-	sourcePath := fmt.Sprintf("%s/%s/%s", uc.mountPath, kernelID, "block_"+blockID)
+	if _, ok := uc.kernels.Attach(key, kernelID.String(), id); !ok {
+		_ = uc.client.Remove(id)
+		return "", fmt.Errorf("kernel %s is already started", kernelID)
+	}
 
-	err := os.MkdirAll(fmt.Sprintf("%s/%s/%s", uc.mountPath, kernelID, userID), 0o777)
+	err = uc.owner.Claim(kernelID)
 	if err != nil {
-		uc.logger.Error("error mkdirall:", logger.LogError(err), slog.String("file", sourcePath))
-		return err
+		uc.logger.Error("error claiming kernel", logger.LogError(err))
+		_ = uc.StopKernel(kernelID, userID)
+		return "", err
 	}
+	return id, nil
+}
 
-	filePath := fmt.Sprintf("%s/%s/%s/%s", uc.mountPath, kernelID, userID, "block_"+blockID)
+// closeCommands закрывает очередь команд ядра, которое остановлено или не запустилось
+func (uc *Compile) closeCommands(kernelID ids.ID) {
+	if err := uc.commands.Close(kernelID); err != nil {
+		uc.logger.Error("error closing kernel commands", logger.LogError(err))
+	}
+}
 
-	file, err := os.ReadFile(sourcePath)
+var (
+	// ErrUnsupportedLanguage - для языка блока нет обработчика
+	ErrUnsupportedLanguage = errors.New("unsupported block language")
+	ErrUnknownMode         = errors.New("unknown run mode")
+)
 
-	if err != nil {
-		uc.logger.Error("error reading file with block", logger.LogError(err), slog.String("file", sourcePath))
-		return err
+// RunBlock собирает блок и отправляет его ядру. params переопределяют переменные блока параметров,
+// heads выбирают версию блока; без heads выполняется текущая. В режиме model.ModeTest после блока
+// выполняются его тесты и бенчмарки. Блок собирает обработчик его языка: Go, Markdown или SQL
+func (uc *Compile) RunBlock(kernelID ids.ID, blockID ids.ID, userID ids.ID, params map[string]json.RawMessage,
+	heads []string, mode string) (model.BlockRun, error) {
+	if mode == "" {
+		mode = model.ModeRun
+	}
+	if mode != model.ModeRun && mode != model.ModeTest {
+		return model.BlockRun{}, fmt.Errorf("%w: %s", ErrUnknownMode, mode)
+	}
+	kernel, ok := uc.kernels.Lookup(kernelKey(kernelID, userID))
+	if !ok {
+		return model.BlockRun{}, fmt.Errorf("kernel %s is not started", kernelID)
 	}
+	kernel.Lock()
+	defer kernel.Unlock()
+	att := kernel.NextAttempt(blockID.String())
 
-	doc, _ := automerge.Load(file)
+	attempt := "at" + strconv.Itoa(att)
+	run := model.BlockRun{Mode: mode, Attempt: attempt}
+	source, err := uc.blocks.Load(kernelID.String(), blockID.String(), heads)
+	if err != nil {
+		uc.logger.Error("error loading block", logger.LogError(err), slog.String("block", blockID.String()))
+		return run, err
+	}
+	run.Heads = source.Heads
+	run.SourceHash = fmt.Sprintf("%x", sha256.Sum256([]byte(source.Text)))
+	handler, ok := uc.languages.Lookup(source.Language)
+	if !ok {
+		return run, fmt.Errorf("%w: %s", ErrUnsupportedLanguage, source.Language)
+	}
 
-	dataFile, _ := doc.Path("text").Text().Get()
+	userDir, err := uc.root.Path(kernelID.String(), userID.String())
+	if err != nil {
+		return run, err
+	}
 
-	types := uc.kernelTypes[kernelID+userID]
-	block := preproc.NewBlock(blockID, dataFile, types)
+	err = os.MkdirAll(userDir, 0o777)
+	if err != nil {
+		uc.logger.Error("error mkdirall:", logger.LogError(err), slog.String("file", userDir))
+		return run, err
+	}
 
-	err = block.Parse()
+	filePath, err := uc.root.Path(kernelID.String(), userID.String(), "block_"+blockID.String())
+	if err != nil {
+		return run, err
+	}
 
+	prepared, err := handler.Prepare(languages.Request{BlockID: blockID.String(), Source: source.Text,
+		Attempt: attempt, Mode: mode, Params: params, Types: kernel.Types})
 	if err != nil {
-		uc.logger.Error("error parsing block", logger.LogError(err))
-		return fmt.Errorf("error parsing block: %s", err)
+		uc.logger.Error("error preparing block", logger.LogError(err), slog.String("language", source.Language))
+		return run, err
 	}
 
-	code := block.FormExportFunc(attempt)
+	run.Parameters = prepared.Parameters
+	code := prepared.Code
 
 	//fmt.Printf("code: %s", code)
 
-	err = os.WriteFile(filePath+".go", []byte(code), os.ModeExclusive)
+	run.CodePath = filePath + ".go"
+	err = os.WriteFile(run.CodePath, []byte(code), os.ModeExclusive)
 
 	if err != nil {
 		uc.logger.Error("error saving block file", logger.LogError(err), slog.String("file", filePath+".go"))
-		return err
+		return run, err
 	}
 
 	// ctxI, cancelI := context.WithTimeout(context.Background(), uc.sConfig.CMDTimeout)
@@ -123,35 +210,236 @@ func (uc *Compile) RunBlock(kernelID string, blockID string, userID string) erro
 	ctx, cancel := context.WithTimeout(context.Background(), uc.sConfig.CompileTimeout)
 	defer cancel()
 
-	filePath2 := fmt.Sprintf("%s/%s/%s/%s_%s.so", uc.mountPath, kernelID, userID, "block_"+strings.ReplaceAll(blockID, "-", "_"), attempt)
+	filePath2, err := uc.root.Path(kernelID.String(), userID.String(), "block_"+blockID.Ident()+"_"+attempt+".so")
+	if err != nil {
+		return run, err
+	}
 	cmd := exec.CommandContext(ctx, "go", "build", "-buildmode=plugin", "-o", filePath2, filePath+".go")
+	compileStart := time.Now()
 	out, err := cmd.CombinedOutput()
+	run.CompileTime = time.Since(compileStart)
 	if err != nil {
 		uc.logger.Error("error building", logger.LogError(err), slog.String("file", filePath2))
-		return fmt.Errorf("error running go build: %v\nOutput: %s", err, out)
+		return run, fmt.Errorf("error running go build: %v\nOutput: %s", err, out)
 	}
 
 	os.Chmod(filePath2, 0o777)
-	//slog.Info("before resp")
-	//resp, err := http.Get("http://" + uc.kernelPrefix + kernelID + "_u" + userID + ":8080/run?block_id=" + blockID + "&user_id=" + userID + "&attempt=" + attempt)
-	resp, err := http.Get("http://" + uc.kernelPrefix + kernelID + ":8080/run?block_id=" + blockID + "&user_id=" + userID + "&attempt=" + attempt)
-	//slog.Info("after resp")
+
+	execute := kernelcmd.NewCommand(model.CommandExecute)
+	execute.BlockID = blockID.String()
+	execute.UserID = userID.String()
+	execute.Attempt = attempt
+	_, err = uc.commands.Send(context.Background(), kernelID, execute)
 	if err != nil {
-		uc.logger.Error("error sending http", logger.LogError(err))
-		return err
+		uc.logger.Error("error sending execute command", logger.LogError(err))
+		return run, err
 	}
 
-	defer func() {
-		_ = resp.Body.Close()
-	}()
-	return nil
+	if prepared.Commit != nil {
+		prepared.Commit()
+	}
+	run.Warnings = prepared.Warnings
+	return run, nil
+}
+
+// ErrUnknownLayout - неизвестная раскладка экспорта
+var ErrUnknownLayout = errors.New("unknown export layout")
+
+const exportPackage = "notebook"
+
+// ExportNotebook собирает блоки в программу на Go. Без blockIDs берутся все блоки ядра по порядку ID.
+// Запущенное ядро не нужно: блоки читаются в текущей версии, а то, что не собирается, попадает в Skipped
+func (uc *Compile) ExportNotebook(kernelID ids.ID, blockIDs []ids.ID, layout string) (model.NotebookExport, error) {
+	if layout != model.ExportMain && layout != model.ExportPackage {
+		return model.NotebookExport{}, fmt.Errorf("%w: %s", ErrUnknownLayout, layout)
+	}
+	blockIDs, err := uc.notebookBlocks(kernelID, blockIDs)
+	if err != nil {
+		return model.NotebookExport{}, err
+	}
+
+	program := preproc.NewProgram()
+	for _, blockID := range blockIDs {
+		source, err := uc.blocks.Load(kernelID.String(), blockID.String(), nil)
+		switch {
+		case errors.Is(err, blocksource.ErrNotFound):
+			return model.NotebookExport{}, err
+		case err != nil:
+			program.Skip(blockID.String(), err.Error())
+		case source.Language != "" && source.Language != "go":
+			program.Skip(blockID.String(), fmt.Sprintf("%s: %s", ErrUnsupportedLanguage, source.Language))
+		default:
+			program.Add(blockID.String(), source.Text)
+		}
+	}
+
+	export := model.NotebookExport{}
+	if layout == model.ExportPackage {
+		export.Files, err = program.Package(exportPackage)
+	} else {
+		var src string
+		src, err = program.Main()
+		export.Files = map[string]string{"main.go": src}
+	}
+	if err != nil {
+		uc.logger.Error("error exporting notebook", logger.LogError(err), slog.String("kernel", kernelID.String()))
+		return model.NotebookExport{}, err
+	}
+	for _, skipped := range program.Skipped() {
+		export.Skipped = append(export.Skipped, model.SkippedBlock{BlockID: skipped.BlockID, Reason: skipped.Reason})
+	}
+	return export, nil
 }
 
-func (uc *Compile) StopKernel(id string) error {
-	err := uc.client.Remove(id)
+// ImportNotebook создаёт блоки ядра из ячеек .ipynb. ID блоков растут по порядку ячеек,
+// поэтому упорядоченные по ID блоки идут так же, как ячейки
+func (uc *Compile) ImportNotebook(kernelID ids.ID, data []byte) (model.NotebookImport, error) {
+	res, err := ipynb.Import(data)
+	if err != nil {
+		return model.NotebookImport{}, err
+	}
+
+	imported := model.NotebookImport{Blocks: make([]model.ImportedBlock, 0, len(res.Blocks))}
+	now := time.Now()
+	for idx, block := range res.Blocks {
+		blockID := ids.New(now.Add(time.Duration(idx) * time.Millisecond))
+		err = uc.blocks.Store(kernelID.String(), blockID.String(),
+			blocksource.Source{Text: block.Text, Language: block.Language})
+		if err != nil {
+			uc.logger.Error("error storing imported block", logger.LogError(err), slog.Int("cell", block.Cell))
+			return imported, err
+		}
+		imported.Blocks = append(imported.Blocks, model.ImportedBlock{Cell: block.Cell, BlockID: blockID.String(),
+			Language: block.Language})
+	}
+	for _, note := range res.Notes {
+		imported.Notes = append(imported.Notes, model.CellNote{Cell: note.Cell, Note: note.Note})
+	}
+	for _, note := range res.Skipped {
+		imported.Skipped = append(imported.Skipped, model.CellNote{Cell: note.Cell, Note: note.Note})
+	}
+	return imported, nil
+}
+
+// NotebookIPYNB выгружает блоки в .ipynb с выводом их последних выполнений, которые lastRuns находит
+// разом для всех блоков. Порядок блоков - как в ExportNotebook
+func (uc *Compile) NotebookIPYNB(kernelID ids.ID, blockIDs []ids.ID,
+	lastRuns func(blockIDs []string) map[string]model.ExecutionRecord) ([]byte, error) {
+	blockIDs, err := uc.notebookBlocks(kernelID, blockIDs)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(blockIDs))
+	for _, blockID := range blockIDs {
+		names = append(names, blockID.String())
+	}
+	records := lastRuns(names)
+	cells := make([]ipynb.ExportCell, 0, len(blockIDs))
+	for _, blockID := range blockIDs {
+		source, err := uc.blocks.Load(kernelID.String(), blockID.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		cell := ipynb.ExportCell{BlockID: blockID.String(), Text: source.Text, Language: source.Language}
+		if record, ok := records[blockID.String()]; ok {
+			cell.Executed, cell.Output, cell.Error = true, record.Output, record.Error
+		}
+		cells = append(cells, cell)
+	}
+	return ipynb.Export(cells)
+}
+
+// notebookBlocks возвращает blockIDs, а без них - все блоки ядра по порядку ID
+func (uc *Compile) notebookBlocks(kernelID ids.ID, blockIDs []ids.ID) ([]ids.ID, error) {
+	if len(blockIDs) != 0 {
+		return blockIDs, nil
+	}
+	names, err := uc.blocks.List(kernelID.String())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: kernel %s", blocksource.ErrNotFound, kernelID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if blockID, err := ids.Parse(name); err == nil {
+			blockIDs = append(blockIDs, blockID)
+		}
+	}
+	return blockIDs, nil
+}
+
+func (uc *Compile) ForgetBlock(kernelID ids.ID, blockID ids.ID, userID ids.ID) ([]string, error) {
+	kernel, ok := uc.kernels.Lookup(kernelKey(kernelID, userID))
+	if !ok {
+		return nil, fmt.Errorf("kernel %s is not started", kernelID)
+	}
+	kernel.Lock()
+	defer kernel.Unlock()
+
+	dependants := kernel.Types.Forget(blockID.String())
+	if len(dependants) == 0 {
+		return nil, nil
+	}
+	return []string{fmt.Sprintf("block %s deleted, re-run dependent blocks: %s", blockID,
+		strings.Join(dependants, ", "))}, nil
+}
+
+func (uc *Compile) StopKernel(kernelID ids.ID, userID ids.ID) error {
+	kernel, ok := uc.kernels.Evict(kernelKey(kernelID, userID))
+	if !ok {
+		return fmt.Errorf("kernel %s is not started", kernelID)
+	}
+	err := uc.owner.Release(kernelID)
+	if err != nil {
+		uc.logger.Error("error releasing kernel", logger.LogError(err))
+	}
+
+	uc.closeCommands(kernelID)
+
+	err = uc.client.Remove(kernel.ContainerID)
 	if err != nil {
 		uc.logger.Error("error removing kernel container", logger.LogError(err))
 		return err
 	}
 	return nil
 }
+
+// InterruptKernel прерывает выполняющийся блок. Блокировку ядра не берёт: её держит как раз RunBlock
+func (uc *Compile) InterruptKernel(kernelID ids.ID, userID ids.ID) error {
+	if _, ok := uc.kernels.Lookup(kernelKey(kernelID, userID)); !ok {
+		return fmt.Errorf("kernel %s is not started", kernelID)
+	}
+	cmd := kernelcmd.NewCommand(model.CommandInterrupt)
+	cmd.UserID = userID.String()
+	_, err := uc.commands.Send(context.Background(), kernelID, cmd)
+	return err
+}
+
+// InspectKernel возвращает состояние ядра так, как его описывает само ядро
+func (uc *Compile) InspectKernel(kernelID ids.ID, userID ids.ID) (json.RawMessage, error) {
+	if _, ok := uc.kernels.Lookup(kernelKey(kernelID, userID)); !ok {
+		return nil, fmt.Errorf("kernel %s is not started", kernelID)
+	}
+	cmd := kernelcmd.NewCommand(model.CommandInspect)
+	cmd.UserID = userID.String()
+	reply, err := uc.commands.Send(context.Background(), kernelID, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return reply.Data, nil
+}
+
+// ReleaseKernel забывает состояние ядра, которое забрала другая реплика. Контейнер продолжает работать,
+// и при остановке этой реплики он тоже не удаляется
+func (uc *Compile) ReleaseKernel(kernelID ids.ID) {
+	evicted := uc.kernels.EvictKernel(kernelID.String())
+	for _, kernel := range evicted {
+		uc.client.Forget(kernel.ContainerID)
+	}
+	uc.logger.Info("kernel released", slog.String("kernel", kernelID.String()), slog.Int("sessions", len(evicted)))
+}
+
+func kernelKey(kernelID ids.ID, userID ids.ID) string {
+	return kernelID.String() + userID.String()
+}
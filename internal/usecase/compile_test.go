@@ -1,18 +1,30 @@
 package usecase
 
 import (
+	"errors"
+	"fmt"
 	"log/slog"
-	"sync"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/automerge/automerge-go"
+	"github.com/dnonakolesax/noted-runner/internal/blocksource"
 	"github.com/dnonakolesax/noted-runner/internal/configs"
 	"github.com/dnonakolesax/noted-runner/internal/httpclient"
+	"github.com/dnonakolesax/noted-runner/internal/ids"
+	"github.com/dnonakolesax/noted-runner/internal/kernelcmd"
 	"github.com/dnonakolesax/noted-runner/internal/metrics"
+	"github.com/dnonakolesax/noted-runner/internal/model"
+	"github.com/dnonakolesax/noted-runner/internal/preproc"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 func TestCompile(t *testing.T) {
+	if _, err := os.Stat("/noted/codes/kernels/0b3c9a52-6f2e-4d0c-9a57-2f8b1c3d4e5f/block_4bcb102d_d663_4bec_86b4_86e978b5b54c"); err != nil {
+		t.Skip("block source is not mounted")
+	}
 	lg := slog.Default()
 	scfg := &configs.ServiceConfig{CompileTimeout: time.Minute, CMDTimeout: time.Minute}
 
@@ -26,12 +38,142 @@ func TestCompile(t *testing.T) {
 		t.Fatalf("%s", err.Error())
 	}
 
-	uc := NewCompilerUsecase(nil, "/noted/codes/kernels", "noted-kernel_", lg, scfg, client)
+	uc := NewCompilerUsecase(nil, nil, kernelcmd.NewHTTPCommander(client, "noted-kernel_", "8080"),
+		"/noted/codes/kernels", "noted-kernel_", preproc.DefaultMagics(), lg, scfg)
 
-	uc.kernelMuxes["11"] = &sync.Mutex{}
-	err = uc.RunBlock("1", "4bcb102d_d663_4bec_86b4_86e978b5b54c", "1")
+	kernelID := ids.MustParse("0b3c9a52-6f2e-4d0c-9a57-2f8b1c3d4e5f")
+	userID := ids.MustParse("7d1e2f3a-4b5c-4d6e-8f90-a1b2c3d4e5f6")
+	uc.kernels.Attach(kernelKey(kernelID, userID), kernelID.String(), "")
+	_, err = uc.RunBlock(kernelID, ids.MustParse("4bcb102d_d663_4bec_86b4_86e978b5b54c"), userID, nil, nil, model.ModeRun)
 
 	if err != nil {
 		t.Fatalf("%s", err.Error())
 	}
 }
+
+func TestRunBlockSourceErrors(t *testing.T) {
+	uc := NewCompilerUsecase(nil, nil, nil, t.TempDir(), "noted-kernel_", preproc.DefaultMagics(), slog.Default(),
+		&configs.ServiceConfig{})
+	loader := blocksource.NewMemoryLoader()
+	uc.blocks = loader
+
+	kernelID := ids.MustParse("0b3c9a52-6f2e-4d0c-9a57-2f8b1c3d4e5f")
+	userID := ids.MustParse("7d1e2f3a-4b5c-4d6e-8f90-a1b2c3d4e5f6")
+	uc.kernels.Attach(kernelKey(kernelID, userID), kernelID.String(), "")
+	corrupt := ids.MustParse("4bcb102d-d663-4bec-86b4-86e978b5b54c")
+	loader.PutRaw(kernelID.String(), corrupt.String(), []byte("half-synced"))
+	python := ids.MustParse("5c1d2e3f-d663-4bec-86b4-86e978b5b54c")
+	doc := automerge.New()
+	_ = doc.Path("text").Set(automerge.NewText("print(1)"))
+	_ = doc.Path("language").Set("python")
+	loader.Put(kernelID.String(), python.String(), doc)
+
+	cases := map[ids.ID]error{
+		ids.MustParse("6d2e3f4a-d663-4bec-86b4-86e978b5b54c"): blocksource.ErrNotFound,
+		corrupt: blocksource.ErrCorrupt,
+		python:  ErrUnsupportedLanguage,
+	}
+	for blockID, want := range cases {
+		run, err := uc.RunBlock(kernelID, blockID, userID, nil, nil, model.ModeRun)
+		if !errors.Is(err, want) {
+			t.Errorf("block %s: expected %v, got %v", blockID, want, err)
+		}
+		if run.CodePath != "" {
+			t.Errorf("block %s was compiled: %s", blockID, run.CodePath)
+		}
+	}
+}
+
+func TestExportNotebook(t *testing.T) {
+	uc := NewCompilerUsecase(nil, nil, nil, t.TempDir(), "noted-kernel_", preproc.DefaultMagics(), slog.Default(),
+		&configs.ServiceConfig{})
+	loader := blocksource.NewMemoryLoader()
+	uc.blocks = loader
+
+	kernelID := ids.MustParse("0b3c9a52-6f2e-4d0c-9a57-2f8b1c3d4e5f")
+	put := func(blockID string, text string, language string) {
+		doc := automerge.New()
+		_ = doc.Path("text").Set(automerge.NewText(text))
+		if language != "" {
+			_ = doc.Path("language").Set(language)
+		}
+		loader.Put(kernelID.String(), blockID, doc)
+	}
+	put("1a2b3c4d-d663-4bec-86b4-86e978b5b54c", "func square(x int) int {\nreturn x * x\n}", "")
+	put("2a2b3c4d-d663-4bec-86b4-86e978b5b54c", "# Notes", "markdown")
+	put("3a2b3c4d-d663-4bec-86b4-86e978b5b54c", "fmt.Println(square(3))", "go")
+	loader.PutRaw(kernelID.String(), "4a2b3c4d-d663-4bec-86b4-86e978b5b54c", []byte("half-synced"))
+
+	export, err := uc.ExportNotebook(kernelID, nil, model.ExportMain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(export.Files["main.go"], "fmt.Println(square(3))") {
+		t.Fatalf("unexpected program:\n%s", export.Files["main.go"])
+	}
+	if len(export.Skipped) != 2 || export.Skipped[0].BlockID != "2a2b3c4d-d663-4bec-86b4-86e978b5b54c" ||
+		export.Skipped[1].BlockID != "4a2b3c4d-d663-4bec-86b4-86e978b5b54c" {
+		t.Fatalf("unexpected skipped blocks: %v", export.Skipped)
+	}
+
+	export, err = uc.ExportNotebook(kernelID, []ids.ID{ids.MustParse("1a2b3c4d-d663-4bec-86b4-86e978b5b54c")},
+		model.ExportPackage)
+	if err != nil || len(export.Files) != 3 || len(export.Skipped) != 0 {
+		t.Fatalf("unexpected package export: %v, %v", export, err)
+	}
+	_, err = uc.ExportNotebook(kernelID, []ids.ID{ids.MustParse("6d2e3f4a-d663-4bec-86b4-86e978b5b54c")},
+		model.ExportMain)
+	if !errors.Is(err, blocksource.ErrNotFound) {
+		t.Fatalf("missing block: expected not found, got %v", err)
+	}
+	if _, err = uc.ExportNotebook(kernelID, nil, "zip"); !errors.Is(err, ErrUnknownLayout) {
+		t.Fatalf("unknown layout is accepted: %v", err)
+	}
+}
+
+func TestImportNotebook(t *testing.T) {
+	uc := NewCompilerUsecase(nil, nil, nil, t.TempDir(), "noted-kernel_", preproc.DefaultMagics(), slog.Default(),
+		&configs.ServiceConfig{})
+	loader := blocksource.NewMemoryLoader()
+	uc.blocks = loader
+	kernelID := ids.MustParse("0b3c9a52-6f2e-4d0c-9a57-2f8b1c3d4e5f")
+
+	var cells []string
+	for idx := range 12 {
+		cells = append(cells, fmt.Sprintf(`{"cell_type": "code", "metadata": {}, "outputs": [], "source": "x%d := %d"}`,
+			idx, idx))
+	}
+	cells = append(cells, `{"cell_type": "raw", "metadata": {}, "source": "raw"}`)
+	data := `{"nbformat": 4, "nbformat_minor": 5, "metadata": {}, "cells": [` + strings.Join(cells, ",") + `]}`
+
+	imported, err := uc.ImportNotebook(kernelID, []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imported.Blocks) != 12 || len(imported.Skipped) != 1 || imported.Skipped[0].Cell != 13 {
+		t.Fatalf("unexpected import %+v", imported)
+	}
+	listed, err := loader.List(kernelID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for idx, blockID := range listed {
+		if blockID != imported.Blocks[idx].BlockID {
+			t.Fatalf("blocks are not listed in cell order: %v", listed)
+		}
+	}
+
+	lastRuns := func(blockIDs []string) map[string]model.ExecutionRecord {
+		if len(blockIDs) != len(listed) {
+			t.Fatalf("history is not asked for all blocks at once: %v", blockIDs)
+		}
+		return map[string]model.ExecutionRecord{listed[0]: {Output: "ran " + listed[0]}}
+	}
+	nb, err := uc.NotebookIPYNB(kernelID, nil, lastRuns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(nb), "ran "+listed[0]) || !strings.Contains(string(nb), `"x11 := 11"`) {
+		t.Fatalf("unexpected notebook %s", nb)
+	}
+}
@@ -0,0 +1,58 @@
+package usecase
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/dnonakolesax/noted-runner/internal/model"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type DeadLetterQueue interface {
+	Peek(name string, limit int) ([]amqp.Delivery, error)
+	Purge(name string) (int, error)
+}
+
+type DeadLetters struct {
+	queue  DeadLetterQueue
+	logger *slog.Logger
+}
+
+func NewDeadLettersUsecase(queue DeadLetterQueue, logger *slog.Logger) *DeadLetters {
+	return &DeadLetters{queue: queue, logger: logger}
+}
+
+// List возвращает до limit мёртвых писем, не удаляя их из очереди
+func (dl *DeadLetters) List(limit int) ([]model.DeadLetter, error) {
+	msgs, err := dl.queue.Peek(model.DeadLetterQueue, limit)
+	if err != nil {
+		return nil, err
+	}
+	letters := make([]model.DeadLetter, 0, len(msgs))
+	for _, msg := range msgs {
+		letters = append(letters, model.DeadLetter{
+			RoutingKey: header(msg.Headers, model.HeaderOriginalKey),
+			Error:      header(msg.Headers, model.HeaderParseError),
+			Body:       string(msg.Body),
+			Timestamp:  msg.Timestamp,
+		})
+	}
+	return letters, nil
+}
+
+func (dl *DeadLetters) Purge() (int, error) {
+	purged, err := dl.queue.Purge(model.DeadLetterQueue)
+	if err != nil {
+		return 0, err
+	}
+	dl.logger.Info("purged dead letters", slog.Int("count", purged))
+	return purged, nil
+}
+
+func header(headers amqp.Table, key string) string {
+	value, ok := headers[key]
+	if !ok {
+		return ""
+	}
+	return fmt.Sprint(value)
+}